@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API key scopes; each scope includes the ones before it
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// scopeRank orders the scopes so that admin implies write and write implies read
+var scopeRank = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// apiKeyPrefix marks tokens issued by the key store
+const apiKeyPrefix = "kg_"
+
+// APIKey is an issued API key; only the hash of its token is stored
+type APIKey struct {
+	ID        int64
+	Hash      string
+	User      string
+	Scopes    []string
+	CreatedAt time.Time
+	RevokedAt time.Time
+}
+
+// Allows reports whether the key grants a scope
+func (key *APIKey) Allows(scope string) bool {
+	for _, granted := range key.Scopes {
+		if scopeRank[granted] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked reports whether the key has been revoked
+func (key *APIKey) Revoked() bool {
+	return !key.RevokedAt.IsZero()
+}
+
+// KeyStore holds the API keys issued for a data directory
+type KeyStore struct {
+	Path string
+	Keys map[int64]*APIKey
+}
+
+// OpenKeyStore loads the API keys stored in a data directory
+func OpenKeyStore(root string) (*KeyStore, error) {
+	store := &KeyStore{
+		Path: filepath.Join(root, "tenants", "keys.txt"),
+		Keys: make(map[int64]*APIKey),
+	}
+
+	file, err := os.Open(store.Path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open keys file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Key ") {
+			continue
+		}
+
+		// Parse key
+		var key APIKey
+		if _, err := fmt.Sscanf(line, "Key %d:", &key.ID); err != nil {
+			return nil, fmt.Errorf("failed to parse key: %v", err)
+		}
+		fields := parseRecordFields(strings.TrimPrefix(line, fmt.Sprintf("Key %d:", key.ID)))
+		key.Hash = fields["Hash"]
+		key.User = fields["User"]
+		key.Scopes = strings.Split(fields["Scopes"], "+")
+		if key.CreatedAt, err = parseTime(fields["CreatedAt"]); err != nil {
+			return nil, fmt.Errorf("failed to parse key: %v", err)
+		}
+		if key.RevokedAt, err = parseTime(fields["RevokedAt"]); err != nil {
+			return nil, fmt.Errorf("failed to parse key: %v", err)
+		}
+		store.Keys[key.ID] = &key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while scanning keys file: %v", err)
+	}
+
+	return store, nil
+}
+
+// Save replaces the keys file, readable only by its owner
+func (store *KeyStore) Save() error {
+	if err := os.MkdirAll(filepath.Dir(store.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create tenants directory: %v", err)
+	}
+	return writeFileAtomic(store.Path, 0o600, func(w io.Writer) error {
+		for _, key := range store.sortedKeys() {
+			_, err := fmt.Fprintf(w, "Key %d: Hash=%s, User=%s, Scopes=%s, CreatedAt=%s, RevokedAt=%s\n",
+				key.ID, key.Hash, key.User, strings.Join(key.Scopes, "+"), formatTime(key.CreatedAt), formatTime(key.RevokedAt))
+			if err != nil {
+				return fmt.Errorf("failed to write key: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// sortedKeys returns the keys in ID order
+func (store *KeyStore) sortedKeys() []*APIKey {
+	keys := make([]*APIKey, 0, len(store.Keys))
+	for _, key := range store.Keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// Issue creates a key for a user and returns its token, which is not stored and cannot be shown again
+func (store *KeyStore) Issue(user string, scopes []string) (string, *APIKey, error) {
+	if err := validateTenantName(user); err != nil {
+		return "", nil, err
+	}
+	if len(scopes) == 0 {
+		return "", nil, errors.New("a key needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if _, ok := scopeRank[scope]; !ok {
+			return "", nil, fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	token := apiKeyPrefix + hex.EncodeToString(secret)
+
+	key := &APIKey{
+		ID:        1,
+		Hash:      hashToken(token),
+		User:      user,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	for id := range store.Keys {
+		key.ID = max(key.ID, id+1)
+	}
+	store.Keys[key.ID] = key
+	return token, key, store.Save()
+}
+
+// Revoke revokes one of a user's keys by ID
+func (store *KeyStore) Revoke(user string, id int64) error {
+	key, ok := store.Keys[id]
+	if !ok || key.User != user {
+		return fmt.Errorf("key %d not found for %s", id, user)
+	}
+	if !key.Revoked() {
+		key.RevokedAt = time.Now().UTC()
+	}
+	return store.Save()
+}
+
+// Authenticate returns the active key matching a token
+func (store *KeyStore) Authenticate(token string) (*APIKey, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return nil, errors.New("malformed API key")
+	}
+	hash := hashToken(token)
+	for _, key := range store.Keys {
+		if key.Hash == hash {
+			if key.Revoked() {
+				return nil, errors.New("API key has been revoked")
+			}
+			return key, nil
+		}
+	}
+	return nil, errors.New("unknown API key")
+}
+
+// hashToken hashes a token for storage; tokens carry 256 bits of entropy so a plain SHA-256 suffices
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// runKeyCommand issues, revokes and lists API keys
+func runKeyCommand(env *CommandEnv, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: key issue|revoke|list ...")
+	}
+	keys, err := OpenKeyStore(env.Store.Root)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "issue":
+		flags := flag.NewFlagSet("key issue", flag.ContinueOnError)
+		scopes := flags.String("scopes", ScopeRead, "comma-separated scopes: read, write, admin")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if env.User == "" {
+			return errors.New("usage: -user <name> key issue [-scopes read,write,admin]")
+		}
+		token, key, err := keys.Issue(env.User, strings.Split(*scopes, ","))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Issued key %d for %s with scopes %s\n", key.ID, key.User, strings.Join(key.Scopes, ", "))
+		fmt.Printf("Token (shown only once): %s\n", token)
+		return nil
+
+	case "revoke":
+		if len(args) != 2 || env.User == "" {
+			return errors.New("usage: -user <name> key revoke <key-id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key ID %q", args[1])
+		}
+		return keys.Revoke(env.User, id)
+
+	case "list":
+		for _, key := range keys.sortedKeys() {
+			if env.User != "" && key.User != env.User {
+				continue
+			}
+			status := "active"
+			if key.Revoked() {
+				status = "revoked " + key.RevokedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("Key %d: user=%s scopes=%s created=%s %s\n", key.ID, key.User, strings.Join(key.Scopes, ","),
+				key.CreatedAt.Local().Format("2006-01-02 15:04"), status)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown key subcommand %q", args[0])
+}
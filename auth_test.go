@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeyRevokeOnlyTouchesTheUsersOwnKeys(t *testing.T) {
+	root := t.TempDir()
+	keys, err := OpenKeyStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, bobKey, err := keys.Issue("bob", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoke := []string{"revoke", strconv.FormatInt(bobKey.ID, 10)}
+	env := &CommandEnv{Store: &TenantStore{Root: root}, User: "alice"}
+	if err := runKeyCommand(env, revoke); err == nil {
+		t.Error("alice revoked bob's key")
+	}
+	env.User = ""
+	if err := runKeyCommand(env, revoke); err == nil {
+		t.Error("a key was revoked without naming its user")
+	}
+	if keys, err = OpenKeyStore(root); err != nil {
+		t.Fatal(err)
+	}
+	if keys.Keys[bobKey.ID].Revoked() {
+		t.Fatal("bob's key is revoked")
+	}
+
+	env.User = "bob"
+	if err := runKeyCommand(env, revoke); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = OpenKeyStore(root); err != nil {
+		t.Fatal(err)
+	}
+	if !keys.Keys[bobKey.ID].Revoked() {
+		t.Error("bob could not revoke their own key")
+	}
+}
+
+func TestCreateWorkspaceRejectsKeyHolders(t *testing.T) {
+	root := t.TempDir()
+	keys, err := OpenKeyStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keys.Issue("erin", []string{ScopeRead}); err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenTenantStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateWorkspace("erin", "alice"); err == nil {
+		t.Error("created a workspace named after a key holder")
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// graphBackupCount is how many previous snapshots are kept beside a graph file as <graph file>.bak.N
+const graphBackupCount = 5
+
+// writeFileAtomic writes a file through a temporary file that is synced and renamed over it,
+// so a crash leaves either the old or the new contents and never a truncated file
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	if err := temp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+
+	writer := bufio.NewWriter(temp)
+	if err := write(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := temp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %v", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %v", err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes a rename or newly created file in a directory durable
+func syncDir(dir string) error {
+	handle, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer handle.Close()
+	if err := handle.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %v", err)
+	}
+	return nil
+}
+
+// graphBackupPath returns the nth most recent backup of a graph file
+func graphBackupPath(graphFilePath string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", graphFilePath, n)
+}
+
+// rotateBackups shifts a graph file's backups along and keeps its current contents as the newest one
+func rotateBackups(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	for n := graphBackupCount - 1; n >= 1; n-- {
+		if err := os.Rename(graphBackupPath(path, n), graphBackupPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate backups: %v", err)
+		}
+	}
+
+	// The file is about to be renamed over, so a hard link keeps its contents without copying them
+	if err := os.Link(path, graphBackupPath(path, 1)); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to back up graph file: %v", err)
+	}
+	if err := os.WriteFile(graphBackupPath(path, 1), data, 0o644); err != nil {
+		return fmt.Errorf("failed to back up graph file: %v", err)
+	}
+	return nil
+}
+
+// isStoreEntry reports whether a top-level entry of the data directory belongs to the store:
+// the default graph with its log, history and transcripts, and the tenants directory
+func isStoreEntry(name string) bool {
+	return strings.HasPrefix(name, "knowledge_graph.txt") || name == "transcripts" || name == "tenants"
+}
+
+// isBackupArtifact reports whether a file is left over from a save and not worth archiving
+func isBackupArtifact(name string) bool {
+	return strings.HasSuffix(name, ".tmp") || strings.Contains(name, ".txt.bak.") || strings.HasSuffix(name, ".damaged")
+}
+
+// writeBackup archives the store's files under root into a gzip-compressed tar file
+func writeBackup(root string, w io.Writer) (int, error) {
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read data directory: %v", err)
+	}
+	files := 0
+	for _, entry := range entries {
+		if !isStoreEntry(entry.Name()) {
+			continue
+		}
+		err := filepath.WalkDir(filepath.Join(root, entry.Name()), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if isBackupArtifact(d.Name()) || !(d.IsDir() || d.Type().IsRegular()) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(name)
+			if d.IsDir() {
+				header.Name += "/"
+			}
+			if err := archive.WriteHeader(header); err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			// The header carries the size; a file growing meanwhile, like an active log, is cut at whole entries on load
+			if _, err := io.CopyN(archive, file, info.Size()); err != nil {
+				return err
+			}
+			files++
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to archive %s: %v", entry.Name(), err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := compressed.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %v", err)
+	}
+	return files, nil
+}
+
+// extractBackup unpacks an archive written by writeBackup into an empty directory
+func extractBackup(r io.Reader, dir string) (int, error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive: %v", err)
+	}
+	defer compressed.Close()
+	archive := tar.NewReader(compressed)
+
+	files := 0
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to read archive: %v", err)
+		}
+		name := filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))
+		top, _, _ := strings.Cut(filepath.ToSlash(name), "/")
+		if !filepath.IsLocal(name) || !isStoreEntry(top) {
+			return 0, fmt.Errorf("archive entry %q is not part of a knowledge graph backup", header.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return 0, fmt.Errorf("failed to restore %s: %v", header.Name, err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return 0, fmt.Errorf("failed to restore %s: %v", header.Name, err)
+			}
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return 0, fmt.Errorf("failed to restore %s: %v", header.Name, err)
+			}
+			_, err = io.Copy(file, archive)
+			if err == nil {
+				err = file.Sync()
+			}
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return 0, fmt.Errorf("failed to restore %s: %v", header.Name, err)
+			}
+			os.Chtimes(path, header.ModTime, header.ModTime)
+			files++
+		default:
+			return 0, fmt.Errorf("archive entry %q is not a regular file", header.Name)
+		}
+	}
+	return files, nil
+}
+
+// verifyRestoredGraphs checks the snapshot checksum of every graph file in a restored directory
+func verifyRestoredGraphs(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() != "knowledge_graph.txt" {
+			return nil
+		}
+		if _, _, err := readSnapshot(path); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// runBackupCommand archives the graphs, logs, keys and workspaces of the data directory
+func runBackupCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := flags.String("out", "", "archive to write (default knowledge-graph-<time>.tar.gz)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("knowledge-graph-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	var files int
+	err := writeFileAtomic(*out, 0o600, func(w io.Writer) error {
+		var err error
+		files, err = writeBackup(env.Store.Root, w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up %d files to %s\n", files, *out)
+	return nil
+}
+
+// runRestoreCommand replaces the store's files in the data directory with the contents of a backup
+// The backup is unpacked and verified in a staging directory first, and the files it replaces are moved
+// aside rather than deleted
+func runRestoreCommand(env *CommandEnv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: restore <archive>")
+	}
+	archive, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer archive.Close()
+
+	// Unpack and check everything before touching the live files
+	root := env.Store.Root
+	staging, err := os.MkdirTemp(root, ".restore-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	defer os.RemoveAll(staging)
+	files, err := extractBackup(archive, staging)
+	if err != nil {
+		return err
+	}
+	if err := verifyRestoredGraphs(staging); err != nil {
+		return fmt.Errorf("archive holds a damaged graph: %v", err)
+	}
+
+	aside := filepath.Join(root, "pre-restore-"+time.Now().Format("20060102-150405"))
+	moved, err := swapStoreEntries(root, staging, aside)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d files from %s\n", files, args[0])
+	if moved > 0 {
+		fmt.Printf("The replaced files were moved to %s\n", aside)
+	}
+	return nil
+}
+
+// renameFile renames a file or directory; tests replace it to make a restore fail halfway
+var renameFile = os.Rename
+
+// swapStoreEntries moves the store's entries in root into aside and the restored entries in staging into
+// their place, one rename each so nothing is copied. If any rename fails, the ones already made are undone
+// in reverse order, leaving the data directory as it was. It returns how many entries were moved aside.
+func swapStoreEntries(root, staging, aside string) (moved int, err error) {
+	type move struct{ from, to string }
+	var done []move
+	created := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(done) - 1; i >= 0; i-- {
+			if undoErr := renameFile(done[i].to, done[i].from); undoErr != nil {
+				err = fmt.Errorf("%v; undoing the restore failed too, so the replaced files may be left in %s: %v", err, aside, undoErr)
+				return
+			}
+		}
+		if created {
+			os.Remove(aside)
+		}
+	}()
+	rename := func(from, to string) error {
+		if err := renameFile(from, to); err != nil {
+			return err
+		}
+		done = append(done, move{from, to})
+		return nil
+	}
+
+	current, err := os.ReadDir(root)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read data directory: %v", err)
+	}
+	for _, entry := range current {
+		if !isStoreEntry(entry.Name()) {
+			continue
+		}
+		// A directory left by an earlier restore is never merged into
+		if !created {
+			if err := os.Mkdir(aside, 0o755); err != nil {
+				return 0, fmt.Errorf("failed to set current files aside: %v", err)
+			}
+			created = true
+		}
+		if err := rename(filepath.Join(root, entry.Name()), filepath.Join(aside, entry.Name())); err != nil {
+			return 0, fmt.Errorf("failed to set current files aside: %v", err)
+		}
+		moved++
+	}
+
+	restored, err := os.ReadDir(staging)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read staging directory: %v", err)
+	}
+	for _, entry := range restored {
+		if err := rename(filepath.Join(staging, entry.Name()), filepath.Join(root, entry.Name())); err != nil {
+			return 0, fmt.Errorf("failed to restore %s: %v", entry.Name(), err)
+		}
+	}
+	if err := syncDir(root); err != nil {
+		return 0, err
+	}
+	return moved, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newBackupTestStore saves a default graph and a tenant graph under a new data directory
+func newBackupTestStore(t *testing.T) (*CommandEnv, string) {
+	t.Helper()
+	store := &TenantStore{Root: t.TempDir()}
+	path := filepath.Join(store.Root, "knowledge_graph.txt")
+	graph := NewKnowledgeGraph()
+	if _, err := AddNote(graph, "Backed up note", []string{"backups"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+	tenant := NewKnowledgeGraph()
+	if _, err := AddNote(tenant, "Alice's note", []string{"tenants"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(store.GraphPath("alice")), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveGraph(store.GraphPath("alice"), tenant); err != nil {
+		t.Fatal(err)
+	}
+	return &CommandEnv{Graph: graph, GraphFilePath: path, Store: store}, path
+}
+
+// noteTextsAt lists the note texts of the graph saved at path
+func noteTextsAt(t *testing.T, path string) []string {
+	t.Helper()
+	graph, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, id := range SearchNodes(graph, NodeFilter{}) {
+		texts = append(texts, graph.Nodes[id].Text)
+	}
+	return texts
+}
+
+func TestSnapshotsKeepRotatingBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	for seq := int64(1); seq <= graphBackupCount+2; seq++ {
+		if err := writeSnapshot(path, graph, seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for n := 1; n <= graphBackupCount; n++ {
+		_, seq, err := readSnapshot(graphBackupPath(path, n))
+		if err != nil {
+			t.Fatalf("backup %d: %v", n, err)
+		}
+		if want := int64(graphBackupCount + 2 - n); seq != want {
+			t.Errorf("backup %d holds the snapshot of entry %d, want %d", n, seq, want)
+		}
+	}
+	if _, err := os.Stat(graphBackupPath(path, graphBackupCount+1)); !os.IsNotExist(err) {
+		t.Errorf("a backup beyond the last %d was kept", graphBackupCount)
+	}
+}
+
+func TestRestoreBringsBackABackup(t *testing.T) {
+	env, path := newBackupTestStore(t)
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := runBackupCommand(env, []string{"-out", archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AddNote(env.Graph, "Added after the backup", []string{"backups"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRestoreCommand(env, []string{archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := noteTextsAt(t, path); len(texts) != 1 || texts[0] != "Backed up note" {
+		t.Errorf("restored graph holds %q, want only the backed up note", texts)
+	}
+	if texts := noteTextsAt(t, env.Store.GraphPath("alice")); len(texts) != 1 {
+		t.Errorf("restored tenant graph holds %q, want alice's note", texts)
+	}
+	asides, err := filepath.Glob(filepath.Join(env.Store.Root, "pre-restore-*", "knowledge_graph.txt"))
+	if err != nil || len(asides) != 1 {
+		t.Fatalf("found %q set aside, want the replaced graph", asides)
+	}
+	if texts := noteTextsAt(t, asides[0]); len(texts) != 2 {
+		t.Errorf("the graph set aside holds %q, want both notes", texts)
+	}
+	if staging, _ := filepath.Glob(filepath.Join(env.Store.Root, ".restore-*")); len(staging) != 0 {
+		t.Errorf("restore left %q behind", staging)
+	}
+}
+
+func TestRestoreRefusesADamagedBackup(t *testing.T) {
+	env, path := newBackupTestStore(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := strings.Replace(string(data), "Backed up note", "Tampered note", 1)
+
+	archive := filepath.Join(t.TempDir(), "damaged.tar.gz")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := gzip.NewWriter(file)
+	writer := tar.NewWriter(compressed)
+	if err := writer.WriteHeader(&tar.Header{Name: "knowledge_graph.txt", Mode: 0o644, Size: int64(len(damaged)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte(damaged))
+	writer.Close()
+	compressed.Close()
+	file.Close()
+
+	if err := runRestoreCommand(env, []string{archive}); err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Errorf("restoring a damaged backup gave %v, want a damaged graph error", err)
+	}
+	if texts := noteTextsAt(t, path); len(texts) != 1 || texts[0] != "Backed up note" {
+		t.Errorf("live graph holds %q after the refused restore", texts)
+	}
+}
+
+func TestRestoreRollsBackWhenASwapFails(t *testing.T) {
+	env, path := newBackupTestStore(t)
+	archive := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := runBackupCommand(env, []string{"-out", archive}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddNote(env.Graph, "Added after the backup", []string{"backups"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving the restored tenants directory into place fails after the live files were set aside
+	defer func() { renameFile = os.Rename }()
+	renameFile = func(from, to string) error {
+		if to == filepath.Join(env.Store.Root, "tenants") && strings.Contains(from, ".restore-") {
+			return errors.New("simulated failure")
+		}
+		return os.Rename(from, to)
+	}
+	if err := runRestoreCommand(env, []string{archive}); err == nil {
+		t.Fatal("the restore succeeded despite the failed rename")
+	}
+
+	if texts := noteTextsAt(t, path); len(texts) != 2 {
+		t.Errorf("live graph holds %q after the failed restore, want both notes", texts)
+	}
+	if texts := noteTextsAt(t, env.Store.GraphPath("alice")); len(texts) != 1 {
+		t.Errorf("tenant graph holds %q after the failed restore", texts)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(env.Store.Root, "*restore-*")); len(leftovers) != 0 {
+		t.Errorf("the failed restore left %q behind", leftovers)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const missingAPIKeyMessage = "OpenAI API key not found. Please set the OPENAI_API_KEY or MY_SECRET environment variable with your API key."
+
+// CommandEnv carries the loaded graph and configuration shared by CLI subcommands
+type CommandEnv struct {
+	Graph         *KnowledgeGraph
+	GraphFilePath string
+	APIKey        string
+
+	// Store and User identify the tenants the command may reach
+	Store *TenantStore
+	User  string
+}
+
+// Save persists the graph after a subcommand has modified it
+func (env *CommandEnv) Save() error {
+	if err := SaveGraph(env.GraphFilePath, env.Graph); err != nil {
+		return fmt.Errorf("failed to save knowledge graph: %v", err)
+	}
+	return nil
+}
+
+// RequireAPIKey returns the OpenAI API key, failing if the subcommand needs one and none is set
+func (env *CommandEnv) RequireAPIKey() (string, error) {
+	if env.APIKey == "" {
+		return "", errors.New(missingAPIKeyMessage)
+	}
+	return env.APIKey, nil
+}
+
+// Command describes a CLI subcommand
+type Command struct {
+	Name    string
+	Usage   string
+	Summary string
+	Run     func(env *CommandEnv, args []string) error
+}
+
+// commands lists the available CLI subcommands
+var commands = []Command{
+	{
+		Name:    "search",
+		Usage:   "search [-concept c] [-text s] [-source s] [-author a] [-tag t] [-since d] [-until d]",
+		Summary: "List notes matching a concept or text",
+		Run:     runSearchCommand,
+	},
+	{
+		Name:    "concept",
+		Usage:   "concept broader|related|unlink|tree|suggest|credit ...",
+		Summary: "Edit and inspect the concept hierarchy",
+		Run:     runConceptCommand,
+	},
+	{
+		Name:    "reweight",
+		Usage:   "reweight [jaccard|overlap|tfidf|salience|embedding]",
+		Summary: "Select the edge weighting strategy and recompute every edge",
+		Run:     runReweightCommand,
+	},
+	{
+		Name:    "prune",
+		Usage:   "prune [-min-weight w] [-top-k k] [-mutual]",
+		Summary: "Set the edge-retention policy and re-prune existing edges",
+		Run:     runPruneCommand,
+	},
+	{
+		Name:    "tag",
+		Usage:   "tag [-remove] <node-id> <tag>...",
+		Summary: "Add or remove manual tags on a note",
+		Run:     runTagCommand,
+	},
+	{
+		Name:    "related",
+		Usage:   "related [-limit n] <node-id>",
+		Summary: "List the notes most related to a note, with recency decay applied",
+		Run:     runRelatedCommand,
+	},
+	{
+		Name:    "topics",
+		Usage:   "topics [-month YYYY-MM | -since d -until d] [-limit n]",
+		Summary: "List the concepts you were thinking about in a period",
+		Run:     runTopicsCommand,
+	},
+	{
+		Name:    "timeline",
+		Usage:   "timeline [-rollup=false] <concept>",
+		Summary: "Show when a concept was mentioned",
+		Run:     runTimelineCommand,
+	},
+	{
+		Name:    "trends",
+		Usage:   "trends [-bucket day|week|month] [-limit n] [concept...]",
+		Summary: "Count concept mentions over time",
+		Run:     runTrendsCommand,
+	},
+	{
+		Name:    "decay",
+		Usage:   "decay <half-life>",
+		Summary: "Make edge weights fade unless reinforced (e.g. 30d; 0 disables)",
+		Run:     runDecayCommand,
+	},
+	{
+		Name:    "workspace",
+		Usage:   "workspace create|share|unshare|list ...",
+		Summary: "Manage graphs shared between users",
+		Run:     runWorkspaceCommand,
+	},
+	{
+		Name:    "key",
+		Usage:   "key issue [-scopes read,write,admin] | revoke <id> | list",
+		Summary: "Manage API keys for the HTTP API",
+		Run:     runKeyCommand,
+	},
+	{
+		Name:    "serve",
+		Usage:   "serve [-addr host:port] [-ui]",
+		Summary: "Serve the graphs over an authenticated HTTP API, with an optional graph viewer",
+		Run:     runServeCommand,
+	},
+	{
+		Name:    "mcp",
+		Usage:   "mcp",
+		Summary: "Serve the graph to LLM agents as Model Context Protocol tools over stdio",
+		Run:     runMCPCommand,
+	},
+	{
+		Name:    "log",
+		Usage:   "log list [-since d] | replay -at <time> [-out path] | compact",
+		Summary: "Inspect the log of saved changes, rebuild the graph as of a past time, or compact the log",
+		Run:     runLogCommand,
+	},
+	{
+		Name:    "sync",
+		Usage:   "sync status | export [-for replica] [-out path] | import <delta-file>... | remote [-token t] [-workspace w] <url>",
+		Summary: "Replicate the graph between devices by exchanging changes as files or with a server",
+		Run:     runSyncCommand,
+	},
+	{
+		Name:    "dedupe",
+		Usage:   "dedupe [-threshold t] [-on-add warn|merge|off] [-dry-run]",
+		Summary: "Merge near-duplicate notes and set what adding a near duplicate does",
+		Run:     runDedupeCommand,
+	},
+	{
+		Name:    "merge",
+		Usage:   "merge [-similarity s] [-dry-run] [-json] <graph-file>",
+		Summary: "Merge another graph into this one, folding duplicate notes together and reporting conflicts",
+		Run:     runMergeCommand,
+	},
+	{
+		Name:    "snapshot",
+		Usage:   "snapshot tag <name> | list | delete <name> | diff [-json] <from> [to] | rollback <name>",
+		Summary: "Name versions of the graph, compare them and roll back to one",
+		Run:     runSnapshotCommand,
+	},
+	{
+		Name:    "backup",
+		Usage:   "backup [-out path]",
+		Summary: "Archive the graphs, their logs, keys and workspaces into one compressed file",
+		Run:     runBackupCommand,
+	},
+	{
+		Name:    "restore",
+		Usage:   "restore <archive>",
+		Summary: "Replace the graphs, keys and workspaces with a backup, setting the current files aside",
+		Run:     runRestoreCommand,
+	},
+	{
+		Name:    "ingest-audio",
+		Usage:   "ingest-audio [-stub] [-concepts a,b] <audio-file>...",
+		Summary: "Transcribe and summarize voice notes into the graph",
+		Run:     runIngestAudioCommand,
+	},
+	{
+		Name:    "import",
+		Usage:   "import [-format markdown|turtle|ntriples|jsonld] [-extract=false] <path>",
+		Summary: "Import a Markdown or Obsidian vault, or notes and relations from RDF",
+		Run:     runImportCommand,
+	},
+	{
+		Name:    "export",
+		Usage:   "export [-format markdown|graphml|gexf|dot|turtle|ntriples|jsonld|neo4j|cypher] [-out path] [-min-weight w] [-concept c] [-node id -depth n]",
+		Summary: "Export the graph as a Markdown vault, for Gephi, yEd and Graphviz, as RDF or for Neo4j",
+		Run:     runExportCommand,
+	},
+}
+
+// runCommand dispatches a subcommand by name
+func runCommand(env *CommandEnv, name string, args []string) error {
+	if name == "help" || name == "-h" || name == "--help" {
+		printCommandUsage()
+		return nil
+	}
+	for _, command := range commands {
+		if command.Name == name {
+			return command.Run(env, args)
+		}
+	}
+	printCommandUsage()
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// printCommandUsage prints the list of subcommands
+func printCommandUsage() {
+	sorted := make([]Command, len(commands))
+	copy(sorted, commands)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	fmt.Fprintln(os.Stderr, "Usage: knowledge-graph [-data dir] [-user name] [-workspace name] [command] [arguments]")
+	fmt.Fprintln(os.Stderr, "Without a command, notes are read interactively from standard input.")
+	fmt.Fprintln(os.Stderr, "")
+	for _, command := range sorted {
+		fmt.Fprintf(os.Stderr, "  %-60s %s\n", command.Usage, command.Summary)
+	}
+}
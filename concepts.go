@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Concept represents a concept as a first-class entity in the knowledge graph
+type Concept struct {
+	ID   int64
+	Name string
+}
+
+// Membership represents a note→concept membership edge in the knowledge graph
+type Membership struct {
+	ID        int64
+	NodeID    int64
+	ConceptID int64
+	Salience  float64
+}
+
+// normalizeConcept returns the key used to match concept names case-insensitively
+func normalizeConcept(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// reindex rebuilds the concept and membership lookup indexes from the graph maps
+func (graph *KnowledgeGraph) reindex() {
+	graph.conceptIndex = make(map[string]int64)
+	graph.nodeMembers = make(map[int64]map[int64]int64)
+	graph.conceptMembers = make(map[int64]map[int64]int64)
+
+	for id, concept := range graph.Concepts {
+		graph.conceptIndex[normalizeConcept(concept.Name)] = id
+	}
+	for id, membership := range graph.Memberships {
+		graph.indexMembership(id, membership)
+	}
+}
+
+// indexMembership records a membership in the node and concept indexes
+func (graph *KnowledgeGraph) indexMembership(id int64, membership *Membership) {
+	if graph.nodeMembers[membership.NodeID] == nil {
+		graph.nodeMembers[membership.NodeID] = make(map[int64]int64)
+	}
+	if graph.conceptMembers[membership.ConceptID] == nil {
+		graph.conceptMembers[membership.ConceptID] = make(map[int64]int64)
+	}
+	graph.nodeMembers[membership.NodeID][membership.ConceptID] = id
+	graph.conceptMembers[membership.ConceptID][membership.NodeID] = id
+}
+
+// ConceptByName looks up a concept by name, ignoring case and surrounding whitespace
+func (graph *KnowledgeGraph) ConceptByName(name string) *Concept {
+	id, ok := graph.conceptIndex[normalizeConcept(name)]
+	if !ok {
+		return nil
+	}
+	return graph.Concepts[id]
+}
+
+// ensureConcept returns the concept with the given name, creating it if needed
+func (graph *KnowledgeGraph) ensureConcept(name string) *Concept {
+	if concept := graph.ConceptByName(name); concept != nil {
+		return concept
+	}
+	concept := &Concept{
+		ID:   graph.generateConceptID(),
+		Name: strings.TrimSpace(name),
+	}
+	graph.Concepts[concept.ID] = concept
+	graph.conceptIndex[normalizeConcept(concept.Name)] = concept.ID
+	graph.touch("Concept", concept.ID)
+	return concept
+}
+
+// addMembership links a node to a concept unless the link already exists, in which case its salience is updated
+func (graph *KnowledgeGraph) addMembership(nodeID, conceptID int64, salience float64) {
+	if id, ok := graph.nodeMembers[nodeID][conceptID]; ok {
+		graph.Memberships[id].Salience = salience
+		graph.touch("Membership", id)
+		return
+	}
+	membership := &Membership{
+		ID:        graph.generateMembershipID(),
+		NodeID:    nodeID,
+		ConceptID: conceptID,
+		Salience:  salience,
+	}
+	graph.Memberships[membership.ID] = membership
+	graph.indexMembership(membership.ID, membership)
+	graph.touch("Membership", membership.ID)
+}
+
+// removeMembership unlinks a node from a concept
+func (graph *KnowledgeGraph) removeMembership(nodeID, conceptID int64) {
+	id, ok := graph.nodeMembers[nodeID][conceptID]
+	if !ok {
+		return
+	}
+	delete(graph.Memberships, id)
+	delete(graph.nodeMembers[nodeID], conceptID)
+	delete(graph.conceptMembers[conceptID], nodeID)
+	graph.touch("Membership", id)
+}
+
+// SetNodeConcepts replaces the concepts a node is a member of, treating earlier concepts as more salient
+func (graph *KnowledgeGraph) SetNodeConcepts(nodeID int64, names []string) {
+	var cleaned []string
+	for _, name := range names {
+		if normalizeConcept(name) != "" {
+			cleaned = append(cleaned, name)
+		}
+	}
+
+	wanted := make(map[int64]bool)
+	for rank, name := range cleaned {
+		concept := graph.ensureConcept(name)
+		if wanted[concept.ID] {
+			continue
+		}
+		wanted[concept.ID] = true
+		graph.addMembership(nodeID, concept.ID, rankSalience(rank, len(cleaned)))
+	}
+
+	// Drop memberships for concepts no longer attached to the node
+	for conceptID := range graph.nodeMembers[nodeID] {
+		if !wanted[conceptID] {
+			graph.removeMembership(nodeID, conceptID)
+		}
+	}
+}
+
+// NodeConceptIDs returns the IDs of the concepts a node is a member of, most salient first; concepts of equal
+// salience keep the order they were attached in
+func (graph *KnowledgeGraph) NodeConceptIDs(nodeID int64) []int64 {
+	members := graph.nodeMembers[nodeID]
+	ids := make([]int64, 0, len(members))
+	for conceptID := range members {
+		ids = append(ids, conceptID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		first, second := graph.Memberships[members[ids[i]]], graph.Memberships[members[ids[j]]]
+		if first.Salience != second.Salience {
+			return first.Salience > second.Salience
+		}
+		return first.ID < second.ID
+	})
+	return ids
+}
+
+// NodeConcepts returns the names of the concepts a node is a member of
+func (graph *KnowledgeGraph) NodeConcepts(nodeID int64) []string {
+	ids := graph.NodeConceptIDs(nodeID)
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		names = append(names, graph.Concepts[id].Name)
+	}
+	return names
+}
+
+// ConceptNodeIDs returns the IDs of the nodes that are members of a concept, in ascending order
+func (graph *KnowledgeGraph) ConceptNodeIDs(conceptID int64) []int64 {
+	ids := make([]int64, 0, len(graph.conceptMembers[conceptID]))
+	for nodeID := range graph.conceptMembers[conceptID] {
+		ids = append(ids, nodeID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// neighbourCandidates returns the nodes sharing at least one concept with the given node
+func (graph *KnowledgeGraph) neighbourCandidates(nodeID int64) []int64 {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for conceptID := range graph.nodeMembers[nodeID] {
+		for otherID := range graph.conceptMembers[conceptID] {
+			if otherID != nodeID && !seen[otherID] {
+				seen[otherID] = true
+				ids = append(ids, otherID)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// migrateLegacyVertices converts per-pair Vertex records from the old file layout into concept memberships
+func (graph *KnowledgeGraph) migrateLegacyVertices(vertices []Vertex) {
+	for _, vertex := range vertices {
+		if normalizeConcept(vertex.Concept) == "" {
+			continue
+		}
+		concept := graph.ensureConcept(vertex.Concept)
+		for _, nodeID := range []int64{vertex.NodeID, vertex.TargetID} {
+			if _, ok := graph.nodeMembers[nodeID][concept.ID]; !ok {
+				graph.addMembership(nodeID, concept.ID, 1.0)
+			}
+		}
+	}
+}
+
+// parseRecordFields parses the "Key=value, Key=value" body of a graph file record
+func parseRecordFields(body string) map[string]string {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(body, ", ") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// parseIDField parses an integer ID field from a parsed graph file record
+func parseIDField(fields map[string]string, key string) (int64, error) {
+	value, ok := fields[key]
+	if !ok {
+		return 0, fmt.Errorf("missing field %s", key)
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid field %s: %v", key, err)
+	}
+	return id, nil
+}
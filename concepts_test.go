@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLegacyVerticesMigrateToMemberships(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	legacy := `Concepts: go, concurrency
+Node 1: Go channels
+Node 2: Go goroutines
+Node 3: Rust ownership
+Edge 1: SourceID=1, TargetID=2, Weight=0.500000
+Vertex 1: NodeID=1, TargetID=2, Concept=go
+Vertex 2: NodeID=1, TargetID=2, Concept=concurrency
+Vertex 3: NodeID=2, TargetID=1, Concept=Go
+`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []string{"migrating", "reloading"} {
+		graph, err := LoadGraph(path)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if len(graph.Concepts) != 2 || len(graph.Memberships) != 4 || len(graph.Edges) != 1 {
+			t.Errorf("%s: got %d concepts, %d memberships and %d edges, want 2, 4 and 1",
+				step, len(graph.Concepts), len(graph.Memberships), len(graph.Edges))
+		}
+		for _, id := range []int64{1, 2} {
+			if got := graph.NodeConcepts(id); !slices.Equal(got, []string{"go", "concurrency"}) {
+				t.Errorf("%s: note %d has concepts %q, want go and concurrency", step, id, got)
+			}
+		}
+		if got := graph.NodeConcepts(3); len(got) != 0 {
+			t.Errorf("%s: note 3 has concepts %q, want none", step, got)
+		}
+		if err := SaveGraph(path, graph); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	graph, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range graph.journal.records {
+		if strings.HasPrefix(key, "Vertex ") {
+			t.Errorf("the saved graph still holds legacy vertex %q", key)
+		}
+	}
+}
+
+func TestConceptNamesSurviveSaving(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	name := "line\nbreak, with \\ backslash"
+	if err := BuildOrUpdateKnowledgeGraph(graph, "Odd concept names", []string{name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Concepts) != 1 || loaded.ConceptByName(name) == nil {
+		t.Errorf("reloaded concepts %q, want only %q", loaded.NodeConcepts(1), name)
+	}
+}
+
+func TestNodeConceptsFollowTheirCurrentRank(t *testing.T) {
+	graph := NewKnowledgeGraph()
+	if err := BuildOrUpdateKnowledgeGraph(graph, "Raft replicates a log", []string{"raft", "logs", "consensus"}); err != nil {
+		t.Fatal(err)
+	}
+	nodeID := graph.sortedNodeIDs()[0]
+	reordered := []string{"consensus", "raft", "logs"}
+	graph.SetNodeConcepts(nodeID, reordered)
+	if got := graph.NodeConcepts(nodeID); !slices.Equal(got, reordered) {
+		t.Errorf("concepts are listed as %q, want %q", got, reordered)
+	}
+
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.NodeConcepts(nodeID); !slices.Equal(got, reordered) {
+		t.Errorf("reloaded concepts are listed as %q, want %q", got, reordered)
+	}
+}
+
+func TestEdgeRelationsSurviveSaving(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	for _, note := range []struct{ text, concept string }{{"Cited note", "citations"}, {"Citing note", "papers"}} {
+		if err := BuildOrUpdateKnowledgeGraph(graph, note.text, []string{note.concept}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := graph.sortedNodeIDs()
+	relation := "cites, see\nalso"
+	graph.PutEdge(ids[1], ids[0], 1, relation, true)
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.FindEdge(ids[1], ids[0], relation, true) == nil {
+		t.Errorf("the %q edge did not survive saving", relation)
+	}
+}
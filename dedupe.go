@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+)
+
+// Duplicate policies applied when a note is added
+const (
+	DuplicateWarn  = "warn"
+	DuplicateMerge = "merge"
+	DuplicateOff   = "off"
+)
+
+const (
+	// defaultDuplicateThreshold is the word overlap from which two notes of a graph are near duplicates
+	defaultDuplicateThreshold = 0.8
+
+	// minHashSize is the number of hash functions in a note's MinHash signature
+	minHashSize = 64
+
+	// minHashBands splits signatures into bands for locality-sensitive hashing; notes sharing any band are compared
+	minHashBands = 16
+
+	// minHashSlack is how far a signature estimate may fall below the threshold before a candidate is dismissed;
+	// with 64 hashes the estimate's standard deviation is at most 0.0625
+	minHashSlack = 0.2
+)
+
+// minHashSeeds perturb one word hash into minHashSize independent hashes
+var minHashSeeds = func() [minHashSize]uint64 {
+	var seeds [minHashSize]uint64
+	for i := range seeds {
+		seeds[i] = mix64(uint64(i+1) * 0x9E3779B97F4A7C15)
+	}
+	return seeds
+}()
+
+// mix64 is the SplitMix64 finalizer, which spreads the bits of a hash
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xBF58476D1CE4E5B9
+	x ^= x >> 27
+	x *= 0x94D049BB133111EB
+	x ^= x >> 31
+	return x
+}
+
+// noteSketch caches the words of a note's text and their MinHash signature
+type noteSketch struct {
+	text      string
+	words     map[string]bool
+	signature [minHashSize]uint64
+}
+
+// newNoteSketch computes the sketch of a text
+func newNoteSketch(text string) *noteSketch {
+	sketch := &noteSketch{text: text, words: noteWords(text)}
+	for i := range sketch.signature {
+		sketch.signature[i] = math.MaxUint64
+	}
+	for word := range sketch.words {
+		hash := fnv.New64a()
+		hash.Write([]byte(word))
+		value := hash.Sum64()
+		for i, seed := range minHashSeeds {
+			if h := mix64(value ^ seed); h < sketch.signature[i] {
+				sketch.signature[i] = h
+			}
+		}
+	}
+	return sketch
+}
+
+// estimate returns the share of matching signature slots, which estimates the Jaccard similarity of the word sets
+func (sketch *noteSketch) estimate(other *noteSketch) float64 {
+	matches := 0
+	for i := range sketch.signature {
+		if sketch.signature[i] == other.signature[i] {
+			matches++
+		}
+	}
+	return float64(matches) / minHashSize
+}
+
+// sketch returns the cached sketch of a note, recomputing it if the text changed
+func (graph *KnowledgeGraph) sketch(node *Node) *noteSketch {
+	if graph.sketches == nil {
+		graph.sketches = make(map[int64]*noteSketch)
+	}
+	if sketch, ok := graph.sketches[node.ID]; ok && sketch.text == node.Text {
+		return sketch
+	}
+	sketch := newNoteSketch(node.Text)
+	graph.sketches[node.ID] = sketch
+	return sketch
+}
+
+// duplicateThreshold returns the similarity from which the graph treats notes as near duplicates
+func (graph *KnowledgeGraph) duplicateThreshold() float64 {
+	if graph.Settings.DuplicateThreshold > 0 {
+		return graph.Settings.DuplicateThreshold
+	}
+	return defaultDuplicateThreshold
+}
+
+// FindNearDuplicate returns the note most similar to a text, other than exclude, if the word overlap reaches
+// threshold; candidates are screened by their MinHash signatures and confirmed by exact Jaccard similarity
+func (graph *KnowledgeGraph) FindNearDuplicate(text string, exclude int64, threshold float64) (*Node, float64) {
+	probe := newNoteSketch(text)
+	var best *Node
+	bestSimilarity := 0.0
+	for _, id := range graph.sortedNodeIDs() {
+		if id == exclude {
+			continue
+		}
+		node := graph.Nodes[id]
+		sketch := graph.sketch(node)
+		if probe.estimate(sketch) < threshold-minHashSlack {
+			continue
+		}
+		if similarity := wordSimilarity(probe.words, sketch.words); similarity >= threshold && similarity > bestSimilarity {
+			best, bestSimilarity = node, similarity
+		}
+	}
+	return best, bestSimilarity
+}
+
+// DuplicateGroup is a set of near-duplicate notes and the note they consolidate into
+type DuplicateGroup struct {
+	Survivor   int64
+	Duplicates []int64
+
+	// Similarity holds each duplicate's word overlap with the note it was matched to
+	Similarity map[int64]float64
+}
+
+// NearDuplicateGroups finds the groups of notes whose texts are near duplicates
+// Notes sharing a band of their MinHash signatures are compared exactly; the oldest note of each group survives
+func (graph *KnowledgeGraph) NearDuplicateGroups(threshold float64) []DuplicateGroup {
+	type bucketKey struct {
+		band int
+		hash uint64
+	}
+	rows := minHashSize / minHashBands
+	buckets := make(map[bucketKey][]int64)
+	for _, id := range graph.sortedNodeIDs() {
+		sketch := graph.sketch(graph.Nodes[id])
+		if len(sketch.words) == 0 {
+			continue
+		}
+		for band := 0; band < minHashBands; band++ {
+			hash := uint64(band)
+			for _, value := range sketch.signature[band*rows : (band+1)*rows] {
+				hash = mix64(hash ^ value)
+			}
+			key := bucketKey{band: band, hash: hash}
+			buckets[key] = append(buckets[key], id)
+		}
+	}
+
+	// Union the confirmed pairs into groups
+	parent := make(map[int64]int64)
+	var find func(id int64) int64
+	find = func(id int64) int64 {
+		if root, ok := parent[id]; ok && root != id {
+			parent[id] = find(root)
+			return parent[id]
+		}
+		return id
+	}
+	similarity := make(map[int64]float64)
+	compared := make(map[[2]int64]bool)
+	for _, ids := range buckets {
+		for i := 0; i < len(ids); i++ {
+			for j := i + 1; j < len(ids); j++ {
+				pair := [2]int64{ids[i], ids[j]}
+				if compared[pair] {
+					continue
+				}
+				compared[pair] = true
+				score := wordSimilarity(graph.sketch(graph.Nodes[pair[0]]).words, graph.sketch(graph.Nodes[pair[1]]).words)
+				if score < threshold {
+					continue
+				}
+				similarity[pair[0]] = max(similarity[pair[0]], score)
+				similarity[pair[1]] = max(similarity[pair[1]], score)
+				if a, b := find(pair[0]), find(pair[1]); a != b {
+					parent[max(a, b)] = min(a, b)
+				}
+			}
+		}
+	}
+
+	members := make(map[int64][]int64)
+	for id := range similarity {
+		root := find(id)
+		members[root] = append(members[root], id)
+	}
+	var groups []DuplicateGroup
+	for _, ids := range members {
+		sort.Slice(ids, func(i, j int) bool { return graph.olderNode(ids[i], ids[j]) })
+		group := DuplicateGroup{Survivor: ids[0], Duplicates: ids[1:], Similarity: make(map[int64]float64)}
+		for _, id := range group.Duplicates {
+			group.Similarity[id] = similarity[id]
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Survivor < groups[j].Survivor })
+	return groups
+}
+
+// olderNode orders notes by creation time, then ID; notes without a creation time sort last
+func (graph *KnowledgeGraph) olderNode(a, b int64) bool {
+	createdA, createdB := graph.Nodes[a].CreatedAt, graph.Nodes[b].CreatedAt
+	if createdA.IsZero() != createdB.IsZero() {
+		return createdB.IsZero()
+	}
+	if !createdA.Equal(createdB) {
+		return createdA.Before(createdB)
+	}
+	return a < b
+}
+
+// MergeNodes consolidates a duplicate note into a surviving one: the survivor gains the duplicate's concepts, tags
+// and attachments and its typed edges, its similarity edges are recomputed, and the duplicate's ID redirects to it
+func (graph *KnowledgeGraph) MergeNodes(survivorID, duplicateID int64) error {
+	survivor, ok := graph.Nodes[survivorID]
+	if !ok {
+		return fmt.Errorf("node %d not found", survivorID)
+	}
+	duplicate, ok := graph.Nodes[duplicateID]
+	if !ok {
+		return fmt.Errorf("node %d not found", duplicateID)
+	}
+	if survivorID == duplicateID {
+		return errors.New("cannot merge a note into itself")
+	}
+
+	graph.foldNote(survivorID, duplicate, graph.NodeConcepts(duplicateID))
+	if duplicate.UpdatedAt.After(survivor.UpdatedAt) {
+		survivor.UpdatedAt = duplicate.UpdatedAt
+	}
+
+	// Typed edges move to the survivor; the stronger weight wins where it already has the edge
+	for _, edge := range graph.Edges {
+		if edge.IsSimilarity() || (edge.SourceID != duplicateID && edge.TargetID != duplicateID) {
+			continue
+		}
+		sourceID, targetID := edge.SourceID, edge.TargetID
+		if sourceID == duplicateID {
+			sourceID = survivorID
+		}
+		if targetID == duplicateID {
+			targetID = survivorID
+		}
+		if sourceID == targetID {
+			continue
+		}
+		weight := edge.Weight
+		if existing := graph.FindEdge(sourceID, targetID, edge.Relation, edge.Directed); existing != nil {
+			weight = max(weight, existing.Weight)
+		}
+		moved := graph.PutEdge(sourceID, targetID, weight, edge.Relation, edge.Directed)
+		if edge.ReinforcedAt.After(moved.ReinforcedAt) {
+			moved.ReinforcedAt = edge.ReinforcedAt
+			graph.touch("Edge", moved.ID)
+		}
+	}
+	// Old IDs keep resolving, including those that redirected to the duplicate
+	for from, to := range graph.Redirects {
+		if to == duplicateID {
+			graph.Redirects[from] = survivorID
+			graph.touch("Redirect", from)
+		}
+	}
+	if err := graph.RemoveNode(duplicateID); err != nil {
+		return err
+	}
+	delete(graph.sketches, duplicateID)
+	graph.Redirects[duplicateID] = survivorID
+	graph.touch("Redirect", duplicateID)
+
+	return graph.UpdateNodeConcepts(survivorID, graph.NodeConcepts(survivorID))
+}
+
+// Node returns a note by ID, following the redirect left when a note was merged into another
+func (graph *KnowledgeGraph) Node(id int64) (*Node, bool) {
+	if node, ok := graph.Nodes[id]; ok {
+		return node, true
+	}
+	if target, ok := graph.Redirects[id]; ok {
+		node, ok := graph.Nodes[target]
+		return node, ok
+	}
+	return nil, false
+}
+
+// checkDuplicate applies the graph's duplicate policy to a note about to be added, returning the existing note it
+// should be merged into, if any
+func (graph *KnowledgeGraph) checkDuplicate(text string) *Node {
+	if graph.Settings.DuplicatePolicy == DuplicateOff {
+		return nil
+	}
+	existing, similarity := graph.FindNearDuplicate(text, 0, graph.duplicateThreshold())
+	if existing == nil {
+		return nil
+	}
+	if graph.Settings.DuplicatePolicy == DuplicateMerge {
+		log.Printf("Merged the new note into near duplicate note %d (%.0f%% similar)", existing.ID, similarity*100)
+		return existing
+	}
+	log.Printf("The new note looks like a near duplicate of note %d (%.0f%% similar)", existing.ID, similarity*100)
+	return nil
+}
+
+// runDedupeCommand sets the duplicate policy and consolidates the near-duplicate notes of the graph
+func runDedupeCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("dedupe", flag.ContinueOnError)
+	threshold := flags.Float64("threshold", env.Graph.duplicateThreshold(), "word overlap (0-1) from which notes are near duplicates")
+	onAdd := flags.String("on-add", "", "what adding a near-duplicate note does: warn, merge or off")
+	dryRun := flags.Bool("dry-run", false, "list the duplicates without merging them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: dedupe [-threshold t] [-on-add warn|merge|off] [-dry-run]")
+	}
+	if *threshold <= 0 || *threshold > 1 {
+		return fmt.Errorf("threshold must be in (0, 1], got %g", *threshold)
+	}
+	switch *onAdd {
+	case "", DuplicateWarn, DuplicateMerge, DuplicateOff:
+	default:
+		return fmt.Errorf("unknown duplicate policy %q: use warn, merge or off", *onAdd)
+	}
+
+	groups := env.Graph.NearDuplicateGroups(*threshold)
+	merged := 0
+	for _, group := range groups {
+		survivor := env.Graph.Nodes[group.Survivor]
+		fmt.Printf("Note %d: %s\n", survivor.ID, firstLine(survivor.Text))
+		for _, id := range group.Duplicates {
+			fmt.Printf("  <- note %d (%.0f%% similar): %s\n", id, group.Similarity[id]*100, firstLine(env.Graph.Nodes[id].Text))
+		}
+		if *dryRun {
+			continue
+		}
+		for _, id := range group.Duplicates {
+			if err := env.Graph.MergeNodes(group.Survivor, id); err != nil {
+				return err
+			}
+			merged++
+		}
+	}
+	if *dryRun {
+		fmt.Printf("Found %d groups of near duplicates\n", len(groups))
+		return nil
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "threshold":
+			env.Graph.Settings.DuplicateThreshold = *threshold
+		case "on-add":
+			env.Graph.Settings.DuplicatePolicy = *onAdd
+		}
+	})
+	env.Graph.PruneEdges()
+	if err := env.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Merged %d near-duplicate notes into %d\n", merged, len(groups))
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// RelationSimilar is the relation of the symmetric concept-similarity edges derived by the graph
+const RelationSimilar = "similar"
+
+// RelationLinksTo is the relation of the directed edges created from links between imported notes
+const RelationLinksTo = "links-to"
+
+// edgeKey identifies an edge by its endpoints and relation; undirected edges use the smaller ID as source
+type edgeKey struct {
+	SourceID int64
+	TargetID int64
+	Relation string
+}
+
+// canonicalEdgeKey returns the key an edge is stored under, ordering the endpoints of undirected edges
+func canonicalEdgeKey(sourceID, targetID int64, relation string, directed bool) edgeKey {
+	if !directed && targetID < sourceID {
+		sourceID, targetID = targetID, sourceID
+	}
+	return edgeKey{SourceID: sourceID, TargetID: targetID, Relation: relation}
+}
+
+// key returns the canonical key of an edge
+func (edge *Edge) key() edgeKey {
+	return canonicalEdgeKey(edge.SourceID, edge.TargetID, edge.Relation, edge.Directed)
+}
+
+// IsSimilarity reports whether an edge is a derived similarity edge rather than a typed relation
+func (edge *Edge) IsSimilarity() bool {
+	return edge.Relation == RelationSimilar
+}
+
+// Other returns the endpoint of the edge opposite to nodeID
+func (edge *Edge) Other(nodeID int64) int64 {
+	if edge.SourceID == nodeID {
+		return edge.TargetID
+	}
+	return edge.SourceID
+}
+
+// indexEdges rebuilds the canonical edge index, dropping duplicate edges between the same pair
+func (graph *KnowledgeGraph) indexEdges() int {
+	graph.edgeIndex = make(map[edgeKey]int64)
+
+	ids := make([]int64, 0, len(graph.Edges))
+	for id := range graph.Edges {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	duplicates := 0
+	for _, id := range ids {
+		edge := graph.Edges[id]
+		key := edge.key()
+		if edge.SourceID != key.SourceID {
+			edge.SourceID, edge.TargetID = key.SourceID, key.TargetID
+			graph.touch("Edge", id)
+		}
+		if existingID, ok := graph.edgeIndex[key]; ok {
+			// Keep the older edge, carrying over the stronger weight
+			existing := graph.Edges[existingID]
+			existing.Weight = max(existing.Weight, edge.Weight)
+			delete(graph.Edges, id)
+			graph.touch("Edge", id)
+			graph.touch("Edge", existingID)
+			duplicates++
+			continue
+		}
+		graph.edgeIndex[key] = id
+	}
+	return duplicates
+}
+
+// FindEdge returns the edge between two nodes with the given relation, or nil if there is none
+func (graph *KnowledgeGraph) FindEdge(sourceID, targetID int64, relation string, directed bool) *Edge {
+	id, ok := graph.edgeIndex[canonicalEdgeKey(sourceID, targetID, relation, directed)]
+	if !ok {
+		return nil
+	}
+	return graph.Edges[id]
+}
+
+// PutEdge creates or updates the edge between two nodes, so each pair holds at most one edge per relation
+func (graph *KnowledgeGraph) PutEdge(sourceID, targetID int64, weight float64, relation string, directed bool) *Edge {
+	if edge := graph.FindEdge(sourceID, targetID, relation, directed); edge != nil {
+		if edge.Weight != weight {
+			edge.Weight = weight
+			graph.publishEdge(EventEdgeUpdated, edge)
+		}
+		return edge
+	}
+
+	key := canonicalEdgeKey(sourceID, targetID, relation, directed)
+	edge := &Edge{
+		ID:       graph.generateEdgeID(),
+		SourceID: key.SourceID,
+		TargetID: key.TargetID,
+		Weight:   weight,
+		Directed: directed,
+		Relation: relation,
+	}
+	graph.Edges[edge.ID] = edge
+	graph.edgeIndex[key] = edge.ID
+	graph.publishEdge(EventEdgeCreated, edge)
+	return edge
+}
+
+// RemoveEdge deletes an edge by ID
+func (graph *KnowledgeGraph) RemoveEdge(id int64) {
+	edge, ok := graph.Edges[id]
+	if !ok {
+		return
+	}
+	delete(graph.edgeIndex, edge.key())
+	delete(graph.Edges, id)
+	graph.publishEdge(EventEdgeRemoved, edge)
+}
+
+// removeSimilarityEdges deletes every derived similarity edge, keeping typed relations
+func (graph *KnowledgeGraph) removeSimilarityEdges() {
+	for id, edge := range graph.Edges {
+		if edge.IsSimilarity() {
+			graph.RemoveEdge(id)
+		}
+	}
+}
+
+// dedupeLoadedEdges canonicalizes edges read from storage and reports duplicates left by older builders
+func (graph *KnowledgeGraph) dedupeLoadedEdges() {
+	if duplicates := graph.indexEdges(); duplicates > 0 {
+		log.Printf("Merged %d duplicate edges", duplicates)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Graph event types
+const (
+	EventNodeAdded       = "node.added"
+	EventNodeUpdated     = "node.updated"
+	EventNodeDeleted     = "node.deleted"
+	EventEdgeCreated     = "edge.created"
+	EventEdgeUpdated     = "edge.updated"
+	EventEdgeRemoved     = "edge.removed"
+	EventConceptsUpdated = "concepts.updated"
+
+	// EventStreamReset tells a resuming subscriber that events were missed and it should reload the graph
+	EventStreamReset = "stream.reset"
+)
+
+const (
+	// eventHistorySize is the number of recent events kept so subscribers can resume after reconnecting
+	eventHistorySize = 1024
+
+	// eventBufferSize is how many events a subscriber may fall behind before it is disconnected
+	eventBufferSize = 256
+
+	// eventKeepAlive is how often an idle stream is pinged so proxies keep it open
+	eventKeepAlive = 25 * time.Second
+)
+
+// GraphEvent describes one change to a graph
+type GraphEvent struct {
+	ID       int64     `json:"id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Tenant   string    `json:"tenant,omitempty"`
+	NodeID   int64     `json:"nodeId,omitempty"`
+	Text     string    `json:"text,omitempty"`
+	Concepts []string  `json:"concepts,omitempty"`
+	EdgeID   int64     `json:"edgeId,omitempty"`
+	SourceID int64     `json:"sourceId,omitempty"`
+	TargetID int64     `json:"targetId,omitempty"`
+	Relation string    `json:"relation,omitempty"`
+	Weight   float64   `json:"weight,omitempty"`
+}
+
+// EventBus fans graph events out to subscribers and keeps a window of recent events for resuming
+type EventBus struct {
+	mu          sync.Mutex
+	lastID      int64
+	history     []GraphEvent
+	subscribers map[*EventSubscription]bool
+}
+
+// EventSubscription receives the events published after it was opened
+// Events is closed when the subscription is closed or falls too far behind
+type EventSubscription struct {
+	Events chan GraphEvent
+	bus    *EventBus
+}
+
+// NewEventBus creates an event bus with no subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*EventSubscription]bool)}
+}
+
+// Publish assigns the next ID to an event and delivers it to every subscriber
+func (bus *EventBus) Publish(event GraphEvent) GraphEvent {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.lastID++
+	event.ID = bus.lastID
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	bus.history = append(bus.history, event)
+	if len(bus.history) > eventHistorySize {
+		bus.history = bus.history[len(bus.history)-eventHistorySize:]
+	}
+
+	for subscription := range bus.subscribers {
+		select {
+		case subscription.Events <- event:
+		default:
+			// A slow subscriber is dropped rather than stalling the graph; it resumes from its last event ID
+			delete(bus.subscribers, subscription)
+			close(subscription.Events)
+		}
+	}
+	return event
+}
+
+// Subscribe opens a subscription and returns the retained events published after afterID
+// A negative afterID starts with new events only; complete is false when events after afterID are no
+// longer retained or afterID is unknown, so the subscriber may have missed changes
+func (bus *EventBus) Subscribe(afterID int64) (subscription *EventSubscription, backlog []GraphEvent, complete bool) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	subscription = &EventSubscription{Events: make(chan GraphEvent, eventBufferSize), bus: bus}
+	bus.subscribers[subscription] = true
+	if afterID < 0 || afterID == bus.lastID {
+		return subscription, nil, true
+	}
+	if afterID > bus.lastID {
+		return subscription, nil, false
+	}
+	oldest := bus.lastID - int64(len(bus.history)) + 1
+	complete = afterID+1 >= oldest
+	for _, event := range bus.history {
+		if event.ID > afterID {
+			backlog = append(backlog, event)
+		}
+	}
+	return subscription, backlog, complete
+}
+
+// LastID returns the ID of the latest event
+func (bus *EventBus) LastID() int64 {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return bus.lastID
+}
+
+// Close ends a subscription
+func (subscription *EventSubscription) Close() {
+	bus := subscription.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.subscribers[subscription] {
+		delete(bus.subscribers, subscription)
+		close(subscription.Events)
+	}
+}
+
+// publish sends an event to the graph's event bus, if it has one, and marks the records it is about as
+// changed so the next save writes them
+func (graph *KnowledgeGraph) publish(event GraphEvent) {
+	if event.NodeID != 0 {
+		graph.touch("Node", event.NodeID)
+		graph.touch("Embedding", event.NodeID)
+	}
+	if event.EdgeID != 0 {
+		graph.touch("Edge", event.EdgeID)
+	}
+	if graph.Events == nil {
+		return
+	}
+	event.Tenant = graph.Tenant
+	graph.Events.Publish(event)
+}
+
+// publishNode sends an event about a node together with its text and concepts
+func (graph *KnowledgeGraph) publishNode(eventType string, node *Node) {
+	graph.publish(GraphEvent{Type: eventType, NodeID: node.ID, Text: node.Text, Concepts: graph.NodeConcepts(node.ID)})
+}
+
+// publishEdge sends an event about an edge
+func (graph *KnowledgeGraph) publishEdge(eventType string, edge *Edge) {
+	graph.publish(GraphEvent{
+		Type:     eventType,
+		EdgeID:   edge.ID,
+		SourceID: edge.SourceID,
+		TargetID: edge.TargetID,
+		Relation: edge.Relation,
+		Weight:   edge.Weight,
+	})
+}
+
+// eventStream is a subscription opened for an HTTP client, with the events it should replay first
+type eventStream struct {
+	subscription *EventSubscription
+	replay       []GraphEvent
+	types        map[string]bool
+}
+
+// openEventStream subscribes a client to a graph's events, resuming after the Last-Event-ID header or the
+// since query parameter; the types parameter limits the stream to a comma-separated list of event types
+func openEventStream(r *http.Request, graph *KnowledgeGraph) (*eventStream, error) {
+	afterID := int64(-1)
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("since")
+	}
+	if resume != "" {
+		var err error
+		if afterID, err = strconv.ParseInt(resume, 10, 64); err != nil || afterID < 0 {
+			return nil, fmt.Errorf("invalid event ID %q", resume)
+		}
+	}
+
+	stream := &eventStream{}
+	if types := r.URL.Query().Get("types"); types != "" {
+		stream.types = make(map[string]bool)
+		for _, name := range strings.Split(types, ",") {
+			stream.types[strings.TrimSpace(name)] = true
+		}
+	}
+
+	subscription, backlog, complete := graph.Events.Subscribe(afterID)
+	stream.subscription = subscription
+	if !complete {
+		// The client missed events we no longer hold; it should reload and carry on from the latest ID
+		stream.replay = append(stream.replay, GraphEvent{ID: graph.Events.LastID(), Type: EventStreamReset, Time: time.Now().UTC(), Tenant: graph.Tenant})
+	}
+	stream.replay = append(stream.replay, backlog...)
+	return stream, nil
+}
+
+// wants reports whether the client asked for an event; resets are always delivered
+func (stream *eventStream) wants(event GraphEvent) bool {
+	return stream.types == nil || stream.types[event.Type] || event.Type == EventStreamReset
+}
+
+// handleEvents streams graph events to the client as Server-Sent Events
+func (server *Server) handleEvents(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	stream, err := openEventStream(r, ctx.Graph)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer stream.subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	send := func(event GraphEvent) error {
+		if !stream.wants(event) {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		return err
+	}
+	for _, event := range stream.replay {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if !server.stillAllowed(ctx) {
+				return
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, open := <-stream.subscription.Events:
+			if !open {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// handleEventsWebSocket streams graph events to the client over a WebSocket, one JSON text message per event
+func (server *Server) handleEventsWebSocket(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	stream, err := openEventStream(r, ctx.Graph)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer stream.subscription.Close()
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer conn.Close()
+
+	// The client sends nothing we act on, but control frames must be answered and a close ends the stream
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.readControlFrames()
+	}()
+
+	send := func(event GraphEvent) error {
+		if !stream.wants(event) {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(data)
+	}
+	for _, event := range stream.replay {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepAlive.C:
+			if !server.stillAllowed(ctx) {
+				conn.CloseWith(websocketClosePolicy, "access revoked")
+				return
+			}
+			if err := conn.Ping(); err != nil {
+				return
+			}
+		case event, open := <-stream.subscription.Events:
+			if !open {
+				conn.CloseWith(websocketCloseTryAgain, "subscriber fell behind; resume from the last event ID")
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	}
+}
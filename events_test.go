@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscriberCount returns how many subscriptions a bus delivers to
+func (bus *EventBus) subscriberCount() int {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return len(bus.subscribers)
+}
+
+// waitForSubscribers waits until a bus has the given number of subscribers
+func waitForSubscribers(t *testing.T, bus *EventBus, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for bus.subscriberCount() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("bus has %d subscribers, want %d", bus.subscriberCount(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// tenantEvents returns the event bus of a tenant's cached graph
+func (api *testAPI) tenantEvents(tenant string) *EventBus {
+	api.api.mu.Lock()
+	defer api.api.mu.Unlock()
+	return api.api.graphs[tenant].graph.Events
+}
+
+func TestEventBusFansOutToEverySubscriber(t *testing.T) {
+	bus := NewEventBus()
+	first, _, _ := bus.Subscribe(-1)
+	second, _, _ := bus.Subscribe(-1)
+	bus.Publish(GraphEvent{Type: EventNodeAdded, NodeID: 1})
+	bus.Publish(GraphEvent{Type: EventNodeUpdated, NodeID: 1})
+
+	for i, subscription := range []*EventSubscription{first, second} {
+		for want := int64(1); want <= 2; want++ {
+			if event := <-subscription.Events; event.ID != want {
+				t.Errorf("subscriber %d got event %d, want %d", i, event.ID, want)
+			}
+		}
+	}
+
+	// A resuming subscriber gets the events it missed
+	third, backlog, complete := bus.Subscribe(1)
+	if !complete || len(backlog) != 1 || backlog[0].Type != EventNodeUpdated {
+		t.Errorf("resuming after event 1 gave %+v, complete %t", backlog, complete)
+	}
+	if _, _, complete := bus.Subscribe(5); complete {
+		t.Error("resuming after an unknown event claimed to miss nothing")
+	}
+
+	first.Close()
+	first.Close()
+	if _, open := <-first.Events; open {
+		t.Error("a closed subscription still delivers")
+	}
+	bus.Publish(GraphEvent{Type: EventNodeDeleted, NodeID: 1})
+	if event := <-third.Events; event.Type != EventNodeDeleted {
+		t.Errorf("third subscriber got %s, want %s", event.Type, EventNodeDeleted)
+	}
+}
+
+func TestEventBusDropsSubscribersThatFallBehind(t *testing.T) {
+	bus := NewEventBus()
+	slow, _, _ := bus.Subscribe(-1)
+	fast, _, _ := bus.Subscribe(-1)
+	for i := 0; i <= eventBufferSize; i++ {
+		bus.Publish(GraphEvent{Type: EventNodeAdded, NodeID: int64(i + 1)})
+		if i < eventBufferSize {
+			<-fast.Events
+		}
+	}
+	if count := bus.subscriberCount(); count != 1 {
+		t.Errorf("bus has %d subscribers, want only the one that kept up", count)
+	}
+	received := 0
+	for range slow.Events {
+		received++
+	}
+	if received != eventBufferSize {
+		t.Errorf("the slow subscriber got %d events before being dropped, want %d", received, eventBufferSize)
+	}
+	slow.Close()
+}
+
+func TestEventStreamUnsubscribesWhenTheClientDisconnects(t *testing.T) {
+	api := newTestAPI(t, "alice")
+	api.addNote("alice", "", "First note")
+	bus := api.tenantEvents("alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, api.server.URL+"/api/events?types="+EventNodeAdded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+api.tokens["alice"])
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	if line, err := reader.ReadString('\n'); err != nil || line != "retry: 3000\n" {
+		t.Fatalf("stream opened with %q, %v", line, err)
+	}
+	waitForSubscribers(t, bus, 1)
+
+	api.addNote("alice", "", "Second note")
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[1] != "event: "+EventNodeAdded || !strings.Contains(lines[2], `"text":"Second note"`) {
+		t.Errorf("stream sent %q, want the added note", lines)
+	}
+
+	cancel()
+	waitForSubscribers(t, bus, 0)
+}
+
+// dialWebSocket opens a WebSocket to the stream of added notes and returns the connection and the handshake response
+func dialWebSocket(t *testing.T, api *testAPI, header string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(api.server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	request := "GET /api/events/ws?types=" + EventNodeAdded + " HTTP/1.1\r\nHost: test\r\n" +
+		"Authorization: Bearer " + api.tokens["alice"] + "\r\n" + header + "\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, response
+}
+
+// readServerFrame reads one unmasked frame sent by the server
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[0]&0x80 == 0 || head[1]&0x80 != 0 {
+		t.Fatalf("frame header %08b %08b, want a final unmasked frame", head[0], head[1])
+	}
+	length := uint64(head[1])
+	switch length {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		io.ReadFull(reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+// writeClientFrame writes one masked frame as a client must
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	t.Helper()
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventWebSocketHandshakeAndFrames(t *testing.T) {
+	api := newTestAPI(t, "alice")
+	api.addNote("alice", "", "First note")
+	bus := api.tenantEvents("alice")
+
+	// The nonce and accept value of the example in RFC 6455 section 1.3
+	upgrade := "Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n"
+	conn, reader, response := dialWebSocket(t, api, upgrade+"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n")
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake answered %d, want 101", response.StatusCode)
+	}
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept is %q", accept)
+	}
+	waitForSubscribers(t, bus, 1)
+
+	api.addNote("alice", "", "Second note")
+	opcode, payload := readServerFrame(t, reader)
+	var event GraphEvent
+	if err := json.Unmarshal(payload, &event); err != nil || opcode != websocketText {
+		t.Fatalf("got opcode %d with %q, want a JSON text message", opcode, payload)
+	}
+	if event.Type != EventNodeAdded || event.Text != "Second note" {
+		t.Errorf("got event %+v, want the added note", event)
+	}
+
+	writeClientFrame(t, conn, websocketPing, []byte("hi"))
+	if opcode, payload := readServerFrame(t, reader); opcode != websocketPong || string(payload) != "hi" {
+		t.Errorf("ping answered with opcode %d and %q, want a pong echoing it", opcode, payload)
+	}
+	writeClientFrame(t, conn, websocketClose, []byte{0x03, 0xE8})
+	if opcode, payload := readServerFrame(t, reader); opcode != websocketClose || !bytes.Equal(payload, []byte{0x03, 0xE8}) {
+		t.Errorf("close answered with opcode %d and %v, want the status echoed", opcode, payload)
+	}
+	waitForSubscribers(t, bus, 0)
+
+	// Handshakes that are not WebSocket version 13 with a 16-byte key are refused
+	for _, header := range []string{
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 8\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n",
+		upgrade + "Sec-WebSocket-Key: c2hvcnQ=\r\n",
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n",
+	} {
+		if _, _, response := dialWebSocket(t, api, header); response.StatusCode != http.StatusBadRequest {
+			t.Errorf("handshake with %q answered %d, want 400", header, response.StatusCode)
+		}
+	}
+	waitForSubscribers(t, bus, 0)
+}
+
+func TestWebSocketFrameLengthEncodings(t *testing.T) {
+	for _, length := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		server, client := net.Pipe()
+		ws := &websocketConn{conn: server}
+		payload := bytes.Repeat([]byte{'x'}, length)
+		go func() {
+			ws.WriteText(payload)
+			server.Close()
+		}()
+		data, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opcode, got := readServerFrame(t, bufio.NewReader(bytes.NewReader(data)))
+		if opcode != websocketText || !bytes.Equal(got, payload) {
+			t.Errorf("a %d-byte message came back as opcode %d with %d bytes", length, opcode, len(got))
+		}
+		header := len(data) - length
+		want := 2
+		if length > 0xFFFF {
+			want = 10
+		} else if length >= 126 {
+			want = 4
+		}
+		if header != want {
+			t.Errorf("a %d-byte message has a %d-byte header, want %d", length, header, want)
+		}
+	}
+}
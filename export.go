@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// graphWriters maps the graph file export formats to their writers
+var graphWriters = map[string]func(w io.Writer, graph *KnowledgeGraph, subgraph *Subgraph) error{
+	"graphml": WriteGraphML,
+	"gexf":    WriteGEXF,
+	"dot":     WriteDOT,
+	"cypher":  writeVerifiedCypher,
+}
+
+// runExportCommand writes the graph in a format other tools can read
+func runExportCommand(env *CommandEnv, args []string) error {
+	var options SubgraphOptions
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "markdown", "export format: markdown, graphml, gexf, dot, turtle, ntriples, jsonld, neo4j or cypher")
+	out := flags.String("out", "", "output directory (markdown, neo4j) or file (default standard output)")
+	related := flags.Int("related", vaultRelatedLimit, "number of edges listed in each note's Related section (markdown)")
+	flags.Float64Var(&options.MinWeight, "min-weight", 0, "leave out edges lighter than this")
+	flags.StringVar(&options.Filter.Concept, "concept", "", "only notes tagged with this concept")
+	flags.BoolVar(&options.Filter.Rollup, "rollup", true, "include notes tagged with narrower concepts")
+	flags.StringVar(&options.Filter.Tag, "tag", "", "only notes with this manual tag")
+	flags.Int64Var(&options.Root, "node", 0, "only notes within -depth edges of this note")
+	flags.IntVar(&options.Depth, "depth", 1, "number of edges to follow from -node")
+	since := flags.String("since", "", "only notes created at or after this date")
+	until := flags.String("until", "", "only notes created before this date")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if options.Filter.Since, err = parseTimeFlag(*since); err != nil {
+		return err
+	}
+	if options.Filter.Until, err = parseTimeFlag(*until); err != nil {
+		return err
+	}
+
+	if *format == "markdown" {
+		if *out == "" {
+			return errors.New("usage: export -format markdown -out <dir>")
+		}
+		if err := ExportMarkdownVault(env.Graph, *out, *related, time.Now()); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d notes to %s\n", len(env.Graph.Nodes), *out)
+		return nil
+	}
+
+	subgraph, err := SelectSubgraph(env.Graph, options)
+	if err != nil {
+		return err
+	}
+	if *format == "neo4j" {
+		if *out == "" {
+			return errors.New("usage: export -format neo4j -out <dir>")
+		}
+		if err := ExportNeo4jCSV(env.Graph, subgraph, *out); err != nil {
+			return err
+		}
+		// Read the files back the way neo4j-admin will, so a malformed export fails here rather than in the import
+		records, err := ReadNeo4jCSV(*out)
+		if err != nil {
+			return fmt.Errorf("export does not parse: %v", err)
+		}
+		if err := verifyNeo4jRecords(env.Graph, subgraph, records); err != nil {
+			return fmt.Errorf("export does not round-trip: %v", err)
+		}
+		fmt.Printf("Exported %d notes to %s; load them with:\n%s\n", len(subgraph.NodeIDs), *out, neo4jImportCommand(*out))
+		return nil
+	}
+
+	write, ok := graphWriters[*format]
+	if writeRDF, isRDF := rdfWriters[*format]; isRDF {
+		write, ok = func(w io.Writer, graph *KnowledgeGraph, subgraph *Subgraph) error {
+			return writeRDF(w, GraphTriples(graph, subgraph))
+		}, true
+	}
+	if !ok {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+	if *out == "" {
+		return write(os.Stdout, env.Graph, subgraph)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+	if err := write(file, env.Graph, subgraph); err != nil {
+		return fmt.Errorf("failed to write export: %v", err)
+	}
+	return file.Close()
+}
@@ -1,4 +1,4 @@
-module main
+module knowledgegraph
 
 go 1.21.6
 
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// clusterIterations bounds the label propagation rounds of ClusterNodes
+const clusterIterations = 20
+
+// exportLabelLength is the length at which node labels are cut in exported graphs
+const exportLabelLength = 60
+
+// Subgraph is the selection of nodes and edges written by the graph exporters
+type Subgraph struct {
+	NodeIDs []int64
+	Edges   []*Edge
+	// Clusters maps every selected node to its cluster, numbered from 1
+	Clusters map[int64]int
+}
+
+// SubgraphOptions selects the part of the graph to export
+type SubgraphOptions struct {
+	// Filter selects the notes to export
+	Filter NodeFilter
+	// Root, when set, restricts the export to the notes within Depth edges of that note
+	Root  int64
+	Depth int
+	// MinWeight drops edges lighter than it
+	MinWeight float64
+}
+
+// SelectSubgraph returns the notes and edges matching the options, with the notes clustered by
+// the edges kept between them
+func SelectSubgraph(graph *KnowledgeGraph, options SubgraphOptions) (*Subgraph, error) {
+	selected := make(map[int64]bool)
+	for _, id := range SearchNodes(graph, options.Filter) {
+		selected[id] = true
+	}
+
+	keep := func(edge *Edge) bool {
+		return edge.Weight >= options.MinWeight && selected[edge.SourceID] && selected[edge.TargetID]
+	}
+
+	if options.Root != 0 {
+		if !selected[options.Root] {
+			return nil, fmt.Errorf("node %d not found or filtered out", options.Root)
+		}
+		// Walk outwards from the root over the edges that survive the filters
+		within := map[int64]bool{options.Root: true}
+		frontier := []int64{options.Root}
+		for depth := 0; depth < options.Depth && len(frontier) > 0; depth++ {
+			var next []int64
+			for _, edge := range graph.Edges {
+				if !keep(edge) {
+					continue
+				}
+				for _, id := range frontier {
+					if edge.SourceID != id && edge.TargetID != id {
+						continue
+					}
+					if other := edge.Other(id); !within[other] {
+						within[other] = true
+						next = append(next, other)
+					}
+				}
+			}
+			frontier = next
+		}
+		selected = within
+	}
+
+	subgraph := &Subgraph{}
+	for id := range selected {
+		subgraph.NodeIDs = append(subgraph.NodeIDs, id)
+	}
+	sort.Slice(subgraph.NodeIDs, func(i, j int) bool { return subgraph.NodeIDs[i] < subgraph.NodeIDs[j] })
+	for _, edge := range graph.Edges {
+		if keep(edge) {
+			subgraph.Edges = append(subgraph.Edges, edge)
+		}
+	}
+	sort.Slice(subgraph.Edges, func(i, j int) bool { return subgraph.Edges[i].ID < subgraph.Edges[j].ID })
+	subgraph.Clusters = ClusterNodes(subgraph.NodeIDs, subgraph.Edges)
+	return subgraph, nil
+}
+
+// ClusterNodes groups nodes into communities by weighted label propagation: every node repeatedly
+// adopts the label carrying the most edge weight among its neighbours. Nodes are visited in ID order
+// and ties go to the smaller label, so the result is deterministic. Clusters are numbered from 1 in
+// order of their smallest node ID.
+func ClusterNodes(nodeIDs []int64, edges []*Edge) map[int64]int {
+	neighbours := make(map[int64]map[int64]float64)
+	for _, edge := range edges {
+		for _, pair := range [][2]int64{{edge.SourceID, edge.TargetID}, {edge.TargetID, edge.SourceID}} {
+			if neighbours[pair[0]] == nil {
+				neighbours[pair[0]] = make(map[int64]float64)
+			}
+			neighbours[pair[0]][pair[1]] += edge.Weight
+		}
+	}
+
+	labels := make(map[int64]int64, len(nodeIDs))
+	for _, id := range nodeIDs {
+		labels[id] = id
+	}
+	for round := 0; round < clusterIterations; round++ {
+		changed := false
+		for _, id := range nodeIDs {
+			votes := make(map[int64]float64)
+			for otherID, weight := range neighbours[id] {
+				if label, ok := labels[otherID]; ok {
+					votes[label] += weight
+				}
+			}
+			best, bestVotes := labels[id], votes[labels[id]]
+			for label, count := range votes {
+				if count > bestVotes || (count == bestVotes && label < best) {
+					best, bestVotes = label, count
+				}
+			}
+			if best != labels[id] {
+				labels[id] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	clusters := make(map[int64]int, len(nodeIDs))
+	numbers := make(map[int64]int)
+	for _, id := range nodeIDs {
+		if _, ok := numbers[labels[id]]; !ok {
+			numbers[labels[id]] = len(numbers) + 1
+		}
+		clusters[id] = numbers[labels[id]]
+	}
+	return clusters
+}
+
+// SharedConcepts returns the concepts two notes have in common, in the first note's order
+func (graph *KnowledgeGraph) SharedConcepts(nodeID, otherID int64) []string {
+	others := make(map[int64]bool)
+	for _, id := range graph.NodeConceptIDs(otherID) {
+		others[id] = true
+	}
+	var shared []string
+	for _, id := range graph.NodeConceptIDs(nodeID) {
+		if others[id] {
+			shared = append(shared, graph.Concepts[id].Name)
+		}
+	}
+	return shared
+}
+
+// WriteGraphML writes a subgraph as GraphML, readable by yEd, Gephi and most graph libraries
+func WriteGraphML(w io.Writer, graph *KnowledgeGraph, subgraph *Subgraph) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, key := range []struct{ id, scope, kind string }{
+		{"label", "node", "string"},
+		{"text", "node", "string"},
+		{"concepts", "node", "string"},
+		{"cluster", "node", "int"},
+		{"created", "node", "string"},
+		{"source", "node", "string"},
+		{"weight", "edge", "double"},
+		{"relation", "edge", "string"},
+		{"shared_concepts", "edge", "string"},
+	} {
+		fmt.Fprintf(&b, `  <key id="%s" for="%s" attr.name="%s" attr.type="%s"/>`+"\n", key.id, key.scope, key.id, key.kind)
+	}
+	b.WriteString(`  <graph id="knowledge-graph" edgedefault="undirected">` + "\n")
+
+	for _, id := range subgraph.NodeIDs {
+		node := graph.Nodes[id]
+		fmt.Fprintf(&b, `    <node id="n%d">`+"\n", id)
+		for _, data := range [][2]string{
+			{"label", exportLabel(node.Text)},
+			{"text", node.Text},
+			{"concepts", strings.Join(graph.NodeConcepts(id), ", ")},
+			{"cluster", fmt.Sprint(subgraph.Clusters[id])},
+			{"created", formatTime(node.CreatedAt)},
+			{"source", node.Source},
+		} {
+			fmt.Fprintf(&b, `      <data key="%s">%s</data>`+"\n", data[0], xmlEscape(data[1]))
+		}
+		b.WriteString("    </node>\n")
+	}
+
+	for _, edge := range subgraph.Edges {
+		fmt.Fprintf(&b, `    <edge id="e%d" source="n%d" target="n%d" directed="%t">`+"\n", edge.ID, edge.SourceID, edge.TargetID, edge.Directed)
+		for _, data := range [][2]string{
+			{"weight", fmt.Sprintf("%f", edge.Weight)},
+			{"relation", edge.Relation},
+			{"shared_concepts", strings.Join(graph.SharedConcepts(edge.SourceID, edge.TargetID), ", ")},
+		} {
+			fmt.Fprintf(&b, `      <data key="%s">%s</data>`+"\n", data[0], xmlEscape(data[1]))
+		}
+		b.WriteString("    </edge>\n")
+	}
+
+	b.WriteString("  </graph>\n</graphml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteGEXF writes a subgraph as GEXF 1.3, Gephi's native format
+func WriteGEXF(w io.Writer, graph *KnowledgeGraph, subgraph *Subgraph) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<gexf xmlns="http://gexf.net/1.3" version="1.3">` + "\n")
+	b.WriteString(`  <graph defaultedgetype="undirected" mode="static">` + "\n")
+	b.WriteString(`    <attributes class="node">` + "\n")
+	b.WriteString(`      <attribute id="text" title="text" type="string"/>` + "\n")
+	b.WriteString(`      <attribute id="concepts" title="concepts" type="string"/>` + "\n")
+	b.WriteString(`      <attribute id="cluster" title="cluster" type="integer"/>` + "\n")
+	b.WriteString(`      <attribute id="created" title="created" type="string"/>` + "\n")
+	b.WriteString("    </attributes>\n")
+	b.WriteString(`    <attributes class="edge">` + "\n")
+	b.WriteString(`      <attribute id="relation" title="relation" type="string"/>` + "\n")
+	b.WriteString(`      <attribute id="shared_concepts" title="shared_concepts" type="string"/>` + "\n")
+	b.WriteString("    </attributes>\n")
+
+	b.WriteString("    <nodes>\n")
+	for _, id := range subgraph.NodeIDs {
+		node := graph.Nodes[id]
+		fmt.Fprintf(&b, `      <node id="%d" label="%s">`+"\n", id, xmlEscape(exportLabel(node.Text)))
+		b.WriteString("        <attvalues>\n")
+		for _, value := range [][2]string{
+			{"text", node.Text},
+			{"concepts", strings.Join(graph.NodeConcepts(id), ", ")},
+			{"cluster", fmt.Sprint(subgraph.Clusters[id])},
+			{"created", formatTime(node.CreatedAt)},
+		} {
+			fmt.Fprintf(&b, `          <attvalue for="%s" value="%s"/>`+"\n", value[0], xmlEscape(value[1]))
+		}
+		b.WriteString("        </attvalues>\n      </node>\n")
+	}
+	b.WriteString("    </nodes>\n")
+
+	b.WriteString("    <edges>\n")
+	for _, edge := range subgraph.Edges {
+		kind := "undirected"
+		if edge.Directed {
+			kind = "directed"
+		}
+		fmt.Fprintf(&b, `      <edge id="%d" source="%d" target="%d" weight="%f" type="%s" label="%s">`+"\n",
+			edge.ID, edge.SourceID, edge.TargetID, edge.Weight, kind, xmlEscape(edge.Relation))
+		b.WriteString("        <attvalues>\n")
+		fmt.Fprintf(&b, `          <attvalue for="relation" value="%s"/>`+"\n", xmlEscape(edge.Relation))
+		fmt.Fprintf(&b, `          <attvalue for="shared_concepts" value="%s"/>`+"\n",
+			xmlEscape(strings.Join(graph.SharedConcepts(edge.SourceID, edge.TargetID), ", ")))
+		b.WriteString("        </attvalues>\n      </edge>\n")
+	}
+	b.WriteString("    </edges>\n")
+
+	b.WriteString("  </graph>\n</gexf>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteDOT writes a subgraph in Graphviz DOT, drawing every cluster as a Graphviz cluster
+// Graphviz has no attribute for fractional edge weights, so the weight drives the pen width and
+// is kept verbatim in kg_weight
+func WriteDOT(w io.Writer, graph *KnowledgeGraph, subgraph *Subgraph) error {
+	var b strings.Builder
+	b.WriteString("digraph knowledge_graph {\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+
+	byCluster := make(map[int][]int64)
+	var clusters []int
+	for _, id := range subgraph.NodeIDs {
+		cluster := subgraph.Clusters[id]
+		if len(byCluster[cluster]) == 0 {
+			clusters = append(clusters, cluster)
+		}
+		byCluster[cluster] = append(byCluster[cluster], id)
+	}
+	for _, cluster := range clusters {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%s;\n", cluster, dotQuote(fmt.Sprintf("cluster %d", cluster)))
+		for _, id := range byCluster[cluster] {
+			node := graph.Nodes[id]
+			fmt.Fprintf(&b, "    n%d [label=%s, tooltip=%s, kg_concepts=%s];\n", id,
+				dotQuote(exportLabel(node.Text)), dotQuote(node.Text), dotQuote(strings.Join(graph.NodeConcepts(id), ", ")))
+		}
+		b.WriteString("  }\n")
+	}
+
+	for _, edge := range subgraph.Edges {
+		fmt.Fprintf(&b, "  n%d -> n%d [label=%s, penwidth=%.2f, kg_weight=%f, kg_shared=%s",
+			edge.SourceID, edge.TargetID, dotQuote(edge.Relation), 1+3*edge.Weight, edge.Weight,
+			dotQuote(strings.Join(graph.SharedConcepts(edge.SourceID, edge.TargetID), ", ")))
+		if !edge.Directed {
+			b.WriteString(", dir=none")
+		}
+		b.WriteString("];\n")
+	}
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// exportLabel shortens note text to a label for graph drawings
+func exportLabel(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= exportLabelLength {
+		return text
+	}
+	return string([]rune(text)[:exportLabelLength-1]) + "…"
+}
+
+// xmlEscape escapes text for XML content and attribute values, replacing the control characters and
+// noncharacters XML 1.0 cannot represent even as character references with U+FFFD
+func xmlEscape(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0xFFFE || r == 0xFFFF {
+			return utf8.RuneError
+		}
+		return r
+	}, text)
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;",
+		"\t", "&#9;", "\n", "&#10;", "\r", "&#13;").Replace(text)
+}
+
+// dotQuote quotes a DOT string
+func dotQuote(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(text) + `"`
+}
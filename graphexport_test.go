@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+func TestGraphExportsAreWellFormedXMLWithControlCharacters(t *testing.T) {
+	graph := NewKnowledgeGraph()
+	texts := []string{"Bell\a and null\x00 from a paste", "Form\ffeed, tab\tand\r\nline <breaks> & \"quotes\"", "Noncharacter ￾￿"}
+	for _, text := range texts {
+		if _, err := AddNote(graph, text, []string{"paste\x1b"}, NodeMetadata{Source: "clip\x02board"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subgraph, err := SelectSubgraph(graph, SubgraphOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subgraph.NodeIDs) != len(texts) {
+		t.Fatalf("selected %d notes, want %d", len(subgraph.NodeIDs), len(texts))
+	}
+
+	for _, format := range []struct {
+		name  string
+		write func(io.Writer, *KnowledgeGraph, *Subgraph) error
+	}{
+		{"GraphML", WriteGraphML},
+		{"GEXF", WriteGEXF},
+	} {
+		var out bytes.Buffer
+		if err := format.write(&out, graph, subgraph); err != nil {
+			t.Fatal(err)
+		}
+		decoder := xml.NewDecoder(&out)
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s export is not well-formed XML: %v", format.name, err)
+				break
+			}
+		}
+	}
+}
+
+func TestXMLEscapeKeepsAllowedWhitespace(t *testing.T) {
+	if got, want := xmlEscape("a\tb\nc\rd\x00e"), "a&#9;b&#10;c&#13;d�e"; got != want {
+		t.Errorf("xmlEscape = %q, want %q", got, want)
+	}
+}
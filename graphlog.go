@@ -0,0 +1,809 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The graph file is a compacted snapshot; saves append the records that changed since to <graph file>.log,
+// and compaction moves those entries to <graph file>.history, which keeps every change for point-in-time queries
+const (
+	// graphLogCompactEntries is how many log entries accumulate before the snapshot is rewritten
+	graphLogCompactEntries = 200
+
+	// graphLogCompactBytes is how large the log may grow before the snapshot is rewritten
+	graphLogCompactBytes = 8 << 20
+)
+
+// graphRecordKinds orders record kinds the way the graph file lists them
+var graphRecordKinds = map[string]int{
+	"Setting":         0,
+	"LastID":          1,
+	"Node":            2,
+	"Embedding":       3,
+	"Edge":            4,
+	"Concept":         5,
+	"Membership":      6,
+	"ConceptRelation": 7,
+	"Vertex":          8,
+	"Redirect":        9,
+}
+
+// graphLogEntry is one saved change: the records written or replaced and the records deleted
+// A reset entry replaces the whole graph, as when a graph is written out in full
+type graphLogEntry struct {
+	Seq    int64             `json:"seq"`
+	Time   time.Time         `json:"time"`
+	Reset  bool              `json:"reset,omitempty"`
+	Put    map[string]string `json:"put,omitempty"`
+	Delete []string          `json:"delete,omitempty"`
+}
+
+// GraphLog is the mutation log of a graph file
+type GraphLog struct {
+	// Path is the graph file holding the latest snapshot
+	Path string
+
+	// records holds the graph's records, keyed by recordKey, as of the last logged entry
+	records map[string]string
+	lastSeq int64
+
+	// pending and pendingBytes measure the log written since the snapshot
+	pending      int
+	pendingBytes int64
+
+	// recovered is set when the snapshot was damaged and rebuilt, so it must be rewritten
+	recovered bool
+}
+
+// graphLogPath returns the log of changes made since a graph file's snapshot
+func graphLogPath(graphFilePath string) string {
+	return graphFilePath + ".log"
+}
+
+// graphHistoryPath returns the archive of a graph file's compacted log entries
+func graphHistoryPath(graphFilePath string) string {
+	return graphFilePath + ".history"
+}
+
+// recordKey identifies the graph element a graph file line belongs to; node metadata lines belong to their node
+func recordKey(line string) (string, bool) {
+	kind, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return "", false
+	}
+	switch kind {
+	case "Setting", "LastID":
+		name, _, _ := strings.Cut(rest, "=")
+		return kind + " " + name, true
+	case "Node", "NodeMeta", "SourcePath", "Author", "Tag", "Attachment":
+		kind = "Node"
+	case "Embedding", "Edge", "Concept", "Membership", "ConceptRelation", "Vertex", "Redirect":
+	default:
+		// The "Concepts:" summary and the snapshot header are not graph elements
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", false
+	}
+	return kind + " " + id, true
+}
+
+// splitRecords groups the lines of a graph file by record key and returns the log sequence of its snapshot header
+func splitRecords(r io.Reader) (map[string]string, int64, error) {
+	records := make(map[string]string)
+	var seq int64
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Snapshot ") {
+			fields := parseRecordFields(strings.TrimPrefix(line, "Snapshot "))
+			var err error
+			if seq, err = strconv.ParseInt(fields["Seq"], 10, 64); err != nil {
+				return nil, 0, fmt.Errorf("failed to parse snapshot header: %v", err)
+			}
+			continue
+		}
+		if key, ok := recordKey(line); ok {
+			records[key] += line + "\n"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error while scanning graph: %v", err)
+	}
+	return records, seq, nil
+}
+
+// graphRecords serializes a graph into records keyed by recordKey
+func graphRecords(graph *KnowledgeGraph) (map[string]string, error) {
+	var b bytes.Buffer
+	if err := writeGraphFile(&b, graph); err != nil {
+		return nil, err
+	}
+	records, _, err := splitRecords(&b)
+	return records, err
+}
+
+// renderRecords writes records back out in graph file order, so node lines precede their metadata and embeddings
+func renderRecords(records map[string]string) string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		kindI, idI, _ := strings.Cut(keys[i], " ")
+		kindJ, idJ, _ := strings.Cut(keys[j], " ")
+		if kindI != kindJ {
+			return graphRecordKinds[kindI] < graphRecordKinds[kindJ]
+		}
+		numI, errI := strconv.ParseInt(idI, 10, 64)
+		numJ, errJ := strconv.ParseInt(idJ, 10, 64)
+		if errI == nil && errJ == nil {
+			return numI < numJ
+		}
+		return idI < idJ
+	})
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(records[key])
+	}
+	return b.String()
+}
+
+// diffRecords returns the entry turning one set of records into another, or nil if they are the same
+func diffRecords(from, to map[string]string) *graphLogEntry {
+	entry := &graphLogEntry{Put: make(map[string]string)}
+	for key, record := range to {
+		if from[key] != record {
+			entry.Put[key] = record
+		}
+	}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			entry.Delete = append(entry.Delete, key)
+		}
+	}
+	if len(entry.Put) == 0 && len(entry.Delete) == 0 {
+		return nil
+	}
+	sort.Strings(entry.Delete)
+	return entry
+}
+
+// apply replays an entry onto a set of records
+func (entry graphLogEntry) apply(records map[string]string) {
+	if entry.Reset {
+		clear(records)
+	}
+	for key, record := range entry.Put {
+		records[key] = record
+	}
+	for _, key := range entry.Delete {
+		delete(records, key)
+	}
+}
+
+// encodeLogEntry frames an entry as one line prefixed with the CRC-32 of its JSON, so a torn write is detected
+func encodeLogEntry(entry graphLogEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode log entry: %v", err)
+	}
+	return fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(data), data), nil
+}
+
+// decodeLogEntry parses a line written by encodeLogEntry
+func decodeLogEntry(line []byte) (graphLogEntry, error) {
+	var entry graphLogEntry
+	sum, data, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return entry, errors.New("missing checksum")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return entry, fmt.Errorf("invalid checksum: %v", err)
+	}
+	if crc32.ChecksumIEEE(data) != uint32(want) {
+		return entry, errors.New("checksum mismatch")
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, fmt.Errorf("invalid entry: %v", err)
+	}
+	return entry, nil
+}
+
+// readLogEntries reads the entries of a log file, which may not exist yet
+// A final record cut short by a crash is skipped, and with repair set it is also truncated away so later
+// appends start on a clean line; damage anywhere else is an error
+func readLogEntries(path string, repair bool) ([]graphLogEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read log: %v", err)
+	}
+
+	var entries []graphLogEntry
+	for offset, lineNumber := 0, 1; offset < len(data); lineNumber++ {
+		end := bytes.IndexByte(data[offset:], '\n')
+		var entry graphLogEntry
+		if end >= 0 {
+			entry, err = decodeLogEntry(data[offset : offset+end])
+		}
+		if end < 0 || err != nil {
+			if end >= 0 && offset+end+1 < len(data) {
+				return nil, fmt.Errorf("log %s is corrupt at line %d: %v", path, lineNumber, err)
+			}
+			if repair {
+				if err := os.Truncate(path, int64(offset)); err != nil {
+					return nil, fmt.Errorf("failed to discard torn log record: %v", err)
+				}
+				log.Printf("Discarded a torn record at the end of %s", path)
+			}
+			break
+		}
+		entries = append(entries, entry)
+		offset += end + 1
+	}
+	return entries, nil
+}
+
+// appendLog appends whole entry lines to a log file and syncs it to disk
+// A partial line left by an earlier crash is cut off first so it cannot swallow the new entries
+func appendLog(path string, lines []byte) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %v", err)
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %v", err)
+	}
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			return fmt.Errorf("failed to read log: %v", err)
+		}
+		if last[0] != '\n' {
+			data, err := io.ReadAll(io.NewSectionReader(file, 0, size))
+			if err != nil {
+				return fmt.Errorf("failed to read log: %v", err)
+			}
+			size = int64(bytes.LastIndexByte(data, '\n') + 1)
+			if err := file.Truncate(size); err != nil {
+				return fmt.Errorf("failed to discard torn log record: %v", err)
+			}
+		}
+	}
+
+	if _, err := file.WriteAt(lines, size); err != nil {
+		return fmt.Errorf("failed to append to log: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync log: %v", err)
+	}
+	return nil
+}
+
+// writeSnapshot replaces a graph file with a snapshot of the graph as of log entry seq
+// The previous file is kept as the newest backup, and the snapshot ends with a checksum of everything before it
+func writeSnapshot(path string, graph *KnowledgeGraph, seq int64) error {
+	if err := rotateBackups(path); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, 0o644, func(w io.Writer) error {
+		hash := sha256.New()
+		out := io.MultiWriter(w, hash)
+		if _, err := fmt.Fprintf(out, "Snapshot Seq=%d, Time=%s\n", seq, formatTime(time.Now())); err != nil {
+			return fmt.Errorf("failed to write snapshot header: %v", err)
+		}
+		if err := writeGraphFile(out, graph); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "Checksum sha256=%x\n", hash.Sum(nil)); err != nil {
+			return fmt.Errorf("failed to write checksum: %v", err)
+		}
+		return nil
+	})
+}
+
+// readSnapshot reads a graph file's records and the log sequence it was taken at, verifying its checksum
+func readSnapshot(path string) (map[string]string, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	if err := verifySnapshot(data); err != nil {
+		return nil, 0, err
+	}
+	return splitRecords(bytes.NewReader(data))
+}
+
+// verifySnapshot checks the checksum ending a snapshot
+// Graph files written before snapshots have neither a header nor a checksum and are accepted as they are
+func verifySnapshot(data []byte) error {
+	body := bytes.TrimSuffix(data, []byte("\n"))
+	start := bytes.LastIndexByte(body, '\n') + 1
+	trailer, ok := bytes.CutPrefix(body[start:], []byte("Checksum "))
+	if !ok {
+		if bytes.HasPrefix(data, []byte("Snapshot ")) {
+			return errors.New("graph file is truncated: its checksum is missing")
+		}
+		return nil
+	}
+	sum := sha256.Sum256(data[:start])
+	if parseRecordFields(string(trailer))["sha256"] != hex.EncodeToString(sum[:]) {
+		return errors.New("graph file is damaged: its checksum does not match")
+	}
+	return nil
+}
+
+// recoverSnapshot rebuilds a graph file's records from its history, or from its newest intact backup and the
+// entries logged after it when the history is incomplete
+func recoverSnapshot(path string) (map[string]string, int64, error) {
+	entries, err := graphLogEntries(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	records := make(map[string]string)
+	seq := int64(-1)
+	if len(entries) == 0 || !entries[0].Reset {
+		found := false
+		for n := 1; n <= graphBackupCount && !found; n++ {
+			if records, seq, err = readSnapshot(graphBackupPath(path, n)); err == nil {
+				log.Printf("Recovering %s from backup %s", path, graphBackupPath(path, n))
+				found = true
+			}
+		}
+		if !found {
+			return nil, 0, fmt.Errorf("no history or intact backup to recover %s from", path)
+		}
+	}
+	for _, entry := range entries {
+		if entry.Seq > seq {
+			entry.apply(records)
+			seq = entry.Seq
+		}
+	}
+	return records, seq, nil
+}
+
+// openGraphLog reads a graph file's snapshot and replays the entries logged after it
+func openGraphLog(path string) (*GraphLog, error) {
+	records, seq, err := readSnapshot(path)
+	recovered := false
+	if err != nil {
+		log.Printf("Cannot read %s (%v); recovering it", path, err)
+		if records, seq, err = recoverSnapshot(path); err != nil {
+			return nil, err
+		}
+
+		// The damaged file is kept for inspection and replaced once the graph is loaded
+		if err := os.Rename(path, path+".damaged"); err != nil {
+			return nil, fmt.Errorf("failed to set damaged graph file aside: %v", err)
+		}
+		recovered = true
+	}
+
+	// Files from before the log have no history; the snapshot becomes its baseline
+	if _, err := os.Stat(graphHistoryPath(path)); os.IsNotExist(err) {
+		baseline := graphLogEntry{Seq: seq, Time: time.Now().UTC(), Reset: true, Put: records}
+		if info, err := os.Stat(path); err == nil {
+			baseline.Time = info.ModTime().UTC()
+		}
+		line, err := encodeLogEntry(baseline)
+		if err != nil {
+			return nil, err
+		}
+		if err := appendLog(graphHistoryPath(path), line); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := readLogEntries(graphLogPath(path), true)
+	if err != nil {
+		return nil, err
+	}
+	journal := &GraphLog{Path: path, records: records, lastSeq: seq, recovered: recovered}
+	for _, entry := range entries {
+		// Entries up to the snapshot remain if a crash interrupted compaction
+		if entry.Seq <= journal.lastSeq {
+			continue
+		}
+		entry.apply(journal.records)
+		journal.lastSeq = entry.Seq
+		journal.pending++
+	}
+	if info, err := os.Stat(graphLogPath(path)); err == nil {
+		journal.pendingBytes = info.Size()
+	}
+	return journal, nil
+}
+
+// ReadGraph loads a graph file with the changes logged since its snapshot, leaving its files untouched
+func ReadGraph(path string) (*KnowledgeGraph, error) {
+	records, seq, err := readSnapshot(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readLogEntries(graphLogPath(path), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Seq > seq {
+			entry.apply(records)
+		}
+	}
+	return parseGraph(strings.NewReader(renderRecords(records)))
+}
+
+// createGraphLog writes a graph out in full to a new snapshot and records it in the history as a reset
+func createGraphLog(path string, graph *KnowledgeGraph) (*GraphLog, error) {
+	records, err := graphRecords(graph)
+	if err != nil {
+		return nil, err
+	}
+	lastSeq, err := latestLogSeq(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := graphLogEntry{Seq: lastSeq + 1, Time: time.Now().UTC(), Reset: true, Put: records}
+	line, err := encodeLogEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err := appendLog(graphHistoryPath(path), line); err != nil {
+		return nil, err
+	}
+	if err := writeSnapshot(path, graph, entry.Seq); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(graphLogPath(path)); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to clear log: %v", err)
+	}
+	return &GraphLog{Path: path, records: records, lastSeq: entry.Seq}, nil
+}
+
+// latestLogSeq returns the highest log sequence recorded for a graph file, or 0 if there is none
+func latestLogSeq(path string) (int64, error) {
+	var seq int64
+	if _, snapshotSeq, err := readSnapshot(path); err == nil {
+		seq = snapshotSeq
+	}
+	entries, err := graphLogEntries(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) > 0 {
+		seq = max(seq, entries[len(entries)-1].Seq)
+	}
+	return seq, nil
+}
+
+// graphLogEntries returns every entry recorded for a graph file, oldest first
+func graphLogEntries(path string) ([]graphLogEntry, error) {
+	history, err := readLogEntries(graphHistoryPath(path), false)
+	if err != nil {
+		return nil, err
+	}
+	active, err := readLogEntries(graphLogPath(path), false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are archived before the log is cleared, so a crash in between leaves them in both
+	var entries []graphLogEntry
+	for _, entry := range append(history, active...) {
+		if len(entries) > 0 && entry.Seq <= entries[len(entries)-1].Seq {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// LastSeq returns the number of the latest log entry
+func (journal *GraphLog) LastSeq() int64 {
+	return journal.lastSeq
+}
+
+// touch marks the record of a graph element as changed since the last save
+// Node and edge events mark their records as they are published; other mutations call it directly
+func (graph *KnowledgeGraph) touch(kind string, id int64) {
+	if graph.dirty == nil {
+		graph.dirty = make(map[string]bool)
+	}
+	graph.dirty[kind+" "+strconv.FormatInt(id, 10)] = true
+}
+
+// touchAll marks every record as changed, for changes that replace the graph wholesale
+func (graph *KnowledgeGraph) touchAll() {
+	graph.dirtyAll = true
+}
+
+// clearDirty forgets the changes once they are saved
+func (graph *KnowledgeGraph) clearDirty() {
+	graph.dirty = nil
+	graph.dirtyAll = false
+}
+
+// renderRecord writes the current record under a key, or returns "" when its element no longer exists
+func (graph *KnowledgeGraph) renderRecord(key string) (string, error) {
+	kind, idText, _ := strings.Cut(key, " ")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid record key %q", key)
+	}
+	var b bytes.Buffer
+	switch kind {
+	case "Node":
+		if node, ok := graph.Nodes[id]; ok {
+			err = writeNodeRecord(&b, node)
+		}
+	case "Embedding":
+		if node, ok := graph.Nodes[id]; ok {
+			err = writeEmbeddingRecord(&b, node)
+		}
+	case "Edge":
+		if edge, ok := graph.Edges[id]; ok {
+			err = writeEdgeRecord(&b, edge)
+		}
+	case "Concept":
+		if concept, ok := graph.Concepts[id]; ok {
+			err = writeConceptRecord(&b, concept)
+		}
+	case "Membership":
+		if membership, ok := graph.Memberships[id]; ok {
+			err = writeMembershipRecord(&b, membership)
+		}
+	case "ConceptRelation":
+		if relation, ok := graph.ConceptRelations[id]; ok {
+			err = writeConceptRelationRecord(&b, relation)
+		}
+	case "Redirect":
+		if target, ok := graph.Redirects[id]; ok {
+			err = writeRedirectRecord(&b, id, target)
+		}
+	default:
+		return "", fmt.Errorf("invalid record key %q", key)
+	}
+	return b.String(), err
+}
+
+// changes returns the entry recording the graph's changes since the last save, or nil if there are none
+// Only the touched records, the settings and the ID counters, which are a handful of lines, are rendered
+func (journal *GraphLog) changes(graph *KnowledgeGraph) (*graphLogEntry, error) {
+	if graph.dirtyAll {
+		records, err := graphRecords(graph)
+		if err != nil {
+			return nil, err
+		}
+		return diffRecords(journal.records, records), nil
+	}
+
+	var settings bytes.Buffer
+	if err := writeSettings(&settings, graph.Settings); err != nil {
+		return nil, err
+	}
+	if err := writeIDCounters(&settings, graph.ids); err != nil {
+		return nil, err
+	}
+	current, _, err := splitRecords(&settings)
+	if err != nil {
+		return nil, err
+	}
+	for key := range graph.dirty {
+		if current[key], err = graph.renderRecord(key); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := &graphLogEntry{Put: make(map[string]string)}
+	for key, record := range current {
+		if record == "" {
+			if _, ok := journal.records[key]; ok {
+				entry.Delete = append(entry.Delete, key)
+			}
+		} else if journal.records[key] != record {
+			entry.Put[key] = record
+		}
+	}
+	if len(entry.Put) == 0 && len(entry.Delete) == 0 {
+		return nil, nil
+	}
+	sort.Strings(entry.Delete)
+	return entry, nil
+}
+
+// Commit appends the records that changed since the last save, compacting the log once it grows large
+func (journal *GraphLog) Commit(graph *KnowledgeGraph) error {
+	entry, err := journal.changes(graph)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		graph.clearDirty()
+		return nil
+	}
+	entry.Seq = journal.lastSeq + 1
+	entry.Time = time.Now().UTC()
+	line, err := encodeLogEntry(*entry)
+	if err != nil {
+		return err
+	}
+	if err := appendLog(graphLogPath(journal.Path), line); err != nil {
+		return err
+	}
+
+	entry.apply(journal.records)
+	graph.clearDirty()
+	journal.lastSeq = entry.Seq
+	journal.pending++
+	journal.pendingBytes += int64(len(line))
+	if journal.pending >= graphLogCompactEntries || journal.pendingBytes >= graphLogCompactBytes {
+		return journal.Compact(graph)
+	}
+	return nil
+}
+
+// Compact archives the log into the history and rewrites the snapshot, so loading needs no replay
+// The graph must be saved, as Commit leaves it
+func (journal *GraphLog) Compact(graph *KnowledgeGraph) error {
+	logPath := graphLogPath(journal.Path)
+	data, err := os.ReadFile(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read log: %v", err)
+	}
+	if len(data) > 0 {
+		if err := appendLog(graphHistoryPath(journal.Path), data); err != nil {
+			return err
+		}
+	}
+	if err := writeSnapshot(journal.Path, graph, journal.lastSeq); err != nil {
+		return err
+	}
+	if err := os.Remove(logPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clear log: %v", err)
+	}
+	journal.pending = 0
+	journal.pendingBytes = 0
+	journal.recovered = false
+	return nil
+}
+
+// ReplayGraphLog reconstructs a graph file's graph as it was saved at a point in time
+func ReplayGraphLog(path string, at time.Time) (*KnowledgeGraph, error) {
+	graph, err := replayGraphLog(path, func(entry graphLogEntry) bool { return !entry.Time.After(at) })
+	if err != nil {
+		return nil, err
+	}
+	if graph == nil {
+		return nil, fmt.Errorf("no changes were recorded by %s", at.Format(time.RFC3339))
+	}
+	return graph, nil
+}
+
+// ReplayGraphLogSeq reconstructs a graph file's graph as it was after log entry seq
+func ReplayGraphLogSeq(path string, seq int64) (*KnowledgeGraph, error) {
+	graph, err := replayGraphLog(path, func(entry graphLogEntry) bool { return entry.Seq <= seq })
+	if err != nil {
+		return nil, err
+	}
+	if graph == nil {
+		return nil, fmt.Errorf("log entry %d is not recorded", seq)
+	}
+	return graph, nil
+}
+
+// replayGraphLog applies the leading log entries accepted by include, returning nil if it accepts none
+func replayGraphLog(path string, include func(entry graphLogEntry) bool) (*KnowledgeGraph, error) {
+	entries, err := graphLogEntries(path)
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]string)
+	found := false
+	for _, entry := range entries {
+		if !include(entry) {
+			break
+		}
+		entry.apply(records)
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+	return parseGraph(strings.NewReader(renderRecords(records)))
+}
+
+// runLogCommand lists, replays and compacts the graph's mutation log
+func runLogCommand(env *CommandEnv, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: log list|replay|compact ...")
+	}
+
+	switch args[0] {
+	case "list":
+		flags := flag.NewFlagSet("log list", flag.ContinueOnError)
+		since := flags.String("since", "", "only list changes saved at or after this time")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		from, err := parseTimeFlag(*since)
+		if err != nil {
+			return err
+		}
+		entries, err := graphLogEntries(env.GraphFilePath)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Time.Before(from) {
+				continue
+			}
+			summary := fmt.Sprintf("%d records written, %d deleted", len(entry.Put), len(entry.Delete))
+			if entry.Reset {
+				summary = fmt.Sprintf("full graph of %d records", len(entry.Put))
+			}
+			fmt.Printf("Entry %d: %s %s\n", entry.Seq, entry.Time.Local().Format("2006-01-02 15:04:05"), summary)
+		}
+		return nil
+
+	case "replay":
+		flags := flag.NewFlagSet("log replay", flag.ContinueOnError)
+		at := flags.String("at", "", "the time to reconstruct the graph at (required)")
+		out := flags.String("out", "", "write the reconstructed graph to this file instead of summarizing it")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *at == "" {
+			return errors.New("usage: log replay -at <time> [-out path]")
+		}
+		when, err := parseTimeFlag(*at)
+		if err != nil {
+			return err
+		}
+		graph, err := ReplayGraphLog(env.GraphFilePath, when)
+		if err != nil {
+			return err
+		}
+		if *out != "" {
+			if err := writeSnapshot(*out, graph, 0); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote the graph as of %s to %s\n", when.Format(time.RFC3339), *out)
+			return nil
+		}
+		fmt.Printf("As of %s: %d notes, %d edges, %d concepts\n", when.Format(time.RFC3339), len(graph.Nodes), len(graph.Edges), len(graph.Concepts))
+		return nil
+
+	case "compact":
+		if err := env.Save(); err != nil {
+			return err
+		}
+		return env.Graph.journal.Compact(env.Graph)
+	}
+
+	return fmt.Errorf("unknown log subcommand %q", args[0])
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkSaved saves a graph and checks that its log holds exactly the graph's records
+func checkSaved(t *testing.T, path string, graph *KnowledgeGraph, step string) *graphLogEntry {
+	t.Helper()
+	before := graph.journal.LastSeq()
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatalf("%s: %v", step, err)
+	}
+	want, err := graphRecords(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(graph.journal.records, want) {
+		for key, record := range want {
+			if graph.journal.records[key] != record {
+				t.Errorf("%s: logged %q, graph has %q", step, graph.journal.records[key], record)
+			}
+		}
+		for key, record := range graph.journal.records {
+			if _, ok := want[key]; !ok {
+				t.Errorf("%s: logged %q for an element the graph no longer has", step, record)
+			}
+		}
+	}
+	if graph.journal.LastSeq() == before {
+		return nil
+	}
+	entries, err := graphLogEntries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &entries[len(entries)-1]
+}
+
+func TestGraphLogCommitsEveryKindOfChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	if err := SaveGraph(path, NewKnowledgeGraph()); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := AddNote(graph, "Go channels", []string{"go", "concurrency"}, NodeMetadata{Tags: []string{"lang"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := AddNote(graph, "Go mutexes", []string{"go", "concurrency"}, NodeMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := AddNote(graph, "Rust ownership", []string{"rust"}, NodeMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSaved(t, path, graph, "adding notes")
+
+	steps := []struct {
+		name   string
+		change func() error
+	}{
+		{"changing concepts", func() error { return graph.UpdateNodeConcepts(third.ID, []string{"rust", "concurrency"}) }},
+		{"relating concepts", func() error {
+			_, err := graph.AddConceptRelation("go", "programming", RelationBroader, OriginManual)
+			return err
+		}},
+		{"unrelating concepts", func() error {
+			graph.RemoveConceptRelations("go", "programming")
+			return nil
+		}},
+		{"linking notes", func() error {
+			graph.PutEdge(third.ID, first.ID, 1, RelationLinksTo, true)
+			return nil
+		}},
+		{"reinforcing edges", func() error {
+			graph.reinforceEdges(third.ID, time.Now().UTC().Add(time.Hour))
+			return nil
+		}},
+		{"changing settings", func() error {
+			graph.Settings.TopK = 1
+			return graph.RecomputeEdges()
+		}},
+		{"merging notes", func() error { return graph.MergeNodes(first.ID, second.ID) }},
+		{"merging graphs", func() error {
+			other := NewKnowledgeGraph()
+			if _, err := AddNote(other, "Go channels", []string{"csp"}, NodeMetadata{Tags: []string{"imported"}}); err != nil {
+				return err
+			}
+			if _, err := AddNote(other, "Python generators", []string{"python"}, NodeMetadata{}); err != nil {
+				return err
+			}
+			_, err := graph.MergeGraph(other, 0.9)
+			return err
+		}},
+		{"removing a note", func() error { return graph.RemoveNode(third.ID) }},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		checkSaved(t, path, graph, step.name)
+	}
+
+	reloaded, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := graphRecords(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := graphRecords(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("reloaded graph differs from the saved one:\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestGraphLogCommitAppendsOnlyTouchedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	for i := 0; i < 20; i++ {
+		if _, err := AddNote(graph, strings.Repeat("filler ", i+1), []string{"filler"}, NodeMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := AddNote(graph, "A note on its own", []string{"solitude"}, NodeMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := checkSaved(t, path, graph, "adding a note")
+	if entry == nil {
+		t.Fatal("adding a note logged nothing")
+	}
+	elements := 0
+	for key := range entry.Put {
+		if strings.HasPrefix(key, "Node ") && key != fmt.Sprintf("Node %d", node.ID) {
+			t.Errorf("adding note %d rewrote %s", node.ID, key)
+		}
+		if !strings.HasPrefix(key, "LastID ") {
+			elements++
+		}
+	}
+	if elements != 3 || len(entry.Delete) != 0 {
+		t.Errorf("adding a note logged %d records and %d deletions, want its node, concept and membership: %v", elements, len(entry.Delete), entry.Put)
+	}
+	if entry := checkSaved(t, path, graph, "saving again"); entry != nil {
+		t.Errorf("saving an unchanged graph logged %v", entry.Put)
+	}
+}
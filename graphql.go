@@ -0,0 +1,1241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// GraphQL limits applied before a query runs
+const (
+	graphQLMaxDepth      = 10
+	graphQLMaxComplexity = 10000
+
+	// graphQLMaxPageSize caps the first argument of paginated fields
+	graphQLMaxPageSize = 100
+
+	// graphQLListEstimate is the assumed length of a list field without a first argument when costing a query
+	graphQLListEstimate = 10
+)
+
+// GraphQLRequest is the body of a GraphQL request
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQLError is an error reported in a GraphQL response
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// GraphQLResponse is the result of a GraphQL request
+type GraphQLResponse struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []GraphQLError  `json:"errors,omitempty"`
+}
+
+// GraphQLSchema describes the object types a GraphQL query can select from
+type GraphQLSchema struct {
+	Query    string
+	Mutation string
+	Objects  []*GraphQLObject
+
+	objectIndex map[string]*GraphQLObject
+}
+
+// GraphQLObject is an object type of a schema
+type GraphQLObject struct {
+	Name        string
+	Description string
+	Fields      []*GraphQLField
+
+	fieldIndex map[string]*GraphQLField
+}
+
+// GraphQLField is a field of an object type
+// Type is a GraphQL type reference such as "Note", "[Concept!]!" or "Int"
+type GraphQLField struct {
+	Name        string
+	Type        string
+	Description string
+	Args        []GraphQLArgument
+	// ListSize is the length assumed for a list field when costing a query; zero means graphQLListEstimate
+	ListSize int
+	Resolve  func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+// GraphQLArgument is an argument of a field; a nil Default means the argument has none
+type GraphQLArgument struct {
+	Name    string
+	Type    string
+	Default interface{}
+}
+
+// graphQLScalars are the built-in scalar types
+var graphQLScalars = map[string]bool{"Int": true, "Float": true, "String": true, "Boolean": true, "ID": true}
+
+// NewGraphQLSchema indexes a schema's object types and fields
+func NewGraphQLSchema(query, mutation string, objects ...*GraphQLObject) *GraphQLSchema {
+	schema := &GraphQLSchema{Query: query, Mutation: mutation, Objects: objects, objectIndex: make(map[string]*GraphQLObject)}
+	for _, object := range objects {
+		object.fieldIndex = make(map[string]*GraphQLField)
+		for _, field := range object.Fields {
+			object.fieldIndex[field.Name] = field
+		}
+		schema.objectIndex[object.Name] = object
+	}
+	return schema
+}
+
+// SDL renders the schema in the GraphQL schema definition language
+func (schema *GraphQLSchema) SDL() string {
+	var b strings.Builder
+	b.WriteString("schema {\n  query: " + schema.Query + "\n")
+	if schema.Mutation != "" {
+		b.WriteString("  mutation: " + schema.Mutation + "\n")
+	}
+	b.WriteString("}\n")
+	for _, object := range schema.Objects {
+		b.WriteString("\n")
+		if object.Description != "" {
+			b.WriteString(strconv.Quote(object.Description) + "\n")
+		}
+		b.WriteString("type " + object.Name + " {\n")
+		for _, field := range object.Fields {
+			if field.Description != "" {
+				b.WriteString("  " + strconv.Quote(field.Description) + "\n")
+			}
+			b.WriteString("  " + field.Name)
+			if len(field.Args) > 0 {
+				var args []string
+				for _, arg := range field.Args {
+					text := arg.Name + ": " + arg.Type
+					if arg.Default != nil {
+						value, _ := json.Marshal(arg.Default)
+						text += " = " + string(value)
+					}
+					args = append(args, text)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + field.Type + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// namedType strips the list and non-null wrappers from a type reference
+func namedType(typeRef string) string {
+	return strings.Trim(typeRef, "[]!")
+}
+
+// isListType reports whether a type reference is a list, ignoring a non-null wrapper
+func isListType(typeRef string) bool {
+	return strings.HasPrefix(typeRef, "[")
+}
+
+// Lexer
+
+// gqlToken is a lexical token of a GraphQL document
+type gqlToken struct {
+	Kind  byte // 'n' name, 'i' int, 'f' float, 's' string, 'p' punctuator, 0 end of input
+	Value string
+	Pos   int
+}
+
+// lexGraphQL splits a GraphQL document into tokens
+func lexGraphQL(source string) ([]gqlToken, error) {
+	var tokens []gqlToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(source[i:], "\uFEFF"):
+			i += len("\uFEFF")
+		case strings.HasPrefix(source[i:], "..."):
+			tokens = append(tokens, gqlToken{Kind: 'p', Value: "...", Pos: i})
+			i += 3
+		case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+			tokens = append(tokens, gqlToken{Kind: 'p', Value: string(c), Pos: i})
+			i++
+		case c == '_' || isASCIILetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || isASCIILetter(source[i]) || isASCIIDigit(source[i])) {
+				i++
+			}
+			tokens = append(tokens, gqlToken{Kind: 'n', Value: source[start:i], Pos: start})
+		case c == '-' || isASCIIDigit(c):
+			start := i
+			kind := byte('i')
+			if c == '-' {
+				i++
+			}
+			digits := i
+			for i < len(source) && isASCIIDigit(source[i]) {
+				i++
+			}
+			if i == digits {
+				return nil, graphQLSyntaxError(source, start, "invalid number")
+			}
+			if i < len(source) && source[i] == '.' {
+				kind = 'f'
+				i++
+				for i < len(source) && isASCIIDigit(source[i]) {
+					i++
+				}
+			}
+			if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+				kind = 'f'
+				i++
+				if i < len(source) && (source[i] == '+' || source[i] == '-') {
+					i++
+				}
+				for i < len(source) && isASCIIDigit(source[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, gqlToken{Kind: kind, Value: source[start:i], Pos: start})
+		case strings.HasPrefix(source[i:], `"""`):
+			end := strings.Index(source[i+3:], `"""`)
+			for end >= 0 && strings.HasSuffix(source[:i+3+end], `\`) {
+				next := strings.Index(source[i+3+end+3:], `"""`)
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += 3 + next
+			}
+			if end < 0 {
+				return nil, graphQLSyntaxError(source, i, "unterminated block string")
+			}
+			value := strings.ReplaceAll(source[i+3:i+3+end], `\"""`, `"""`)
+			tokens = append(tokens, gqlToken{Kind: 's', Value: strings.TrimSpace(value), Pos: i})
+			i += 3 + end + 3
+		case c == '"':
+			value, size, err := lexGraphQLString(source[i:])
+			if err != nil {
+				return nil, graphQLSyntaxError(source, i, err.Error())
+			}
+			tokens = append(tokens, gqlToken{Kind: 's', Value: value, Pos: i})
+			i += size
+		default:
+			r, _ := utf8.DecodeRuneInString(source[i:])
+			return nil, graphQLSyntaxError(source, i, fmt.Sprintf("unexpected character %q", r))
+		}
+	}
+	return append(tokens, gqlToken{Pos: len(source)}), nil
+}
+
+// lexGraphQLString decodes a quoted string at the start of text, returning it and the length it spans
+func lexGraphQLString(text string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '"':
+			return b.String(), i + 1, nil
+		case c == '\n' || c == '\r':
+			return "", 0, errors.New("unterminated string")
+		case c == '\\':
+			if i+1 >= len(text) {
+				return "", 0, errors.New("unterminated string")
+			}
+			escape := text[i+1]
+			i += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 > len(text) {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(text[i:i+4], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", escape)
+			}
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// graphQLSyntaxError reports a syntax error with its line and column
+func graphQLSyntaxError(source string, pos int, message string) error {
+	line := 1 + strings.Count(source[:pos], "\n")
+	column := pos - strings.LastIndex(source[:pos], "\n")
+	return fmt.Errorf("syntax error at line %d, column %d: %s", line, column, message)
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isASCIIDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Parser
+
+// gqlDocument is a parsed GraphQL document
+type gqlDocument struct {
+	Operations []*gqlOperation
+	Fragments  map[string]*gqlFragment
+}
+
+// gqlOperation is a query or mutation of a document
+type gqlOperation struct {
+	Kind       string
+	Name       string
+	Variables  []gqlVariable
+	Selections []*gqlSelection
+}
+
+// gqlVariable is a variable definition of an operation
+type gqlVariable struct {
+	Name    string
+	Type    string
+	Default *gqlValue
+}
+
+// gqlFragment is a named fragment of a document
+type gqlFragment struct {
+	Name          string
+	TypeCondition string
+	Selections    []*gqlSelection
+}
+
+// gqlSelection is a field, fragment spread or inline fragment of a selection set
+type gqlSelection struct {
+	Kind          byte // 'f' field, 's' fragment spread, 'i' inline fragment
+	Alias         string
+	Name          string
+	Arguments     []gqlArgumentValue
+	Directives    []gqlDirective
+	TypeCondition string
+	Selections    []*gqlSelection
+}
+
+// responseKey is the key a field's value is returned under
+func (selection *gqlSelection) responseKey() string {
+	if selection.Alias != "" {
+		return selection.Alias
+	}
+	return selection.Name
+}
+
+// gqlArgumentValue is an argument given to a field or directive
+type gqlArgumentValue struct {
+	Name  string
+	Value *gqlValue
+}
+
+// gqlDirective is a directive applied to a selection
+type gqlDirective struct {
+	Name      string
+	Arguments []gqlArgumentValue
+}
+
+// gqlValue is an input value literal
+type gqlValue struct {
+	Kind   byte // '$' variable, 'i' int, 'f' float, 's' string, 'b' boolean, 'n' null, 'e' enum, 'l' list, 'o' object
+	Raw    string
+	List   []*gqlValue
+	Fields []gqlArgumentValue
+}
+
+// gqlParser is a recursive-descent parser over the tokens of a document
+type gqlParser struct {
+	source string
+	tokens []gqlToken
+	pos    int
+}
+
+// ParseGraphQL parses a GraphQL document
+func ParseGraphQL(source string) (*gqlDocument, error) {
+	tokens, err := lexGraphQL(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &gqlParser{source: source, tokens: tokens}
+	document := &gqlDocument{Fragments: make(map[string]*gqlFragment)}
+	for parser.peek().Kind != 0 {
+		token := parser.peek()
+		switch {
+		case token.Kind == 'p' && token.Value == "{":
+			selections, err := parser.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			document.Operations = append(document.Operations, &gqlOperation{Kind: "query", Selections: selections})
+		case token.Kind == 'n' && (token.Value == "query" || token.Value == "mutation" || token.Value == "subscription"):
+			operation, err := parser.operation()
+			if err != nil {
+				return nil, err
+			}
+			for _, other := range document.Operations {
+				if operation.Name != "" && other.Name == operation.Name {
+					return nil, fmt.Errorf("operation %q is defined more than once", operation.Name)
+				}
+			}
+			document.Operations = append(document.Operations, operation)
+		case token.Kind == 'n' && token.Value == "fragment":
+			fragment, err := parser.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := document.Fragments[fragment.Name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined more than once", fragment.Name)
+			}
+			document.Fragments[fragment.Name] = fragment
+		default:
+			return nil, parser.errorf("expected an operation or fragment")
+		}
+	}
+	if len(document.Operations) == 0 {
+		return nil, errors.New("document contains no operation")
+	}
+	return document, nil
+}
+
+// Operation selects the operation to run by name; the name may be empty when there is only one
+func (document *gqlDocument) Operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(document.Operations) > 1 {
+			return nil, errors.New("operationName is required when the document has several operations")
+		}
+		return document.Operations[0], nil
+	}
+	for _, operation := range document.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func (parser *gqlParser) peek() gqlToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *gqlParser) next() gqlToken {
+	token := parser.tokens[parser.pos]
+	if token.Kind != 0 {
+		parser.pos++
+	}
+	return token
+}
+
+// skip consumes the punctuator if it is next
+func (parser *gqlParser) skip(punctuator string) bool {
+	if token := parser.peek(); token.Kind == 'p' && token.Value == punctuator {
+		parser.pos++
+		return true
+	}
+	return false
+}
+
+func (parser *gqlParser) expect(punctuator string) error {
+	if !parser.skip(punctuator) {
+		return parser.errorf("expected %q", punctuator)
+	}
+	return nil
+}
+
+func (parser *gqlParser) name() (string, error) {
+	if token := parser.peek(); token.Kind == 'n' {
+		parser.pos++
+		return token.Value, nil
+	}
+	return "", parser.errorf("expected a name")
+}
+
+func (parser *gqlParser) errorf(format string, args ...interface{}) error {
+	token := parser.peek()
+	found := "end of input"
+	if token.Kind != 0 {
+		found = strconv.Quote(token.Value)
+	}
+	return graphQLSyntaxError(parser.source, token.Pos, fmt.Sprintf(format, args...)+", found "+found)
+}
+
+func (parser *gqlParser) operation() (*gqlOperation, error) {
+	operation := &gqlOperation{Kind: parser.next().Value}
+	if parser.peek().Kind == 'n' {
+		operation.Name = parser.next().Value
+	}
+	if parser.skip("(") {
+		for !parser.skip(")") {
+			if err := parser.expect("$"); err != nil {
+				return nil, err
+			}
+			name, err := parser.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := parser.expect(":"); err != nil {
+				return nil, err
+			}
+			typeRef, err := parser.typeRef()
+			if err != nil {
+				return nil, err
+			}
+			variable := gqlVariable{Name: name, Type: typeRef}
+			if parser.skip("=") {
+				if variable.Default, err = parser.value(true); err != nil {
+					return nil, err
+				}
+			}
+			operation.Variables = append(operation.Variables, variable)
+		}
+	}
+	if _, err := parser.directives(); err != nil {
+		return nil, err
+	}
+	var err error
+	operation.Selections, err = parser.selectionSet()
+	return operation, err
+}
+
+func (parser *gqlParser) fragment() (*gqlFragment, error) {
+	parser.next()
+	name, err := parser.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, parser.errorf("fragment must be named")
+	}
+	if on, err := parser.name(); err != nil || on != "on" {
+		return nil, parser.errorf("expected \"on\"")
+	}
+	fragment := &gqlFragment{Name: name}
+	if fragment.TypeCondition, err = parser.name(); err != nil {
+		return nil, err
+	}
+	if _, err := parser.directives(); err != nil {
+		return nil, err
+	}
+	fragment.Selections, err = parser.selectionSet()
+	return fragment, err
+}
+
+func (parser *gqlParser) typeRef() (string, error) {
+	var typeRef string
+	if parser.skip("[") {
+		inner, err := parser.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := parser.expect("]"); err != nil {
+			return "", err
+		}
+		typeRef = "[" + inner + "]"
+	} else {
+		name, err := parser.name()
+		if err != nil {
+			return "", err
+		}
+		typeRef = name
+	}
+	if parser.skip("!") {
+		typeRef += "!"
+	}
+	return typeRef, nil
+}
+
+func (parser *gqlParser) selectionSet() ([]*gqlSelection, error) {
+	if err := parser.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*gqlSelection
+	for !parser.skip("}") {
+		selection, err := parser.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, selection)
+	}
+	if len(selections) == 0 {
+		return nil, parser.errorf("selection set must not be empty")
+	}
+	return selections, nil
+}
+
+func (parser *gqlParser) selection() (*gqlSelection, error) {
+	var err error
+	if parser.skip("...") {
+		selection := &gqlSelection{Kind: 'i'}
+		if token := parser.peek(); token.Kind == 'n' && token.Value != "on" {
+			selection.Kind = 's'
+			selection.Name = parser.next().Value
+			selection.Directives, err = parser.directives()
+			return selection, err
+		}
+		if token := parser.peek(); token.Kind == 'n' && token.Value == "on" {
+			parser.next()
+			if selection.TypeCondition, err = parser.name(); err != nil {
+				return nil, err
+			}
+		}
+		if selection.Directives, err = parser.directives(); err != nil {
+			return nil, err
+		}
+		selection.Selections, err = parser.selectionSet()
+		return selection, err
+	}
+
+	selection := &gqlSelection{Kind: 'f'}
+	if selection.Name, err = parser.name(); err != nil {
+		return nil, err
+	}
+	if parser.skip(":") {
+		selection.Alias = selection.Name
+		if selection.Name, err = parser.name(); err != nil {
+			return nil, err
+		}
+	}
+	if selection.Arguments, err = parser.arguments(false); err != nil {
+		return nil, err
+	}
+	if selection.Directives, err = parser.directives(); err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.Kind == 'p' && token.Value == "{" {
+		selection.Selections, err = parser.selectionSet()
+	}
+	return selection, err
+}
+
+func (parser *gqlParser) arguments(constant bool) ([]gqlArgumentValue, error) {
+	if !parser.skip("(") {
+		return nil, nil
+	}
+	var arguments []gqlArgumentValue
+	for !parser.skip(")") {
+		name, err := parser.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := parser.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := parser.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, gqlArgumentValue{Name: name, Value: value})
+	}
+	return arguments, nil
+}
+
+func (parser *gqlParser) directives() ([]gqlDirective, error) {
+	var directives []gqlDirective
+	for parser.skip("@") {
+		name, err := parser.name()
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := parser.arguments(false)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, gqlDirective{Name: name, Arguments: arguments})
+	}
+	return directives, nil
+}
+
+// value parses an input value; constant values may not refer to variables
+func (parser *gqlParser) value(constant bool) (*gqlValue, error) {
+	token := parser.peek()
+	switch {
+	case token.Kind == 'p' && token.Value == "$" && !constant:
+		parser.next()
+		name, err := parser.name()
+		return &gqlValue{Kind: '$', Raw: name}, err
+	case token.Kind == 'i' || token.Kind == 'f' || token.Kind == 's':
+		parser.next()
+		return &gqlValue{Kind: token.Kind, Raw: token.Value}, nil
+	case token.Kind == 'n':
+		parser.next()
+		switch token.Value {
+		case "true", "false":
+			return &gqlValue{Kind: 'b', Raw: token.Value}, nil
+		case "null":
+			return &gqlValue{Kind: 'n'}, nil
+		}
+		return &gqlValue{Kind: 'e', Raw: token.Value}, nil
+	case token.Kind == 'p' && token.Value == "[":
+		parser.next()
+		list := &gqlValue{Kind: 'l'}
+		for !parser.skip("]") {
+			item, err := parser.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list.List = append(list.List, item)
+		}
+		return list, nil
+	case token.Kind == 'p' && token.Value == "{":
+		parser.next()
+		object := &gqlValue{Kind: 'o'}
+		for !parser.skip("}") {
+			name, err := parser.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := parser.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := parser.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			object.Fields = append(object.Fields, gqlArgumentValue{Name: name, Value: value})
+		}
+		return object, nil
+	}
+	return nil, parser.errorf("expected a value")
+}
+
+// Execution
+
+// graphQLExecution holds the state of one running operation
+type graphQLExecution struct {
+	schema    *GraphQLSchema
+	document  *gqlDocument
+	variables map[string]interface{}
+	errors    []GraphQLError
+
+	// variableTypes holds the declared type of each variable of the operation
+	variableTypes map[string]string
+
+	// Context is passed through to the resolvers
+	Context interface{}
+}
+
+// Execute validates an operation against the schema, checks its depth and complexity, and runs it
+func (schema *GraphQLSchema) Execute(document *gqlDocument, operation *gqlOperation, variables map[string]interface{}, context interface{}) *GraphQLResponse {
+	fail := func(err error) *GraphQLResponse {
+		return &GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}}
+	}
+
+	rootName := schema.Query
+	switch operation.Kind {
+	case "mutation":
+		rootName = schema.Mutation
+	case "subscription":
+		rootName = ""
+	}
+	root := schema.objectIndex[rootName]
+	if root == nil {
+		return fail(fmt.Errorf("%s operations are not supported", operation.Kind))
+	}
+
+	exec := &graphQLExecution{
+		schema:        schema,
+		document:      document,
+		variables:     make(map[string]interface{}),
+		variableTypes: make(map[string]string),
+		Context:       context,
+	}
+	for _, variable := range operation.Variables {
+		exec.variableTypes[variable.Name] = variable.Type
+		if !graphQLScalars[namedType(variable.Type)] {
+			return fail(fmt.Errorf("variable $%s has unsupported type %s", variable.Name, variable.Type))
+		}
+		value, provided := variables[variable.Name]
+		switch {
+		case provided:
+			coerced, err := coerceGraphQLJSON(value, variable.Type)
+			if err != nil {
+				return fail(fmt.Errorf("variable $%s: %v", variable.Name, err))
+			}
+			exec.variables[variable.Name] = coerced
+		case variable.Default != nil:
+			coerced, err := exec.coerceValue(variable.Default, variable.Type)
+			if err != nil {
+				return fail(fmt.Errorf("variable $%s: %v", variable.Name, err))
+			}
+			exec.variables[variable.Name] = coerced
+		case strings.HasSuffix(variable.Type, "!"):
+			return fail(fmt.Errorf("variable $%s of type %s is required", variable.Name, variable.Type))
+		}
+	}
+
+	cost, err := exec.analyze(root, operation.Selections, 1, make(map[string]bool))
+	if err != nil {
+		return fail(err)
+	}
+	if cost > graphQLMaxComplexity {
+		return fail(fmt.Errorf("query complexity %d exceeds the limit of %d", cost, graphQLMaxComplexity))
+	}
+
+	response := &GraphQLResponse{}
+	data, ok := exec.executeSelections(root, nil, operation.Selections, nil)
+	if !ok {
+		response.Data = json.RawMessage("null")
+	} else if response.Data, err = json.Marshal(data); err != nil {
+		return fail(fmt.Errorf("failed to encode result: %v", err))
+	}
+	response.Errors = exec.errors
+	return response
+}
+
+// analyze validates a selection set and returns its estimated cost: one per field, with the cost of a
+// list field's selections multiplied by its first argument or graphQLListEstimate
+func (exec *graphQLExecution) analyze(object *GraphQLObject, selections []*gqlSelection, depth int, visiting map[string]bool) (int, error) {
+	if depth > graphQLMaxDepth {
+		return 0, fmt.Errorf("query depth exceeds the limit of %d", graphQLMaxDepth)
+	}
+	cost := 0
+	for _, selection := range selections {
+		included, err := exec.included(selection)
+		if err != nil {
+			return 0, err
+		}
+		if !included {
+			continue
+		}
+
+		switch selection.Kind {
+		case 's':
+			fragment, ok := exec.document.Fragments[selection.Name]
+			if !ok {
+				return 0, fmt.Errorf("unknown fragment %q", selection.Name)
+			}
+			if fragment.TypeCondition != object.Name {
+				return 0, fmt.Errorf("fragment %q on %s cannot be spread within %s", fragment.Name, fragment.TypeCondition, object.Name)
+			}
+			if visiting[fragment.Name] {
+				return 0, fmt.Errorf("fragment %q spreads itself", fragment.Name)
+			}
+			visiting[fragment.Name] = true
+			fragmentCost, err := exec.analyze(object, fragment.Selections, depth, visiting)
+			delete(visiting, fragment.Name)
+			if err != nil {
+				return 0, err
+			}
+			cost += fragmentCost
+			continue
+		case 'i':
+			if selection.TypeCondition != "" && selection.TypeCondition != object.Name {
+				return 0, fmt.Errorf("inline fragment on %s cannot be used within %s", selection.TypeCondition, object.Name)
+			}
+			fragmentCost, err := exec.analyze(object, selection.Selections, depth, visiting)
+			if err != nil {
+				return 0, err
+			}
+			cost += fragmentCost
+			continue
+		}
+
+		if selection.Name == "__typename" {
+			if len(selection.Selections) > 0 {
+				return 0, errors.New("field \"__typename\" cannot have a selection of subfields")
+			}
+			continue
+		}
+		field, ok := object.fieldIndex[selection.Name]
+		if !ok {
+			return 0, fmt.Errorf("cannot query field %q on type %s", selection.Name, object.Name)
+		}
+		args, err := exec.coerceArguments(field, selection)
+		if err != nil {
+			return 0, err
+		}
+
+		child := exec.schema.objectIndex[namedType(field.Type)]
+		if child == nil {
+			if len(selection.Selections) > 0 {
+				return 0, fmt.Errorf("field %q of type %s cannot have a selection of subfields", selection.Name, field.Type)
+			}
+			cost++
+			continue
+		}
+		if len(selection.Selections) == 0 {
+			return 0, fmt.Errorf("field %q of type %s must have a selection of subfields", selection.Name, field.Type)
+		}
+		childCost, err := exec.analyze(child, selection.Selections, depth+1, visiting)
+		if err != nil {
+			return 0, err
+		}
+		multiplier := 1
+		if first, ok := args["first"].(int); ok {
+			if first < 0 || first > graphQLMaxPageSize {
+				return 0, fmt.Errorf("argument \"first\" of field %q must be between 0 and %d", field.Name, graphQLMaxPageSize)
+			}
+			multiplier = first
+		} else if field.ListSize > 0 {
+			multiplier = field.ListSize
+		} else if isListType(field.Type) {
+			multiplier = graphQLListEstimate
+		}
+		cost += 1 + multiplier*childCost
+	}
+	return cost, nil
+}
+
+// included evaluates the @skip and @include directives of a selection
+func (exec *graphQLExecution) included(selection *gqlSelection) (bool, error) {
+	for _, directive := range selection.Directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			return false, fmt.Errorf("unknown directive @%s", directive.Name)
+		}
+		if len(directive.Arguments) != 1 || directive.Arguments[0].Name != "if" {
+			return false, fmt.Errorf("directive @%s takes a single if argument", directive.Name)
+		}
+		value, err := exec.coerceValue(directive.Arguments[0].Value, "Boolean!")
+		if err != nil {
+			return false, fmt.Errorf("directive @%s: %v", directive.Name, err)
+		}
+		if value.(bool) == (directive.Name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// collectFields flattens the fragments of a selection set and groups the fields by response key
+func (exec *graphQLExecution) collectFields(object *GraphQLObject, selections []*gqlSelection, groups [][]*gqlSelection, index map[string]int) [][]*gqlSelection {
+	for _, selection := range selections {
+		if included, _ := exec.included(selection); !included {
+			continue
+		}
+		switch selection.Kind {
+		case 's':
+			groups = exec.collectFields(object, exec.document.Fragments[selection.Name].Selections, groups, index)
+		case 'i':
+			groups = exec.collectFields(object, selection.Selections, groups, index)
+		default:
+			key := selection.responseKey()
+			if i, ok := index[key]; ok {
+				groups[i] = append(groups[i], selection)
+				continue
+			}
+			index[key] = len(groups)
+			groups = append(groups, []*gqlSelection{selection})
+		}
+	}
+	return groups
+}
+
+// executeSelections resolves the fields of an object; it returns false when a non-null field came back
+// null, which makes the whole object null
+func (exec *graphQLExecution) executeSelections(object *GraphQLObject, source interface{}, selections []*gqlSelection, path []interface{}) (*graphQLResult, bool) {
+	result := &graphQLResult{values: make(map[string]interface{})}
+	for _, group := range exec.collectFields(object, selections, nil, make(map[string]int)) {
+		key := group[0].responseKey()
+		fieldPath := append(path[:len(path):len(path)], key)
+		value, ok := exec.executeField(object, source, group, fieldPath)
+		if !ok {
+			return nil, false
+		}
+		result.keys = append(result.keys, key)
+		result.values[key] = value
+	}
+	return result, true
+}
+
+// executeField resolves one field, merging the selections of every occurrence of its response key
+func (exec *graphQLExecution) executeField(object *GraphQLObject, source interface{}, group []*gqlSelection, path []interface{}) (interface{}, bool) {
+	selection := group[0]
+	if selection.Name == "__typename" {
+		return object.Name, true
+	}
+	field := object.fieldIndex[selection.Name]
+	args, err := exec.coerceArguments(field, selection)
+	var value interface{}
+	if err == nil {
+		value, err = field.Resolve(exec, source, args)
+	}
+	if err != nil {
+		exec.errors = append(exec.errors, GraphQLError{Message: err.Error(), Path: path})
+		return nil, !strings.HasSuffix(field.Type, "!")
+	}
+	var selections []*gqlSelection
+	for _, occurrence := range group {
+		selections = append(selections, occurrence.Selections...)
+	}
+	return exec.completeValue(field.Type, value, selections, path)
+}
+
+// completeValue shapes a resolved value by its type; it returns false when a null must propagate to the parent
+func (exec *graphQLExecution) completeValue(typeRef string, value interface{}, selections []*gqlSelection, path []interface{}) (interface{}, bool) {
+	nonNull := strings.HasSuffix(typeRef, "!")
+	typeRef = strings.TrimSuffix(typeRef, "!")
+	if isNilValue(value) {
+		if nonNull {
+			exec.errors = append(exec.errors, GraphQLError{Message: "cannot return null for non-null field", Path: path})
+			return nil, false
+		}
+		return nil, true
+	}
+
+	var completed interface{}
+	ok := true
+	switch {
+	case isListType(typeRef):
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice {
+			exec.errors = append(exec.errors, GraphQLError{Message: "expected a list", Path: path})
+			return nil, !nonNull
+		}
+		list := make([]interface{}, 0, items.Len())
+		for i := 0; i < items.Len() && ok; i++ {
+			var item interface{}
+			item, ok = exec.completeValue(typeRef[1:len(typeRef)-1], items.Index(i).Interface(), selections, append(path[:len(path):len(path)], i))
+			list = append(list, item)
+		}
+		completed = list
+	case graphQLScalars[typeRef]:
+		completed = value
+	default:
+		completed, ok = exec.executeSelections(exec.schema.objectIndex[typeRef], value, selections, path)
+	}
+	if !ok {
+		return nil, !nonNull
+	}
+	return completed, true
+}
+
+// isNilValue reports whether a resolved value is nil, including typed nil pointers and slices
+func isNilValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	switch reflected := reflect.ValueOf(value); reflected.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		return reflected.IsNil()
+	}
+	return false
+}
+
+// coerceArguments checks the arguments given to a field against its definition and applies defaults
+func (exec *graphQLExecution) coerceArguments(field *GraphQLField, selection *gqlSelection) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	given := make(map[string]*gqlValue)
+	for _, argument := range selection.Arguments {
+		given[argument.Name] = argument.Value
+	}
+	for _, definition := range field.Args {
+		value, ok := given[definition.Name]
+		delete(given, definition.Name)
+		if ok && value.Kind == '$' {
+			if _, set := exec.variables[value.Raw]; !set {
+				ok = false
+			}
+		}
+		if !ok {
+			if definition.Default != nil {
+				args[definition.Name] = definition.Default
+			} else if strings.HasSuffix(definition.Type, "!") {
+				return nil, fmt.Errorf("field %q requires argument %q of type %s", field.Name, definition.Name, definition.Type)
+			}
+			continue
+		}
+		coerced, err := exec.coerceValue(value, definition.Type)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q of field %q: %v", definition.Name, field.Name, err)
+		}
+		if coerced == nil {
+			coerced = definition.Default
+		}
+		if coerced != nil {
+			args[definition.Name] = coerced
+		}
+	}
+	for name := range given {
+		return nil, fmt.Errorf("unknown argument %q on field %q", name, field.Name)
+	}
+	return args, nil
+}
+
+// coerceValue converts an input literal to the Go value of a type: int, float64, string, bool or []interface{}
+func (exec *graphQLExecution) coerceValue(value *gqlValue, typeRef string) (interface{}, error) {
+	nonNull := strings.HasSuffix(typeRef, "!")
+	typeRef = strings.TrimSuffix(typeRef, "!")
+	switch value.Kind {
+	case '$':
+		declared, ok := exec.variableTypes[value.Raw]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined", value.Raw)
+		}
+		if namedType(declared) != namedType(typeRef) && !(namedType(declared) == "Int" && namedType(typeRef) == "Float") {
+			return nil, fmt.Errorf("variable $%s of type %s cannot be used as %s", value.Raw, declared, typeRef)
+		}
+		variable := exec.variables[value.Raw]
+		if variable == nil {
+			if nonNull {
+				return nil, fmt.Errorf("variable $%s is not set", value.Raw)
+			}
+			return nil, nil
+		}
+		if number, ok := variable.(int); ok && typeRef == "Float" {
+			return float64(number), nil
+		}
+		if _, ok := variable.([]interface{}); !ok && isListType(typeRef) {
+			return []interface{}{variable}, nil
+		}
+		return variable, nil
+	case 'n':
+		if nonNull {
+			return nil, fmt.Errorf("expected %s, found null", typeRef)
+		}
+		return nil, nil
+	}
+
+	if isListType(typeRef) {
+		itemType := typeRef[1 : len(typeRef)-1]
+		items := []*gqlValue{value}
+		if value.Kind == 'l' {
+			items = value.List
+		}
+		list := []interface{}{}
+		for _, item := range items {
+			coerced, err := exec.coerceValue(item, itemType)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, coerced)
+		}
+		return list, nil
+	}
+
+	switch {
+	case typeRef == "Int" && value.Kind == 'i':
+		return strconv.Atoi(value.Raw)
+	case typeRef == "Float" && (value.Kind == 'i' || value.Kind == 'f'):
+		return strconv.ParseFloat(value.Raw, 64)
+	case typeRef == "String" && value.Kind == 's':
+		return value.Raw, nil
+	case typeRef == "ID" && (value.Kind == 's' || value.Kind == 'i'):
+		return value.Raw, nil
+	case typeRef == "Boolean" && value.Kind == 'b':
+		return value.Raw == "true", nil
+	}
+	return nil, fmt.Errorf("expected %s, found %s", typeRef, describeGraphQLValue(value))
+}
+
+// coerceGraphQLJSON converts a JSON-decoded variable value to the Go value of a type
+func coerceGraphQLJSON(value interface{}, typeRef string) (interface{}, error) {
+	nonNull := strings.HasSuffix(typeRef, "!")
+	typeRef = strings.TrimSuffix(typeRef, "!")
+	if value == nil {
+		if nonNull {
+			return nil, fmt.Errorf("expected %s, found null", typeRef)
+		}
+		return nil, nil
+	}
+	if isListType(typeRef) {
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		list := []interface{}{}
+		for _, item := range items {
+			coerced, err := coerceGraphQLJSON(item, typeRef[1:len(typeRef)-1])
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, coerced)
+		}
+		return list, nil
+	}
+
+	switch typed := value.(type) {
+	case float64:
+		switch {
+		case typeRef == "Float":
+			return typed, nil
+		case (typeRef == "Int" || typeRef == "ID") && typed == float64(int(typed)):
+			if typeRef == "ID" {
+				return strconv.Itoa(int(typed)), nil
+			}
+			return int(typed), nil
+		}
+	case string:
+		if typeRef == "String" || typeRef == "ID" {
+			return typed, nil
+		}
+	case bool:
+		if typeRef == "Boolean" {
+			return typed, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, found %v", typeRef, value)
+}
+
+// describeGraphQLValue names the kind of an input literal for error messages
+func describeGraphQLValue(value *gqlValue) string {
+	switch value.Kind {
+	case 'i', 'f', 'e':
+		return value.Raw
+	case 's':
+		return strconv.Quote(value.Raw)
+	case 'b':
+		return value.Raw
+	case 'l':
+		return "a list"
+	case 'o':
+		return "an input object"
+	}
+	return "a value"
+}
+
+// graphQLResult is a result object that keeps its fields in selection order when encoded
+type graphQLResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// MarshalJSON encodes the fields in selection order
+func (result *graphQLResult) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range result.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(result.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
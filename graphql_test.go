@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGraphQLTestGraph adds five notes on consensus, one of them by bob, with raft narrower than consensus
+func newGraphQLTestGraph(t *testing.T) *KnowledgeGraph {
+	t.Helper()
+	graph := NewKnowledgeGraph()
+	notes := []struct {
+		text     string
+		concepts []string
+		author   string
+	}{
+		{"Raft elects a leader", []string{"raft", "leader election"}, "alice"},
+		{"Raft replicates a log", []string{"raft", "logs"}, "alice"},
+		{"Paxos needs a majority", []string{"paxos", "consensus"}, "bob"},
+		{"Leases bound leadership", []string{"leader election"}, "alice"},
+		{"Logs are append only", []string{"logs"}, "alice"},
+	}
+	for _, note := range notes {
+		if _, err := AddNote(graph, note.text, note.concepts, NodeMetadata{Author: note.author}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := graph.AddConceptRelation("raft", "consensus", RelationBroader, OriginManual); err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+// runGraphQL executes a query against a graph and decodes its data into result
+func runGraphQL(t *testing.T, graph *KnowledgeGraph, query string, variables map[string]interface{}, result interface{}) []GraphQLError {
+	t.Helper()
+	document, err := ParseGraphQL(query)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", query, err)
+	}
+	operation, err := document.Operation("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := knowledgeGraphSchema.Execute(document, operation, variables, &graphQLContext{Graph: graph, Now: time.Now()})
+	if result != nil && len(response.Data) > 0 {
+		if err := json.Unmarshal(response.Data, result); err != nil {
+			t.Fatalf("decoding %s: %v", response.Data, err)
+		}
+	}
+	return response.Errors
+}
+
+// noteTextsPage is the data of a notes query selecting texts and page info
+type noteTextsPage struct {
+	Notes struct {
+		TotalCount int
+		Nodes      []struct{ Text string }
+		PageInfo   struct {
+			EndCursor   string
+			HasNextPage bool
+		}
+	}
+}
+
+func (page noteTextsPage) texts() []string {
+	var texts []string
+	for _, node := range page.Notes.Nodes {
+		texts = append(texts, node.Text)
+	}
+	return texts
+}
+
+func TestGraphQLRejectsSyntaxErrors(t *testing.T) {
+	invalid := []string{
+		`{ notes { nodes { text } }`,
+		`{ notes(first: ) { totalCount } }`,
+		`{ note(id: "1) { text } }`,
+		`query Q($id: ID!) { note(id: $id) { text } } query Q { notes { totalCount } }`,
+		`{ notes { totalCount } } @`,
+	}
+	for _, query := range invalid {
+		if _, err := ParseGraphQL(query); err == nil {
+			t.Errorf("parsed %q without an error", query)
+		}
+	}
+
+	// Queries that parse but do not fit the schema fail before running
+	graph := newGraphQLTestGraph(t)
+	for _, query := range []string{
+		`{ notes { nodes { title } } }`,
+		`{ note(id: 1, name: "x") { text } }`,
+		`{ notes }`,
+		`query($first: Int!) { notes(first: $first) { totalCount } }`,
+	} {
+		var data map[string]interface{}
+		if errs := runGraphQL(t, graph, query, nil, &data); len(errs) == 0 {
+			t.Errorf("%q ran without errors: %v", query, data)
+		}
+	}
+}
+
+func TestGraphQLTraversesNestedFields(t *testing.T) {
+	graph := newGraphQLTestGraph(t)
+	var data struct {
+		Note struct {
+			Text     string
+			Concepts []struct {
+				Name    string
+				Broader []struct{ Name string }
+				Notes   struct{ Nodes []struct{ Text string } }
+			}
+			Related struct {
+				Nodes []struct {
+					Note   struct{ Text string }
+					Weight float64
+				}
+			}
+		}
+	}
+	errs := runGraphQL(t, graph, `query($id: ID!) {
+		note(id: $id) {
+			text
+			concepts { name broader { name } notes { nodes { text } } }
+			related { nodes { note { text } weight } }
+		}
+	}`, map[string]interface{}{"id": strconv.FormatInt(graph.sortedNodeIDs()[0], 10)}, &data)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	note := data.Note
+	if note.Text != "Raft elects a leader" || len(note.Concepts) != 2 {
+		t.Fatalf("got note %+v", note)
+	}
+	raft := note.Concepts[0]
+	if raft.Name != "raft" || len(raft.Broader) != 1 || raft.Broader[0].Name != "consensus" || len(raft.Notes.Nodes) != 2 {
+		t.Errorf("raft concept %+v, want it broader than consensus with two notes", raft)
+	}
+	var related []string
+	for _, node := range note.Related.Nodes {
+		related = append(related, node.Note.Text)
+		if node.Weight <= 0 {
+			t.Errorf("related note %q weighs %g", node.Note.Text, node.Weight)
+		}
+	}
+	if !slices.Contains(related, "Raft replicates a log") || !slices.Contains(related, "Leases bound leadership") {
+		t.Errorf("related notes %q, want both notes sharing a concept", related)
+	}
+}
+
+func TestGraphQLFiltersNotes(t *testing.T) {
+	graph := newGraphQLTestGraph(t)
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{`author: "bob"`, []string{"Paxos needs a majority"}},
+		{`concept: "consensus"`, []string{"Raft elects a leader", "Raft replicates a log", "Paxos needs a majority"}},
+		{`concept: "consensus", rollup: false`, []string{"Paxos needs a majority"}},
+		{`text: "log"`, []string{"Raft replicates a log", "Logs are append only"}},
+		{`concept: "logs", author: "alice"`, []string{"Raft replicates a log", "Logs are append only"}},
+		{`concept: "nothing"`, nil},
+	}
+	for _, test := range tests {
+		var page noteTextsPage
+		if errs := runGraphQL(t, graph, `{ notes(`+test.args+`) { totalCount nodes { text } } }`, nil, &page); len(errs) != 0 {
+			t.Errorf("notes(%s): %v", test.args, errs)
+			continue
+		}
+		if got := page.texts(); !slices.Equal(got, test.want) || page.Notes.TotalCount != len(test.want) {
+			t.Errorf("notes(%s) = %q of %d, want %q", test.args, got, page.Notes.TotalCount, test.want)
+		}
+	}
+
+	var edges struct {
+		Edges struct{ Nodes []struct{ Relation string } }
+	}
+	graph.PutEdge(2, 5, 1, "explains", true)
+	if errs := runGraphQL(t, graph, `{ edges(relation: "explains") { nodes { relation } } }`, nil, &edges); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(edges.Edges.Nodes) != 1 {
+		t.Errorf("found %d explains edges, want 1", len(edges.Edges.Nodes))
+	}
+}
+
+func TestGraphQLPagesWithCursors(t *testing.T) {
+	graph := newGraphQLTestGraph(t)
+	query := `query($after: String) { notes(first: 2, after: $after) { totalCount nodes { text } pageInfo { endCursor hasNextPage } } }`
+	var all []string
+	variables := map[string]interface{}{}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination does not end")
+		}
+		var page noteTextsPage
+		if errs := runGraphQL(t, graph, query, variables, &page); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+		if page.Notes.TotalCount != 5 {
+			t.Errorf("page %d counts %d notes in total, want 5", pages, page.Notes.TotalCount)
+		}
+		all = append(all, page.texts()...)
+		if !page.Notes.PageInfo.HasNextPage {
+			break
+		}
+		variables["after"] = page.Notes.PageInfo.EndCursor
+	}
+	want := []string{"Raft elects a leader", "Raft replicates a log", "Paxos needs a majority", "Leases bound leadership", "Logs are append only"}
+	if !slices.Equal(all, want) {
+		t.Errorf("paged through %q, want %q", all, want)
+	}
+
+	var page noteTextsPage
+	if errs := runGraphQL(t, graph, query, map[string]interface{}{"after": "bogus"}, &page); len(errs) == 0 {
+		t.Error("an invalid cursor was accepted")
+	}
+	if errs := runGraphQL(t, graph, `{ notes(first: 1000) { totalCount } }`, nil, &page); len(errs) == 0 {
+		t.Errorf("a page of 1000 was accepted, the limit is %d", graphQLMaxPageSize)
+	}
+}
+
+func TestGraphQLRejectsOverComplexQueries(t *testing.T) {
+	graph := newGraphQLTestGraph(t)
+
+	// Each page multiplies the cost of what it selects
+	wide := `{ notes(first: 100) { nodes { related(first: 100) { nodes { note { related(first: 100) { nodes { weight } } } } } } } }`
+	if errs := runGraphQL(t, graph, wide, nil, nil); len(errs) == 0 || !strings.Contains(errs[0].Message, "complexity") {
+		t.Errorf("wide query gave %v, want a complexity error", errs)
+	}
+
+	levels := graphQLMaxDepth + 1
+	deep := "{ note(id: 1) { concepts { " + strings.Repeat("broader { ", levels-2) + "name" + strings.Repeat(" }", levels) + " }"
+	if errs := runGraphQL(t, graph, deep, nil, nil); len(errs) == 0 || !strings.Contains(errs[0].Message, "depth") {
+		t.Errorf("a query %d levels deep gave %v, want a depth error", levels, errs)
+	}
+
+	// Fragments cannot hide a cycle
+	cyclic := `{ notes { nodes { ...A } } } fragment A on Note { concepts { notes { nodes { ...A } } } }`
+	if errs := runGraphQL(t, graph, cyclic, nil, nil); len(errs) == 0 {
+		t.Error("a cyclic fragment was accepted")
+	}
+}
+
+func TestGraphQLMutationsNeedPOSTAndTheWriteScope(t *testing.T) {
+	api := newTestAPI(t, "alice")
+	keys, err := OpenKeyStore(api.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly, _, err := keys.Issue("alice", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutation := `mutation { addNote(text: "Added over GraphQL", concepts: ["graphql"]) { id text } }`
+
+	send := func(method, token string) (int, GraphQLResponse) {
+		t.Helper()
+		var request *http.Request
+		if method == http.MethodGet {
+			request, err = http.NewRequest(method, api.server.URL+"/api/graphql?query="+url.QueryEscape(mutation), nil)
+		} else {
+			data, _ := json.Marshal(GraphQLRequest{Query: mutation})
+			request, err = http.NewRequest(method, api.server.URL+"/api/graphql", bytes.NewReader(data))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var body GraphQLResponse
+		json.NewDecoder(response.Body).Decode(&body)
+		return response.StatusCode, body
+	}
+
+	if status, _ := send(http.MethodGet, api.tokens["alice"]); status != http.StatusMethodNotAllowed {
+		t.Errorf("mutation over GET: got %d, want 405", status)
+	}
+	if status, _ := send(http.MethodPost, readOnly); status != http.StatusForbidden {
+		t.Errorf("mutation with a read-only key: got %d, want 403", status)
+	}
+	if texts := api.noteTexts("alice", ""); len(texts) != 0 {
+		t.Fatalf("refused mutations added %q", texts)
+	}
+
+	status, body := send(http.MethodPost, api.tokens["alice"])
+	if status != http.StatusOK || len(body.Errors) != 0 {
+		t.Fatalf("mutation with a write key: %d %+v", status, body)
+	}
+	if texts := api.noteTexts("alice", ""); !slices.Equal(texts, []string{"Added over GraphQL"}) {
+		t.Errorf("graph holds %q after the mutation", texts)
+	}
+}
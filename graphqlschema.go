@@ -0,0 +1,685 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// graphQLContext is what the knowledge graph resolvers run against
+type graphQLContext struct {
+	Graph *KnowledgeGraph
+	Now   time.Time
+
+	// AddNote records a note for the addNote mutation, extracting its concepts when none are given
+	AddNote func(text string, concepts, tags []string) (*Node, error)
+}
+
+// graphQLConnection is a page of a paginated list
+type graphQLConnection struct {
+	Items []interface{}
+	Total int
+	Start int
+}
+
+// graphQLPageInfo tells a client whether there are more pages and where the next one starts
+type graphQLPageInfo struct {
+	EndCursor   string
+	HasNextPage bool
+}
+
+// graphQLVertex is a concept shared by two notes, the unit of the legacy per-pair graph layout
+type graphQLVertex struct {
+	NodeID    int64
+	TargetID  int64
+	ConceptID int64
+}
+
+// knowledgeGraphSchema is the GraphQL schema served at /api/graphql
+var knowledgeGraphSchema = newKnowledgeGraphSchema()
+
+// Arguments shared by several fields
+var (
+	pageArgs = []GraphQLArgument{
+		{Name: "first", Type: "Int", Default: 20},
+		{Name: "after", Type: "String"},
+	}
+	noteFilterArgs = []GraphQLArgument{
+		{Name: "concept", Type: "String"},
+		{Name: "rollup", Type: "Boolean", Default: true},
+		{Name: "text", Type: "String"},
+		{Name: "source", Type: "String"},
+		{Name: "author", Type: "String"},
+		{Name: "tag", Type: "String"},
+		{Name: "since", Type: "String"},
+		{Name: "until", Type: "String"},
+	}
+	edgeFilterArgs = []GraphQLArgument{
+		{Name: "relation", Type: "String"},
+		{Name: "minWeight", Type: "Float"},
+		{Name: "since", Type: "String"},
+		{Name: "until", Type: "String"},
+	}
+)
+
+// joinArgs concatenates argument lists
+func joinArgs(lists ...[]GraphQLArgument) []GraphQLArgument {
+	var args []GraphQLArgument
+	for _, list := range lists {
+		args = append(args, list...)
+	}
+	return args
+}
+
+// newKnowledgeGraphSchema defines the object types of the knowledge graph and their resolvers
+func newKnowledgeGraphSchema() *GraphQLSchema {
+	query := &GraphQLObject{Name: "Query", Fields: []*GraphQLField{
+		{
+			Name: "note", Type: "Note", Description: "A note by ID",
+			Args: []GraphQLArgument{{Name: "id", Type: "ID!"}},
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				id, err := strconv.ParseInt(args["id"].(string), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid note ID %q", args["id"])
+				}
+				node, _ := graphQLGraph(exec).Node(id)
+				return node, nil
+			},
+		},
+		{
+			Name: "notes", Type: "NoteConnection!", Description: "Notes matching a filter, in ID order; since and until bound the creation time",
+			Args: joinArgs(noteFilterArgs, pageArgs),
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				filter, err := graphQLNodeFilter(args)
+				if err != nil {
+					return nil, err
+				}
+				graph := graphQLGraph(exec)
+				var items []interface{}
+				for _, id := range SearchNodes(graph, filter) {
+					items = append(items, graph.Nodes[id])
+				}
+				return paginate(items, args)
+			},
+		},
+		{
+			Name: "concept", Type: "Concept", Description: "A concept by name",
+			Args: []GraphQLArgument{{Name: "name", Type: "String!"}},
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return graphQLGraph(exec).ConceptByName(args["name"].(string)), nil
+			},
+		},
+		{
+			Name: "concepts", Type: "ConceptConnection!", Description: "Concepts in name order, optionally only those whose name contains text",
+			Args: joinArgs([]GraphQLArgument{{Name: "text", Type: "String"}}, pageArgs),
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				graph := graphQLGraph(exec)
+				text, _ := args["text"].(string)
+				var concepts []*Concept
+				for _, concept := range graph.Concepts {
+					if strings.Contains(normalizeConcept(concept.Name), normalizeConcept(text)) {
+						concepts = append(concepts, concept)
+					}
+				}
+				sort.Slice(concepts, func(i, j int) bool { return concepts[i].Name < concepts[j].Name })
+				var items []interface{}
+				for _, concept := range concepts {
+					items = append(items, concept)
+				}
+				return paginate(items, args)
+			},
+		},
+		{
+			Name: "edge", Type: "Edge", Description: "An edge by ID",
+			Args: []GraphQLArgument{{Name: "id", Type: "ID!"}},
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				id, err := strconv.ParseInt(args["id"].(string), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid edge ID %q", args["id"])
+				}
+				return graphQLGraph(exec).Edges[id], nil
+			},
+		},
+		{
+			Name: "edges", Type: "EdgeConnection!", Description: "Edges in ID order; minWeight compares the weight after recency decay, since and until bound when the edge was last reinforced",
+			Args: joinArgs(edgeFilterArgs, pageArgs),
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				graph := graphQLGraph(exec)
+				ids := make([]int64, 0, len(graph.Edges))
+				for id := range graph.Edges {
+					ids = append(ids, id)
+				}
+				return graphQLEdges(exec, ids, args)
+			},
+		},
+		{
+			Name: "vertices", Type: "VertexConnection!", Description: "Concepts shared by pairs of notes, for a note, a concept or both",
+			Args: joinArgs([]GraphQLArgument{{Name: "note", Type: "ID"}, {Name: "concept", Type: "String"}}, pageArgs),
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				graph := graphQLGraph(exec)
+				var conceptID int64
+				if name, ok := args["concept"].(string); ok {
+					concept := graph.ConceptByName(name)
+					if concept == nil {
+						return paginate(nil, args)
+					}
+					conceptID = concept.ID
+				}
+				idText, ok := args["note"].(string)
+				if !ok {
+					if conceptID == 0 {
+						return nil, errors.New("vertices needs a note or a concept")
+					}
+					return paginate(conceptVertices(graph, conceptID), args)
+				}
+				id, err := strconv.ParseInt(idText, 10, 64)
+				if err != nil || graph.Nodes[id] == nil {
+					return nil, fmt.Errorf("note %s not found", idText)
+				}
+				return paginate(noteVertices(graph, id, conceptID), args)
+			},
+		},
+	}}
+
+	mutation := &GraphQLObject{Name: "Mutation", Fields: []*GraphQLField{
+		{
+			Name: "addNote", Type: "Note!", Description: "Adds a note, extracting its concepts unless they are given",
+			Args: []GraphQLArgument{
+				{Name: "text", Type: "String!"},
+				{Name: "concepts", Type: "[String!]"},
+				{Name: "tags", Type: "[String!]"},
+			},
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				text := args["text"].(string)
+				if strings.TrimSpace(text) == "" {
+					return nil, errors.New("note text must not be empty")
+				}
+				return exec.Context.(*graphQLContext).AddNote(text, graphQLStrings(args["concepts"]), graphQLStrings(args["tags"]))
+			},
+		},
+	}}
+
+	note := &GraphQLObject{Name: "Note", Description: "A note in the knowledge graph", Fields: []*GraphQLField{
+		{Name: "id", Type: "ID!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return strconv.FormatInt(source.(*Node).ID, 10), nil
+		}},
+		{Name: "text", Type: "String!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*Node).Text, nil
+		}},
+		{Name: "createdAt", Type: "String", Description: "RFC 3339 timestamp", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLTime(source.(*Node).CreatedAt), nil
+		}},
+		{Name: "updatedAt", Type: "String", Description: "RFC 3339 timestamp", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLTime(source.(*Node).UpdatedAt), nil
+		}},
+		{Name: "source", Type: "String", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLOptional(source.(*Node).Source), nil
+		}},
+		{Name: "author", Type: "String", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLOptional(source.(*Node).Author), nil
+		}},
+		{Name: "tags", Type: "[String!]!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return append([]string{}, source.(*Node).Tags...), nil
+		}},
+		{Name: "attachments", Type: "[String!]!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return append([]string{}, source.(*Node).Attachments...), nil
+		}},
+		{Name: "concepts", Type: "[Concept!]!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			graph := graphQLGraph(exec)
+			concepts := []*Concept{}
+			for _, id := range graph.NodeConceptIDs(source.(*Node).ID) {
+				concepts = append(concepts, graph.Concepts[id])
+			}
+			return concepts, nil
+		}},
+		{
+			Name: "related", Type: "RelatedNoteConnection!", Description: "Notes sharing concepts with this one, strongest first after recency decay",
+			Args: joinArgs([]GraphQLArgument{{Name: "minWeight", Type: "Float"}}, pageArgs),
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				context := exec.Context.(*graphQLContext)
+				minWeight, _ := args["minWeight"].(float64)
+				var items []interface{}
+				for _, related := range RelatedNotes(context.Graph, source.(*Node).ID, 0, context.Now) {
+					if related.Weight >= minWeight {
+						items = append(items, related)
+					}
+				}
+				return paginate(items, args)
+			},
+		},
+		{
+			Name: "edges", Type: "EdgeConnection!", Description: "Edges of any relation touching this note",
+			Args: joinArgs(edgeFilterArgs, pageArgs),
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				id := source.(*Node).ID
+				var ids []int64
+				for _, edge := range graphQLGraph(exec).Edges {
+					if edge.SourceID == id || edge.TargetID == id {
+						ids = append(ids, edge.ID)
+					}
+				}
+				return graphQLEdges(exec, ids, args)
+			},
+		},
+		{
+			Name: "vertices", Type: "VertexConnection!", Description: "The concepts this note shares with each other note",
+			Args: pageArgs,
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return paginate(noteVertices(graphQLGraph(exec), source.(*Node).ID, 0), args)
+			},
+		},
+	}}
+
+	relatedNote := &GraphQLObject{Name: "RelatedNote", Description: "A note related to another, with the weight of their similarity edge", Fields: []*GraphQLField{
+		{Name: "note", Type: "Note!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(RelatedNote).Node, nil
+		}},
+		{Name: "weight", Type: "Float!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(RelatedNote).Weight, nil
+		}},
+	}}
+
+	concept := &GraphQLObject{Name: "Concept", Description: "A concept notes are tagged with", Fields: []*GraphQLField{
+		{Name: "id", Type: "ID!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return strconv.FormatInt(source.(*Concept).ID, 10), nil
+		}},
+		{Name: "name", Type: "String!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*Concept).Name, nil
+		}},
+		{
+			Name: "noteCount", Type: "Int!",
+			Args: []GraphQLArgument{{Name: "rollup", Type: "Boolean", Default: false}},
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return len(graphQLGraph(exec).NotesForConcept(source.(*Concept).Name, args["rollup"].(bool))), nil
+			},
+		},
+		{
+			Name: "notes", Type: "NoteConnection!", Description: "Notes tagged with the concept, or with a narrower one when rollup is set",
+			Args: joinArgs([]GraphQLArgument{
+				{Name: "rollup", Type: "Boolean", Default: false},
+				{Name: "since", Type: "String"},
+				{Name: "until", Type: "String"},
+			}, pageArgs),
+			Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+				filter, err := graphQLNodeFilter(args)
+				if err != nil {
+					return nil, err
+				}
+				filter.Concept = source.(*Concept).Name
+				graph := graphQLGraph(exec)
+				var items []interface{}
+				for _, id := range SearchNodes(graph, filter) {
+					items = append(items, graph.Nodes[id])
+				}
+				return paginate(items, args)
+			},
+		},
+		{Name: "broader", Type: "[Concept!]!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			graph := graphQLGraph(exec)
+			return graphQLConcepts(graph, graph.BroaderConceptIDs(source.(*Concept).ID)), nil
+		}},
+		{Name: "narrower", Type: "[Concept!]!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			graph := graphQLGraph(exec)
+			return graphQLConcepts(graph, graph.NarrowerConceptIDs(source.(*Concept).ID)), nil
+		}},
+		{Name: "related", Type: "[Concept!]!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			graph := graphQLGraph(exec)
+			return graphQLConcepts(graph, graph.RelatedConceptIDs(source.(*Concept).ID)), nil
+		}},
+	}}
+
+	edge := &GraphQLObject{Name: "Edge", Description: "An edge between two notes", Fields: []*GraphQLField{
+		{Name: "id", Type: "ID!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return strconv.FormatInt(source.(*Edge).ID, 10), nil
+		}},
+		{Name: "source", Type: "Note!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLGraph(exec).Nodes[source.(*Edge).SourceID], nil
+		}},
+		{Name: "target", Type: "Note!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLGraph(exec).Nodes[source.(*Edge).TargetID], nil
+		}},
+		{Name: "relation", Type: "String!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*Edge).Relation, nil
+		}},
+		{Name: "directed", Type: "Boolean!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*Edge).Directed, nil
+		}},
+		{Name: "weight", Type: "Float!", Description: "The stored weight", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*Edge).Weight, nil
+		}},
+		{Name: "effectiveWeight", Type: "Float!", Description: "The weight after recency decay", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			context := exec.Context.(*graphQLContext)
+			return context.Graph.EffectiveWeight(source.(*Edge), context.Now), nil
+		}},
+		{Name: "reinforcedAt", Type: "String", Description: "When a note last brought both ends up together", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLTime(graphQLGraph(exec).lastReinforced(source.(*Edge))), nil
+		}},
+		{Name: "sharedConcepts", Type: "[Concept!]!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			graph := graphQLGraph(exec)
+			concepts := []*Concept{}
+			for _, name := range graph.SharedConcepts(source.(*Edge).SourceID, source.(*Edge).TargetID) {
+				concepts = append(concepts, graph.ConceptByName(name))
+			}
+			return concepts, nil
+		}},
+	}}
+
+	vertex := &GraphQLObject{Name: "Vertex", Description: "A concept shared by two notes", Fields: []*GraphQLField{
+		{Name: "note", Type: "Note!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLGraph(exec).Nodes[source.(graphQLVertex).NodeID], nil
+		}},
+		{Name: "target", Type: "Note!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLGraph(exec).Nodes[source.(graphQLVertex).TargetID], nil
+		}},
+		{Name: "concept", Type: "Concept!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLGraph(exec).Concepts[source.(graphQLVertex).ConceptID], nil
+		}},
+	}}
+
+	pageInfo := &GraphQLObject{Name: "PageInfo", Description: "Where a page ends; pass endCursor as after to get the next page", Fields: []*GraphQLField{
+		{Name: "endCursor", Type: "String", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return graphQLOptional(source.(graphQLPageInfo).EndCursor), nil
+		}},
+		{Name: "hasNextPage", Type: "Boolean!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(graphQLPageInfo).HasNextPage, nil
+		}},
+	}}
+
+	return NewGraphQLSchema("Query", "Mutation",
+		query, mutation, note, relatedNote, concept, edge, vertex,
+		connectionObject("NoteConnection", "Note"),
+		connectionObject("RelatedNoteConnection", "RelatedNote"),
+		connectionObject("ConceptConnection", "Concept"),
+		connectionObject("EdgeConnection", "Edge"),
+		connectionObject("VertexConnection", "Vertex"),
+		pageInfo,
+	)
+}
+
+// connectionObject defines the page type of a paginated list
+func connectionObject(name, itemType string) *GraphQLObject {
+	return &GraphQLObject{Name: name, Description: "A page of " + itemType + " results", Fields: []*GraphQLField{
+		{Name: "totalCount", Type: "Int!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*graphQLConnection).Total, nil
+		}},
+		// The connection field's first argument already multiplies the cost of the page
+		{Name: "nodes", Type: "[" + itemType + "!]!", ListSize: 1, Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*graphQLConnection).Items, nil
+		}},
+		{Name: "pageInfo", Type: "PageInfo!", Resolve: func(exec *graphQLExecution, source interface{}, args map[string]interface{}) (interface{}, error) {
+			connection := source.(*graphQLConnection)
+			end := connection.Start + len(connection.Items)
+			info := graphQLPageInfo{HasNextPage: end < connection.Total}
+			if len(connection.Items) > 0 {
+				info.EndCursor = encodeCursor(end - 1)
+			}
+			return info, nil
+		}},
+	}}
+}
+
+// paginate cuts the page selected by the first and after arguments out of a list
+func paginate(items []interface{}, args map[string]interface{}) (*graphQLConnection, error) {
+	start := 0
+	if after, ok := args["after"].(string); ok {
+		position, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		start = position + 1
+	}
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + args["first"].(int)
+	if end > len(items) {
+		end = len(items)
+	}
+	page := items[start:end]
+	if page == nil {
+		page = []interface{}{}
+	}
+	return &graphQLConnection{Items: page, Total: len(items), Start: start}, nil
+}
+
+// encodeCursor returns the opaque cursor of a list position
+func encodeCursor(position int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("cursor:" + strconv.Itoa(position)))
+}
+
+// decodeCursor returns the list position of a cursor
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if text, ok := strings.CutPrefix(string(data), "cursor:"); ok {
+			if position, err := strconv.Atoi(text); err == nil && position >= 0 {
+				return position, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor %q", cursor)
+}
+
+// graphQLGraph returns the graph a resolver runs against
+func graphQLGraph(exec *graphQLExecution) *KnowledgeGraph {
+	return exec.Context.(*graphQLContext).Graph
+}
+
+// graphQLNodeFilter builds a note filter from field arguments
+func graphQLNodeFilter(args map[string]interface{}) (NodeFilter, error) {
+	var filter NodeFilter
+	filter.Concept, _ = args["concept"].(string)
+	filter.Rollup, _ = args["rollup"].(bool)
+	filter.Text, _ = args["text"].(string)
+	filter.Source, _ = args["source"].(string)
+	filter.Author, _ = args["author"].(string)
+	filter.Tag, _ = args["tag"].(string)
+	var err error
+	since, _ := args["since"].(string)
+	if filter.Since, err = parseTimeFlag(since); err != nil {
+		return filter, err
+	}
+	until, _ := args["until"].(string)
+	if filter.Until, err = parseTimeFlag(until); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// graphQLEdges filters and pages edges by the relation, minWeight, since and until arguments
+func graphQLEdges(exec *graphQLExecution, ids []int64, args map[string]interface{}) (*graphQLConnection, error) {
+	context := exec.Context.(*graphQLContext)
+	relation, _ := args["relation"].(string)
+	minWeight, _ := args["minWeight"].(float64)
+	since, _ := args["since"].(string)
+	until, _ := args["until"].(string)
+	sinceTime, err := parseTimeFlag(since)
+	if err != nil {
+		return nil, err
+	}
+	untilTime, err := parseTimeFlag(until)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var items []interface{}
+	for _, id := range ids {
+		edge := context.Graph.Edges[id]
+		if relation != "" && edge.Relation != relation {
+			continue
+		}
+		if context.Graph.EffectiveWeight(edge, context.Now) < minWeight {
+			continue
+		}
+		reinforced := context.Graph.lastReinforced(edge)
+		if !sinceTime.IsZero() && reinforced.Before(sinceTime) {
+			continue
+		}
+		if !untilTime.IsZero() && !reinforced.Before(untilTime) {
+			continue
+		}
+		items = append(items, edge)
+	}
+	return paginate(items, args)
+}
+
+// noteVertices lists the concepts a note shares with every other note, optionally for one concept only
+func noteVertices(graph *KnowledgeGraph, nodeID, conceptID int64) []interface{} {
+	var vertices []interface{}
+	for _, shared := range graph.NodeConceptIDs(nodeID) {
+		if conceptID != 0 && shared != conceptID {
+			continue
+		}
+		for _, other := range graph.ConceptNodeIDs(shared) {
+			if other != nodeID {
+				vertices = append(vertices, graphQLVertex{NodeID: nodeID, TargetID: other, ConceptID: shared})
+			}
+		}
+	}
+	return vertices
+}
+
+// conceptVertices lists every pair of notes sharing a concept
+func conceptVertices(graph *KnowledgeGraph, conceptID int64) []interface{} {
+	var vertices []interface{}
+	members := graph.ConceptNodeIDs(conceptID)
+	for i, nodeID := range members {
+		for _, other := range members[i+1:] {
+			vertices = append(vertices, graphQLVertex{NodeID: nodeID, TargetID: other, ConceptID: conceptID})
+		}
+	}
+	return vertices
+}
+
+// graphQLConcepts looks up concepts by ID
+func graphQLConcepts(graph *KnowledgeGraph, ids []int64) []*Concept {
+	concepts := []*Concept{}
+	for _, id := range ids {
+		if concept, ok := graph.Concepts[id]; ok {
+			concepts = append(concepts, concept)
+		}
+	}
+	return concepts
+}
+
+// graphQLStrings converts a coerced [String!] argument
+func graphQLStrings(value interface{}) []string {
+	var values []string
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		values = append(values, item.(string))
+	}
+	return values
+}
+
+// graphQLTime formats a timestamp, returning null for the zero time
+func graphQLTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// graphQLOptional returns null for an empty string
+func graphQLOptional(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// handleGraphQL runs a GraphQL query or mutation; GET without a query returns the schema
+func (server *Server) handleGraphQL(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	var request GraphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		request.Query = query.Get("query")
+		request.OperationName = query.Get("operationName")
+		if request.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, knowledgeGraphSchema.SDL())
+			return
+		}
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid variables: %v", err))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := decodeJSONBody(w, r, maxRequestBody, &request); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Errorf("invalid GraphQL request: %v", err))
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+
+	document, err := ParseGraphQL(request.Query)
+	if err == nil {
+		var operation *gqlOperation
+		if operation, err = document.Operation(request.OperationName); err == nil {
+			server.runGraphQL(w, r, ctx, document, operation, request.Variables)
+			return
+		}
+	}
+	writeJSON(w, http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+}
+
+// runGraphQL executes a parsed operation under the graph lock
+func (server *Server) runGraphQL(w http.ResponseWriter, r *http.Request, ctx *requestContext, document *gqlDocument, operation *gqlOperation, variables map[string]interface{}) {
+	if operation.Kind == "mutation" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("mutations must be sent with POST"))
+			return
+		}
+		if !ctx.Key.Allows(ScopeWrite) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("API key lacks the %s scope", ScopeWrite))
+			return
+		}
+	}
+
+	mutated := false
+	context := &graphQLContext{
+		Graph: ctx.Graph,
+		Now:   time.Now(),
+		AddNote: func(text string, concepts, tags []string) (*Node, error) {
+			if len(concepts) == 0 {
+				if server.APIKey == "" {
+					return nil, errors.New("concepts are required when the server has no OpenAI API key")
+				}
+				var err error
+				concepts, err = ExtractConcepts(text, server.APIKey)
+				if err != nil {
+					return nil, err
+				}
+			}
+			node, err := AddNote(ctx.Graph, text, concepts, NodeMetadata{Source: SourceAPI, Author: ctx.Key.User, Tags: tags})
+			if err == nil {
+				mutated = true
+			}
+			return node, err
+		},
+	}
+
+	response := knowledgeGraphSchema.Execute(document, operation, variables, context)
+	if mutated {
+		if err := server.save(ctx); err != nil {
+			response.Errors = append(response.Errors, GraphQLError{Message: err.Error()})
+		}
+	}
+	status := http.StatusOK
+	if response.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, response)
+}
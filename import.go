@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// NoteImporter adds notes from external files to a graph, updating the notes imported earlier
+// from the same place instead of duplicating them
+type NoteImporter struct {
+	// ExtractConcepts adds extracted concepts to the imported ones; nil keeps the imported concepts only
+	ExtractConcepts func(text string) ([]string, error)
+
+	// Author is used for notes whose source names no author
+	Author string
+}
+
+// ImportResult counts what an import changed
+type ImportResult struct {
+	Added     int
+	Updated   int
+	Unchanged int
+	Links     int
+}
+
+// ImportedNote is a note read from an external source
+type ImportedNote struct {
+	// Key identifies where the note came from, such as a file path or an IRI
+	Key         string
+	Text        string
+	Concepts    []string
+	Tags        []string
+	Author      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ContentHash string
+}
+
+// importedNodes indexes the notes imported earlier by their key
+func importedNodes(graph *KnowledgeGraph) map[string]*Node {
+	imported := make(map[string]*Node)
+	for _, node := range graph.Nodes {
+		if node.Source == SourceImport && node.SourcePath != "" {
+			imported[node.SourcePath] = node
+		}
+	}
+	return imported
+}
+
+// upsertNote adds an imported note, or updates the note imported earlier under the same key if its
+// content hash changed. Updated notes lose their outgoing typed edges, which the importer recreates.
+func (importer *NoteImporter) upsertNote(graph *KnowledgeGraph, existing *Node, note ImportedNote, result *ImportResult) (*Node, error) {
+	if existing != nil && existing.ContentHash == note.ContentHash {
+		result.Unchanged++
+		return existing, nil
+	}
+
+	concepts := append([]string(nil), note.Concepts...)
+	if importer.ExtractConcepts != nil {
+		extracted, err := importer.ExtractConcepts(note.Text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract concepts from %s: %v", note.Key, err)
+		}
+		concepts = append(concepts, extracted...)
+	}
+	if note.UpdatedAt.IsZero() {
+		note.UpdatedAt = time.Now().UTC()
+	}
+
+	if existing == nil {
+		author := note.Author
+		if author == "" {
+			author = importer.Author
+		}
+		created := note.CreatedAt
+		if created.IsZero() {
+			created = note.UpdatedAt
+		}
+		node, err := AddNote(graph, note.Text, concepts, NodeMetadata{
+			CreatedAt:   created,
+			UpdatedAt:   note.UpdatedAt,
+			Source:      SourceImport,
+			Author:      author,
+			Tags:        note.Tags,
+			SourcePath:  note.Key,
+			ContentHash: note.ContentHash,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Added++
+		return node, nil
+	}
+
+	// The source changed: replace the text, embedding, concepts and outgoing links of its note
+	existing.Text = note.Text
+	existing.Embedding = nil
+	if err := graph.embedNode(existing); err != nil {
+		return nil, err
+	}
+	for id, edge := range graph.Edges {
+		if !edge.IsSimilarity() && edge.SourceID == existing.ID {
+			graph.RemoveEdge(id)
+		}
+	}
+	if err := graph.UpdateNodeConcepts(existing.ID, concepts); err != nil {
+		return nil, err
+	}
+	if note.Author != "" {
+		existing.Author = note.Author
+	}
+	if len(note.Tags) > 0 {
+		existing.Tags = note.Tags
+	}
+	existing.UpdatedAt = note.UpdatedAt
+	existing.ContentHash = note.ContentHash
+	graph.reinforceEdges(existing.ID, note.UpdatedAt)
+	graph.publishNode(EventNodeUpdated, existing)
+	result.Updated++
+	return existing, nil
+}
+
+// runImportCommand imports notes from a Markdown directory or an RDF file
+func runImportCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "markdown", "import format: markdown, turtle, ntriples or jsonld")
+	extract := flags.Bool("extract", true, "extract concepts from the notes in addition to their imported concepts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: import [-format markdown|turtle|ntriples|jsonld] [-extract=false] <path>")
+	}
+
+	importer := &NoteImporter{Author: env.User}
+	if importer.Author == "" {
+		importer.Author = currentAuthor()
+	}
+	if *extract {
+		apiKey, err := env.RequireAPIKey()
+		if err != nil {
+			return err
+		}
+		importer.ExtractConcepts = func(text string) ([]string, error) { return ExtractConcepts(text, apiKey) }
+	}
+
+	var result ImportResult
+	var err error
+	switch *format {
+	case "markdown":
+		result, err = importer.ImportMarkdown(env.Graph, flags.Arg(0))
+	case "turtle", "ntriples", "jsonld":
+		var data []byte
+		if data, err = os.ReadFile(flags.Arg(0)); err != nil {
+			return fmt.Errorf("failed to read %s: %v", flags.Arg(0), err)
+		}
+		var triples []Triple
+		if triples, err = rdfParsers[*format](string(data)); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", flags.Arg(0), err)
+		}
+		result, err = importer.ImportRDF(env.Graph, triples)
+	default:
+		return fmt.Errorf("unknown import format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d new notes, updated %d, %d unchanged, %d links\n", result.Added, result.Updated, result.Unchanged, result.Links)
+	return env.Save()
+}
@@ -3,43 +3,98 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
 // KnowledgeGraph represents the knowledge graph
 type KnowledgeGraph struct {
-	Nodes    map[int64]*Node
-	Edges    map[int64]*Edge
-	Vertices map[int64]*Vertex
+	Nodes       map[int64]*Node
+	Edges       map[int64]*Edge
+	Concepts    map[int64]*Concept
+	Memberships map[int64]*Membership
+
+	// ConceptRelations organizes concepts into a broader/narrower/related taxonomy
+	ConceptRelations map[int64]*ConceptRelation
+
+	// Settings holds the per-graph options persisted with the graph
+	Settings GraphSettings
+
+	// Redirects maps the IDs of notes merged into others to the notes that absorbed them
+	Redirects map[int64]int64
+
+	// Tenant is the user or workspace owning the graph; it is implied by where the graph is stored
+	Tenant string
+
+	// Embedder computes note embeddings for the embedding weighting strategy; it is not persisted
+	Embedder Embedder
+
+	// Events receives every change made to the graph when set; it is not persisted
+	Events *EventBus
+
+	// sketches caches the MinHash sketches used to find near-duplicate notes
+	sketches map[int64]*noteSketch
+
+	// ids numbers the graph's elements independently of any other graph
+	ids idCounters
+
+	// journal records saved changes in the mutation log of the file the graph was loaded from
+	journal *GraphLog
+
+	// dirty holds the keys of the records changed since the graph was last saved, so a save appends
+	// only those; dirtyAll is set by changes that replace the graph wholesale
+	dirty    map[string]bool
+	dirtyAll bool
+
+	// Lookup indexes derived from Edges, Concepts and Memberships
+	edgeIndex      map[edgeKey]int64
+	conceptIndex   map[string]int64
+	nodeMembers    map[int64]map[int64]int64
+	conceptMembers map[int64]map[int64]int64
 }
 
-var (
-	nodeIDCounter   int64 = 1
-	edgeIDCounter   int64 = 1
-	vertexIDCounter int64 = 1
-)
+// idCounters holds the last ID handed out for each kind of graph element
+type idCounters struct {
+	node       int64
+	edge       int64
+	concept    int64
+	membership int64
+	relation   int64
+}
 
 // Node represents a node in the knowledge graph
 type Node struct {
-	ID       int64
-	Text     string
-	Concepts []string
+	ID        int64
+	Text      string
+	Embedding []float32
+	NodeMetadata
 }
 
 // Edge represents an edge in the knowledge graph
+// Similarity edges are undirected and stored once per pair with the smaller node ID as source,
+// while typed relations may be directed from SourceID to TargetID
 type Edge struct {
 	ID       int64
 	SourceID int64
 	TargetID int64
 	Weight   float64
+	Directed bool
+	Relation string
+
+	// ReinforcedAt is when a new note last brought both endpoints up together; zero if never
+	ReinforcedAt time.Time
 }
 
-// Vertex represents a vertex in the knowledge graph
+// Vertex represents a shared-concept record from the legacy per-pair graph layout
 type Vertex struct {
 	ID       int64
 	NodeID   int64
@@ -49,47 +104,72 @@ type Vertex struct {
 
 // NewKnowledgeGraph creates a new instance of KnowledgeGraph
 func NewKnowledgeGraph() *KnowledgeGraph {
-	return &KnowledgeGraph{
-		Nodes:    make(map[int64]*Node),
-		Edges:    make(map[int64]*Edge),
-		Vertices: make(map[int64]*Vertex),
+	graph := &KnowledgeGraph{
+		Nodes:       make(map[int64]*Node),
+		Edges:       make(map[int64]*Edge),
+		Concepts:    make(map[int64]*Concept),
+		Memberships: make(map[int64]*Membership),
+
+		ConceptRelations: make(map[int64]*ConceptRelation),
+		Redirects:        make(map[int64]int64),
 	}
+	graph.reindex()
+	graph.indexEdges()
+	return graph
 }
 
 func main() {
+	// Parse the global flags selecting whose graph to open
+	dataDir := flag.String("data", ".", "directory holding the knowledge graphs")
+	user := flag.String("user", os.Getenv("KG_USER"), "user whose personal graph to open")
+	workspace := flag.String("workspace", "", "shared workspace graph to open instead of the personal graph")
+	flag.Usage = printCommandUsage
+	flag.Parse()
+
 	// Retrieve OpenAI API key from environment variables
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		// Try retrieving from secrets if environment variable is not set
-		apiKey = os.Getenv("MY_SECRET")
-		if apiKey == "" {
-			log.Fatal("OpenAI API key not found. Please set the OPENAI_API_KEY or MY_SECRET environment variable with your API key.")
-		}
+	apiKey := lookupAPIKey()
+
+	// Resolve the tenant whose graph is used
+	store, err := OpenTenantStore(*dataDir)
+	if err != nil {
+		log.Fatalf("Failed to open tenant store: %v", err)
+	}
+	tenant, err := store.Resolve(*user, *workspace)
+	if err != nil {
+		log.Fatalf("Failed to select graph: %v", err)
 	}
-	log.Println("AI Client Initialized!")
 
 	// Initialize the graph
-	graph := NewKnowledgeGraph()
+	graphFilePath := store.GraphPath(tenant)
+	graph, err := store.LoadTenantGraph(tenant)
+	if err != nil {
+		log.Fatalf("Failed to load knowledge graph: %v", err)
+	}
 
-	// Check if a graph file exists
-	graphFilePath := "knowledge_graph.txt"
-	_, err := os.Stat(graphFilePath)
-	if os.IsNotExist(err) {
-		// Create a new knowledge graph if the file doesn't exist
-		log.Println("Knowledge Graph Created")
-		if err := SaveGraph(graphFilePath, graph); err != nil {
-			log.Fatalf("Failed to save knowledge graph: %v", err)
+	// Embeddings are only available with an API key
+	if apiKey != "" {
+		graph.Embedder = NewOpenAIEmbedder(apiKey)
+	}
+
+	// Run a subcommand instead of the interactive prompt if one was given
+	if args := flag.Args(); len(args) > 0 {
+		env := &CommandEnv{
+			Graph:         graph,
+			GraphFilePath: graphFilePath,
+			APIKey:        apiKey,
+			Store:         store,
+			User:          *user,
 		}
-	} else if err == nil {
-		// Load the existing knowledge graph
-		log.Println("Knowledge Graph Accessed!")
-		graph, err = LoadGraph(graphFilePath)
-		if err != nil {
-			log.Fatalf("Failed to load knowledge graph: %v", err)
+		if err := runCommand(env, args[0], args[1:]); err != nil {
+			log.Fatalf("%s: %v", args[0], err)
 		}
-	} else {
-		log.Fatalf("Error checking graph file: %v", err)
+		return
+	}
+
+	if apiKey == "" {
+		log.Fatal(missingAPIKeyMessage)
 	}
+	log.Println("AI Client Initialized!")
 
 	// Get user input for note text
 	scanner := bufio.NewScanner(os.Stdin)
@@ -108,7 +188,12 @@ func main() {
 		}
 
 		// Build or update knowledge graph with the provided note text and concepts
-		if err := BuildOrUpdateKnowledgeGraph(graph, noteText, concepts); err != nil {
+		author := *user
+		if author == "" {
+			author = currentAuthor()
+		}
+		_, err = AddNote(graph, noteText, concepts, NodeMetadata{Source: SourceTyped, Author: author})
+		if err != nil {
 			log.Fatalf("Failed to build or update knowledge graph: %v", err)
 		}
 
@@ -126,6 +211,37 @@ func main() {
 	}
 }
 
+// loadOrCreateGraph loads the knowledge graph stored at a path, creating an empty one if the file doesn't exist
+func loadOrCreateGraph(graphFilePath string) (*KnowledgeGraph, error) {
+	// Check if a graph file exists
+	_, err := os.Stat(graphFilePath)
+	if os.IsNotExist(err) {
+		// Create a new knowledge graph if the file doesn't exist
+		log.Println("Knowledge Graph Created")
+		graph := NewKnowledgeGraph()
+		if err := SaveGraph(graphFilePath, graph); err != nil {
+			return nil, fmt.Errorf("failed to save knowledge graph: %v", err)
+		}
+		return graph, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error checking graph file: %v", err)
+	}
+
+	// Load the existing knowledge graph
+	log.Println("Knowledge Graph Accessed!")
+	return LoadGraph(graphFilePath)
+}
+
+// lookupAPIKey retrieves the OpenAI API key from the environment, or "" if none is set
+func lookupAPIKey() string {
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		// Try retrieving from secrets if environment variable is not set
+		apiKey = os.Getenv("MY_SECRET")
+	}
+	return apiKey
+}
+
 // ExtractConcepts extracts concepts from a text using OpenAI's GPT
 func ExtractConcepts(text string, apiKey string) ([]string, error) {
 	// Initialize OpenAI client with API key
@@ -158,123 +274,312 @@ func ExtractConcepts(text string, apiKey string) ([]string, error) {
 
 // BuildOrUpdateKnowledgeGraph builds the knowledge graph with the provided note text and concepts, or updates an existing graph
 func BuildOrUpdateKnowledgeGraph(graph *KnowledgeGraph, noteText string, concepts []string) error {
-	// Create nodes for the note
-	node := Node{
-		ID:       generateNodeID(),
-		Text:     noteText,
-		Concepts: concepts,
-	}
-	graph.Nodes[node.ID] = &node
-
-	// Create edges and vertices based on the relationships between nodes
-	for _, existingNode := range graph.Nodes {
-		if existingNode.ID != node.ID {
-			// Calculate edge weight based on concept similarity
-			weight := CalculateWeight(node.Concepts, existingNode.Concepts)
-			if weight > 0 {
-				// Create an edge between the nodes
-				edge := Edge{
-					ID:       generateEdgeID(),
-					SourceID: node.ID,
-					TargetID: existingNode.ID,
-					Weight:   weight,
-				}
-				graph.Edges[edge.ID] = &edge
-
-				// Create vertices for the concepts shared by the nodes
-				for _, concept := range node.Concepts {
-					if Contains(existingNode.Concepts, concept) {
-						vertex := Vertex{
-							ID:       generateVertexID(),
-							NodeID:   node.ID,
-							TargetID: existingNode.ID,
-							Concept:  concept,
-						}
-						graph.Vertices[vertex.ID] = &vertex
-					}
-				}
-			}
+	_, err := AddNote(graph, noteText, concepts, NodeMetadata{
+		Source: SourceTyped,
+		Author: currentAuthor(),
+	})
+	return err
+}
+
+// connectNode creates edges from a node to the candidate notes accepted by include, weighted by the graph's strategy
+func (graph *KnowledgeGraph) connectNode(nodeID int64, include func(otherID int64) bool) {
+	for _, existingID := range graph.similarityCandidates(nodeID) {
+		if !include(existingID) {
+			continue
+		}
+
+		// Calculate edge weight based on concept similarity
+		weight := graph.weightFunc().Weight(graph, nodeID, existingID)
+		if weight > 0 && weight >= graph.Settings.MinWeight {
+			// Create or update the symmetric edge between the nodes
+			graph.PutEdge(nodeID, existingID, weight, RelationSimilar, false)
 		}
 	}
+}
+
+// UpdateNodeConcepts replaces a node's concepts and recomputes its similarity edges
+func (graph *KnowledgeGraph) UpdateNodeConcepts(nodeID int64, concepts []string) error {
+	node, ok := graph.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %d not found", nodeID)
+	}
+	graph.SetNodeConcepts(nodeID, concepts)
 
+	// Relink the node under its new concepts
+	for id, edge := range graph.Edges {
+		if edge.IsSimilarity() && (edge.SourceID == nodeID || edge.TargetID == nodeID) {
+			graph.RemoveEdge(id)
+		}
+	}
+	graph.connectNode(nodeID, func(int64) bool { return true })
+	graph.PruneEdges()
+
+	node.UpdatedAt = time.Now().UTC()
+	graph.publishNode(EventConceptsUpdated, node)
+	return nil
+}
+
+// RemoveNode deletes a node together with its concept memberships and edges
+func (graph *KnowledgeGraph) RemoveNode(nodeID int64) error {
+	node, ok := graph.Nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("node %d not found", nodeID)
+	}
+	graph.SetNodeConcepts(nodeID, nil)
+	for id, edge := range graph.Edges {
+		if edge.SourceID == nodeID || edge.TargetID == nodeID {
+			graph.RemoveEdge(id)
+		}
+	}
+	delete(graph.Nodes, nodeID)
+
+	// IDs that redirected to the note now lead nowhere
+	for from, to := range graph.Redirects {
+		if to == nodeID {
+			delete(graph.Redirects, from)
+			graph.touch("Redirect", from)
+		}
+	}
+	graph.publish(GraphEvent{Type: EventNodeDeleted, NodeID: nodeID, Text: node.Text})
 	return nil
 }
 
 // SaveGraph saves the knowledge graph to storage
+// A graph loaded from filePath only appends its changes to the file's mutation log; any other graph is
+// written out in full and starts a new log
 func SaveGraph(filePath string, graph *KnowledgeGraph) error {
-	// Create or open the file
-	file, err := os.Create(filePath)
+	if graph.journal != nil && graph.journal.Path == filePath {
+		return graph.journal.Commit(graph)
+	}
+	journal, err := createGraphLog(filePath, graph)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return err
 	}
-	defer file.Close()
+	graph.journal = journal
+	graph.clearDirty()
+	return nil
+}
 
+// writeGraphFile writes every record of the graph in the graph file format
+func writeGraphFile(w io.Writer, graph *KnowledgeGraph) error {
 	// Write concepts to the file
-	_, err = fmt.Fprintf(file, "Concepts: %s\n", strings.Join(getAllConcepts(graph), ", "))
+	_, err := fmt.Fprintf(w, "Concepts: %s\n", escapeText(strings.Join(getAllConcepts(graph), ", ")))
 	if err != nil {
 		return fmt.Errorf("failed to write concepts: %v", err)
 	}
 
+	// Write settings to the file
+	if err := writeSettings(w, graph.Settings); err != nil {
+		return err
+	}
+
+	// Write the ID high-water marks, so IDs of deleted elements are not handed out again
+	if err := writeIDCounters(w, graph.ids); err != nil {
+		return err
+	}
+
 	// Write nodes to the file
-	for id, node := range graph.Nodes {
-		_, err := fmt.Fprintf(file, "Node %d: %s\n", id, node.Text)
-		if err != nil {
-			return fmt.Errorf("failed to write node: %v", err)
+	for _, node := range graph.Nodes {
+		if err := writeNodeRecord(w, node); err != nil {
+			return err
+		}
+	}
+
+	// Write node embeddings to the file
+	for _, node := range graph.Nodes {
+		if err := writeEmbeddingRecord(w, node); err != nil {
+			return err
 		}
 	}
 
 	// Write edges to the file
-	for id, edge := range graph.Edges {
-		_, err := fmt.Fprintf(file, "Edge %d: SourceID=%d, TargetID=%d, Weight=%f\n", id, edge.SourceID, edge.TargetID, edge.Weight)
-		if err != nil {
-			return fmt.Errorf("failed to write edge: %v", err)
+	for _, edge := range graph.Edges {
+		if err := writeEdgeRecord(w, edge); err != nil {
+			return err
 		}
 	}
 
-	// Write vertices to the file
-	for id, vertex := range graph.Vertices {
-		_, err := fmt.Fprintf(file, "Vertex %d: NodeID=%d, TargetID=%d, Concept=%s\n", id, vertex.NodeID, vertex.TargetID, vertex.Concept)
-		if err != nil {
-			return fmt.Errorf("failed to write vertex: %v", err)
+	// Write concepts to the file
+	for _, concept := range graph.Concepts {
+		if err := writeConceptRecord(w, concept); err != nil {
+			return err
+		}
+	}
+
+	// Write note→concept memberships to the file
+	for _, membership := range graph.Memberships {
+		if err := writeMembershipRecord(w, membership); err != nil {
+			return err
+		}
+	}
+
+	// Write concept relations to the file
+	for _, relation := range graph.ConceptRelations {
+		if err := writeConceptRelationRecord(w, relation); err != nil {
+			return err
+		}
+	}
+
+	// Write the redirects of merged notes to the file
+	for id, target := range graph.Redirects {
+		if err := writeRedirectRecord(w, id, target); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// LoadGraph loads the knowledge graph from storage
-func LoadGraph(filePath string) (*KnowledgeGraph, error) {
-	// Open the file
-	file, err := os.Open(filePath)
+// writeNodeRecord writes a node's line followed by its metadata
+func writeNodeRecord(w io.Writer, node *Node) error {
+	if _, err := fmt.Fprintf(w, "Node %d: %s\n", node.ID, escapeText(node.Text)); err != nil {
+		return fmt.Errorf("failed to write node: %v", err)
+	}
+	return writeNodeMetadata(w, node)
+}
+
+// writeEmbeddingRecord writes a node's embedding, if it has one
+func writeEmbeddingRecord(w io.Writer, node *Node) error {
+	if len(node.Embedding) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "Embedding %d: %s\n", node.ID, formatEmbedding(node.Embedding)); err != nil {
+		return fmt.Errorf("failed to write embedding: %v", err)
+	}
+	return nil
+}
+
+// writeEdgeRecord writes an edge
+func writeEdgeRecord(w io.Writer, edge *Edge) error {
+	_, err := fmt.Fprintf(w, "Edge %d: SourceID=%d, TargetID=%d, Weight=%f, Directed=%t, Relation=%s, ReinforcedAt=%s\n",
+		edge.ID, edge.SourceID, edge.TargetID, edge.Weight, edge.Directed, escapeField(edge.Relation), formatTime(edge.ReinforcedAt))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %v", err)
+		return fmt.Errorf("failed to write edge: %v", err)
 	}
-	defer file.Close()
+	return nil
+}
 
-	// Initialize maps for nodes, edges, and vertices
-	graph := &KnowledgeGraph{
-		Nodes:    make(map[int64]*Node),
-		Edges:    make(map[int64]*Edge),
-		Vertices: make(map[int64]*Vertex),
+// writeConceptRecord writes a concept
+func writeConceptRecord(w io.Writer, concept *Concept) error {
+	if _, err := fmt.Fprintf(w, "Concept %d: %s\n", concept.ID, escapeText(concept.Name)); err != nil {
+		return fmt.Errorf("failed to write concept: %v", err)
+	}
+	return nil
+}
+
+// writeMembershipRecord writes a note→concept membership
+func writeMembershipRecord(w io.Writer, membership *Membership) error {
+	_, err := fmt.Fprintf(w, "Membership %d: NodeID=%d, ConceptID=%d, Salience=%f\n", membership.ID, membership.NodeID, membership.ConceptID, membership.Salience)
+	if err != nil {
+		return fmt.Errorf("failed to write membership: %v", err)
 	}
+	return nil
+}
 
-	// Create a scanner to read from the file
-	scanner := bufio.NewScanner(file)
+// writeConceptRelationRecord writes a concept relation
+func writeConceptRelationRecord(w io.Writer, relation *ConceptRelation) error {
+	_, err := fmt.Fprintf(w, "ConceptRelation %d: SourceID=%d, TargetID=%d, Kind=%s, Origin=%s\n", relation.ID, relation.SourceID, relation.TargetID, relation.Kind, relation.Origin)
+	if err != nil {
+		return fmt.Errorf("failed to write concept relation: %v", err)
+	}
+	return nil
+}
+
+// writeRedirectRecord writes the redirect of a merged note
+func writeRedirectRecord(w io.Writer, id, target int64) error {
+	if _, err := fmt.Fprintf(w, "Redirect %d: NodeID=%d\n", id, target); err != nil {
+		return fmt.Errorf("failed to write redirect: %v", err)
+	}
+	return nil
+}
+
+// LoadGraph loads the knowledge graph from storage, replaying the changes logged since its last snapshot
+func LoadGraph(filePath string) (*KnowledgeGraph, error) {
+	journal, err := openGraphLog(filePath)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := parseGraph(strings.NewReader(renderRecords(journal.records)))
+	if err != nil {
+		return nil, err
+	}
+	graph.journal = journal
+	if journal.recovered {
+		if err := journal.Compact(graph); err != nil {
+			return nil, err
+		}
+		log.Printf("Recovered %s", filePath)
+	}
+	return graph, nil
+}
+
+// parseGraph reads a graph in the graph file format
+func parseGraph(r io.Reader) (*KnowledgeGraph, error) {
+	// Initialize maps for nodes, edges, concepts, and memberships
+	graph := NewKnowledgeGraph()
+
+	// Vertices from the legacy layout are collected and migrated once the file is read
+	var legacyVertices []Vertex
+	var err error
+
+	// Create a scanner to read from the file, allowing for long imported notes
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
 
 	// Read each line and parse graph elements
 	for scanner.Scan() {
 		line := scanner.Text()
 
+		// Parse setting
+		if strings.HasPrefix(line, "Setting ") {
+			key, value, _ := strings.Cut(strings.TrimPrefix(line, "Setting "), "=")
+			if err := applySetting(&graph.Settings, key, value); err != nil {
+				return nil, fmt.Errorf("failed to parse setting: %v", err)
+			}
+		}
+
+		// Parse ID high-water mark
+		if strings.HasPrefix(line, "LastID ") {
+			kind, value, _ := strings.Cut(strings.TrimPrefix(line, "LastID "), "=")
+			if err := graph.ids.apply(kind, value); err != nil {
+				return nil, fmt.Errorf("failed to parse last ID: %v", err)
+			}
+		}
+
 		// Parse node
-		if strings.HasPrefix(line, "Node") {
+		if strings.HasPrefix(line, "Node ") {
 			var node Node
 			if _, err := fmt.Sscanf(line, "Node %d:", &node.ID); err != nil {
 				return nil, fmt.Errorf("failed to parse node: %v", err)
 			}
-			node.Text = strings.TrimSpace(strings.TrimPrefix(line, fmt.Sprintf("Node %d:", node.ID)))
+			node.Text = unescapeText(strings.TrimSpace(strings.TrimPrefix(line, fmt.Sprintf("Node %d:", node.ID))))
 			graph.Nodes[node.ID] = &node
 		}
 
+		// Parse node metadata (written right after the node line it belongs to)
+		for _, kind := range []string{"NodeMeta", "SourcePath", "Author", "Tag", "Attachment"} {
+			if strings.HasPrefix(line, kind+" ") {
+				if err := parseNodeMetadata(graph, kind, line); err != nil {
+					return nil, fmt.Errorf("failed to parse node metadata: %v", err)
+				}
+			}
+		}
+
+		// Parse embedding (node lines always precede embedding lines)
+		if strings.HasPrefix(line, "Embedding") {
+			var id int64
+			if _, err := fmt.Sscanf(line, "Embedding %d:", &id); err != nil {
+				return nil, fmt.Errorf("failed to parse embedding: %v", err)
+			}
+			embedding, err := parseEmbedding(strings.TrimPrefix(line, fmt.Sprintf("Embedding %d:", id)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse embedding: %v", err)
+			}
+			if node, ok := graph.Nodes[id]; ok {
+				node.Embedding = embedding
+			}
+		}
+
 		// Parse edge
 		if strings.HasPrefix(line, "Edge") {
 			var edge Edge
@@ -282,55 +587,252 @@ func LoadGraph(filePath string) (*KnowledgeGraph, error) {
 				&edge.ID, &edge.SourceID, &edge.TargetID, &edge.Weight); err != nil {
 				return nil, fmt.Errorf("failed to parse edge: %v", err)
 			}
+
+			// Edges written before typed relations existed are undirected similarity edges
+			fields := parseRecordFields(strings.TrimPrefix(line, fmt.Sprintf("Edge %d:", edge.ID)))
+			edge.Relation = RelationSimilar
+			if relation, ok := fields["Relation"]; ok && relation != "" {
+				edge.Relation = unescapeText(relation)
+			}
+			if directed, ok := fields["Directed"]; ok {
+				if edge.Directed, err = strconv.ParseBool(directed); err != nil {
+					return nil, fmt.Errorf("failed to parse edge: %v", err)
+				}
+			}
+			if edge.ReinforcedAt, err = parseTime(fields["ReinforcedAt"]); err != nil {
+				return nil, fmt.Errorf("failed to parse edge: %v", err)
+			}
 			graph.Edges[edge.ID] = &edge
 		}
 
-		// Parse vertex
+		// Parse concept (the "Concepts:" summary line is informational only)
+		if strings.HasPrefix(line, "Concept ") {
+			var concept Concept
+			if _, err := fmt.Sscanf(line, "Concept %d:", &concept.ID); err != nil {
+				return nil, fmt.Errorf("failed to parse concept: %v", err)
+			}
+			concept.Name = unescapeText(strings.TrimSpace(strings.TrimPrefix(line, fmt.Sprintf("Concept %d:", concept.ID))))
+			graph.Concepts[concept.ID] = &concept
+		}
+
+		// Parse membership
+		if strings.HasPrefix(line, "Membership") {
+			membership := Membership{Salience: 1.0}
+			if _, err := fmt.Sscanf(line, "Membership %d: NodeID=%d, ConceptID=%d",
+				&membership.ID, &membership.NodeID, &membership.ConceptID); err != nil {
+				return nil, fmt.Errorf("failed to parse membership: %v", err)
+			}
+			if _, salience, ok := strings.Cut(line, "Salience="); ok {
+				if membership.Salience, err = strconv.ParseFloat(salience, 64); err != nil {
+					return nil, fmt.Errorf("failed to parse membership salience: %v", err)
+				}
+			}
+			graph.Memberships[membership.ID] = &membership
+		}
+
+		// Parse concept relation
+		if strings.HasPrefix(line, "ConceptRelation") {
+			var relation ConceptRelation
+			if _, err := fmt.Sscanf(line, "ConceptRelation %d:", &relation.ID); err != nil {
+				return nil, fmt.Errorf("failed to parse concept relation: %v", err)
+			}
+			fields := parseRecordFields(strings.TrimPrefix(line, fmt.Sprintf("ConceptRelation %d:", relation.ID)))
+			if relation.SourceID, err = parseIDField(fields, "SourceID"); err != nil {
+				return nil, fmt.Errorf("failed to parse concept relation: %v", err)
+			}
+			if relation.TargetID, err = parseIDField(fields, "TargetID"); err != nil {
+				return nil, fmt.Errorf("failed to parse concept relation: %v", err)
+			}
+			relation.Kind = fields["Kind"]
+			relation.Origin = fields["Origin"]
+			graph.ConceptRelations[relation.ID] = &relation
+		}
+
+		// Parse redirect
+		if strings.HasPrefix(line, "Redirect ") {
+			var id, target int64
+			if _, err := fmt.Sscanf(line, "Redirect %d: NodeID=%d", &id, &target); err != nil {
+				return nil, fmt.Errorf("failed to parse redirect: %v", err)
+			}
+			graph.Redirects[id] = target
+		}
+
+		// Parse legacy vertex
 		if strings.HasPrefix(line, "Vertex") {
 			var vertex Vertex
-			if _, err := fmt.Sscanf(line, "Vertex %d: NodeID=%d, TargetID=%d, Concept=%s",
-				&vertex.ID, &vertex.NodeID, &vertex.TargetID, &vertex.Concept); err != nil {
+			if _, err := fmt.Sscanf(line, "Vertex %d:", &vertex.ID); err != nil {
+				return nil, fmt.Errorf("failed to parse vertex: %v", err)
+			}
+			// Concepts may span several words, so the fields are split rather than scanned
+			fields := parseRecordFields(strings.TrimPrefix(line, fmt.Sprintf("Vertex %d:", vertex.ID)))
+			if vertex.NodeID, err = parseIDField(fields, "NodeID"); err != nil {
 				return nil, fmt.Errorf("failed to parse vertex: %v", err)
 			}
-			graph.Vertices[vertex.ID] = &vertex
+			if vertex.TargetID, err = parseIDField(fields, "TargetID"); err != nil {
+				return nil, fmt.Errorf("failed to parse vertex: %v", err)
+			}
+			vertex.Concept = fields["Concept"]
+			legacyVertices = append(legacyVertices, vertex)
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error while scanning file: %v", err)
+		return nil, fmt.Errorf("error while scanning graph: %v", err)
+	}
+
+	// Make sure newly generated IDs don't collide with the loaded ones, even in files without high-water marks
+	advanceIDCounters(graph)
+
+	// Rebuild lookup indexes and migrate the legacy per-pair vertices into memberships
+	graph.reindex()
+	graph.dedupeLoadedEdges()
+	if len(legacyVertices) > 0 {
+		graph.migrateLegacyVertices(legacyVertices)
+		graph.touchAll()
+		log.Printf("Migrated %d legacy vertices into %d concept memberships", len(legacyVertices), len(graph.Memberships))
 	}
 
 	return graph, nil
 }
 
-// Helper functions for generating unique IDs
-func generateNodeID() int64 {
-	nodeIDCounter++
-	return nodeIDCounter
+// escapeText keeps multi-line text on its record line
+func escapeText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(text)
+}
+
+// escapeField escapes a value written among the comma-separated fields of a record
+func escapeField(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, ",", `\c`).Replace(value)
+}
+
+// unescapeText decodes text written by escapeText or escapeField
+func unescapeText(text string) string {
+	if !strings.Contains(text, `\`) {
+		return text
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 == len(text) {
+			b.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'c':
+			b.WriteByte(',')
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
+
+// Helper functions for generating unique IDs within a graph
+func (graph *KnowledgeGraph) generateNodeID() int64 {
+	graph.ids.node++
+	return graph.ids.node
+}
+
+func (graph *KnowledgeGraph) generateEdgeID() int64 {
+	graph.ids.edge++
+	return graph.ids.edge
+}
+
+func (graph *KnowledgeGraph) generateConceptID() int64 {
+	graph.ids.concept++
+	return graph.ids.concept
 }
 
-func generateEdgeID() int64 {
-	edgeIDCounter++
-	return edgeIDCounter
+func (graph *KnowledgeGraph) generateMembershipID() int64 {
+	graph.ids.membership++
+	return graph.ids.membership
 }
 
-func generateVertexID() int64 {
-	vertexIDCounter++
-	return vertexIDCounter
+func (graph *KnowledgeGraph) generateConceptRelationID() int64 {
+	graph.ids.relation++
+	return graph.ids.relation
 }
 
-// CalculateWeight calculates the weight between two sets of concepts based on Jaccard similarity
+// writeIDCounters writes the last ID handed out for each kind of graph element
+func writeIDCounters(w io.Writer, ids idCounters) error {
+	for _, counter := range []struct {
+		kind string
+		last int64
+	}{
+		{"Node", ids.node},
+		{"Edge", ids.edge},
+		{"Concept", ids.concept},
+		{"Membership", ids.membership},
+		{"ConceptRelation", ids.relation},
+	} {
+		if _, err := fmt.Fprintf(w, "LastID %s=%d\n", counter.kind, counter.last); err != nil {
+			return fmt.Errorf("failed to write last ID: %v", err)
+		}
+	}
+	return nil
+}
+
+// apply raises a counter to a high-water mark read from a "LastID Kind=n" record
+func (ids *idCounters) apply(kind, value string) error {
+	last, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s ID %q", kind, value)
+	}
+	switch kind {
+	case "Node":
+		ids.node = max(ids.node, last)
+	case "Edge":
+		ids.edge = max(ids.edge, last)
+	case "Concept":
+		ids.concept = max(ids.concept, last)
+	case "Membership":
+		ids.membership = max(ids.membership, last)
+	case "ConceptRelation":
+		ids.relation = max(ids.relation, last)
+	default:
+		return fmt.Errorf("unknown ID kind %q", kind)
+	}
+	return nil
+}
+
+// advanceIDCounters moves the ID counters past every ID already present in the graph
+func advanceIDCounters(graph *KnowledgeGraph) {
+	for id := range graph.Nodes {
+		graph.ids.node = max(graph.ids.node, id)
+	}
+	for id := range graph.Redirects {
+		graph.ids.node = max(graph.ids.node, id)
+	}
+	for id := range graph.Edges {
+		graph.ids.edge = max(graph.ids.edge, id)
+	}
+	for id := range graph.Concepts {
+		graph.ids.concept = max(graph.ids.concept, id)
+	}
+	for id := range graph.Memberships {
+		graph.ids.membership = max(graph.ids.membership, id)
+	}
+	for id := range graph.ConceptRelations {
+		graph.ids.relation = max(graph.ids.relation, id)
+	}
+}
+
+// CalculateWeight calculates the weight between two sets of concepts based on Jaccard similarity, matching concepts case-insensitively
 func CalculateWeight(concepts1, concepts2 []string) float64 {
 	// Convert concept slices to sets for easier comparison
 	set1 := make(map[string]bool)
 	set2 := make(map[string]bool)
 
 	for _, concept := range concepts1 {
-		set1[concept] = true
+		set1[normalizeConcept(concept)] = true
 	}
 
 	for _, concept := range concepts2 {
-		set2[concept] = true
+		set2[normalizeConcept(concept)] = true
 	}
 
 	// Calculate Jaccard similarity
@@ -351,6 +853,32 @@ func CalculateWeight(concepts1, concepts2 []string) float64 {
 	return float64(intersection) / float64(union)
 }
 
+// similarityCandidates returns the notes that may have a non-zero weight with the given note
+func (graph *KnowledgeGraph) similarityCandidates(nodeID int64) []int64 {
+	if selector, ok := graph.weightFunc().(candidateFunc); ok {
+		return selector.Candidates(graph, nodeID)
+	}
+	if graph.Settings.OntologyCredit <= 0 || len(graph.ConceptRelations) == 0 {
+		return graph.neighbourCandidates(nodeID)
+	}
+
+	// Widen the candidates to notes whose concepts sit in the same part of the hierarchy
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, conceptID := range graph.NodeConceptIDs(nodeID) {
+		for _, familyID := range graph.ontologyNeighbourConcepts(conceptID) {
+			for _, otherID := range graph.ConceptNodeIDs(familyID) {
+				if otherID != nodeID && !seen[otherID] {
+					seen[otherID] = true
+					ids = append(ids, otherID)
+				}
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
 // Contains checks if a string exists in a slice
 func Contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -389,17 +917,13 @@ func ExtractNodesEdgesVertices(graph *KnowledgeGraph, client *openai.Client) err
 	return nil
 }
 
-// getAllConcepts retrieves all unique concepts from the knowledge graph
+// getAllConcepts retrieves all unique concepts referenced by notes in the knowledge graph
 func getAllConcepts(graph *KnowledgeGraph) []string {
-	conceptsMap := make(map[string]bool)
-	for _, node := range graph.Nodes {
-		for _, concept := range node.Concepts {
-			conceptsMap[concept] = true
+	concepts := make([]string, 0, len(graph.Concepts))
+	for id, concept := range graph.Concepts {
+		if len(graph.conceptMembers[id]) > 0 {
+			concepts = append(concepts, concept.Name)
 		}
 	}
-	concepts := make([]string, 0, len(conceptsMap))
-	for concept := range conceptsMap {
-		concepts = append(concepts, concept)
-	}
 	return concepts
 }
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// wikilinkPattern matches [[target]], [[target#heading]] and [[target|alias]]
+var wikilinkPattern = regexp.MustCompile(`\[\[([^\]|#]+)(?:#[^\]|]*)?(?:\|[^\]]*)?\]\]`)
+
+// markdownLinkPattern matches [text](target) links
+var markdownLinkPattern = regexp.MustCompile(`\[[^\]]*\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+
+// MarkdownNote is a Markdown file split into its front matter and body
+type MarkdownNote struct {
+	// Path is the file's slash-separated path relative to the vault
+	Path        string
+	Title       string
+	Body        string
+	Tags        []string
+	Aliases     []string
+	Author      string
+	Created     time.Time
+	ContentHash string
+
+	// Links are the vault-relative names, without extension, that the note's wikilinks and
+	// Markdown links point to
+	Links []string
+}
+
+// ParseMarkdownNote splits a Markdown file into a note, reading tags, aliases, title, author and
+// creation date from its YAML front matter
+func ParseMarkdownNote(relPath string, content []byte) (*MarkdownNote, error) {
+	sum := sha256.Sum256(content)
+	note := &MarkdownNote{
+		Path:        relPath,
+		Title:       strings.TrimSuffix(path.Base(relPath), path.Ext(relPath)),
+		ContentHash: hex.EncodeToString(sum[:]),
+	}
+
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	frontMatter, body := splitFrontMatter(text)
+	note.Body = strings.TrimSpace(body)
+
+	for key, values := range parseFrontMatter(frontMatter) {
+		switch strings.ToLower(key) {
+		case "tags", "tag", "concepts":
+			for _, tag := range values {
+				if tag = strings.TrimPrefix(tag, "#"); tag != "" {
+					note.Tags = append(note.Tags, tag)
+				}
+			}
+		case "aliases", "alias":
+			note.Aliases = append(note.Aliases, values...)
+		case "title":
+			if len(values) > 0 && values[0] != "" {
+				note.Title = values[0]
+			}
+		case "author":
+			if len(values) > 0 {
+				note.Author = values[0]
+			}
+		case "created", "date":
+			if len(values) > 0 && note.Created.IsZero() {
+				created, err := parseTimeFlag(values[0])
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", relPath, err)
+				}
+				note.Created = created
+			}
+		}
+	}
+	sort.Strings(note.Tags)
+
+	for _, match := range wikilinkPattern.FindAllStringSubmatch(note.Body, -1) {
+		note.Links = append(note.Links, strings.TrimSuffix(strings.TrimSpace(match[1]), ".md"))
+	}
+	for _, match := range markdownLinkPattern.FindAllStringSubmatch(note.Body, -1) {
+		if link := resolveMarkdownLink(relPath, match[1]); link != "" {
+			note.Links = append(note.Links, link)
+		}
+	}
+
+	return note, nil
+}
+
+// splitFrontMatter separates a leading "---" delimited front matter block from the body
+func splitFrontMatter(text string) (string, string) {
+	if !strings.HasPrefix(text, "---\n") {
+		return "", text
+	}
+	rest := text[len("---\n"):]
+	for offset := 0; offset < len(rest); {
+		end := strings.IndexByte(rest[offset:], '\n')
+		if end < 0 {
+			end = len(rest) - offset
+		}
+		line := strings.TrimSpace(rest[offset : offset+end])
+		if line == "---" || line == "..." {
+			return rest[:offset], rest[min(offset+end+1, len(rest)):]
+		}
+		offset += end + 1
+	}
+	return "", text
+}
+
+// parseFrontMatter reads the subset of YAML used in note front matter: scalars, inline lists
+// ([a, b] or a, b) and block lists of "- item" lines
+func parseFrontMatter(frontMatter string) map[string][]string {
+	values := make(map[string][]string)
+	var current string
+	for _, line := range strings.Split(frontMatter, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Block list item belonging to the last key
+		if strings.HasPrefix(trimmed, "- ") && current != "" {
+			values[current] = append(values[current], unquoteYAML(strings.TrimPrefix(trimmed, "- ")))
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		current = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if value == "" {
+			values[current] = nil
+			continue
+		}
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			value = value[1 : len(value)-1]
+		} else if current != "tags" && current != "tag" && current != "concepts" && current != "aliases" {
+			values[current] = []string{unquoteYAML(value)}
+			continue
+		}
+		for _, item := range strings.Split(value, ",") {
+			if item = unquoteYAML(item); item != "" {
+				values[current] = append(values[current], item)
+			}
+		}
+	}
+	return values
+}
+
+// unquoteYAML trims the whitespace and quotes around a YAML scalar
+func unquoteYAML(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return value
+}
+
+// ImportMarkdown walks dir and adds or updates a note for every Markdown file in it, such as the
+// notes of an Obsidian vault. Notes are keyed by the file's absolute path, so importing the same
+// directory again only updates the files whose content hash changed.
+func (importer *NoteImporter) ImportMarkdown(graph *KnowledgeGraph, dir string) (ImportResult, error) {
+	var result ImportResult
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return result, err
+	}
+	files, err := markdownFiles(root)
+	if err != nil {
+		return result, err
+	}
+
+	imported := importedNodes(graph)
+	notes := make(map[int64]*MarkdownNote)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return result, err
+		}
+		relPath, err := filepath.Rel(root, file)
+		if err != nil {
+			return result, err
+		}
+		note, err := ParseMarkdownNote(filepath.ToSlash(relPath), content)
+		if err != nil {
+			return result, err
+		}
+
+		text := note.Body
+		if text == "" {
+			text = note.Title
+		}
+		node, err := importer.upsertNote(graph, imported[file], ImportedNote{
+			Key:         file,
+			Text:        text,
+			Concepts:    note.Tags,
+			Author:      note.Author,
+			CreatedAt:   note.Created,
+			UpdatedAt:   info.ModTime().UTC(),
+			ContentHash: note.ContentHash,
+		}, &result)
+		if err != nil {
+			return result, err
+		}
+		notes[node.ID] = note
+	}
+
+	// Turn links into directed edges once every note of the vault is in the graph
+	result.Links = linkMarkdownNotes(graph, notes)
+	return result, nil
+}
+
+// linkMarkdownNotes creates a links-to edge for every link that resolves to another imported note
+func linkMarkdownNotes(graph *KnowledgeGraph, notes map[int64]*MarkdownNote) int {
+	// Obsidian resolves wikilinks by path, file name or alias, ignoring case
+	byName := make(map[string]int64)
+	for id, note := range notes {
+		withoutExt := strings.TrimSuffix(note.Path, path.Ext(note.Path))
+		for _, name := range append([]string{withoutExt, path.Base(withoutExt)}, note.Aliases...) {
+			name = strings.ToLower(name)
+			if _, taken := byName[name]; !taken || name == strings.ToLower(withoutExt) {
+				byName[name] = id
+			}
+		}
+	}
+
+	ids := make([]int64, 0, len(notes))
+	for id := range notes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	links := 0
+	for _, id := range ids {
+		note := notes[id]
+		for _, link := range note.Links {
+			targetID, ok := byName[strings.ToLower(link)]
+			if !ok || targetID == id {
+				continue
+			}
+			graph.PutEdge(id, targetID, 1, RelationLinksTo, true)
+			links++
+		}
+	}
+	return links
+}
+
+// resolveMarkdownLink turns a Markdown link target, relative to the linking note, into a
+// vault-relative name without extension; links to other sites and non-Markdown files resolve to ""
+func resolveMarkdownLink(notePath, link string) string {
+	if strings.Contains(link, "://") || strings.HasPrefix(link, "mailto:") || strings.HasPrefix(link, "#") {
+		return ""
+	}
+	link, _, _ = strings.Cut(link, "#")
+	if unescaped, err := url.PathUnescape(link); err == nil {
+		link = unescaped
+	}
+	ext := path.Ext(link)
+	if ext != ".md" && ext != ".markdown" {
+		return ""
+	}
+	link = strings.TrimSuffix(link, ext)
+
+	if strings.HasPrefix(link, "/") {
+		return strings.TrimPrefix(link, "/")
+	}
+	return path.Join(path.Dir(notePath), link)
+}
+
+// markdownFiles lists the Markdown files under root, skipping hidden directories such as .obsidian
+func markdownFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if file != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := strings.ToLower(filepath.Ext(file)); ext == ".md" || ext == ".markdown" {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %v", root, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// vaultRelatedLimit is the default number of edges listed in an exported note's Related section
+const vaultRelatedLimit = 10
+
+// vaultLink is an entry of an exported note's Related section
+type vaultLink struct {
+	Node     *Node
+	Relation string
+	Weight   float64
+}
+
+// ExportMarkdownVault writes the graph as a Markdown vault: one file per note under notes/, with
+// front matter, a Related section listing its strongest edges and a Backlinks section, and one
+// index page per concept under concepts/
+func ExportMarkdownVault(graph *KnowledgeGraph, dir string, relatedLimit int, now time.Time) error {
+	for _, sub := range []string{"notes", "concepts"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return fmt.Errorf("failed to create vault directory: %v", err)
+		}
+	}
+
+	conceptNames := conceptVaultNames(graph)
+
+	// Work out every note's Related section first, so backlinks can be derived from them
+	related := make(map[int64][]vaultLink)
+	backlinks := make(map[int64][]*Node)
+	for _, id := range graph.sortedNodeIDs() {
+		related[id] = vaultRelated(graph, id, relatedLimit, now)
+		linked := make(map[int64]bool)
+		for _, link := range related[id] {
+			if !linked[link.Node.ID] {
+				linked[link.Node.ID] = true
+				backlinks[link.Node.ID] = append(backlinks[link.Node.ID], graph.Nodes[id])
+			}
+		}
+	}
+
+	for _, id := range graph.sortedNodeIDs() {
+		node := graph.Nodes[id]
+		var b strings.Builder
+		b.WriteString("---\n")
+		fmt.Fprintf(&b, "id: %d\n", node.ID)
+		writeYAMLList(&b, "concepts", graph.NodeConcepts(id))
+		if !node.CreatedAt.IsZero() {
+			fmt.Fprintf(&b, "created: %s\n", formatTime(node.CreatedAt))
+			fmt.Fprintf(&b, "updated: %s\n", formatTime(node.UpdatedAt))
+		}
+		if node.Source != "" {
+			fmt.Fprintf(&b, "source: %s\n", node.Source)
+		}
+		if node.Author != "" {
+			fmt.Fprintf(&b, "author: %s\n", strconv.Quote(node.Author))
+		}
+		writeYAMLList(&b, "tags", node.Tags)
+		b.WriteString("---\n\n")
+		b.WriteString(node.Text)
+		b.WriteString("\n")
+
+		if concepts := graph.NodeConcepts(id); len(concepts) > 0 {
+			links := make([]string, len(concepts))
+			for i, concept := range concepts {
+				links[i] = conceptVaultLink(conceptNames, concept)
+			}
+			fmt.Fprintf(&b, "\nConcepts: %s\n", strings.Join(links, ", "))
+		}
+		if len(related[id]) > 0 {
+			b.WriteString("\n## Related\n\n")
+			for _, link := range related[id] {
+				fmt.Fprintf(&b, "- [[%s]] (%s, %.2f)\n", noteVaultName(link.Node), link.Relation, link.Weight)
+			}
+		}
+		if len(backlinks[id]) > 0 {
+			b.WriteString("\n## Backlinks\n\n")
+			for _, other := range backlinks[id] {
+				fmt.Fprintf(&b, "- [[%s]]\n", noteVaultName(other))
+			}
+		}
+
+		file := filepath.Join(dir, "notes", noteVaultName(node)+".md")
+		if err := os.WriteFile(file, []byte(b.String()), 0o644); err != nil {
+			return fmt.Errorf("failed to write note %d: %v", id, err)
+		}
+	}
+
+	for _, concept := range graph.Concepts {
+		nodeIDs := graph.ConceptNodeIDs(concept.ID)
+		broader := graph.BroaderConceptIDs(concept.ID)
+		narrower := graph.NarrowerConceptIDs(concept.ID)
+		if len(nodeIDs) == 0 && len(broader) == 0 && len(narrower) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "---\nconcept: %s\n---\n\n# %s\n", strconv.Quote(concept.Name), concept.Name)
+		for _, section := range []struct {
+			label string
+			ids   []int64
+		}{
+			{"Broader", broader},
+			{"Narrower", narrower},
+			{"Related", graph.RelatedConceptIDs(concept.ID)},
+		} {
+			if len(section.ids) == 0 {
+				continue
+			}
+			links := make([]string, len(section.ids))
+			for i, id := range section.ids {
+				links[i] = conceptVaultLink(conceptNames, graph.Concepts[id].Name)
+			}
+			fmt.Fprintf(&b, "\n%s: %s\n", section.label, strings.Join(links, ", "))
+		}
+		if len(nodeIDs) > 0 {
+			b.WriteString("\n## Notes\n\n")
+			for _, nodeID := range nodeIDs {
+				fmt.Fprintf(&b, "- [[%s]]\n", noteVaultName(graph.Nodes[nodeID]))
+			}
+		}
+
+		file := filepath.Join(dir, "concepts", conceptNames[concept.Name]+".md")
+		if err := os.WriteFile(file, []byte(b.String()), 0o644); err != nil {
+			return fmt.Errorf("failed to write concept %q: %v", concept.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// vaultRelated returns a note's strongest edges after recency decay, leaving out faded edges and the links
+// other notes make to it, which show up as backlinks instead
+func vaultRelated(graph *KnowledgeGraph, nodeID int64, limit int, now time.Time) []vaultLink {
+	var links []vaultLink
+	for _, edge := range graph.Edges {
+		if (edge.SourceID != nodeID && (edge.TargetID != nodeID || edge.Directed)) || graph.Faded(edge, now) {
+			continue
+		}
+		if other, ok := graph.Nodes[edge.Other(nodeID)]; ok {
+			links = append(links, vaultLink{Node: other, Relation: edge.Relation, Weight: graph.EffectiveWeight(edge, now)})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Weight != links[j].Weight {
+			return links[i].Weight > links[j].Weight
+		}
+		return links[i].Node.ID < links[j].Node.ID
+	})
+	if limit > 0 && len(links) > limit {
+		links = links[:limit]
+	}
+	return links
+}
+
+// noteVaultName returns the file name, without extension, of a note in an exported vault
+func noteVaultName(node *Node) string {
+	return vaultSlug(node.Text, node.ID)
+}
+
+// conceptVaultLink returns a wikilink to a concept's index page
+func conceptVaultLink(names map[string]string, name string) string {
+	return fmt.Sprintf("[[concepts/%s|%s]]", names[name], name)
+}
+
+// conceptVaultNames returns the file name, without extension, of every concept's index page by concept
+// name; when several concepts share a slug the oldest keeps it and the others get their ID appended
+func conceptVaultNames(graph *KnowledgeGraph) map[string]string {
+	ids := sortedKeys(graph.Concepts)
+	names := make(map[string]string, len(ids))
+	taken := make(map[string]bool, len(ids))
+	var clashes []int64
+	for _, id := range ids {
+		slug := vaultSlug(graph.Concepts[id].Name, 0)
+		if taken[slug] {
+			clashes = append(clashes, id)
+			continue
+		}
+		taken[slug] = true
+		names[graph.Concepts[id].Name] = slug
+	}
+	for _, id := range clashes {
+		slug := vaultSlug(graph.Concepts[id].Name, 0)
+		for taken[slug] {
+			slug = fmt.Sprintf("%s-%d", slug, id)
+		}
+		taken[slug] = true
+		names[graph.Concepts[id].Name] = slug
+	}
+	return names
+}
+
+// vaultSlug turns text into a file name made of its first few words, prefixed by an ID when non-zero
+func vaultSlug(text string, id int64) string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '+'
+	}) {
+		if len(words) == 6 {
+			break
+		}
+		words = append(words, word)
+	}
+	slug := strings.Join(words, "-")
+	if id == 0 {
+		if slug == "" {
+			slug = "untitled"
+		}
+		return slug
+	}
+	if slug == "" {
+		return strconv.FormatInt(id, 10)
+	}
+	return fmt.Sprintf("%d-%s", id, slug)
+}
+
+// writeYAMLList writes a front-matter key with a block list of quoted values
+func writeYAMLList(b *strings.Builder, key string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s:\n", key)
+	for _, value := range values {
+		fmt.Fprintf(b, "  - %s\n", strconv.Quote(value))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportMarkdownVaultKeepsCollidingConceptPagesApart(t *testing.T) {
+	graph := NewKnowledgeGraph()
+	names := []string{"C", "C#", "node.js", "node js"}
+	for _, name := range names {
+		if _, err := AddNote(graph, "Notes on "+name, []string{name}, NodeMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := t.TempDir()
+	if err := ExportMarkdownVault(graph, dir, vaultRelatedLimit, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := os.ReadDir(filepath.Join(dir, "concepts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != len(names) {
+		t.Fatalf("vault has %d concept pages, want %d", len(pages), len(names))
+	}
+
+	// Every concept link leads to the page of the concept it names
+	link := regexp.MustCompile(`\[\[concepts/([^|\]]+)\|([^\]]+)\]\]`)
+	notes, err := filepath.Glob(filepath.Join(dir, "notes", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linked := make(map[string]bool)
+	for _, note := range notes {
+		data, err := os.ReadFile(note)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range link.FindAllStringSubmatch(string(data), -1) {
+			page, err := os.ReadFile(filepath.Join(dir, "concepts", match[1]+".md"))
+			if err != nil {
+				t.Errorf("link to %q: %v", match[2], err)
+				continue
+			}
+			if !strings.Contains(string(page), "\n# "+match[2]+"\n") {
+				t.Errorf("link to %q leads to the page of another concept:\n%s", match[2], page)
+			}
+			linked[match[2]] = true
+		}
+	}
+	if len(linked) != len(names) {
+		t.Errorf("notes link to %d concepts, want %d", len(linked), len(names))
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mcpProtocolVersions are the Model Context Protocol revisions the server speaks, newest first
+var mcpProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+)
+
+// mcpSearchLimit is the default number of notes returned by the search tool
+const mcpSearchLimit = 20
+
+// MCPServer exposes a knowledge graph to LLM agents as Model Context Protocol tools and resources
+type MCPServer struct {
+	Graph         *KnowledgeGraph
+	GraphFilePath string
+	APIKey        string
+
+	// Author is recorded on the notes added through the server
+	Author string
+
+	tools []mcpTool
+}
+
+// mcpTool is a tool an agent can call
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+
+	call func(arguments json.RawMessage) (interface{}, error)
+}
+
+// mcpRequest is a JSON-RPC request or notification; notifications have no ID
+type mcpRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// mcpResponse is a JSON-RPC response
+type mcpResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
+}
+
+// mcpError is a JSON-RPC error
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpResource is a readable document listed by resources/list
+type mcpResource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+// mcpResourceTemplate describes a family of readable documents
+type mcpResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType"`
+}
+
+// Resources served by the MCP server
+const (
+	mcpConceptsURI     = "kg://concepts"
+	mcpNoteURIPrefix   = "kg://notes/"
+	mcpNoteURITemplate = mcpNoteURIPrefix + "{id}"
+)
+
+// NewMCPServer creates an MCP server for a loaded graph
+func NewMCPServer(graph *KnowledgeGraph, graphFilePath, apiKey, author string) *MCPServer {
+	server := &MCPServer{Graph: graph, GraphFilePath: graphFilePath, APIKey: apiKey, Author: author}
+	server.tools = []mcpTool{
+		{
+			Name:        "add_note",
+			Description: "Add a note to the knowledge graph and link it to related notes. Concepts are extracted from the text when none are given.",
+			InputSchema: mcpObjectSchema([]string{"text"}, map[string]interface{}{
+				"text":     mcpProperty("string", "The text of the note"),
+				"concepts": mcpArrayProperty("Concepts the note is about; extracted automatically if omitted"),
+				"tags":     mcpArrayProperty("Manual tags for the note"),
+			}),
+			call: server.addNote,
+		},
+		{
+			Name:        "search",
+			Description: "Find notes by text, concept, tag, author or creation date. Concept searches include narrower concepts.",
+			InputSchema: mcpObjectSchema(nil, map[string]interface{}{
+				"text":    mcpProperty("string", "Only notes containing this text, ignoring case"),
+				"concept": mcpProperty("string", "Only notes about this concept"),
+				"tag":     mcpProperty("string", "Only notes with this manual tag"),
+				"author":  mcpProperty("string", "Only notes by this author"),
+				"since":   mcpProperty("string", "Only notes created at or after this date (YYYY-MM-DD or RFC 3339)"),
+				"until":   mcpProperty("string", "Only notes created before this date (YYYY-MM-DD or RFC 3339)"),
+				"limit":   mcpProperty("integer", "Maximum number of notes to return (default 20)"),
+			}),
+			call: server.search,
+		},
+		{
+			Name:        "related_notes",
+			Description: "List the notes most related to a note by shared concepts, strongest first.",
+			InputSchema: mcpObjectSchema([]string{"id"}, map[string]interface{}{
+				"id":    mcpProperty("integer", "The note ID"),
+				"limit": mcpProperty("integer", "Maximum number of notes to return (default 10)"),
+			}),
+			call: server.relatedNotes,
+		},
+		{
+			Name:        "get_node",
+			Description: "Get a note with its concepts, metadata and related notes.",
+			InputSchema: mcpObjectSchema([]string{"id"}, map[string]interface{}{
+				"id": mcpProperty("integer", "The note ID"),
+			}),
+			call: server.getNode,
+		},
+		{
+			Name:        "find_path",
+			Description: "Find the shortest chain of links between two notes, preferring the strongest links.",
+			InputSchema: mcpObjectSchema([]string{"from", "to"}, map[string]interface{}{
+				"from":       mcpProperty("integer", "The ID of the note to start from"),
+				"to":         mcpProperty("integer", "The ID of the note to reach"),
+				"min_weight": mcpProperty("number", "Ignore links weaker than this"),
+			}),
+			call: server.findPath,
+		},
+		{
+			Name:        "list_concepts",
+			Description: "List the concepts in the graph with the number of notes about each, most used first.",
+			InputSchema: mcpObjectSchema(nil, map[string]interface{}{
+				"since": mcpProperty("string", "Only count notes created at or after this date"),
+				"until": mcpProperty("string", "Only count notes created before this date"),
+				"limit": mcpProperty("integer", "Maximum number of concepts to return"),
+			}),
+			call: server.listConcepts,
+		},
+	}
+	return server
+}
+
+// mcpObjectSchema returns the JSON schema of a tool's arguments
+func mcpObjectSchema(required []string, properties map[string]interface{}) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// mcpProperty returns the JSON schema of a scalar argument
+func mcpProperty(kind, description string) map[string]interface{} {
+	return map[string]interface{}{"type": kind, "description": description}
+}
+
+// mcpArrayProperty returns the JSON schema of a list-of-strings argument
+func mcpArrayProperty(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": map[string]string{"type": "string"}, "description": description}
+}
+
+// Serve reads newline-delimited JSON-RPC messages until the input closes, writing one response per request
+func (server *MCPServer) Serve(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(w)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if response := server.handle([]byte(line)); response != nil {
+			if err := encoder.Encode(response); err != nil {
+				return fmt.Errorf("failed to write response: %v", err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read request: %v", err)
+	}
+	return nil
+}
+
+// handle answers one message; notifications get no response
+func (server *MCPServer) handle(message []byte) *mcpResponse {
+	var request mcpRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return &mcpResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcpError{Code: jsonRPCParseError, Message: err.Error()}}
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		if len(request.ID) == 0 {
+			request.ID = json.RawMessage("null")
+		}
+		return &mcpResponse{JSONRPC: "2.0", ID: request.ID, Error: &mcpError{Code: jsonRPCInvalidRequest, Message: "not a JSON-RPC 2.0 request"}}
+	}
+
+	result, rpcErr := server.dispatch(request.Method, request.Params)
+	if len(request.ID) == 0 {
+		return nil
+	}
+	if rpcErr != nil {
+		return &mcpResponse{JSONRPC: "2.0", ID: request.ID, Error: rpcErr}
+	}
+	return &mcpResponse{JSONRPC: "2.0", ID: request.ID, Result: result}
+}
+
+// dispatch runs a JSON-RPC method
+func (server *MCPServer) dispatch(method string, params json.RawMessage) (interface{}, *mcpError) {
+	switch method {
+	case "initialize":
+		var request struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if err := decodeMCPParams(params, &request); err != nil {
+			return nil, err
+		}
+		version := mcpProtocolVersions[0]
+		for _, supported := range mcpProtocolVersions {
+			if request.ProtocolVersion == supported {
+				version = supported
+			}
+		}
+		return map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities": map[string]interface{}{
+				"tools":     map[string]bool{"listChanged": false},
+				"resources": map[string]bool{"listChanged": false, "subscribe": false},
+			},
+			"serverInfo":   map[string]string{"name": "knowledge-graph", "version": "1.0.0"},
+			"instructions": "Notes are linked by the concepts they share. Use search or list_concepts to find notes, then get_node, related_notes and find_path to walk the graph.",
+		}, nil
+	case "ping":
+		return map[string]interface{}{}, nil
+	case "notifications/initialized", "notifications/cancelled":
+		return nil, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": server.tools}, nil
+	case "tools/call":
+		var request struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := decodeMCPParams(params, &request); err != nil {
+			return nil, err
+		}
+		for _, tool := range server.tools {
+			if tool.Name == request.Name {
+				return server.callTool(tool, request.Arguments), nil
+			}
+		}
+		return nil, &mcpError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("unknown tool %q", request.Name)}
+	case "resources/list":
+		return map[string]interface{}{"resources": []mcpResource{{
+			URI:         mcpConceptsURI,
+			Name:        "Concepts",
+			Description: "Every concept with its note count and its broader concepts",
+			MimeType:    "application/json",
+		}}}, nil
+	case "resources/templates/list":
+		return map[string]interface{}{"resourceTemplates": []mcpResourceTemplate{{
+			URITemplate: mcpNoteURITemplate,
+			Name:        "Note",
+			Description: "A note with its concepts, metadata and related notes",
+			MimeType:    "application/json",
+		}}}, nil
+	case "resources/read":
+		var request struct {
+			URI string `json:"uri"`
+		}
+		if err := decodeMCPParams(params, &request); err != nil {
+			return nil, err
+		}
+		content, err := server.readResource(request.URI)
+		if err != nil {
+			return nil, &mcpError{Code: jsonRPCInvalidParams, Message: err.Error()}
+		}
+		text, err := json.MarshalIndent(content, "", "  ")
+		if err != nil {
+			return nil, &mcpError{Code: jsonRPCInternalError, Message: err.Error()}
+		}
+		return map[string]interface{}{"contents": []map[string]string{{
+			"uri":      request.URI,
+			"mimeType": "application/json",
+			"text":     string(text),
+		}}}, nil
+	}
+	return nil, &mcpError{Code: jsonRPCMethodNotFound, Message: fmt.Sprintf("method %q not found", method)}
+}
+
+// decodeMCPParams decodes the params of a request
+func decodeMCPParams(params json.RawMessage, target interface{}) *mcpError {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, target); err != nil {
+		return &mcpError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	return nil
+}
+
+// callTool runs a tool; failures are reported to the agent as an error result rather than a protocol error
+func (server *MCPServer) callTool(tool mcpTool, arguments json.RawMessage) map[string]interface{} {
+	if len(arguments) == 0 {
+		arguments = json.RawMessage("{}")
+	}
+	result, err := tool.call(arguments)
+	var text string
+	if err == nil {
+		var encoded []byte
+		if encoded, err = json.MarshalIndent(result, "", "  "); err == nil {
+			text = string(encoded)
+		}
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"content": []map[string]string{{"type": "text", "text": err.Error()}},
+			"isError": true,
+		}
+	}
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": false,
+	}
+}
+
+// decodeToolArguments decodes a tool's arguments, rejecting unknown ones
+func decodeToolArguments(arguments json.RawMessage, target interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(string(arguments)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("invalid arguments: %v", err)
+	}
+	return nil
+}
+
+// addNote implements the add_note tool the same way BuildOrUpdateKnowledgeGraph does, then saves the graph
+func (server *MCPServer) addNote(arguments json.RawMessage) (interface{}, error) {
+	var request noteRequest
+	if err := decodeToolArguments(arguments, &request); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(request.Text) == "" {
+		return nil, errors.New("note text must not be empty")
+	}
+	concepts := request.Concepts
+	if len(concepts) == 0 {
+		if server.APIKey == "" {
+			return nil, errors.New("concepts are required when no OpenAI API key is set")
+		}
+		var err error
+		if concepts, err = ExtractConcepts(request.Text, server.APIKey); err != nil {
+			return nil, err
+		}
+	}
+
+	node, err := AddNote(server.Graph, request.Text, concepts, NodeMetadata{
+		Source: SourceAPI,
+		Author: server.Author,
+		Tags:   request.Tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveGraph(server.GraphFilePath, server.Graph); err != nil {
+		return nil, fmt.Errorf("failed to save knowledge graph: %v", err)
+	}
+	return server.nodeWithRelated(node, 10), nil
+}
+
+// search implements the search tool
+func (server *MCPServer) search(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		Text    string `json:"text"`
+		Concept string `json:"concept"`
+		Tag     string `json:"tag"`
+		Author  string `json:"author"`
+		Since   string `json:"since"`
+		Until   string `json:"until"`
+		Limit   int    `json:"limit"`
+	}
+	if err := decodeToolArguments(arguments, &request); err != nil {
+		return nil, err
+	}
+	filter := NodeFilter{Concept: request.Concept, Rollup: true, Text: request.Text, Tag: request.Tag, Author: request.Author}
+	var err error
+	if filter.Since, err = parseTimeFlag(request.Since); err != nil {
+		return nil, err
+	}
+	if filter.Until, err = parseTimeFlag(request.Until); err != nil {
+		return nil, err
+	}
+	if request.Limit <= 0 {
+		request.Limit = mcpSearchLimit
+	}
+
+	ids := SearchNodes(server.Graph, filter)
+	views := []nodeView{}
+	for _, id := range ids {
+		if len(views) == request.Limit {
+			break
+		}
+		views = append(views, newNodeView(server.Graph, server.Graph.Nodes[id]))
+	}
+	return map[string]interface{}{"total": len(ids), "notes": views}, nil
+}
+
+// relatedNotes implements the related_notes tool
+func (server *MCPServer) relatedNotes(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		ID    int64 `json:"id"`
+		Limit int   `json:"limit"`
+	}
+	if err := decodeToolArguments(arguments, &request); err != nil {
+		return nil, err
+	}
+	node, ok := server.Graph.Node(request.ID)
+	if !ok {
+		return nil, fmt.Errorf("node %d not found", request.ID)
+	}
+	if request.Limit <= 0 {
+		request.Limit = 10
+	}
+	related := []relatedView{}
+	for _, note := range RelatedNotes(server.Graph, node.ID, request.Limit, time.Now()) {
+		related = append(related, relatedView{ID: note.Node.ID, Text: note.Node.Text, Weight: note.Weight})
+	}
+	return related, nil
+}
+
+// getNode implements the get_node tool
+func (server *MCPServer) getNode(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		ID int64 `json:"id"`
+	}
+	if err := decodeToolArguments(arguments, &request); err != nil {
+		return nil, err
+	}
+	node, ok := server.Graph.Node(request.ID)
+	if !ok {
+		return nil, fmt.Errorf("node %d not found", request.ID)
+	}
+	return server.nodeWithRelated(node, 10), nil
+}
+
+// findPath implements the find_path tool
+func (server *MCPServer) findPath(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		From      int64   `json:"from"`
+		To        int64   `json:"to"`
+		MinWeight float64 `json:"min_weight"`
+	}
+	if err := decodeToolArguments(arguments, &request); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	path, err := FindPath(server.Graph, request.From, request.To, request.MinWeight, now)
+	if err != nil {
+		return nil, err
+	}
+	if path == nil {
+		return map[string]interface{}{"found": false}, nil
+	}
+
+	type step struct {
+		ID       int64   `json:"id"`
+		Text     string  `json:"text"`
+		Relation string  `json:"relation,omitempty"`
+		Weight   float64 `json:"weight,omitempty"`
+	}
+	steps := []step{}
+	for i, id := range path.NodeIDs {
+		current := step{ID: id, Text: server.Graph.Nodes[id].Text}
+		// Each step after the first records the link it was reached by
+		if i > 0 {
+			edge := path.Edges[i-1]
+			current.Relation = edge.Relation
+			current.Weight = server.Graph.EffectiveWeight(edge, now)
+		}
+		steps = append(steps, current)
+	}
+	return map[string]interface{}{"found": true, "length": len(path.Edges), "steps": steps}, nil
+}
+
+// listConcepts implements the list_concepts tool
+func (server *MCPServer) listConcepts(arguments json.RawMessage) (interface{}, error) {
+	var request struct {
+		Since string `json:"since"`
+		Until string `json:"until"`
+		Limit int    `json:"limit"`
+	}
+	if err := decodeToolArguments(arguments, &request); err != nil {
+		return nil, err
+	}
+	since, err := parseTimeFlag(request.Since)
+	if err != nil {
+		return nil, err
+	}
+	until, err := parseTimeFlag(request.Until)
+	if err != nil {
+		return nil, err
+	}
+	counts := TopConcepts(server.Graph, since, until)
+	if request.Limit > 0 && len(counts) > request.Limit {
+		counts = counts[:request.Limit]
+	}
+	if counts == nil {
+		counts = []ConceptCount{}
+	}
+	return counts, nil
+}
+
+// nodeWithRelated returns the JSON view of a note including its strongest related notes
+func (server *MCPServer) nodeWithRelated(node *Node, limit int) nodeView {
+	view := newNodeView(server.Graph, node)
+	for _, related := range RelatedNotes(server.Graph, node.ID, limit, time.Now()) {
+		view.Related = append(view.Related, relatedView{ID: related.Node.ID, Text: related.Node.Text, Weight: related.Weight})
+	}
+	return view
+}
+
+// readResource returns the content of a resource URI
+func (server *MCPServer) readResource(uri string) (interface{}, error) {
+	if uri == mcpConceptsURI {
+		type conceptEntry struct {
+			Name    string   `json:"name"`
+			Notes   int      `json:"notes"`
+			Broader []string `json:"broader,omitempty"`
+		}
+		concepts := []conceptEntry{}
+		for _, count := range TopConcepts(server.Graph, time.Time{}, time.Time{}) {
+			entry := conceptEntry{Name: count.Concept, Notes: count.Count}
+			if concept := server.Graph.ConceptByName(count.Concept); concept != nil {
+				for _, id := range server.Graph.BroaderConceptIDs(concept.ID) {
+					entry.Broader = append(entry.Broader, server.Graph.Concepts[id].Name)
+				}
+			}
+			concepts = append(concepts, entry)
+		}
+		return concepts, nil
+	}
+	if idText, ok := strings.CutPrefix(uri, mcpNoteURIPrefix); ok {
+		id, err := strconv.ParseInt(idText, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid note ID %q", idText)
+		}
+		node, ok := server.Graph.Node(id)
+		if !ok {
+			return nil, fmt.Errorf("node %d not found", id)
+		}
+		return server.nodeWithRelated(node, 10), nil
+	}
+	return nil, fmt.Errorf("unknown resource %q", uri)
+}
+
+// runMCPCommand serves the graph to an MCP client over standard input and output
+func runMCPCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("mcp", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	author := env.User
+	if author == "" {
+		author = currentAuthor()
+	}
+	server := NewMCPServer(env.Graph, env.GraphFilePath, env.APIKey, author)
+	return server.Serve(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// mcpClient drives an MCPServer over a pair of pipes, as an agent talks to `mcp` over stdio
+type mcpClient struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *bufio.Scanner
+	done   chan error
+	lastID int
+}
+
+// startMCP serves a graph stored at path to a new client
+func startMCP(t *testing.T, path string) *mcpClient {
+	t.Helper()
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+	client := &mcpClient{t: t, in: requestWriter, out: bufio.NewScanner(responseReader), done: make(chan error, 1)}
+	server := NewMCPServer(NewKnowledgeGraph(), path, "", "agent")
+	go func() {
+		err := server.Serve(requestReader, responseWriter)
+		responseWriter.Close()
+		client.done <- err
+	}()
+	return client
+}
+
+// send writes one line to the server
+func (client *mcpClient) send(line string) {
+	client.t.Helper()
+	if _, err := io.WriteString(client.in, line+"\n"); err != nil {
+		client.t.Fatal(err)
+	}
+}
+
+// notify sends a notification, which gets no reply
+func (client *mcpClient) notify(method string) {
+	client.t.Helper()
+	client.send(fmt.Sprintf(`{"jsonrpc":"2.0","method":%q}`, method))
+}
+
+// call sends a request and returns its reply
+func (client *mcpClient) call(method string, params interface{}) mcpReply {
+	client.t.Helper()
+	client.lastID++
+	data, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": client.lastID, "method": method, "params": params})
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	client.send(string(data))
+	reply := client.read()
+	if string(reply.ID) != fmt.Sprint(client.lastID) {
+		client.t.Fatalf("%s: reply has ID %s, want %d", method, reply.ID, client.lastID)
+	}
+	return reply
+}
+
+// read reads the next reply
+func (client *mcpClient) read() mcpReply {
+	client.t.Helper()
+	if !client.out.Scan() {
+		client.t.Fatalf("server closed its output: %v", client.out.Err())
+	}
+	var reply mcpReply
+	if err := json.Unmarshal(client.out.Bytes(), &reply); err != nil {
+		client.t.Fatalf("reply %q is not JSON: %v", client.out.Text(), err)
+	}
+	if reply.JSONRPC != "2.0" {
+		client.t.Errorf("reply %q is not JSON-RPC 2.0", client.out.Text())
+	}
+	return reply
+}
+
+// mcpReply is a JSON-RPC response as a client decodes it
+type mcpReply struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *mcpError       `json:"error"`
+}
+
+// toolResult decodes the result of tools/call
+func (client *mcpClient) toolResult(reply mcpReply, view interface{}) bool {
+	client.t.Helper()
+	var result struct {
+		Content []struct{ Type, Text string }
+		IsError bool
+	}
+	if reply.Error != nil {
+		client.t.Fatalf("tools/call failed: %+v", reply.Error)
+	}
+	if err := json.Unmarshal(reply.Result, &result); err != nil || len(result.Content) != 1 || result.Content[0].Type != "text" {
+		client.t.Fatalf("malformed tool result %s", reply.Result)
+	}
+	if !result.IsError && view != nil {
+		if err := json.Unmarshal([]byte(result.Content[0].Text), view); err != nil {
+			client.t.Fatalf("tool returned %q: %v", result.Content[0].Text, err)
+		}
+	}
+	return result.IsError
+}
+
+func TestMCPServerOverStdio(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	client := startMCP(t, path)
+
+	var initialized struct {
+		ProtocolVersion string            `json:"protocolVersion"`
+		ServerInfo      map[string]string `json:"serverInfo"`
+		Capabilities    map[string]json.RawMessage
+	}
+	reply := client.call("initialize", map[string]interface{}{"protocolVersion": "2024-11-05", "capabilities": map[string]interface{}{}})
+	if err := json.Unmarshal(reply.Result, &initialized); err != nil || reply.Error != nil {
+		t.Fatalf("initialize: %s %+v", reply.Result, reply.Error)
+	}
+	if initialized.ProtocolVersion != "2024-11-05" || initialized.ServerInfo["name"] != "knowledge-graph" || initialized.Capabilities["tools"] == nil {
+		t.Errorf("initialize returned %s", reply.Result)
+	}
+	client.notify("notifications/initialized")
+
+	var listed struct{ Tools []mcpTool }
+	reply = client.call("tools/list", nil)
+	if err := json.Unmarshal(reply.Result, &listed); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, tool := range listed.Tools {
+		names[tool.Name] = tool.InputSchema["type"] == "object"
+	}
+	for _, name := range []string{"add_note", "search", "related_notes", "get_node", "find_path", "list_concepts"} {
+		if !names[name] {
+			t.Errorf("tools/list lacks %s or its input schema", name)
+		}
+	}
+
+	var added nodeView
+	reply = client.call("tools/call", map[string]interface{}{"name": "add_note", "arguments": map[string]interface{}{"text": "Pipes carry JSON-RPC", "concepts": []string{"mcp"}}})
+	if client.toolResult(reply, &added) || added.Text != "Pipes carry JSON-RPC" || added.Author != "agent" {
+		t.Errorf("add_note returned %s", reply.Result)
+	}
+	var found struct {
+		Notes []nodeView
+		Total int
+	}
+	reply = client.call("tools/call", map[string]interface{}{"name": "search", "arguments": map[string]interface{}{"concept": "mcp"}})
+	if client.toolResult(reply, &found) || found.Total != 1 || len(found.Notes) != 1 || found.Notes[0].ID != added.ID {
+		t.Errorf("search returned %s", reply.Result)
+	}
+
+	// Failures: a bad tool argument is a tool error, an unknown tool or method a protocol error
+	if reply = client.call("tools/call", map[string]interface{}{"name": "get_node", "arguments": map[string]interface{}{"id": 999}}); !client.toolResult(reply, nil) {
+		t.Errorf("get_node of a missing note returned %s", reply.Result)
+	}
+	if reply = client.call("tools/call", map[string]interface{}{"name": "drop_graph"}); reply.Error == nil || reply.Error.Code != jsonRPCInvalidParams {
+		t.Errorf("unknown tool returned %s %+v", reply.Result, reply.Error)
+	}
+	if reply = client.call("sampling/createMessage", nil); reply.Error == nil || reply.Error.Code != jsonRPCMethodNotFound {
+		t.Errorf("unknown method returned %s %+v", reply.Result, reply.Error)
+	}
+	client.send("{not json")
+	if reply = client.read(); reply.Error == nil || reply.Error.Code != jsonRPCParseError || string(reply.ID) != "null" {
+		t.Errorf("malformed message returned %s %+v", reply.Result, reply.Error)
+	}
+
+	client.in.Close()
+	if err := <-client.done; err != nil {
+		t.Errorf("server stopped with %v", err)
+	}
+	graph, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 1 {
+		t.Errorf("graph file holds %d notes, want the one added over MCP", len(graph.Nodes))
+	}
+}
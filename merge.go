@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// defaultMergeSimilarity is the word overlap from which notes of two graphs are taken to be the same note
+const defaultMergeSimilarity = 0.9
+
+// Merge conflict kinds
+const (
+	ConflictText      = "text"
+	ConflictAuthor    = "author"
+	ConflictEmbedding = "embedding"
+	ConflictTaxonomy  = "taxonomy"
+	ConflictSettings  = "settings"
+)
+
+// MergeReport describes what merging another graph into this one did
+type MergeReport struct {
+	NodesAdded     int              `json:"nodesAdded"`
+	Duplicates     []MergeDuplicate `json:"duplicates"`
+	ConceptsAdded  []string         `json:"conceptsAdded"`
+	RelationsAdded int              `json:"conceptRelationsAdded"`
+	TypedEdges     int              `json:"typedEdgesAdded"`
+	CrossEdges     int              `json:"crossGraphEdges"`
+	Conflicts      []MergeConflict  `json:"conflicts"`
+
+	// NodeIDs maps each node ID of the other graph to the ID it has in the merged graph, including the IDs the
+	// other graph redirects, which redirect here too
+	NodeIDs map[int64]int64 `json:"nodeIds"`
+}
+
+// MergeDuplicate records a note of the other graph folded into an existing note
+type MergeDuplicate struct {
+	OtherID    int64   `json:"otherId"`
+	NodeID     int64   `json:"nodeId"`
+	Reason     string  `json:"reason"`
+	Similarity float64 `json:"similarity"`
+}
+
+// MergeConflict records a difference the merge resolved by keeping this graph's version
+type MergeConflict struct {
+	Kind   string `json:"kind"`
+	NodeID int64  `json:"nodeId,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// noteContentHash hashes a note's text ignoring case and whitespace differences
+func noteContentHash(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(strings.ToLower(text)), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// noteWords returns the set of lower-cased words in a note
+func noteWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = true
+	}
+	return words
+}
+
+// wordSimilarity returns the Jaccard similarity of two word sets
+func wordSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// mergeIndex finds the existing note another graph's note duplicates
+type mergeIndex struct {
+	hashes map[string]int64
+	words  map[int64]map[string]bool
+}
+
+// newMergeIndex indexes the notes of a graph
+func newMergeIndex(graph *KnowledgeGraph) *mergeIndex {
+	index := &mergeIndex{hashes: make(map[string]int64), words: make(map[int64]map[string]bool)}
+	for _, id := range graph.sortedNodeIDs() {
+		index.add(graph.Nodes[id])
+	}
+	return index
+}
+
+// add makes a note available as a duplicate target; the first note with a given content wins
+func (index *mergeIndex) add(node *Node) {
+	for _, hash := range []string{node.ContentHash, noteContentHash(node.Text)} {
+		if _, ok := index.hashes[hash]; hash != "" && !ok {
+			index.hashes[hash] = node.ID
+		}
+	}
+	index.words[node.ID] = noteWords(node.Text)
+}
+
+// find returns the note a note duplicates, why, and how similar they are
+func (index *mergeIndex) find(node *Node, threshold float64) (int64, string, float64) {
+	if node.ContentHash != "" {
+		if id, ok := index.hashes[node.ContentHash]; ok {
+			return id, "content hash", 1
+		}
+	}
+	if id, ok := index.hashes[noteContentHash(node.Text)]; ok {
+		return id, "content hash", 1
+	}
+
+	words := noteWords(node.Text)
+	bestID, best := int64(0), 0.0
+	for id, candidate := range index.words {
+		// Jaccard similarity can't reach the threshold when one set is much larger than the other
+		small, large := min(len(words), len(candidate)), max(len(words), len(candidate))
+		if large == 0 || float64(small)/float64(large) < threshold {
+			continue
+		}
+		if similarity := wordSimilarity(words, candidate); similarity > best || (similarity == best && id < bestID) {
+			bestID, best = id, similarity
+		}
+	}
+	if bestID != 0 && best >= threshold {
+		return bestID, "near duplicate", best
+	}
+	return 0, "", 0
+}
+
+// MergeGraph merges another graph into this one
+// Notes of the other graph get new IDs unless they duplicate an existing note, in which case their concepts, tags
+// and attachments are folded into it; concepts are matched by name, typed edges are carried over and similarity
+// edges are recomputed with the graph's weighting strategy
+func (graph *KnowledgeGraph) MergeGraph(other *KnowledgeGraph, similarity float64) (*MergeReport, error) {
+	report := &MergeReport{NodeIDs: make(map[int64]int64)}
+	if graph.Settings != other.Settings {
+		report.Conflicts = append(report.Conflicts, MergeConflict{Kind: ConflictSettings, Detail: "the graphs have different settings; kept this graph's"})
+	}
+
+	knownConcepts := make(map[string]bool)
+	for _, concept := range graph.Concepts {
+		knownConcepts[normalizeConcept(concept.Name)] = true
+	}
+	original := make(map[int64]bool, len(graph.Nodes))
+	for id := range graph.Nodes {
+		original[id] = true
+	}
+	embeddingSize := 0
+	for _, node := range graph.Nodes {
+		if len(node.Embedding) > 0 {
+			embeddingSize = len(node.Embedding)
+			break
+		}
+	}
+
+	index := newMergeIndex(graph)
+	touched := make(map[int64]bool)
+	added := make(map[int64]bool)
+	for _, otherID := range other.sortedNodeIDs() {
+		incoming := other.Nodes[otherID]
+		concepts := other.NodeConcepts(otherID)
+
+		if id, reason, score := index.find(incoming, similarity); id != 0 {
+			report.NodeIDs[otherID] = id
+			report.Duplicates = append(report.Duplicates, MergeDuplicate{OtherID: otherID, NodeID: id, Reason: reason, Similarity: score})
+			conceptsChanged, conflicts := graph.foldNote(id, incoming, concepts)
+			if conceptsChanged {
+				touched[id] = true
+			}
+			report.Conflicts = append(report.Conflicts, conflicts...)
+			continue
+		}
+
+		node := &Node{ID: graph.generateNodeID(), Text: incoming.Text, NodeMetadata: incoming.NodeMetadata}
+		node.Tags = slices.Clone(incoming.Tags)
+		node.Attachments = slices.Clone(incoming.Attachments)
+		if len(incoming.Embedding) > 0 && (embeddingSize == 0 || len(incoming.Embedding) == embeddingSize) {
+			node.Embedding = slices.Clone(incoming.Embedding)
+			embeddingSize = len(node.Embedding)
+		} else if len(incoming.Embedding) > 0 {
+			report.Conflicts = append(report.Conflicts, MergeConflict{Kind: ConflictEmbedding, NodeID: node.ID,
+				Detail: fmt.Sprintf("dropped the %d-dimensional embedding of note %d; this graph uses %d dimensions", len(incoming.Embedding), otherID, embeddingSize)})
+		}
+		if err := graph.embedNode(node); err != nil {
+			return nil, err
+		}
+		graph.Nodes[node.ID] = node
+		graph.SetNodeConcepts(node.ID, concepts)
+		index.add(node)
+
+		report.NodeIDs[otherID] = node.ID
+		report.NodesAdded++
+		touched[node.ID] = true
+		added[node.ID] = true
+		graph.publishNode(EventNodeAdded, node)
+	}
+
+	// Redirects of the other graph get IDs of their own here, which resolve to the notes that absorbed them
+	for _, otherID := range sortedKeys(other.Redirects) {
+		target := report.NodeIDs[other.Redirects[otherID]]
+		if target == 0 {
+			continue
+		}
+		id := graph.generateNodeID()
+		graph.Redirects[id] = target
+		graph.touch("Redirect", id)
+		report.NodeIDs[otherID] = id
+	}
+
+	// Concepts no note uses any more still carry the other graph's taxonomy
+	for _, concept := range other.Concepts {
+		graph.ensureConcept(concept.Name)
+	}
+	for _, concept := range graph.Concepts {
+		if !knownConcepts[normalizeConcept(concept.Name)] {
+			report.ConceptsAdded = append(report.ConceptsAdded, concept.Name)
+		}
+	}
+	sort.Strings(report.ConceptsAdded)
+
+	relations := len(graph.ConceptRelations)
+	for _, id := range sortedKeys(other.ConceptRelations) {
+		relation := other.ConceptRelations[id]
+		source, target := other.Concepts[relation.SourceID], other.Concepts[relation.TargetID]
+		if source == nil || target == nil {
+			continue
+		}
+		if _, err := graph.AddConceptRelation(source.Name, target.Name, relation.Kind, relation.Origin); err != nil {
+			report.Conflicts = append(report.Conflicts, MergeConflict{Kind: ConflictTaxonomy,
+				Detail: fmt.Sprintf("skipped %s %s %s: %v", source.Name, relation.Kind, target.Name, err)})
+		}
+	}
+	report.RelationsAdded = len(graph.ConceptRelations) - relations
+
+	// Typed relations are carried over; the stronger weight wins when both graphs have the edge
+	for _, edge := range other.Edges {
+		if edge.IsSimilarity() {
+			continue
+		}
+		sourceID, targetID := report.NodeIDs[edge.SourceID], report.NodeIDs[edge.TargetID]
+		if sourceID == 0 || targetID == 0 || sourceID == targetID {
+			continue
+		}
+		weight := edge.Weight
+		existing := graph.FindEdge(sourceID, targetID, edge.Relation, edge.Directed)
+		if existing == nil {
+			report.TypedEdges++
+		} else {
+			weight = max(weight, existing.Weight)
+		}
+		merged := graph.PutEdge(sourceID, targetID, weight, edge.Relation, edge.Directed)
+		if edge.ReinforcedAt.After(merged.ReinforcedAt) {
+			merged.ReinforcedAt = edge.ReinforcedAt
+			graph.touch("Edge", merged.ID)
+		}
+	}
+
+	// Similarity edges are recomputed for every note that arrived or gained concepts
+	for _, id := range sortedKeys(touched) {
+		for edgeID, edge := range graph.Edges {
+			if edge.IsSimilarity() && (edge.SourceID == id || edge.TargetID == id) {
+				graph.RemoveEdge(edgeID)
+			}
+		}
+		graph.connectNode(id, func(int64) bool { return true })
+	}
+	graph.PruneEdges()
+	for _, edge := range graph.Edges {
+		if edge.IsSimilarity() && ((added[edge.SourceID] && original[edge.TargetID]) || (original[edge.SourceID] && added[edge.TargetID])) {
+			report.CrossEdges++
+		}
+	}
+	return report, nil
+}
+
+// foldNote merges a note into the existing note it duplicates, keeping the existing text, and reports whether the
+// existing note gained concepts
+func (graph *KnowledgeGraph) foldNote(id int64, incoming *Node, concepts []string) (bool, []MergeConflict) {
+	var conflicts []MergeConflict
+	node := graph.Nodes[id]
+	if incoming.Text != node.Text {
+		conflicts = append(conflicts, MergeConflict{Kind: ConflictText, NodeID: id, Detail: "kept the existing text; the duplicate reads: " + firstLine(incoming.Text)})
+	}
+	if incoming.Author != "" && node.Author != "" && incoming.Author != node.Author {
+		conflicts = append(conflicts, MergeConflict{Kind: ConflictAuthor, NodeID: id, Detail: fmt.Sprintf("kept author %s over %s", node.Author, incoming.Author)})
+	}
+
+	conceptsChanged := false
+	existing := graph.NodeConcepts(id)
+	for _, name := range concepts {
+		if !slices.ContainsFunc(existing, func(have string) bool { return normalizeConcept(have) == normalizeConcept(name) }) {
+			existing = append(existing, name)
+			conceptsChanged = true
+		}
+	}
+	if conceptsChanged {
+		graph.SetNodeConcepts(id, existing)
+	}
+	changed := conceptsChanged
+	for _, tag := range incoming.Tags {
+		if !node.HasTag(tag) {
+			node.Tags = append(node.Tags, tag)
+			changed = true
+		}
+	}
+	for _, attachment := range incoming.Attachments {
+		if !slices.Contains(node.Attachments, attachment) {
+			node.Attachments = append(node.Attachments, attachment)
+			changed = true
+		}
+	}
+	if !incoming.CreatedAt.IsZero() && incoming.CreatedAt.Before(node.CreatedAt) {
+		node.CreatedAt = incoming.CreatedAt
+		changed = true
+	}
+	if changed {
+		graph.publishNode(EventNodeUpdated, node)
+	}
+	return conceptsChanged, conflicts
+}
+
+// runMergeCommand merges another graph file into the current graph and reports what happened
+func runMergeCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	similarity := flags.Float64("similarity", defaultMergeSimilarity, "word overlap (0-1) from which two notes are the same note")
+	dryRun := flags.Bool("dry-run", false, "report what would be merged without saving")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: merge [-similarity s] [-dry-run] [-json] <graph-file>")
+	}
+	if *similarity <= 0 || *similarity > 1 {
+		return fmt.Errorf("similarity must be in (0, 1], got %g", *similarity)
+	}
+
+	other, err := ReadGraph(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	report, err := env.Graph.MergeGraph(other, *similarity)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := env.Save(); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	verb := "Merged"
+	if *dryRun {
+		verb = "Would merge"
+	}
+	fmt.Printf("%s %s: %d notes added, %d duplicates folded in, %d concepts added, %d concept relations, %d typed edges, %d similarity edges across the graphs\n",
+		verb, flags.Arg(0), report.NodesAdded, len(report.Duplicates), len(report.ConceptsAdded), report.RelationsAdded, report.TypedEdges, report.CrossEdges)
+	for _, duplicate := range report.Duplicates {
+		fmt.Printf("Duplicate: note %d is note %d (%s, %.2f)\n", duplicate.OtherID, duplicate.NodeID, duplicate.Reason, duplicate.Similarity)
+	}
+	for _, conflict := range report.Conflicts {
+		if conflict.NodeID != 0 {
+			fmt.Printf("Conflict (%s) on note %d: %s\n", conflict.Kind, conflict.NodeID, conflict.Detail)
+		} else {
+			fmt.Printf("Conflict (%s): %s\n", conflict.Kind, conflict.Detail)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReimportIsIdempotentWithMergeOnAdd(t *testing.T) {
+	graph := NewKnowledgeGraph()
+	graph.Settings.DuplicatePolicy = DuplicateMerge
+	if _, err := AddNote(graph, "Raft elects a leader to replicate the log", []string{"raft"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+
+	vault := t.TempDir()
+	if err := os.WriteFile(filepath.Join(vault, "raft.md"), []byte("Raft elects a leader to replicate the log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	importer := &NoteImporter{Author: "tester"}
+	first, err := importer.ImportMarkdown(graph, vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Added != 1 {
+		t.Fatalf("first import added %d notes, want 1", first.Added)
+	}
+	notes := len(graph.Nodes)
+
+	second, err := importer.ImportMarkdown(graph, vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Added != 0 || second.Updated != 0 || second.Unchanged != 1 {
+		t.Errorf("re-import added %d, updated %d and left %d unchanged, want 0, 0 and 1", second.Added, second.Updated, second.Unchanged)
+	}
+	if len(graph.Nodes) != notes {
+		t.Errorf("re-import left %d notes, want %d", len(graph.Nodes), notes)
+	}
+}
+
+func TestMergeGraphCarriesRedirects(t *testing.T) {
+	other := NewKnowledgeGraph()
+	for _, text := range []string{"Gossip spreads membership changes", "Gossip protocols spread membership", "Vector clocks order events"} {
+		if _, err := AddNote(other, text, []string{"distributed"}, NodeMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := other.MergeNodes(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	if err := SaveGraph(path, NewKnowledgeGraph()); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddNote(graph, "Consistent hashing spreads keys", []string{"distributed"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := graph.MergeGraph(other, defaultMergeSimilarity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retired, ok := report.NodeIDs[2]
+	if !ok {
+		t.Fatal("the report does not map the redirected note")
+	}
+	if _, ok := graph.Nodes[retired]; ok {
+		t.Fatalf("the redirected note maps to live note %d", retired)
+	}
+	node, ok := graph.Node(retired)
+	if !ok || node.ID != report.NodeIDs[1] {
+		t.Errorf("ID %d resolves to %v, want the note that absorbed it (%d)", retired, node, report.NodeIDs[1])
+	}
+	checkSaved(t, path, graph, "merging")
+}
+
+func TestRedirectsFollowMergesAndDieWithTheirNote(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	if err := SaveGraph(path, NewKnowledgeGraph()); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"Raft log replication", "Raft replicates logs", "Raft replicates the log"} {
+		if _, err := AddNote(graph, text, []string{"raft"}, NodeMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := graph.MergeNodes(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.MergeNodes(3, 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{1, 2} {
+		if node, ok := graph.Node(id); !ok || node.ID != 3 {
+			t.Errorf("ID %d resolves to %v, want note 3", id, node)
+		}
+	}
+	checkSaved(t, path, graph, "merging")
+
+	if err := graph.RemoveNode(3); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Redirects) != 0 {
+		t.Errorf("redirects %v outlived the note they lead to", graph.Redirects)
+	}
+	checkSaved(t, path, graph, "removing")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node sources
+const (
+	SourceTyped  = "typed"
+	SourceVoice  = "voice"
+	SourceImport = "import"
+	SourceAPI    = "api"
+)
+
+// NodeMetadata describes when, where and by whom a note was recorded
+type NodeMetadata struct {
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Source      string
+	Author      string
+	Tags        []string
+	Attachments []string
+
+	// SourcePath and ContentHash identify imported notes, so re-importing a file updates its note
+	SourcePath  string
+	ContentHash string
+}
+
+// AddNote adds a note with its concepts and metadata to the graph and links it to related notes
+func AddNote(graph *KnowledgeGraph, noteText string, concepts []string, metadata NodeMetadata) (*Node, error) {
+	now := time.Now().UTC()
+	if metadata.CreatedAt.IsZero() {
+		metadata.CreatedAt = now
+	}
+	if metadata.UpdatedAt.IsZero() {
+		metadata.UpdatedAt = metadata.CreatedAt
+	}
+
+	// A near duplicate of an existing note is folded into it when the graph's policy says so; imported notes always
+	// get a note of their own, so re-importing their source finds and updates it
+	var existing *Node
+	if metadata.SourcePath == "" {
+		existing = graph.checkDuplicate(noteText)
+	}
+	if existing != nil {
+		graph.foldNote(existing.ID, &Node{Text: noteText, NodeMetadata: metadata}, concepts)
+		existing.UpdatedAt = now
+		if err := graph.UpdateNodeConcepts(existing.ID, graph.NodeConcepts(existing.ID)); err != nil {
+			return nil, err
+		}
+		graph.reinforceEdges(existing.ID, now)
+		return existing, nil
+	}
+
+	// Create nodes for the note
+	node := &Node{
+		ID:           graph.generateNodeID(),
+		Text:         noteText,
+		NodeMetadata: metadata,
+	}
+	if err := graph.embedNode(node); err != nil {
+		return nil, err
+	}
+	graph.Nodes[node.ID] = node
+
+	// Link the note to its concepts
+	graph.SetNodeConcepts(node.ID, concepts)
+
+	// Create edges to the related notes, reinforce the links among them and apply the edge-retention policy
+	graph.connectNode(node.ID, func(int64) bool { return true })
+	graph.reinforceEdges(node.ID, node.UpdatedAt)
+	graph.PruneEdges()
+
+	graph.publishNode(EventNodeAdded, node)
+	return node, nil
+}
+
+// HasTag reports whether a node carries a manual tag, ignoring case
+func (node *Node) HasTag(tag string) bool {
+	for _, t := range node.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentAuthor returns the local user name used as the author of typed notes
+func currentAuthor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return os.Getenv("USERNAME")
+}
+
+// writeNodeMetadata writes the metadata records that follow a node's line in the graph file
+func writeNodeMetadata(w io.Writer, node *Node) error {
+	_, err := fmt.Fprintf(w, "NodeMeta %d: CreatedAt=%s, UpdatedAt=%s, Source=%s, ContentHash=%s\n",
+		node.ID, formatTime(node.CreatedAt), formatTime(node.UpdatedAt), escapeField(node.Source), escapeField(node.ContentHash))
+	if err != nil {
+		return fmt.Errorf("failed to write node metadata: %v", err)
+	}
+	if node.Author != "" {
+		if _, err := fmt.Fprintf(w, "Author %d: %s\n", node.ID, escapeText(node.Author)); err != nil {
+			return fmt.Errorf("failed to write node author: %v", err)
+		}
+	}
+	if node.SourcePath != "" {
+		if _, err := fmt.Fprintf(w, "SourcePath %d: %s\n", node.ID, escapeText(node.SourcePath)); err != nil {
+			return fmt.Errorf("failed to write node source path: %v", err)
+		}
+	}
+	for _, tag := range node.Tags {
+		if _, err := fmt.Fprintf(w, "Tag %d: %s\n", node.ID, escapeText(tag)); err != nil {
+			return fmt.Errorf("failed to write node tag: %v", err)
+		}
+	}
+	for _, attachment := range node.Attachments {
+		if _, err := fmt.Fprintf(w, "Attachment %d: %s\n", node.ID, escapeText(attachment)); err != nil {
+			return fmt.Errorf("failed to write node attachment: %v", err)
+		}
+	}
+	return nil
+}
+
+// parseNodeMetadata applies a NodeMeta, SourcePath, Author, Tag or Attachment record to the node it refers to
+func parseNodeMetadata(graph *KnowledgeGraph, kind, line string) error {
+	var id int64
+	if _, err := fmt.Sscanf(line, kind+" %d:", &id); err != nil {
+		return err
+	}
+	node, ok := graph.Nodes[id]
+	if !ok {
+		return fmt.Errorf("%s record for unknown node %d", kind, id)
+	}
+	value := strings.TrimSpace(strings.TrimPrefix(line, fmt.Sprintf("%s %d:", kind, id)))
+
+	switch kind {
+	case "NodeMeta":
+		fields := parseRecordFields(value)
+		var err error
+		if node.CreatedAt, err = parseTime(fields["CreatedAt"]); err != nil {
+			return err
+		}
+		if node.UpdatedAt, err = parseTime(fields["UpdatedAt"]); err != nil {
+			return err
+		}
+		node.Source = unescapeText(fields["Source"])
+		node.ContentHash = unescapeText(fields["ContentHash"])
+	case "SourcePath":
+		node.SourcePath = unescapeText(value)
+	case "Author":
+		node.Author = unescapeText(value)
+	case "Tag":
+		node.Tags = append(node.Tags, unescapeText(value))
+	case "Attachment":
+		node.Attachments = append(node.Attachments, unescapeText(value))
+	}
+	return nil
+}
+
+// formatTime encodes a timestamp for the graph file, using "-" for unknown times
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime decodes a timestamp written by formatTime
+func parseTime(value string) (time.Time, error) {
+	if value == "" || value == "-" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// parseTimeFlag parses a date or timestamp given on the command line
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02", "2006-01"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use YYYY-MM-DD or RFC 3339", value)
+}
+
+// runTagCommand adds or removes manual tags on a node
+func runTagCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("tag", flag.ContinueOnError)
+	remove := flags.Bool("remove", false, "remove the tags instead of adding them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return errors.New("usage: tag [-remove] <node-id> <tag>...")
+	}
+
+	id, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid node ID %q", flags.Arg(0))
+	}
+	node, ok := env.Graph.Node(id)
+	if !ok {
+		return fmt.Errorf("node %d not found", id)
+	}
+
+	for _, tag := range flags.Args()[1:] {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "":
+		case *remove:
+			for i, t := range node.Tags {
+				if strings.EqualFold(t, tag) {
+					node.Tags = append(node.Tags[:i], node.Tags[i+1:]...)
+					break
+				}
+			}
+		case !node.HasTag(tag):
+			node.Tags = append(node.Tags, tag)
+		}
+	}
+	node.UpdatedAt = time.Now().UTC()
+	env.Graph.publishNode(EventNodeUpdated, node)
+
+	printNode(env.Graph, node)
+	return env.Save()
+}
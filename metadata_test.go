@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNodeMetadataSurvivesSeparatorsAndNewlines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	metadata := NodeMetadata{
+		Source:      "import, vault",
+		Author:      "Ada\nNode 99: injected",
+		SourcePath:  `notes\daily` + "\n2024.md",
+		Tags:        []string{"to do, later", "line\nbreak"},
+		Attachments: []string{"a.png\r\nTag 1: x", `C:\files\b.pdf`},
+		ContentHash: "abc,def",
+	}
+	node, err := AddNote(graph, "A note", []string{"testing"}, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGraph(path)
+	if err != nil {
+		t.Fatalf("failed to load saved graph: %v", err)
+	}
+	if len(loaded.Nodes) != 1 {
+		t.Fatalf("loaded %d nodes, want 1", len(loaded.Nodes))
+	}
+	got := loaded.Nodes[node.ID]
+	if got.Source != metadata.Source || got.Author != metadata.Author || got.SourcePath != metadata.SourcePath || got.ContentHash != metadata.ContentHash {
+		t.Errorf("metadata changed on reload: got %+v, want %+v", got.NodeMetadata, metadata)
+	}
+	if !slices.Equal(got.Tags, metadata.Tags) {
+		t.Errorf("tags changed on reload: got %q, want %q", got.Tags, metadata.Tags)
+	}
+	if !slices.Equal(got.Attachments, metadata.Attachments) {
+		t.Errorf("attachments changed on reload: got %q, want %q", got.Attachments, metadata.Attachments)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Neo4j labels and relationship types used by the exports
+const (
+	neo4jNoteLabel      = "Note"
+	neo4jConceptLabel   = "Concept"
+	neo4jMembershipType = "HAS_CONCEPT"
+)
+
+// neo4jArrayDelimiter separates the elements of array columns, as neo4j-admin expects by default
+const neo4jArrayDelimiter = ";"
+
+// neo4jFiles lists the CSV files of an export with the neo4j-admin option that loads each
+var neo4jFiles = []struct{ name, option string }{
+	{"notes.csv", "--nodes"},
+	{"concepts.csv", "--nodes"},
+	{"edges.csv", "--relationships"},
+	{"memberships.csv", "--relationships"},
+	{"concept_relations.csv", "--relationships"},
+}
+
+// neo4jRelationshipType turns an edge relation such as links-to into a relationship type such as LINKS_TO
+func neo4jRelationshipType(relation string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, relation))
+}
+
+// neo4jConcepts returns the IDs of the concepts used by the subgraph's notes, in ID order
+func neo4jConcepts(graph *KnowledgeGraph, subgraph *Subgraph) []int64 {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, nodeID := range subgraph.NodeIDs {
+		for _, conceptID := range graph.NodeConceptIDs(nodeID) {
+			if !seen[conceptID] {
+				seen[conceptID] = true
+				ids = append(ids, conceptID)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// neo4jConceptRelations returns the concept relations among the given concepts, in ID order
+func neo4jConceptRelations(graph *KnowledgeGraph, conceptIDs []int64) []*ConceptRelation {
+	included := make(map[int64]bool)
+	for _, id := range conceptIDs {
+		included[id] = true
+	}
+	var relations []*ConceptRelation
+	for _, relation := range graph.ConceptRelations {
+		if included[relation.SourceID] && included[relation.TargetID] {
+			relations = append(relations, relation)
+		}
+	}
+	sort.Slice(relations, func(i, j int) bool { return relations[i].ID < relations[j].ID })
+	return relations
+}
+
+// ExportNeo4jCSV writes a subgraph as CSV files for `neo4j-admin database import full`:
+// notes and concepts as nodes, and edges, concept memberships and concept relations as relationships
+func ExportNeo4jCSV(graph *KnowledgeGraph, subgraph *Subgraph, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create export directory: %v", err)
+	}
+	conceptIDs := neo4jConcepts(graph, subgraph)
+
+	tables := make(map[string][][]string)
+	tables["notes.csv"] = [][]string{{"noteId:ID(Note)", "text", "createdAt:datetime", "updatedAt:datetime", "source", "author", "tags:string[]", ":LABEL"}}
+	for _, id := range subgraph.NodeIDs {
+		node := graph.Nodes[id]
+		tables["notes.csv"] = append(tables["notes.csv"], []string{
+			strconv.FormatInt(id, 10), node.Text, neo4jTime(node.CreatedAt), neo4jTime(node.UpdatedAt),
+			node.Source, node.Author, strings.Join(node.Tags, neo4jArrayDelimiter), neo4jNoteLabel,
+		})
+	}
+
+	tables["concepts.csv"] = [][]string{{"conceptId:ID(Concept)", "name", ":LABEL"}}
+	for _, id := range conceptIDs {
+		tables["concepts.csv"] = append(tables["concepts.csv"], []string{strconv.FormatInt(id, 10), graph.Concepts[id].Name, neo4jConceptLabel})
+	}
+
+	tables["edges.csv"] = [][]string{{":START_ID(Note)", ":END_ID(Note)", ":TYPE", "edgeId:long", "weight:double", "directed:boolean"}}
+	for _, edge := range subgraph.Edges {
+		tables["edges.csv"] = append(tables["edges.csv"], []string{
+			strconv.FormatInt(edge.SourceID, 10), strconv.FormatInt(edge.TargetID, 10), neo4jRelationshipType(edge.Relation),
+			strconv.FormatInt(edge.ID, 10), strconv.FormatFloat(edge.Weight, 'f', -1, 64), strconv.FormatBool(edge.Directed),
+		})
+	}
+
+	tables["memberships.csv"] = [][]string{{":START_ID(Note)", ":END_ID(Concept)", ":TYPE", "salience:double"}}
+	for _, membership := range neo4jMemberships(graph, subgraph) {
+		tables["memberships.csv"] = append(tables["memberships.csv"], []string{
+			strconv.FormatInt(membership.NodeID, 10), strconv.FormatInt(membership.ConceptID, 10), neo4jMembershipType,
+			strconv.FormatFloat(membership.Salience, 'f', -1, 64),
+		})
+	}
+
+	tables["concept_relations.csv"] = [][]string{{":START_ID(Concept)", ":END_ID(Concept)", ":TYPE", "origin"}}
+	for _, relation := range neo4jConceptRelations(graph, conceptIDs) {
+		tables["concept_relations.csv"] = append(tables["concept_relations.csv"], []string{
+			strconv.FormatInt(relation.SourceID, 10), strconv.FormatInt(relation.TargetID, 10), neo4jRelationshipType(relation.Kind), relation.Origin,
+		})
+	}
+
+	for _, file := range neo4jFiles {
+		if err := writeCSVFile(filepath.Join(dir, file.name), tables[file.name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// neo4jMemberships returns the concept memberships of the subgraph's notes
+func neo4jMemberships(graph *KnowledgeGraph, subgraph *Subgraph) []*Membership {
+	included := make(map[int64]bool)
+	for _, id := range subgraph.NodeIDs {
+		included[id] = true
+	}
+	var memberships []*Membership
+	for _, membership := range graph.Memberships {
+		if included[membership.NodeID] {
+			memberships = append(memberships, membership)
+		}
+	}
+	sort.Slice(memberships, func(i, j int) bool { return memberships[i].ID < memberships[j].ID })
+	return memberships
+}
+
+// neo4jImportCommand returns the neo4j-admin invocation that loads an export directory
+func neo4jImportCommand(dir string) string {
+	// Note text spans lines, which neo4j-admin only accepts inside quoted values when told to
+	parts := []string{"neo4j-admin database import full", "--array-delimiter=" + strconv.Quote(neo4jArrayDelimiter), "--multiline-fields=true"}
+	for _, file := range neo4jFiles {
+		parts = append(parts, file.option+"="+filepath.Join(dir, file.name))
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeCSVFile writes rows to a CSV file
+func writeCSVFile(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// neo4jTime formats a timestamp for a datetime column, leaving unknown times empty
+func neo4jTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatTime(t)
+}
+
+// WriteCypher writes a subgraph as a Cypher script of idempotent MERGE statements, one per line
+func WriteCypher(w io.Writer, graph *KnowledgeGraph, subgraph *Subgraph) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE CONSTRAINT note_id IF NOT EXISTS FOR (n:%s) REQUIRE n.id IS UNIQUE;\n", neo4jNoteLabel)
+	fmt.Fprintf(&b, "CREATE CONSTRAINT concept_id IF NOT EXISTS FOR (c:%s) REQUIRE c.id IS UNIQUE;\n", neo4jConceptLabel)
+
+	for _, id := range subgraph.NodeIDs {
+		node := graph.Nodes[id]
+		fmt.Fprintf(&b, "MERGE (n:%s {id: %d}) SET n.text = %s", neo4jNoteLabel, id, cypherString(node.Text))
+		if !node.CreatedAt.IsZero() {
+			fmt.Fprintf(&b, ", n.createdAt = datetime(%s), n.updatedAt = datetime(%s)",
+				cypherString(formatTime(node.CreatedAt)), cypherString(formatTime(node.UpdatedAt)))
+		}
+		fmt.Fprintf(&b, ", n.source = %s, n.author = %s, n.tags = %s;\n", cypherString(node.Source), cypherString(node.Author), cypherList(node.Tags))
+	}
+
+	conceptIDs := neo4jConcepts(graph, subgraph)
+	for _, id := range conceptIDs {
+		fmt.Fprintf(&b, "MERGE (c:%s {id: %d}) SET c.name = %s;\n", neo4jConceptLabel, id, cypherString(graph.Concepts[id].Name))
+	}
+
+	for _, edge := range subgraph.Edges {
+		fmt.Fprintf(&b, "MATCH (a:%s {id: %d}), (b:%s {id: %d}) MERGE (a)-[r:%s {id: %d}]->(b) SET r.weight = %s, r.directed = %t;\n",
+			neo4jNoteLabel, edge.SourceID, neo4jNoteLabel, edge.TargetID, neo4jRelationshipType(edge.Relation), edge.ID,
+			strconv.FormatFloat(edge.Weight, 'f', -1, 64), edge.Directed)
+	}
+	for _, membership := range neo4jMemberships(graph, subgraph) {
+		fmt.Fprintf(&b, "MATCH (a:%s {id: %d}), (b:%s {id: %d}) MERGE (a)-[r:%s]->(b) SET r.salience = %s;\n",
+			neo4jNoteLabel, membership.NodeID, neo4jConceptLabel, membership.ConceptID, neo4jMembershipType,
+			strconv.FormatFloat(membership.Salience, 'f', -1, 64))
+	}
+	for _, relation := range neo4jConceptRelations(graph, conceptIDs) {
+		fmt.Fprintf(&b, "MATCH (a:%s {id: %d}), (b:%s {id: %d}) MERGE (a)-[r:%s]->(b) SET r.origin = %s;\n",
+			neo4jConceptLabel, relation.SourceID, neo4jConceptLabel, relation.TargetID, neo4jRelationshipType(relation.Kind),
+			cypherString(relation.Origin))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeVerifiedCypher writes a Cypher script after reading it back with the local parser, so a
+// malformed script fails the export rather than the load into the database
+func writeVerifiedCypher(w io.Writer, graph *KnowledgeGraph, subgraph *Subgraph) error {
+	var script strings.Builder
+	if err := WriteCypher(&script, graph, subgraph); err != nil {
+		return err
+	}
+	records, err := ReadCypher(script.String())
+	if err != nil {
+		return fmt.Errorf("export does not parse: %v", err)
+	}
+	if err := verifyNeo4jRecords(graph, subgraph, records); err != nil {
+		return fmt.Errorf("export does not round-trip: %v", err)
+	}
+	_, err = io.WriteString(w, script.String())
+	return err
+}
+
+// cypherString quotes a Cypher string literal, escaping line breaks so every statement stays on one line
+func cypherString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`).Replace(value) + "'"
+}
+
+// cypherList writes a Cypher list of strings
+func cypherList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = cypherString(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// neo4jRecords is what a Neo4j export describes, as read back by the local parsers
+type neo4jRecords struct {
+	// Nodes maps "Label:id" to the node's properties
+	Nodes map[string]map[string]string
+	// Relationships lists the relationships between the nodes
+	Relationships []neo4jRelationship
+}
+
+// neo4jRelationship is a relationship read back from an export
+type neo4jRelationship struct {
+	Start, End string
+	Type       string
+	Properties map[string]string
+}
+
+// ReadNeo4jCSV parses a CSV export the way neo4j-admin does: the header names each column and its
+// type, :ID(Group) columns define nodes and :START_ID(Group)/:END_ID(Group) columns reference them
+func ReadNeo4jCSV(dir string) (*neo4jRecords, error) {
+	records := &neo4jRecords{Nodes: make(map[string]map[string]string)}
+	for _, file := range neo4jFiles {
+		data, err := os.ReadFile(filepath.Join(dir, file.name))
+		if err != nil {
+			return nil, err
+		}
+		// encoding/csv drops the carriage return of a CRLF inside a quoted value, which neo4j-admin keeps;
+		// records end in a bare LF, so every CRLF in the file belongs to a value and is doubled up to survive
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\r\r\n"))
+		rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.name, err)
+		}
+		if len(rows) == 0 {
+			return nil, fmt.Errorf("%s: missing header", file.name)
+		}
+
+		header := rows[0]
+		for line, row := range rows[1:] {
+			properties := make(map[string]string)
+			var id, start, end, kind string
+			for i, column := range header {
+				name, fieldType, _ := strings.Cut(column, ":")
+				group := ""
+				if open := strings.IndexByte(fieldType, '('); open >= 0 && strings.HasSuffix(fieldType, ")") {
+					fieldType, group = fieldType[:open], fieldType[open+1:len(fieldType)-1]
+				}
+				value := row[i]
+				switch fieldType {
+				case "ID":
+					id = group + ":" + value
+				case "START_ID":
+					start = group + ":" + value
+				case "END_ID":
+					end = group + ":" + value
+				case "TYPE":
+					kind = value
+				case "LABEL":
+				case "long", "int", "double", "float":
+					if _, err := strconv.ParseFloat(value, 64); err != nil {
+						return nil, fmt.Errorf("%s line %d: %s is not a number: %q", file.name, line+2, name, value)
+					}
+				case "boolean":
+					if _, err := strconv.ParseBool(value); err != nil {
+						return nil, fmt.Errorf("%s line %d: %s is not a boolean: %q", file.name, line+2, name, value)
+					}
+				case "datetime":
+					if _, err := parseTime(value); err != nil {
+						return nil, fmt.Errorf("%s line %d: %s is not a datetime: %q", file.name, line+2, name, value)
+					}
+				}
+				if name != "" {
+					properties[name] = value
+				}
+			}
+
+			if file.option == "--nodes" {
+				if _, duplicate := records.Nodes[id]; duplicate {
+					return nil, fmt.Errorf("%s line %d: duplicate node %s", file.name, line+2, id)
+				}
+				records.Nodes[id] = properties
+			} else {
+				records.Relationships = append(records.Relationships, neo4jRelationship{Start: start, End: end, Type: kind, Properties: properties})
+			}
+		}
+	}
+	return records, nil
+}
+
+// ReadCypher parses a script written by WriteCypher back into nodes and relationships
+func ReadCypher(script string) (*neo4jRecords, error) {
+	records := &neo4jRecords{Nodes: make(map[string]map[string]string)}
+	for number, line := range strings.Split(script, "\n") {
+		if line == "" || strings.HasPrefix(line, "CREATE CONSTRAINT") {
+			continue
+		}
+		tokens, err := cypherTokens(strings.TrimSuffix(line, ";"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number+1, err)
+		}
+
+		// Node patterns "(var:Label {id: n})" name the nodes of the statement
+		variables := make(map[string]string)
+		var relationship *neo4jRelationship
+		var target map[string]string
+		for i := 0; i < len(tokens); i++ {
+			switch {
+			case tokens[i] == "(" && i+7 < len(tokens) && tokens[i+2] == ":" && tokens[i+4] == "{":
+				key := tokens[i+3] + ":" + tokens[i+7]
+				variables[tokens[i+1]] = key
+				if tokens[0] == "MERGE" && records.Nodes[key] == nil {
+					records.Nodes[key] = map[string]string{"id": tokens[i+7]}
+				}
+				i += 8
+			case tokens[i] == "[" && i+3 < len(tokens) && tokens[i+2] == ":":
+				pattern := relationshipPattern(tokens[i:])
+				if i < 3 || i+len(pattern)+3 >= len(tokens) {
+					return nil, fmt.Errorf("line %d: malformed relationship pattern", number+1)
+				}
+				start, end := variables[tokens[i-3]], variables[tokens[i+len(pattern)+3]]
+				if start == "" || end == "" || records.Nodes[start] == nil || records.Nodes[end] == nil {
+					return nil, fmt.Errorf("line %d: relationship between undefined nodes", number+1)
+				}
+				relationship = &neo4jRelationship{Start: start, End: end, Type: tokens[i+3], Properties: make(map[string]string)}
+				i += len(pattern)
+			case tokens[i] == "SET":
+				if relationship != nil {
+					target = relationship.Properties
+				} else {
+					target = records.Nodes[variables[tokens[i+1]]]
+				}
+			case target != nil && i+3 < len(tokens) && tokens[i+1] == "." && tokens[i+3] == "=":
+				target[tokens[i+2]] = tokens[i+4]
+				i += 4
+			}
+		}
+		if relationship != nil {
+			records.Relationships = append(records.Relationships, *relationship)
+		}
+	}
+	return records, nil
+}
+
+// relationshipPattern returns the tokens of a "[r:TYPE {…}]" pattern
+func relationshipPattern(tokens []string) []string {
+	for i, token := range tokens {
+		if token == "]" {
+			return tokens[:i+1]
+		}
+	}
+	return tokens
+}
+
+// cypherTokens splits a Cypher statement into identifiers, punctuation and decoded literals
+// Function calls such as datetime('…') are reduced to their argument
+func cypherTokens(statement string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == ' ':
+			i++
+		case c == '\'':
+			var b strings.Builder
+			i++
+			for ; i < len(statement) && statement[i] != '\''; i++ {
+				if statement[i] == '\\' && i+1 < len(statement) {
+					i++
+					switch statement[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 'r':
+						b.WriteByte('\r')
+					default:
+						b.WriteByte(statement[i])
+					}
+					continue
+				}
+				b.WriteByte(statement[i])
+			}
+			if i >= len(statement) {
+				return nil, errors.New("unterminated string")
+			}
+			i++
+			tokens = append(tokens, b.String())
+		case c == '[' && len(tokens) > 0 && tokens[len(tokens)-1] == "=":
+			// A list value, kept as its literal text
+			end, quoted := i+1, false
+			for ; end < len(statement) && (quoted || statement[end] != ']'); end++ {
+				switch {
+				case statement[end] == '\\' && quoted:
+					end++
+				case statement[end] == '\'':
+					quoted = !quoted
+				}
+			}
+			if end >= len(statement) {
+				return nil, errors.New("unterminated list")
+			}
+			tokens = append(tokens, statement[i:end+1])
+			i = end + 1
+		case strings.ContainsRune("(){}[]:,.=-<>", rune(c)):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			start := i
+			for i < len(statement) && !strings.ContainsRune(" (){}[]:,=<>'", rune(statement[i])) &&
+				!(statement[i] == '.' && (start == i || !unicode.IsDigit(rune(statement[i-1])))) &&
+				!(statement[i] == '-' && i > start) {
+				i++
+			}
+			word := statement[start:i]
+			if i < len(statement) && statement[i] == '(' && len(tokens) > 0 && tokens[len(tokens)-1] == "=" {
+				// datetime('…'): keep the argument
+				end := strings.IndexByte(statement[i:], ')')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated call to %s", word)
+				}
+				inner, err := cypherTokens(statement[i+1 : i+end])
+				if err != nil {
+					return nil, err
+				}
+				tokens = append(tokens, inner...)
+				i += end + 1
+				continue
+			}
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens, nil
+}
+
+// verifyNeo4jRecords checks that an export read back by a local parser describes the subgraph
+func verifyNeo4jRecords(graph *KnowledgeGraph, subgraph *Subgraph, records *neo4jRecords) error {
+	for _, id := range subgraph.NodeIDs {
+		properties, ok := records.Nodes[fmt.Sprintf("%s:%d", neo4jNoteLabel, id)]
+		if !ok {
+			return fmt.Errorf("note %d is missing from the export", id)
+		}
+		if properties["text"] != graph.Nodes[id].Text {
+			return fmt.Errorf("text of note %d does not round-trip", id)
+		}
+	}
+	conceptIDs := neo4jConcepts(graph, subgraph)
+	if len(records.Nodes) != len(subgraph.NodeIDs)+len(conceptIDs) {
+		return fmt.Errorf("export has %d nodes, expected %d", len(records.Nodes), len(subgraph.NodeIDs)+len(conceptIDs))
+	}
+
+	counts := make(map[string]int)
+	for _, relationship := range records.Relationships {
+		if records.Nodes[relationship.Start] == nil || records.Nodes[relationship.End] == nil {
+			return fmt.Errorf("%s relationship references a missing node", relationship.Type)
+		}
+		counts[relationship.Type]++
+	}
+	expected := make(map[string]int)
+	for _, edge := range subgraph.Edges {
+		expected[neo4jRelationshipType(edge.Relation)]++
+	}
+	expected[neo4jMembershipType] += len(neo4jMemberships(graph, subgraph))
+	for _, relation := range neo4jConceptRelations(graph, conceptIDs) {
+		expected[neo4jRelationshipType(relation.Kind)]++
+	}
+	for kind, count := range expected {
+		if counts[kind] != count {
+			return fmt.Errorf("export has %d %s relationships, expected %d", counts[kind], kind, count)
+		}
+	}
+	if len(records.Relationships) != len(subgraph.Edges)+len(neo4jMemberships(graph, subgraph))+len(neo4jConceptRelations(graph, conceptIDs)) {
+		return errors.New("export has unexpected relationships")
+	}
+	return nil
+}
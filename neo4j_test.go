@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// neo4jTestGraph builds a graph whose text and metadata need quoting in CSV and Cypher
+func neo4jTestGraph(t *testing.T) (*KnowledgeGraph, *Subgraph) {
+	t.Helper()
+	graph := NewKnowledgeGraph()
+	first, err := AddNote(graph, "It's a \"quoted\", comma-separated\nmulti-line note with a C:\\path\\ and 'quotes'", []string{"go", "testing"},
+		NodeMetadata{Author: "O'Brien, Pat", Source: "import", Tags: []string{"to do", "it's, done"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := AddNote(graph, "Second note; MERGE (x) -- not a statement\r\nend", []string{"go"}, NodeMetadata{Author: `back\slash`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.PutEdge(second.ID, first.ID, 1, RelationLinksTo, true)
+	if _, err := graph.AddConceptRelation("testing", "go", RelationBroader, OriginManual); err != nil {
+		t.Fatal(err)
+	}
+	subgraph, err := SelectSubgraph(graph, SubgraphOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph, subgraph
+}
+
+// checkNeo4jRecords compares what an export read back holds with the graph it was written from
+func checkNeo4jRecords(t *testing.T, graph *KnowledgeGraph, records *neo4jRecords, tags func(string) []string) {
+	t.Helper()
+	if want := len(graph.Nodes) + len(graph.Concepts); len(records.Nodes) != want {
+		t.Errorf("read back %d nodes, want %d", len(records.Nodes), want)
+	}
+	for id, node := range graph.Nodes {
+		properties := records.Nodes[fmt.Sprintf("%s:%d", neo4jNoteLabel, id)]
+		if properties == nil {
+			t.Errorf("note %d is missing", id)
+			continue
+		}
+		if properties["text"] != node.Text || properties["author"] != node.Author || properties["source"] != node.Source {
+			t.Errorf("note %d read back as %q by %q from %q", id, properties["text"], properties["author"], properties["source"])
+		}
+		if got := tags(properties["tags"]); !slices.Equal(got, node.Tags) && len(got)+len(node.Tags) > 0 {
+			t.Errorf("tags of note %d read back as %q, want %q", id, got, node.Tags)
+		}
+	}
+	for id, concept := range graph.Concepts {
+		if name := records.Nodes[fmt.Sprintf("%s:%d", neo4jConceptLabel, id)]["name"]; name != concept.Name {
+			t.Errorf("concept %d read back as %q, want %q", id, name, concept.Name)
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, relationship := range records.Relationships {
+		counts[relationship.Type]++
+	}
+	want := map[string]int{
+		neo4jRelationshipType(RelationSimilar): 1,
+		neo4jRelationshipType(RelationLinksTo): 1,
+		neo4jMembershipType:                    len(graph.Memberships),
+		neo4jRelationshipType(RelationBroader): 1,
+	}
+	if len(counts) != len(want) {
+		t.Errorf("read back relationship types %v, want %v", counts, want)
+	}
+	for kind, count := range want {
+		if counts[kind] != count {
+			t.Errorf("read back %d %s relationships, want %d", counts[kind], kind, count)
+		}
+	}
+}
+
+func TestNeo4jCSVRoundTrip(t *testing.T) {
+	graph, subgraph := neo4jTestGraph(t)
+	dir := t.TempDir()
+	if err := ExportNeo4jCSV(graph, subgraph, dir); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadNeo4jCSV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNeo4jRecords(t, graph, records, func(column string) []string {
+		if column == "" {
+			return nil
+		}
+		return strings.Split(column, neo4jArrayDelimiter)
+	})
+	if err := verifyNeo4jRecords(graph, subgraph, records); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNeo4jCypherRoundTrip(t *testing.T) {
+	graph, subgraph := neo4jTestGraph(t)
+	var script strings.Builder
+	if err := writeVerifiedCypher(&script, graph, subgraph); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(script.String(), "\n"); lines != 2+len(graph.Nodes)+len(graph.Concepts)+len(graph.Edges)+len(graph.Memberships)+1 {
+		t.Errorf("script has %d statements; a value broke a statement across lines:\n%s", lines, script.String())
+	}
+	records, err := ReadCypher(script.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNeo4jRecords(t, graph, records, func(list string) []string {
+		tokens, err := cypherTokens(list)
+		if err != nil {
+			t.Fatalf("tag list %q: %v", list, err)
+		}
+		// "[", value, ",", value, …, "]"
+		var values []string
+		for i := 1; i < len(tokens)-1; i += 2 {
+			values = append(values, tokens[i])
+		}
+		return values
+	})
+}
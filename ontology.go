@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Concept relation kinds
+const (
+	// RelationBroader links a narrower concept (SourceID) to a broader one (TargetID)
+	RelationBroader = "broader"
+	// RelationRelated links two associated concepts without implying a hierarchy
+	RelationRelated = "related"
+)
+
+// Concept relation origins
+const (
+	OriginManual = "manual"
+	OriginLLM    = "llm"
+	OriginImport = "import"
+)
+
+// maxOntologyCredit keeps a pair of concepts that only share an ancestor worth less than an exact match
+const maxOntologyCredit = 0.9
+
+// ConceptRelation represents a taxonomy link between two concepts
+type ConceptRelation struct {
+	ID       int64
+	SourceID int64
+	TargetID int64
+	Kind     string
+	Origin   string
+}
+
+// AddConceptRelation links two concepts by name, creating the concepts if needed
+func (graph *KnowledgeGraph) AddConceptRelation(sourceName, targetName, kind, origin string) (*ConceptRelation, error) {
+	if kind != RelationBroader && kind != RelationRelated {
+		return nil, fmt.Errorf("unknown concept relation kind %q", kind)
+	}
+	if normalizeConcept(sourceName) == "" || normalizeConcept(targetName) == "" {
+		return nil, errors.New("concept names must not be empty")
+	}
+	if normalizeConcept(sourceName) == normalizeConcept(targetName) {
+		return nil, fmt.Errorf("concept %q cannot be related to itself", sourceName)
+	}
+
+	source := graph.ensureConcept(sourceName)
+	target := graph.ensureConcept(targetName)
+	if existing := graph.findConceptRelation(source.ID, target.ID, kind); existing != nil {
+		return existing, nil
+	}
+
+	// Refuse links that would make the hierarchy cyclic
+	if kind == RelationBroader {
+		if _, ok := graph.ConceptAncestors(target.ID)[source.ID]; ok {
+			return nil, fmt.Errorf("%q is already broader than %q", source.Name, target.Name)
+		}
+	}
+
+	relation := &ConceptRelation{
+		ID:       graph.generateConceptRelationID(),
+		SourceID: source.ID,
+		TargetID: target.ID,
+		Kind:     kind,
+		Origin:   origin,
+	}
+	graph.ConceptRelations[relation.ID] = relation
+	graph.touch("ConceptRelation", relation.ID)
+	return relation, nil
+}
+
+// RemoveConceptRelations removes every relation between two concepts and returns how many were removed
+func (graph *KnowledgeGraph) RemoveConceptRelations(firstName, secondName string) int {
+	first := graph.ConceptByName(firstName)
+	second := graph.ConceptByName(secondName)
+	if first == nil || second == nil {
+		return 0
+	}
+
+	removed := 0
+	for id, relation := range graph.ConceptRelations {
+		if (relation.SourceID == first.ID && relation.TargetID == second.ID) ||
+			(relation.SourceID == second.ID && relation.TargetID == first.ID) {
+			delete(graph.ConceptRelations, id)
+			graph.touch("ConceptRelation", id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// findConceptRelation finds an existing relation, treating related links as symmetric
+func (graph *KnowledgeGraph) findConceptRelation(sourceID, targetID int64, kind string) *ConceptRelation {
+	for _, relation := range graph.ConceptRelations {
+		if relation.Kind != kind {
+			continue
+		}
+		if relation.SourceID == sourceID && relation.TargetID == targetID {
+			return relation
+		}
+		if kind == RelationRelated && relation.SourceID == targetID && relation.TargetID == sourceID {
+			return relation
+		}
+	}
+	return nil
+}
+
+// BroaderConceptIDs returns the direct broader concepts of a concept
+func (graph *KnowledgeGraph) BroaderConceptIDs(conceptID int64) []int64 {
+	var ids []int64
+	for _, relation := range graph.ConceptRelations {
+		if relation.Kind == RelationBroader && relation.SourceID == conceptID {
+			ids = append(ids, relation.TargetID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// NarrowerConceptIDs returns the direct narrower concepts of a concept
+func (graph *KnowledgeGraph) NarrowerConceptIDs(conceptID int64) []int64 {
+	var ids []int64
+	for _, relation := range graph.ConceptRelations {
+		if relation.Kind == RelationBroader && relation.TargetID == conceptID {
+			ids = append(ids, relation.SourceID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// RelatedConceptIDs returns the concepts linked to a concept by a related relation
+func (graph *KnowledgeGraph) RelatedConceptIDs(conceptID int64) []int64 {
+	var ids []int64
+	for _, relation := range graph.ConceptRelations {
+		if relation.Kind != RelationRelated {
+			continue
+		}
+		if relation.SourceID == conceptID {
+			ids = append(ids, relation.TargetID)
+		} else if relation.TargetID == conceptID {
+			ids = append(ids, relation.SourceID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// ConceptAncestors returns every broader concept of a concept, mapped to its distance in the hierarchy
+func (graph *KnowledgeGraph) ConceptAncestors(conceptID int64) map[int64]int {
+	ancestors := make(map[int64]int)
+	frontier := []int64{conceptID}
+	for depth := 1; len(frontier) > 0; depth++ {
+		var next []int64
+		for _, id := range frontier {
+			for _, broaderID := range graph.BroaderConceptIDs(id) {
+				if _, seen := ancestors[broaderID]; !seen && broaderID != conceptID {
+					ancestors[broaderID] = depth
+					next = append(next, broaderID)
+				}
+			}
+		}
+		frontier = next
+	}
+	return ancestors
+}
+
+// ConceptDescendants returns every narrower concept of a concept, in ascending ID order
+func (graph *KnowledgeGraph) ConceptDescendants(conceptID int64) []int64 {
+	seen := map[int64]bool{conceptID: true}
+	var descendants []int64
+	frontier := []int64{conceptID}
+	for len(frontier) > 0 {
+		var next []int64
+		for _, id := range frontier {
+			for _, narrowerID := range graph.NarrowerConceptIDs(id) {
+				if !seen[narrowerID] {
+					seen[narrowerID] = true
+					descendants = append(descendants, narrowerID)
+					next = append(next, narrowerID)
+				}
+			}
+		}
+		frontier = next
+	}
+	sort.Slice(descendants, func(i, j int) bool { return descendants[i] < descendants[j] })
+	return descendants
+}
+
+// NotesForConcept returns the notes tagged with a concept, rolling up notes tagged with narrower concepts if asked
+func (graph *KnowledgeGraph) NotesForConcept(name string, rollup bool) []int64 {
+	concept := graph.ConceptByName(name)
+	if concept == nil {
+		return nil
+	}
+
+	conceptIDs := []int64{concept.ID}
+	if rollup {
+		conceptIDs = append(conceptIDs, graph.ConceptDescendants(concept.ID)...)
+	}
+
+	seen := make(map[int64]bool)
+	var nodeIDs []int64
+	for _, conceptID := range conceptIDs {
+		for _, nodeID := range graph.ConceptNodeIDs(conceptID) {
+			if !seen[nodeID] {
+				seen[nodeID] = true
+				nodeIDs = append(nodeIDs, nodeID)
+			}
+		}
+	}
+	sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i] < nodeIDs[j] })
+	return nodeIDs
+}
+
+// conceptsShareAncestor reports whether two concepts share an ancestor or one is an ancestor of the other
+func (graph *KnowledgeGraph) conceptsShareAncestor(firstID, secondID int64) bool {
+	firstLineage := graph.ConceptAncestors(firstID)
+	firstLineage[firstID] = 0
+	if _, ok := firstLineage[secondID]; ok {
+		return true
+	}
+	for ancestorID := range graph.ConceptAncestors(secondID) {
+		if _, ok := firstLineage[ancestorID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ontologyNeighbourConcepts returns the concepts in the same hierarchy family as a concept
+func (graph *KnowledgeGraph) ontologyNeighbourConcepts(conceptID int64) []int64 {
+	roots := []int64{conceptID}
+	for ancestorID := range graph.ConceptAncestors(conceptID) {
+		roots = append(roots, ancestorID)
+	}
+
+	var family []int64
+	for _, rootID := range roots {
+		family = append(family, rootID)
+		family = append(family, graph.ConceptDescendants(rootID)...)
+	}
+	return family
+}
+
+// CalculateWeightWithOntology calculates Jaccard similarity where concepts sharing an ancestor count as a partial match worth credit
+func CalculateWeightWithOntology(graph *KnowledgeGraph, concepts1, concepts2 []string, credit float64) float64 {
+	set1 := make(map[string]bool)
+	set2 := make(map[string]bool)
+	for _, concept := range concepts1 {
+		set1[normalizeConcept(concept)] = true
+	}
+	for _, concept := range concepts2 {
+		set2[normalizeConcept(concept)] = true
+	}
+
+	// Count exact matches and collect the concepts left over on each side
+	exact := 0
+	var unmatched1, unmatched2 []int64
+	for concept := range set1 {
+		if set2[concept] {
+			exact++
+		} else if c := graph.ConceptByName(concept); c != nil {
+			unmatched1 = append(unmatched1, c.ID)
+		}
+	}
+	for concept := range set2 {
+		if set1[concept] {
+			continue
+		}
+		if c := graph.ConceptByName(concept); c != nil {
+			unmatched2 = append(unmatched2, c.ID)
+		}
+	}
+	sort.Slice(unmatched1, func(i, j int) bool { return unmatched1[i] < unmatched1[j] })
+	sort.Slice(unmatched2, func(i, j int) bool { return unmatched2[i] < unmatched2[j] })
+
+	// Pair up leftover concepts that share an ancestor, using each concept at most once
+	partial := 0
+	used := make(map[int64]bool)
+	for _, first := range unmatched1 {
+		for _, second := range unmatched2 {
+			if !used[second] && graph.conceptsShareAncestor(first, second) {
+				used[second] = true
+				partial++
+				break
+			}
+		}
+	}
+
+	// A partial pair is one concept in the union like an exact match, but scores only the credit
+	intersection := float64(exact) + min(credit, maxOntologyCredit)*float64(partial)
+	union := float64(len(set1) + len(set2) - exact - partial)
+
+	// Prevent division by zero
+	if union <= 0 {
+		return 0.0
+	}
+
+	return intersection / union
+}
+
+// SuggestConceptHierarchy asks the LLM to propose broader/narrower links between the graph's concepts
+func SuggestConceptHierarchy(graph *KnowledgeGraph, apiKey string) ([][2]string, error) {
+	concepts := getAllConcepts(graph)
+	if len(concepts) == 0 {
+		return nil, nil
+	}
+	sort.Strings(concepts)
+
+	// Initialize OpenAI client with API key
+	client := openai.NewClient(apiKey)
+
+	// Prepare system prompt
+	prompt := "You are an AI assistant that is an expert at organizing knowledge. You will take the provided concepts and arrange them into a taxonomy. Respond with one relation per line in the form \"narrower > broader\", for example \"PostgreSQL > databases\". Prefer concepts from the list, but you may introduce a broader category when it groups several of them. Do not respond with anything else.\nConcepts: " + strings.Join(concepts, ", ")
+
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model: openai.GPT3Dot5Turbo,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest concept hierarchy: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("failed to suggest concept hierarchy: empty response")
+	}
+
+	var suggestions [][2]string
+	for _, line := range strings.Split(resp.Choices[0].Message.Content, "\n") {
+		narrower, broader, ok := strings.Cut(line, ">")
+		if !ok {
+			continue
+		}
+		narrower = strings.Trim(strings.TrimSpace(narrower), "-*\"")
+		broader = strings.Trim(strings.TrimSpace(broader), "-*\"")
+		if narrower != "" && broader != "" {
+			suggestions = append(suggestions, [2]string{strings.TrimSpace(narrower), strings.TrimSpace(broader)})
+		}
+	}
+	return suggestions, nil
+}
+
+// runConceptCommand edits and inspects the concept hierarchy
+func runConceptCommand(env *CommandEnv, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: concept broader|related|unlink|tree|suggest|credit ...")
+	}
+	graph := env.Graph
+
+	switch args[0] {
+	case "broader", "related":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: concept %s <concept> <concept>", args[0])
+		}
+		if _, err := graph.AddConceptRelation(args[1], args[2], args[0], OriginManual); err != nil {
+			return err
+		}
+		return saveOntologyChange(env)
+
+	case "unlink":
+		if len(args) != 3 {
+			return errors.New("usage: concept unlink <concept> <concept>")
+		}
+		if graph.RemoveConceptRelations(args[1], args[2]) == 0 {
+			return fmt.Errorf("no relation between %q and %q", args[1], args[2])
+		}
+		return saveOntologyChange(env)
+
+	case "tree":
+		var roots []int64
+		if len(args) > 1 {
+			concept := graph.ConceptByName(args[1])
+			if concept == nil {
+				return fmt.Errorf("unknown concept %q", args[1])
+			}
+			roots = []int64{concept.ID}
+		} else {
+			for id := range graph.Concepts {
+				if len(graph.BroaderConceptIDs(id)) == 0 {
+					roots = append(roots, id)
+				}
+			}
+			sort.Slice(roots, func(i, j int) bool {
+				return graph.Concepts[roots[i]].Name < graph.Concepts[roots[j]].Name
+			})
+		}
+		for _, rootID := range roots {
+			printConceptTree(graph, rootID, 0, make(map[int64]bool))
+		}
+		return nil
+
+	case "suggest":
+		flags := flag.NewFlagSet("concept suggest", flag.ContinueOnError)
+		apply := flags.Bool("apply", false, "add the suggested relations to the graph")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		apiKey, err := env.RequireAPIKey()
+		if err != nil {
+			return err
+		}
+		suggestions, err := SuggestConceptHierarchy(graph, apiKey)
+		if err != nil {
+			return err
+		}
+		for _, suggestion := range suggestions {
+			fmt.Printf("%s > %s\n", suggestion[0], suggestion[1])
+			if *apply {
+				if _, err := graph.AddConceptRelation(suggestion[0], suggestion[1], RelationBroader, OriginLLM); err != nil {
+					fmt.Printf("  skipped: %v\n", err)
+				}
+			}
+		}
+		if *apply {
+			return saveOntologyChange(env)
+		}
+		return nil
+
+	case "credit":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: concept credit <0..%g>", maxOntologyCredit)
+		}
+		credit, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || credit < 0 || credit > maxOntologyCredit {
+			return fmt.Errorf("invalid credit %q: must be a number between 0 and %g", args[1], maxOntologyCredit)
+		}
+		graph.Settings.OntologyCredit = credit
+
+		// Existing edges were weighted with the old credit
+		if err := graph.RecomputeEdges(); err != nil {
+			return err
+		}
+		fmt.Printf("Recomputed %d edges with ontology credit %g\n", len(graph.Edges), credit)
+		return env.Save()
+	}
+
+	return fmt.Errorf("unknown concept subcommand %q", args[0])
+}
+
+// saveOntologyChange saves a change to the concept hierarchy, first reweighting the edges if they credit related concepts
+func saveOntologyChange(env *CommandEnv) error {
+	if env.Graph.Settings.OntologyCredit > 0 {
+		if err := env.Graph.RecomputeEdges(); err != nil {
+			return err
+		}
+	}
+	return env.Save()
+}
+
+// printConceptTree prints a concept and its narrower concepts as an indented tree
+func printConceptTree(graph *KnowledgeGraph, conceptID int64, depth int, visited map[int64]bool) {
+	if visited[conceptID] {
+		return
+	}
+	visited[conceptID] = true
+
+	concept := graph.Concepts[conceptID]
+	line := fmt.Sprintf("%s%s (%d notes)", strings.Repeat("  ", depth), concept.Name, len(graph.conceptMembers[conceptID]))
+	if related := graph.RelatedConceptIDs(conceptID); len(related) > 0 {
+		names := make([]string, 0, len(related))
+		for _, id := range related {
+			names = append(names, graph.Concepts[id].Name)
+		}
+		line += " related: " + strings.Join(names, ", ")
+	}
+	fmt.Println(line)
+
+	for _, narrowerID := range graph.NarrowerConceptIDs(conceptID) {
+		printConceptTree(graph, narrowerID, depth+1, visited)
+	}
+}
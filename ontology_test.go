@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCalculateWeightWithOntologyRanksPartialBelowExact(t *testing.T) {
+	graph := NewKnowledgeGraph()
+	for _, name := range []string{"go", "rust", "python", "ruby"} {
+		if _, err := graph.AddConceptRelation(name, "programming", RelationBroader, OriginManual); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	identical := CalculateWeightWithOntology(graph, []string{"go", "rust"}, []string{"go", "rust"}, 1)
+	siblings := CalculateWeightWithOntology(graph, []string{"go", "rust"}, []string{"python", "ruby"}, 1)
+	if identical != 1 {
+		t.Errorf("identical concepts weigh %g, want 1", identical)
+	}
+	if siblings >= identical {
+		t.Errorf("disjoint sibling concepts weigh %g, not less than identical concepts (%g)", siblings, identical)
+	}
+	if want := maxOntologyCredit; siblings != want {
+		t.Errorf("disjoint sibling concepts weigh %g, want the capped credit %g", siblings, want)
+	}
+
+	// One exact and one partial pair out of two concepts on each side
+	mixed := CalculateWeightWithOntology(graph, []string{"go", "rust"}, []string{"go", "python"}, 0.5)
+	if want := 1.5 / 2; mixed != want {
+		t.Errorf("mixed match weighs %g, want %g", mixed, want)
+	}
+	unrelated := CalculateWeightWithOntology(graph, []string{"go"}, []string{"cooking"}, 0.5)
+	if unrelated != 0 {
+		t.Errorf("unrelated concepts weigh %g, want 0", unrelated)
+	}
+}
+
+func TestConceptCommandReweightsEdgesWhenTheHierarchyChanges(t *testing.T) {
+	graph := NewKnowledgeGraph()
+	graph.Settings.OntologyCredit = 0.5
+	for _, note := range []struct{ text, concept string }{{"Go tips", "go"}, {"Rust tips", "rust"}} {
+		if err := BuildOrUpdateKnowledgeGraph(graph, note.text, []string{note.concept}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := &CommandEnv{Graph: graph, GraphFilePath: filepath.Join(t.TempDir(), "graph.txt")}
+
+	for _, args := range [][]string{{"broader", "go", "programming"}, {"broader", "rust", "programming"}} {
+		if err := runConceptCommand(env, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(graph.Edges) != 1 {
+		t.Fatalf("got %d edges once the concepts were related, want 1 between the sibling notes", len(graph.Edges))
+	}
+	if err := runConceptCommand(env, []string{"unlink", "rust", "programming"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Edges) != 0 {
+		t.Errorf("got %d edges after the concepts were unlinked, want none", len(graph.Edges))
+	}
+}
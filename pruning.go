@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+)
+
+// PruneEdges removes the similarity edges not retained by the graph's edge-retention policy and returns how many were removed
+// Typed relations are never pruned. The policy applies to stored weights, so recency decay never deletes an edge:
+// readers leave out the edges that have faded instead, and they come back if the half-life grows or a note reinforces them
+func (graph *KnowledgeGraph) PruneEdges() int {
+	removed := 0
+
+	// Drop edges below the minimum weight
+	if graph.Settings.MinWeight > 0 {
+		for id, edge := range graph.Edges {
+			if edge.IsSimilarity() && edge.Weight < graph.Settings.MinWeight {
+				graph.RemoveEdge(id)
+				removed++
+			}
+		}
+	}
+
+	if graph.Settings.TopK <= 0 {
+		return removed
+	}
+
+	// Rank each node's edges and remember which ones make its top k
+	incident := make(map[int64][]*Edge)
+	for _, edge := range graph.Edges {
+		if !edge.IsSimilarity() {
+			continue
+		}
+		incident[edge.SourceID] = append(incident[edge.SourceID], edge)
+		incident[edge.TargetID] = append(incident[edge.TargetID], edge)
+	}
+	votes := make(map[int64]int)
+	for _, edges := range incident {
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].Weight != edges[j].Weight {
+				return edges[i].Weight > edges[j].Weight
+			}
+			return edges[i].ID < edges[j].ID
+		})
+		for i := 0; i < len(edges) && i < graph.Settings.TopK; i++ {
+			votes[edges[i].ID]++
+		}
+	}
+
+	// Keep an edge if it is among the top k of either endpoint, or of both for mutual kNN
+	required := 1
+	if graph.Settings.MutualKNN {
+		required = 2
+	}
+	for id, edge := range graph.Edges {
+		if edge.IsSimilarity() && votes[id] < required {
+			graph.RemoveEdge(id)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// runPruneCommand updates the edge-retention policy and re-prunes the existing graph
+func runPruneCommand(env *CommandEnv, args []string) error {
+	settings := &env.Graph.Settings
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	flags.Float64Var(&settings.MinWeight, "min-weight", settings.MinWeight, "drop edges weighing less than this")
+	flags.IntVar(&settings.TopK, "top-k", settings.TopK, "keep only each node's k strongest edges (0 keeps all)")
+	flags.BoolVar(&settings.MutualKNN, "mutual", settings.MutualKNN, "keep an edge only if it is in the top k of both nodes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if settings.MinWeight < 0 || settings.TopK < 0 {
+		return errors.New("min-weight and top-k must not be negative")
+	}
+	if settings.MutualKNN && settings.TopK == 0 {
+		return errors.New("mutual kNN needs -top-k")
+	}
+
+	removed := env.Graph.PruneEdges()
+	fmt.Printf("Pruned %d edges, %d remain\n", removed, len(env.Graph.Edges))
+	return env.Save()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newDecayTestGraph returns a graph of three notes with a 30-day half-life
+func newDecayTestGraph(now time.Time) *KnowledgeGraph {
+	graph := NewKnowledgeGraph()
+	for id := int64(1); id <= 3; id++ {
+		graph.Nodes[id] = &Node{ID: id, Text: "note", NodeMetadata: NodeMetadata{CreatedAt: now, UpdatedAt: now}}
+	}
+	graph.Settings.DecayHalfLife = 30 * 24 * time.Hour
+	return graph
+}
+
+func TestDecayHidesFadedEdgesWithoutPruningThem(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	graph := newDecayTestGraph(now)
+	graph.Settings.MinWeight = 0.3
+
+	// A strong link untouched for 90 days has decayed to an eighth of its weight
+	stale := graph.PutEdge(1, 2, 0.8, RelationSimilar, false)
+	stale.ReinforcedAt = now.Add(-90 * 24 * time.Hour)
+	fresh := graph.PutEdge(1, 3, 0.5, RelationSimilar, false)
+	fresh.ReinforcedAt = now.Add(-24 * time.Hour)
+
+	if removed := graph.PruneEdges(); removed != 0 {
+		t.Errorf("pruned %d edges, want none since their stored weights meet the minimum", removed)
+	}
+	env := &CommandEnv{Graph: graph, GraphFilePath: filepath.Join(t.TempDir(), "graph.txt")}
+	if err := runDecayCommand(env, []string{"1d"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Edges) != 2 {
+		t.Fatalf("shortening the half-life left %d edges, want 2", len(graph.Edges))
+	}
+
+	graph.Settings.DecayHalfLife = 30 * 24 * time.Hour
+	if !graph.Faded(stale, now) || graph.Faded(fresh, now) {
+		t.Errorf("faded: stale %t, fresh %t; want true and false", graph.Faded(stale, now), graph.Faded(fresh, now))
+	}
+	related := RelatedNotes(graph, 1, 0, now)
+	if len(related) != 1 || related[0].Node.ID != 3 {
+		t.Errorf("related notes %v, want only note 3", related)
+	}
+
+	// A longer half-life brings the stale link back
+	graph.Settings.DecayHalfLife = 365 * 24 * time.Hour
+	if related := RelatedNotes(graph, 1, 0, now); len(related) != 2 {
+		t.Errorf("found %d related notes after lengthening the half-life, want 2", len(related))
+	}
+}
+
+func TestRelatedNotesRankByDecayedWeight(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	graph := newDecayTestGraph(now)
+
+	stale := graph.PutEdge(1, 2, 0.9, RelationSimilar, false)
+	stale.ReinforcedAt = now.Add(-60 * 24 * time.Hour)
+	fresh := graph.PutEdge(1, 3, 0.4, RelationSimilar, false)
+	fresh.ReinforcedAt = now
+
+	related := RelatedNotes(graph, 1, 1, now)
+	if len(related) != 1 || related[0].Node.ID != 3 {
+		t.Errorf("strongest related note %v, want note 3 since the stale link decayed to 0.225", related)
+	}
+}
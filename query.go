@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NodeFilter selects notes in graph queries
+type NodeFilter struct {
+	// Concept restricts results to notes tagged with the concept
+	Concept string
+	// Rollup also matches notes tagged with concepts narrower than Concept
+	Rollup bool
+	// Text restricts results to notes containing the substring, ignoring case
+	Text string
+	// Source, Author and Tag restrict results to notes with matching metadata
+	Source string
+	Author string
+	Tag    string
+	// Since and Until restrict results to notes created within the range; zero values are open-ended
+	Since time.Time
+	Until time.Time
+}
+
+// Matches reports whether a node passes the non-concept parts of the filter
+func (filter NodeFilter) Matches(graph *KnowledgeGraph, node *Node) bool {
+	if filter.Text != "" && !strings.Contains(strings.ToLower(node.Text), strings.ToLower(filter.Text)) {
+		return false
+	}
+	if filter.Source != "" && node.Source != filter.Source {
+		return false
+	}
+	if filter.Author != "" && !strings.EqualFold(node.Author, filter.Author) {
+		return false
+	}
+	if filter.Tag != "" && !node.HasTag(filter.Tag) {
+		return false
+	}
+	if !filter.Since.IsZero() && node.CreatedAt.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !node.CreatedAt.Before(filter.Until) {
+		return false
+	}
+	return true
+}
+
+// SearchNodes returns the IDs of the nodes matching the filter in ascending order
+func SearchNodes(graph *KnowledgeGraph, filter NodeFilter) []int64 {
+	var candidates []int64
+	if filter.Concept != "" {
+		candidates = graph.NotesForConcept(filter.Concept, filter.Rollup)
+	} else {
+		for id := range graph.Nodes {
+			candidates = append(candidates, id)
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+	}
+
+	var ids []int64
+	for _, id := range candidates {
+		if node, ok := graph.Nodes[id]; ok && filter.Matches(graph, node) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// runSearchCommand lists the notes matching a concept or text
+func runSearchCommand(env *CommandEnv, args []string) error {
+	var filter NodeFilter
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	flags.StringVar(&filter.Concept, "concept", "", "only notes tagged with this concept")
+	flags.BoolVar(&filter.Rollup, "rollup", true, "include notes tagged with narrower concepts")
+	flags.StringVar(&filter.Text, "text", "", "only notes containing this text")
+	flags.StringVar(&filter.Source, "source", "", "only notes from this source (typed, voice, import, api)")
+	flags.StringVar(&filter.Author, "author", "", "only notes by this author")
+	flags.StringVar(&filter.Tag, "tag", "", "only notes with this manual tag")
+	since := flags.String("since", "", "only notes created at or after this date")
+	until := flags.String("until", "", "only notes created before this date")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if filter.Since, err = parseTimeFlag(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTimeFlag(*until); err != nil {
+		return err
+	}
+	if filter.Concept == "" && filter.Text == "" && flags.NArg() > 0 {
+		filter.Text = strings.Join(flags.Args(), " ")
+	}
+
+	for _, id := range SearchNodes(env.Graph, filter) {
+		printNode(env.Graph, env.Graph.Nodes[id])
+	}
+	return nil
+}
+
+// RelatedNote is a note linked to another by a similarity edge
+type RelatedNote struct {
+	Node   *Node
+	Weight float64
+}
+
+// RelatedNotes returns the notes linked to a node by similarity edges that have not faded, strongest first after recency decay
+func RelatedNotes(graph *KnowledgeGraph, nodeID int64, limit int, now time.Time) []RelatedNote {
+	var related []RelatedNote
+	for _, edge := range graph.Edges {
+		if !edge.IsSimilarity() || (edge.SourceID != nodeID && edge.TargetID != nodeID) || graph.Faded(edge, now) {
+			continue
+		}
+		if other, ok := graph.Nodes[edge.Other(nodeID)]; ok {
+			related = append(related, RelatedNote{Node: other, Weight: graph.EffectiveWeight(edge, now)})
+		}
+	}
+	sort.Slice(related, func(i, j int) bool {
+		if related[i].Weight != related[j].Weight {
+			return related[i].Weight > related[j].Weight
+		}
+		return related[i].Node.ID < related[j].Node.ID
+	})
+	if limit > 0 && len(related) > limit {
+		related = related[:limit]
+	}
+	return related
+}
+
+// NotePath is a chain of notes and the edges linking each to the next
+type NotePath struct {
+	NodeIDs []int64
+	Edges   []*Edge
+}
+
+// FindPath returns the shortest chain of edges linking two notes, following edges of any relation in
+// either direction and ignoring those whose weight after recency decay is below minWeight
+// Among equally short chains the strongest edges are preferred; the path is nil if the notes are not connected
+func FindPath(graph *KnowledgeGraph, fromID, toID int64, minWeight float64, now time.Time) (*NotePath, error) {
+	for _, id := range []int64{fromID, toID} {
+		if _, ok := graph.Nodes[id]; !ok {
+			return nil, fmt.Errorf("node %d not found", id)
+		}
+	}
+
+	adjacent := make(map[int64][]*Edge)
+	weights := make(map[int64]float64)
+	for _, edge := range graph.Edges {
+		weight := graph.EffectiveWeight(edge, now)
+		if weight < minWeight {
+			continue
+		}
+		weights[edge.ID] = weight
+		adjacent[edge.SourceID] = append(adjacent[edge.SourceID], edge)
+		adjacent[edge.TargetID] = append(adjacent[edge.TargetID], edge)
+	}
+	for _, edges := range adjacent {
+		sort.Slice(edges, func(i, j int) bool {
+			if weights[edges[i].ID] != weights[edges[j].ID] {
+				return weights[edges[i].ID] > weights[edges[j].ID]
+			}
+			return edges[i].ID < edges[j].ID
+		})
+	}
+
+	// Breadth-first search, remembering the edge each note was first reached by
+	via := map[int64]*Edge{fromID: nil}
+	queue := []int64{fromID}
+	for len(queue) > 0 && via[toID] == nil && fromID != toID {
+		id := queue[0]
+		queue = queue[1:]
+		for _, edge := range adjacent[id] {
+			next := edge.Other(id)
+			if _, seen := via[next]; !seen {
+				via[next] = edge
+				queue = append(queue, next)
+			}
+		}
+	}
+	if _, reached := via[toID]; !reached {
+		return nil, nil
+	}
+
+	path := &NotePath{NodeIDs: []int64{toID}}
+	for id := toID; id != fromID; {
+		edge := via[id]
+		id = edge.Other(id)
+		path.NodeIDs = append([]int64{id}, path.NodeIDs...)
+		path.Edges = append([]*Edge{edge}, path.Edges...)
+	}
+	return path, nil
+}
+
+// runRelatedCommand lists the notes most related to a note
+func runRelatedCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("related", flag.ContinueOnError)
+	limit := flags.Int("limit", 10, "number of related notes to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: related [-limit n] <node-id>")
+	}
+	id, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid node ID %q", flags.Arg(0))
+	}
+	node, ok := env.Graph.Node(id)
+	if !ok {
+		return fmt.Errorf("node %d not found", id)
+	}
+
+	for _, related := range RelatedNotes(env.Graph, node.ID, *limit, time.Now()) {
+		fmt.Printf("%.3f  ", related.Weight)
+		printNode(env.Graph, related.Node)
+	}
+	return nil
+}
+
+// printNode prints a node with its concepts on one line
+func printNode(graph *KnowledgeGraph, node *Node) {
+	fmt.Printf("Node %d: %s [%s]\n", node.ID, node.Text, strings.Join(graph.NodeConcepts(node.ID), ", "))
+}
@@ -0,0 +1,1239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// kgVocab is the namespace of the knowledge graph vocabulary described in vocab/kg.ttl
+const kgVocab = "https://github.com/saint0x/knowledge-graph/vocab#"
+
+// Namespaces of the standard vocabularies used for export and recognised on import
+const (
+	rdfNS     = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	rdfsNS    = "http://www.w3.org/2000/01/rdf-schema#"
+	xsdNS     = "http://www.w3.org/2001/XMLSchema#"
+	skosNS    = "http://www.w3.org/2004/02/skos/core#"
+	dctermsNS = "http://purl.org/dc/terms/"
+	schemaNS  = "https://schema.org/"
+	foafNS    = "http://xmlns.com/foaf/0.1/"
+)
+
+// rdfResourceBase prefixes the IRIs minted for the graph's notes, concepts and edges
+const rdfResourceBase = "urn:kg:"
+
+// rdfPrefixes are the prefixes used to compact IRIs in Turtle and JSON-LD output
+var rdfPrefixes = []struct{ prefix, namespace string }{
+	{"kg", kgVocab},
+	{"rdf", rdfNS},
+	{"rdfs", rdfsNS},
+	{"xsd", xsdNS},
+	{"skos", skosNS},
+	{"dcterms", dctermsNS},
+}
+
+// Predicates whose literal values give an imported resource its title and description
+var (
+	rdfTitlePredicates       = []string{skosNS + "prefLabel", rdfsNS + "label", dctermsNS + "title", schemaNS + "name", foafNS + "name"}
+	rdfDescriptionPredicates = []string{dctermsNS + "description", rdfsNS + "comment", schemaNS + "description", skosNS + "definition"}
+)
+
+// RDFTermKind distinguishes IRIs, blank nodes and literals
+type RDFTermKind int
+
+// RDF term kinds
+const (
+	RDFIRI RDFTermKind = iota
+	RDFBlank
+	RDFLiteral
+)
+
+// RDFTerm is a subject, predicate or object of a triple
+type RDFTerm struct {
+	Kind  RDFTermKind
+	Value string
+	// Datatype and Language qualify literals; a literal with neither is an xsd:string
+	Datatype string
+	Language string
+}
+
+// Triple is an RDF statement
+type Triple struct {
+	Subject   RDFTerm
+	Predicate RDFTerm
+	Object    RDFTerm
+}
+
+// rdfParsers maps the RDF import formats to their parsers; N-Triples is a subset of Turtle
+var rdfParsers = map[string]func(input string) ([]Triple, error){
+	"turtle":   ParseTurtle,
+	"ntriples": ParseTurtle,
+	"jsonld":   ParseJSONLD,
+}
+
+// rdfWriters maps the RDF export formats to their writers
+var rdfWriters = map[string]func(w io.Writer, triples []Triple) error{
+	"turtle":   WriteTurtle,
+	"ntriples": WriteNTriples,
+	"jsonld":   WriteJSONLD,
+}
+
+// iriTerm returns an IRI term
+func iriTerm(iri string) RDFTerm {
+	return RDFTerm{Kind: RDFIRI, Value: iri}
+}
+
+// literalTerm returns a literal term; an empty datatype makes a plain string
+func literalTerm(value, datatype string) RDFTerm {
+	return RDFTerm{Kind: RDFLiteral, Value: value, Datatype: datatype}
+}
+
+// key identifies the resource an IRI or blank node term refers to
+func (term RDFTerm) key() string {
+	if term.Kind == RDFBlank {
+		return "_:" + term.Value
+	}
+	return term.Value
+}
+
+// noteIRI returns the IRI of a note
+func noteIRI(id int64) string {
+	return fmt.Sprintf("%snote:%d", rdfResourceBase, id)
+}
+
+// conceptIRI returns the IRI of a concept
+func conceptIRI(name string) string {
+	return rdfResourceBase + "concept:" + url.PathEscape(name)
+}
+
+// relationPredicate returns the vocabulary property for an edge relation
+func relationPredicate(relation string) string {
+	return kgVocab + url.PathEscape(relation)
+}
+
+// GraphTriples describes a subgraph in RDF: notes as kg:Note with their text, metadata and
+// kg:concept links, concepts as skos:Concept with their hierarchy, and every edge both as a direct
+// kg:<relation> statement and as a kg:Edge resource carrying its weight
+func GraphTriples(graph *KnowledgeGraph, subgraph *Subgraph) []Triple {
+	var triples []Triple
+	add := func(subject string, predicate string, object RDFTerm) {
+		triples = append(triples, Triple{Subject: iriTerm(subject), Predicate: iriTerm(predicate), Object: object})
+	}
+
+	concepts := make(map[int64]bool)
+	for _, id := range subgraph.NodeIDs {
+		node := graph.Nodes[id]
+		subject := noteIRI(id)
+		add(subject, rdfNS+"type", iriTerm(kgVocab+"Note"))
+		add(subject, kgVocab+"text", literalTerm(node.Text, ""))
+		if !node.CreatedAt.IsZero() {
+			add(subject, kgVocab+"created", literalTerm(formatTime(node.CreatedAt), xsdNS+"dateTime"))
+			add(subject, kgVocab+"updated", literalTerm(formatTime(node.UpdatedAt), xsdNS+"dateTime"))
+		}
+		if node.Source != "" {
+			add(subject, kgVocab+"source", literalTerm(node.Source, ""))
+		}
+		if node.Author != "" {
+			add(subject, kgVocab+"author", literalTerm(node.Author, ""))
+		}
+		for _, tag := range node.Tags {
+			add(subject, kgVocab+"tag", literalTerm(tag, ""))
+		}
+		for _, conceptID := range graph.NodeConceptIDs(id) {
+			concepts[conceptID] = true
+			add(subject, kgVocab+"concept", iriTerm(conceptIRI(graph.Concepts[conceptID].Name)))
+		}
+	}
+
+	conceptIDs := make([]int64, 0, len(concepts))
+	for id := range concepts {
+		conceptIDs = append(conceptIDs, id)
+	}
+	sort.Slice(conceptIDs, func(i, j int) bool { return conceptIDs[i] < conceptIDs[j] })
+	for _, id := range conceptIDs {
+		subject := conceptIRI(graph.Concepts[id].Name)
+		add(subject, rdfNS+"type", iriTerm(skosNS+"Concept"))
+		add(subject, skosNS+"prefLabel", literalTerm(graph.Concepts[id].Name, ""))
+		for _, broaderID := range graph.BroaderConceptIDs(id) {
+			if concepts[broaderID] {
+				add(subject, skosNS+"broader", iriTerm(conceptIRI(graph.Concepts[broaderID].Name)))
+			}
+		}
+		for _, relatedID := range graph.RelatedConceptIDs(id) {
+			if concepts[relatedID] && id < relatedID {
+				add(subject, skosNS+"related", iriTerm(conceptIRI(graph.Concepts[relatedID].Name)))
+			}
+		}
+	}
+
+	for _, edge := range subgraph.Edges {
+		add(noteIRI(edge.SourceID), relationPredicate(edge.Relation), iriTerm(noteIRI(edge.TargetID)))
+		subject := fmt.Sprintf("%sedge:%d", rdfResourceBase, edge.ID)
+		add(subject, rdfNS+"type", iriTerm(kgVocab+"Edge"))
+		add(subject, kgVocab+"from", iriTerm(noteIRI(edge.SourceID)))
+		add(subject, kgVocab+"to", iriTerm(noteIRI(edge.TargetID)))
+		add(subject, kgVocab+"relation", literalTerm(edge.Relation, ""))
+		add(subject, kgVocab+"weight", literalTerm(strconv.FormatFloat(edge.Weight, 'f', -1, 64), xsdNS+"double"))
+		add(subject, kgVocab+"directed", literalTerm(strconv.FormatBool(edge.Directed), xsdNS+"boolean"))
+	}
+
+	return triples
+}
+
+// WriteNTriples writes triples as N-Triples, one statement per line
+func WriteNTriples(w io.Writer, triples []Triple) error {
+	var b strings.Builder
+	for _, triple := range triples {
+		fmt.Fprintf(&b, "%s %s %s .\n", triple.Subject.nTriples(), triple.Predicate.nTriples(), triple.Object.nTriples())
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// nTriples encodes a term in N-Triples syntax
+func (term RDFTerm) nTriples() string {
+	switch term.Kind {
+	case RDFIRI:
+		return "<" + escapeIRI(term.Value) + ">"
+	case RDFBlank:
+		return "_:" + term.Value
+	}
+	literal := `"` + escapeRDFString(term.Value) + `"`
+	if term.Language != "" {
+		return literal + "@" + term.Language
+	}
+	if term.Datatype != "" && term.Datatype != xsdNS+"string" {
+		return literal + "^^<" + escapeIRI(term.Datatype) + ">"
+	}
+	return literal
+}
+
+// WriteTurtle writes triples as Turtle, grouping consecutive statements about the same subject
+func WriteTurtle(w io.Writer, triples []Triple) error {
+	var b strings.Builder
+	for _, prefix := range rdfPrefixes {
+		fmt.Fprintf(&b, "@prefix %s: <%s> .\n", prefix.prefix, prefix.namespace)
+	}
+
+	for i, triple := range triples {
+		switch {
+		case i > 0 && triple.Subject == triples[i-1].Subject && triple.Predicate == triples[i-1].Predicate:
+			b.WriteString(" ,\n        ")
+		case i > 0 && triple.Subject == triples[i-1].Subject:
+			b.WriteString(" ;\n    " + triple.Predicate.turtlePredicate() + " ")
+		default:
+			if i > 0 {
+				b.WriteString(" .\n")
+			}
+			b.WriteString("\n" + triple.Subject.turtle() + "\n    " + triple.Predicate.turtlePredicate() + " ")
+		}
+		b.WriteString(triple.Object.turtle())
+	}
+	if len(triples) > 0 {
+		b.WriteString(" .\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// turtlePredicate encodes a predicate in Turtle, abbreviating rdf:type to "a"
+func (term RDFTerm) turtlePredicate() string {
+	if term.Kind == RDFIRI && term.Value == rdfNS+"type" {
+		return "a"
+	}
+	return term.turtle()
+}
+
+// turtle encodes a term in Turtle, using prefixed names where possible
+func (term RDFTerm) turtle() string {
+	switch term.Kind {
+	case RDFIRI:
+		if compact, ok := compactIRI(term.Value); ok {
+			return compact
+		}
+	case RDFLiteral:
+		if term.Datatype != "" && term.Datatype != xsdNS+"string" && term.Language == "" {
+			if compact, ok := compactIRI(term.Datatype); ok {
+				return `"` + escapeRDFString(term.Value) + `"^^` + compact
+			}
+		}
+	}
+	return term.nTriples()
+}
+
+// compactIRI abbreviates an IRI to a prefixed name if its local part needs no escaping
+func compactIRI(iri string) (string, bool) {
+	for _, prefix := range rdfPrefixes {
+		local, ok := strings.CutPrefix(iri, prefix.namespace)
+		if !ok || local == "" {
+			continue
+		}
+		valid := true
+		for i, r := range local {
+			if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || (i > 0 && (r == '-' || r >= '0' && r <= '9'))) {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return prefix.prefix + ":" + local, true
+		}
+	}
+	return "", false
+}
+
+// escapeRDFString escapes a string for a quoted N-Triples or Turtle literal
+func escapeRDFString(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(value)
+}
+
+// escapeIRI escapes the characters N-Triples forbids in IRIs
+func escapeIRI(iri string) string {
+	var b strings.Builder
+	for _, r := range iri {
+		if r <= ' ' || strings.ContainsRune(`<>"{}|^`+"`"+`\`, r) {
+			fmt.Fprintf(&b, `\u%04X`, r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// WriteJSONLD writes triples as a JSON-LD document with one object per subject
+func WriteJSONLD(w io.Writer, triples []Triple) error {
+	context := make(map[string]string)
+	for _, prefix := range rdfPrefixes {
+		context[prefix.prefix] = prefix.namespace
+	}
+
+	var objects []map[string]interface{}
+	bySubject := make(map[RDFTerm]map[string]interface{})
+	for _, triple := range triples {
+		object, ok := bySubject[triple.Subject]
+		if !ok {
+			object = map[string]interface{}{"@id": triple.Subject.key()}
+			bySubject[triple.Subject] = object
+			objects = append(objects, object)
+		}
+
+		key, value := "", interface{}(nil)
+		if triple.Predicate.Value == rdfNS+"type" && triple.Object.Kind == RDFIRI {
+			key, value = "@type", jsonLDCompact(triple.Object.Value)
+		} else {
+			key, value = jsonLDCompact(triple.Predicate.Value), jsonLDValue(triple.Object)
+		}
+		switch existing := object[key].(type) {
+		case nil:
+			object[key] = value
+		case []interface{}:
+			object[key] = append(existing, value)
+		default:
+			object[key] = []interface{}{existing, value}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"@context": context,
+		"@graph":   objects,
+	})
+}
+
+// jsonLDCompact abbreviates an IRI with the output prefixes
+func jsonLDCompact(iri string) string {
+	if compact, ok := compactIRI(iri); ok {
+		return compact
+	}
+	return iri
+}
+
+// jsonLDValue encodes an object term as a JSON-LD value
+func jsonLDValue(term RDFTerm) interface{} {
+	switch {
+	case term.Kind != RDFLiteral:
+		return map[string]interface{}{"@id": term.key()}
+	case term.Language != "":
+		return map[string]interface{}{"@value": term.Value, "@language": term.Language}
+	case term.Datatype != "" && term.Datatype != xsdNS+"string":
+		return map[string]interface{}{"@value": term.Value, "@type": jsonLDCompact(term.Datatype)}
+	}
+	return term.Value
+}
+
+// turtleParser reads Turtle, and therefore N-Triples, documents
+// Collections and the quoted triples of RDF-star are not supported
+type turtleParser struct {
+	input    string
+	pos      int
+	prefixes map[string]string
+	base     *url.URL
+	blanks   int
+	triples  []Triple
+}
+
+// ParseTurtle parses a Turtle or N-Triples document
+func ParseTurtle(input string) ([]Triple, error) {
+	parser := &turtleParser{input: input, prefixes: make(map[string]string)}
+	for {
+		parser.skipSpace()
+		if parser.pos >= len(parser.input) {
+			return parser.triples, nil
+		}
+		if err := parser.statement(); err != nil {
+			line := strings.Count(parser.input[:min(parser.pos, len(parser.input))], "\n") + 1
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
+
+// statement parses a directive or a block of triples about one subject
+func (parser *turtleParser) statement() error {
+	rest := parser.input[parser.pos:]
+	for _, directive := range []string{"@prefix", "@base", "PREFIX", "BASE"} {
+		if len(rest) > len(directive) && strings.EqualFold(rest[:len(directive)], directive) &&
+			(directive[0] == '@' || rest[len(directive)] == ' ' || rest[len(directive)] == '\t') {
+			parser.pos += len(directive)
+			return parser.directive(strings.TrimPrefix(strings.ToLower(directive), "@"), directive[0] == '@')
+		}
+	}
+
+	var subject RDFTerm
+	var err error
+	if parser.peek() == '[' {
+		// A blank node property list may stand on its own as a statement
+		if subject, err = parser.blankNodePropertyList(); err != nil {
+			return err
+		}
+		parser.skipSpace()
+		if parser.peek() == '.' {
+			parser.pos++
+			return nil
+		}
+	} else if subject, err = parser.resource(); err != nil {
+		return err
+	}
+	if err := parser.predicateObjectList(subject); err != nil {
+		return err
+	}
+	return parser.expect('.')
+}
+
+// directive parses the rest of a prefix or base declaration
+func (parser *turtleParser) directive(kind string, dotted bool) error {
+	parser.skipSpace()
+	if kind == "prefix" {
+		start := parser.pos
+		for parser.pos < len(parser.input) && parser.input[parser.pos] != ':' && !isTurtleSpace(parser.input[parser.pos]) {
+			parser.pos++
+		}
+		if parser.peek() != ':' {
+			return errors.New("expected ':' in prefix declaration")
+		}
+		name := parser.input[start:parser.pos]
+		parser.pos++
+		parser.skipSpace()
+		iri, err := parser.iriRef()
+		if err != nil {
+			return err
+		}
+		parser.prefixes[name] = iri
+	} else {
+		iri, err := parser.iriRef()
+		if err != nil {
+			return err
+		}
+		if parser.base, err = url.Parse(iri); err != nil {
+			return fmt.Errorf("invalid base IRI %q", iri)
+		}
+	}
+	if dotted {
+		return parser.expect('.')
+	}
+	return nil
+}
+
+// predicateObjectList parses "predicate object, object; predicate object" for a subject
+func (parser *turtleParser) predicateObjectList(subject RDFTerm) error {
+	for {
+		parser.skipSpace()
+		var predicate RDFTerm
+		if parser.peek() == 'a' && parser.pos+1 < len(parser.input) && isTurtleSpace(parser.input[parser.pos+1]) {
+			parser.pos++
+			predicate = iriTerm(rdfNS + "type")
+		} else {
+			var err error
+			if predicate, err = parser.resource(); err != nil {
+				return err
+			}
+			if predicate.Kind != RDFIRI {
+				return errors.New("predicate must be an IRI")
+			}
+		}
+
+		for {
+			object, err := parser.object()
+			if err != nil {
+				return err
+			}
+			parser.triples = append(parser.triples, Triple{Subject: subject, Predicate: predicate, Object: object})
+			parser.skipSpace()
+			if parser.peek() != ',' {
+				break
+			}
+			parser.pos++
+		}
+
+		if parser.peek() != ';' {
+			return nil
+		}
+		for parser.peek() == ';' {
+			parser.pos++
+			parser.skipSpace()
+		}
+		if c := parser.peek(); c == '.' || c == ']' {
+			return nil
+		}
+	}
+}
+
+// object parses an object: a resource, a blank node property list or a literal
+func (parser *turtleParser) object() (RDFTerm, error) {
+	parser.skipSpace()
+	switch c := parser.peek(); {
+	case c == '[':
+		return parser.blankNodePropertyList()
+	case c == '(':
+		return RDFTerm{}, errors.New("RDF collections are not supported")
+	case c == '"' || c == '\'':
+		return parser.literal()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return parser.number()
+	}
+	for _, keyword := range []string{"true", "false"} {
+		rest := parser.input[parser.pos:]
+		if strings.HasPrefix(rest, keyword) && (len(rest) == len(keyword) || !isPNChar(rune(rest[len(keyword)]))) {
+			parser.pos += len(keyword)
+			return literalTerm(keyword, xsdNS+"boolean"), nil
+		}
+	}
+	return parser.resource()
+}
+
+// resource parses an IRI reference, prefixed name or blank node label
+func (parser *turtleParser) resource() (RDFTerm, error) {
+	parser.skipSpace()
+	if parser.peek() == '<' {
+		iri, err := parser.iriRef()
+		return iriTerm(iri), err
+	}
+	if strings.HasPrefix(parser.input[parser.pos:], "_:") {
+		parser.pos += 2
+		return RDFTerm{Kind: RDFBlank, Value: parser.name()}, nil
+	}
+
+	name := parser.name()
+	prefix, local, ok := strings.Cut(name, ":")
+	if !ok {
+		if name == "" && parser.pos < len(parser.input) {
+			return RDFTerm{}, fmt.Errorf("unexpected %q", parser.input[parser.pos])
+		}
+		return RDFTerm{}, fmt.Errorf("expected a prefixed name, got %q", name)
+	}
+	namespace, ok := parser.prefixes[prefix]
+	if !ok {
+		return RDFTerm{}, fmt.Errorf("undeclared prefix %q", prefix)
+	}
+	return iriTerm(namespace + strings.ReplaceAll(local, `\`, "")), nil
+}
+
+// name reads a prefixed name or blank node label, which may not end with a dot
+func (parser *turtleParser) name() string {
+	start := parser.pos
+	for parser.pos < len(parser.input) {
+		r, size := utf8.DecodeRuneInString(parser.input[parser.pos:])
+		if r == '\\' && parser.pos+1 < len(parser.input) {
+			parser.pos += 2
+			continue
+		}
+		if !isPNChar(r) && r != ':' && r != '.' && r != '%' {
+			break
+		}
+		parser.pos += size
+	}
+	for parser.pos > start && parser.input[parser.pos-1] == '.' {
+		parser.pos--
+	}
+	return parser.input[start:parser.pos]
+}
+
+// blankNodePropertyList parses "[ predicate object; ... ]" into a fresh blank node
+func (parser *turtleParser) blankNodePropertyList() (RDFTerm, error) {
+	parser.pos++
+	parser.blanks++
+	node := RDFTerm{Kind: RDFBlank, Value: fmt.Sprintf("genid%d", parser.blanks)}
+	parser.skipSpace()
+	if parser.peek() != ']' {
+		if err := parser.predicateObjectList(node); err != nil {
+			return RDFTerm{}, err
+		}
+	}
+	return node, parser.expect(']')
+}
+
+// iriRef parses "<iri>", resolving it against the base IRI
+func (parser *turtleParser) iriRef() (string, error) {
+	if parser.peek() != '<' {
+		return "", errors.New("expected '<'")
+	}
+	end := strings.IndexByte(parser.input[parser.pos:], '>')
+	if end < 0 {
+		return "", errors.New("unterminated IRI")
+	}
+	iri, err := unescapeRDF(parser.input[parser.pos+1 : parser.pos+end])
+	if err != nil {
+		return "", err
+	}
+	parser.pos += end + 1
+	if parser.base != nil {
+		if ref, err := url.Parse(iri); err == nil && !ref.IsAbs() {
+			iri = parser.base.ResolveReference(ref).String()
+		}
+	}
+	return iri, nil
+}
+
+// literal parses a quoted literal with an optional language tag or datatype
+func (parser *turtleParser) literal() (RDFTerm, error) {
+	quote := parser.input[parser.pos : parser.pos+1]
+	if strings.HasPrefix(parser.input[parser.pos:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	parser.pos += len(quote)
+
+	start := parser.pos
+	for {
+		if parser.pos >= len(parser.input) {
+			return RDFTerm{}, errors.New("unterminated literal")
+		}
+		if parser.input[parser.pos] == '\\' {
+			parser.pos += 2
+			continue
+		}
+		if strings.HasPrefix(parser.input[parser.pos:], quote) {
+			break
+		}
+		if len(quote) == 1 && parser.input[parser.pos] == '\n' {
+			return RDFTerm{}, errors.New("line break in literal")
+		}
+		parser.pos++
+	}
+	value, err := unescapeRDF(parser.input[start:parser.pos])
+	if err != nil {
+		return RDFTerm{}, err
+	}
+	parser.pos += len(quote)
+
+	term := literalTerm(value, "")
+	switch {
+	case parser.peek() == '@':
+		parser.pos++
+		start := parser.pos
+		for parser.pos < len(parser.input) && (isPNChar(rune(parser.input[parser.pos])) && parser.input[parser.pos] != '_' && parser.input[parser.pos] != '.') {
+			parser.pos++
+		}
+		term.Language = parser.input[start:parser.pos]
+	case strings.HasPrefix(parser.input[parser.pos:], "^^"):
+		parser.pos += 2
+		datatype, err := parser.resource()
+		if err != nil {
+			return RDFTerm{}, err
+		}
+		term.Datatype = datatype.Value
+	}
+	return term, nil
+}
+
+// number parses an integer, decimal or double literal
+func (parser *turtleParser) number() (RDFTerm, error) {
+	start := parser.pos
+	if c := parser.peek(); c == '+' || c == '-' {
+		parser.pos++
+	}
+	datatype := xsdNS + "integer"
+	for parser.pos < len(parser.input) {
+		c := parser.input[parser.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && parser.pos+1 < len(parser.input) && parser.input[parser.pos+1] >= '0' && parser.input[parser.pos+1] <= '9':
+			if datatype == xsdNS+"integer" {
+				datatype = xsdNS + "decimal"
+			}
+		case c == 'e' || c == 'E':
+			datatype = xsdNS + "double"
+			if parser.pos+1 < len(parser.input) && (parser.input[parser.pos+1] == '+' || parser.input[parser.pos+1] == '-') {
+				parser.pos++
+			}
+		default:
+			value := parser.input[start:parser.pos]
+			if value == "" || value == "+" || value == "-" {
+				return RDFTerm{}, fmt.Errorf("invalid number %q", value)
+			}
+			return literalTerm(value, datatype), nil
+		}
+		parser.pos++
+	}
+	return literalTerm(parser.input[start:], datatype), nil
+}
+
+// expect skips whitespace and consumes a punctuation character
+func (parser *turtleParser) expect(c byte) error {
+	parser.skipSpace()
+	if parser.peek() != c {
+		if parser.pos >= len(parser.input) {
+			return fmt.Errorf("expected %q at end of input", c)
+		}
+		return fmt.Errorf("expected %q, found %q", c, parser.input[parser.pos])
+	}
+	parser.pos++
+	return nil
+}
+
+// peek returns the next byte, or 0 at the end of the input
+func (parser *turtleParser) peek() byte {
+	if parser.pos >= len(parser.input) {
+		return 0
+	}
+	return parser.input[parser.pos]
+}
+
+// skipSpace skips whitespace and comments
+func (parser *turtleParser) skipSpace() {
+	for parser.pos < len(parser.input) {
+		switch c := parser.input[parser.pos]; {
+		case isTurtleSpace(c):
+			parser.pos++
+		case c == '#':
+			for parser.pos < len(parser.input) && parser.input[parser.pos] != '\n' {
+				parser.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// isTurtleSpace reports whether a byte is Turtle whitespace
+func isTurtleSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isPNChar reports whether a rune may appear in a prefixed name or blank node label
+func isPNChar(r rune) bool {
+	return r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f
+}
+
+// unescapeRDF decodes the string and numeric escapes of Turtle literals and IRIs
+func unescapeRDF(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'b':
+			b.WriteByte('\b')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u', 'U':
+			size := 4
+			if value[i] == 'U' {
+				size = 8
+			}
+			if i+size >= len(value) {
+				return "", errors.New("truncated unicode escape")
+			}
+			code, err := strconv.ParseUint(value[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode escape %q", value[i-1:i+1+size])
+			}
+			b.WriteRune(rune(code))
+			i += size
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// jsonLDContext maps terms and prefixes to IRIs, with the type coercions of term definitions
+type jsonLDContext struct {
+	terms map[string]string
+	types map[string]string
+	vocab string
+}
+
+// jsonLDParser turns a JSON-LD document into triples
+// It understands inline contexts, @id, @type, @value, @language, @graph and nested node objects;
+// remote contexts are not fetched
+type jsonLDParser struct {
+	blanks  int
+	triples []Triple
+}
+
+// ParseJSONLD parses a JSON-LD document
+func ParseJSONLD(input string) ([]Triple, error) {
+	var document interface{}
+	if err := json.Unmarshal([]byte(input), &document); err != nil {
+		return nil, err
+	}
+	parser := &jsonLDParser{}
+	if err := parser.element(document, &jsonLDContext{terms: map[string]string{}, types: map[string]string{}}); err != nil {
+		return nil, err
+	}
+	return parser.triples, nil
+}
+
+// element parses a top-level value: an array of node objects or a node object
+func (parser *jsonLDParser) element(value interface{}, context *jsonLDContext) error {
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if err := parser.element(item, context); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		_, err := parser.node(value, context)
+		return err
+	}
+	return nil
+}
+
+// node parses a node object and returns the term it describes
+func (parser *jsonLDParser) node(object map[string]interface{}, context *jsonLDContext) (RDFTerm, error) {
+	if local, ok := object["@context"]; ok {
+		var err error
+		if context, err = context.extend(local); err != nil {
+			return RDFTerm{}, err
+		}
+	}
+
+	var subject RDFTerm
+	if id, ok := object["@id"].(string); ok {
+		subject = context.resource(id)
+	} else {
+		parser.blanks++
+		subject = RDFTerm{Kind: RDFBlank, Value: fmt.Sprintf("genid%d", parser.blanks)}
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := object[key]
+		switch key {
+		case "@context", "@id":
+			continue
+		case "@graph":
+			if err := parser.element(value, context); err != nil {
+				return RDFTerm{}, err
+			}
+			continue
+		case "@type":
+			for _, item := range jsonLDList(value) {
+				if name, ok := item.(string); ok {
+					parser.triples = append(parser.triples, Triple{Subject: subject, Predicate: iriTerm(rdfNS + "type"), Object: context.resource(name)})
+				}
+			}
+			continue
+		}
+
+		predicate := context.expand(key)
+		if predicate == "" || strings.HasPrefix(key, "@") {
+			continue
+		}
+		for _, item := range jsonLDList(value) {
+			object, ok, err := parser.value(item, context, context.types[key])
+			if err != nil {
+				return RDFTerm{}, err
+			}
+			if ok {
+				parser.triples = append(parser.triples, Triple{Subject: subject, Predicate: iriTerm(predicate), Object: object})
+			}
+		}
+	}
+	return subject, nil
+}
+
+// value parses a property value, coerced by the term's @type if the value is a plain string
+func (parser *jsonLDParser) value(value interface{}, context *jsonLDContext, coercion string) (RDFTerm, bool, error) {
+	switch value := value.(type) {
+	case string:
+		switch coercion {
+		case "@id", "@vocab":
+			return context.resource(value), true, nil
+		case "":
+			return literalTerm(value, ""), true, nil
+		}
+		return literalTerm(value, context.expand(coercion)), true, nil
+	case float64:
+		if value == float64(int64(value)) {
+			return literalTerm(strconv.FormatInt(int64(value), 10), xsdNS+"integer"), true, nil
+		}
+		return literalTerm(strconv.FormatFloat(value, 'g', -1, 64), xsdNS+"double"), true, nil
+	case bool:
+		return literalTerm(strconv.FormatBool(value), xsdNS+"boolean"), true, nil
+	case map[string]interface{}:
+		if literal, ok := value["@value"]; ok {
+			term := literalTerm(fmt.Sprint(literal), "")
+			if datatype, ok := value["@type"].(string); ok {
+				term.Datatype = context.expand(datatype)
+			}
+			if language, ok := value["@language"].(string); ok {
+				term.Language = language
+			}
+			return term, true, nil
+		}
+		if list, ok := value["@list"]; ok {
+			// Lists are flattened into repeated values
+			for _, item := range jsonLDList(list) {
+				if err := parser.element(item, context); err != nil {
+					return RDFTerm{}, false, err
+				}
+			}
+			return RDFTerm{}, false, nil
+		}
+		term, err := parser.node(value, context)
+		return term, err == nil, err
+	}
+	return RDFTerm{}, false, nil
+}
+
+// extend returns the context with a local context applied
+func (context *jsonLDContext) extend(local interface{}) (*jsonLDContext, error) {
+	extended := &jsonLDContext{terms: make(map[string]string), types: make(map[string]string), vocab: context.vocab}
+	for term, iri := range context.terms {
+		extended.terms[term] = iri
+	}
+	for term, coercion := range context.types {
+		extended.types[term] = coercion
+	}
+
+	for _, item := range jsonLDList(local) {
+		definitions, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("remote JSON-LD contexts are not supported")
+		}
+		for term, definition := range definitions {
+			switch definition := definition.(type) {
+			case string:
+				if term == "@vocab" {
+					extended.vocab = definition
+				} else {
+					extended.terms[term] = definition
+				}
+			case map[string]interface{}:
+				if id, ok := definition["@id"].(string); ok {
+					extended.terms[term] = id
+				}
+				if coercion, ok := definition["@type"].(string); ok {
+					extended.types[term] = coercion
+				}
+			}
+		}
+	}
+
+	// Term definitions may use prefixes defined in the same context
+	for term, iri := range extended.terms {
+		extended.terms[term] = extended.expandPrefix(iri)
+	}
+	return extended, nil
+}
+
+// expand turns a term, compact IRI or absolute IRI into an absolute IRI, or "" if it isn't mapped
+func (context *jsonLDContext) expand(term string) string {
+	if iri, ok := context.terms[term]; ok {
+		return iri
+	}
+	if expanded := context.expandPrefix(term); expanded != term || strings.Contains(term, ":") {
+		return expanded
+	}
+	if context.vocab != "" {
+		return context.vocab + term
+	}
+	return ""
+}
+
+// expandPrefix expands a compact IRI whose prefix is defined in the context
+func (context *jsonLDContext) expandPrefix(value string) string {
+	prefix, local, ok := strings.Cut(value, ":")
+	if !ok || strings.HasPrefix(local, "//") {
+		return value
+	}
+	if namespace, ok := context.terms[prefix]; ok {
+		return namespace + local
+	}
+	return value
+}
+
+// resource returns the IRI or blank node term for an @id or @type value
+func (context *jsonLDContext) resource(value string) RDFTerm {
+	if label, ok := strings.CutPrefix(value, "_:"); ok {
+		return RDFTerm{Kind: RDFBlank, Value: label}
+	}
+	if iri, ok := context.terms[value]; ok {
+		return iriTerm(iri)
+	}
+	return iriTerm(context.expandPrefix(value))
+}
+
+// jsonLDList returns a JSON-LD value as a list of values
+func jsonLDList(value interface{}) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{value}
+}
+
+// ImportRDF adds the resources described by triples to the graph. Subjects typed kg:Note, or with
+// a text, label or title, become notes; kg:concept and dcterms:subject values and rdf:type classes
+// become their concepts; SKOS broader and related statements become concept relations; and
+// statements between two notes become directed edges named after the predicate. kg:Edge resources
+// restore the weight and direction of typed edges. Similarity edges are derived again from the
+// concepts rather than imported. Notes are keyed by their IRI, so importing a file again updates
+// the notes whose statements changed.
+func (importer *NoteImporter) ImportRDF(graph *KnowledgeGraph, triples []Triple) (ImportResult, error) {
+	var result ImportResult
+
+	var subjects []string
+	statements := make(map[string][]Triple)
+	types := make(map[string]map[string]bool)
+	concepts := make(map[string]bool)
+	for _, triple := range triples {
+		subject := triple.Subject.key()
+		if _, ok := statements[subject]; !ok {
+			subjects = append(subjects, subject)
+		}
+		statements[subject] = append(statements[subject], triple)
+
+		switch triple.Predicate.Value {
+		case rdfNS + "type":
+			if types[subject] == nil {
+				types[subject] = make(map[string]bool)
+			}
+			types[subject][triple.Object.Value] = true
+		case kgVocab + "concept", dctermsNS + "subject":
+			concepts[triple.Object.key()] = true
+		case skosNS + "broader", skosNS + "narrower", skosNS + "related":
+			concepts[subject] = true
+			concepts[triple.Object.key()] = true
+		}
+	}
+	for subject, classes := range types {
+		if classes[skosNS+"Concept"] || classes[kgVocab+"Concept"] {
+			concepts[subject] = true
+		}
+	}
+
+	// literal returns the first literal value of a subject for any of the predicates
+	literal := func(subject string, predicates ...string) string {
+		for _, predicate := range predicates {
+			for _, triple := range statements[subject] {
+				if triple.Predicate.Value == predicate && triple.Object.Kind == RDFLiteral {
+					return triple.Object.Value
+				}
+			}
+		}
+		return ""
+	}
+	// conceptName names a concept resource by its label, or by the IRI it was exported under
+	conceptName := func(subject string) string {
+		if label := literal(subject, rdfTitlePredicates...); label != "" {
+			return label
+		}
+		if name, ok := strings.CutPrefix(subject, rdfResourceBase+"concept:"); ok {
+			if unescaped, err := url.PathUnescape(name); err == nil {
+				return unescaped
+			}
+		}
+		return rdfLocalName(subject)
+	}
+
+	imported := importedNodes(graph)
+	nodeIDs := make(map[string]int64)
+	for _, subject := range subjects {
+		if concepts[subject] || types[subject][kgVocab+"Edge"] {
+			continue
+		}
+		text := literal(subject, kgVocab+"text")
+		if text == "" {
+			var parts []string
+			for _, part := range []string{literal(subject, rdfTitlePredicates...), literal(subject, rdfDescriptionPredicates...)} {
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			text = strings.Join(parts, "\n\n")
+		}
+		if text == "" {
+			continue
+		}
+
+		note := ImportedNote{
+			Key:         subject,
+			Text:        text,
+			Author:      literal(subject, kgVocab+"author", dctermsNS+"creator"),
+			ContentHash: rdfStatementsHash(statements[subject]),
+		}
+		var err error
+		if note.CreatedAt, err = parseTimeFlag(literal(subject, kgVocab+"created", dctermsNS+"created")); err != nil {
+			return result, fmt.Errorf("%s: %v", subject, err)
+		}
+		if note.UpdatedAt, err = parseTimeFlag(literal(subject, kgVocab+"updated", dctermsNS+"modified")); err != nil {
+			return result, fmt.Errorf("%s: %v", subject, err)
+		}
+		for _, triple := range statements[subject] {
+			switch {
+			case triple.Predicate.Value == kgVocab+"tag" && triple.Object.Kind == RDFLiteral:
+				note.Tags = append(note.Tags, triple.Object.Value)
+			case triple.Predicate.Value == kgVocab+"concept" || triple.Predicate.Value == dctermsNS+"subject":
+				if triple.Object.Kind == RDFLiteral {
+					note.Concepts = append(note.Concepts, triple.Object.Value)
+				} else {
+					note.Concepts = append(note.Concepts, conceptName(triple.Object.key()))
+				}
+			case triple.Predicate.Value == rdfNS+"type" && triple.Object.Value != kgVocab+"Note":
+				note.Concepts = append(note.Concepts, conceptName(triple.Object.key()))
+			}
+		}
+
+		node, err := importer.upsertNote(graph, imported[subject], note, &result)
+		if err != nil {
+			return result, err
+		}
+		nodeIDs[subject] = node.ID
+	}
+
+	// Typed edges described as kg:Edge resources keep their weight and direction
+	reified := make(map[edgeKey]bool)
+	for _, subject := range subjects {
+		if !types[subject][kgVocab+"Edge"] {
+			continue
+		}
+		var from, to string
+		for _, triple := range statements[subject] {
+			switch triple.Predicate.Value {
+			case kgVocab + "from":
+				from = triple.Object.key()
+			case kgVocab + "to":
+				to = triple.Object.key()
+			}
+		}
+		sourceID, targetID := nodeIDs[from], nodeIDs[to]
+		relation := literal(subject, kgVocab+"relation")
+		if sourceID == 0 || targetID == 0 || relation == "" || relation == RelationSimilar {
+			continue
+		}
+		weight, err := strconv.ParseFloat(literal(subject, kgVocab+"weight"), 64)
+		if err != nil {
+			weight = 1
+		}
+		directed := literal(subject, kgVocab+"directed") != "false"
+		graph.PutEdge(sourceID, targetID, weight, relation, directed)
+		reified[edgeKey{SourceID: sourceID, TargetID: targetID, Relation: relation}] = true
+		reified[edgeKey{SourceID: targetID, TargetID: sourceID, Relation: relation}] = true
+		result.Links++
+	}
+
+	// Any other statement between two notes is a directed edge named after its predicate
+	for _, triple := range triples {
+		sourceID, targetID := nodeIDs[triple.Subject.key()], nodeIDs[triple.Object.key()]
+		if sourceID == 0 || targetID == 0 || triple.Object.Kind == RDFLiteral || sourceID == targetID {
+			continue
+		}
+		relation := rdfRelationName(triple.Predicate.Value)
+		if relation == RelationSimilar || reified[edgeKey{SourceID: sourceID, TargetID: targetID, Relation: relation}] {
+			continue
+		}
+		graph.PutEdge(sourceID, targetID, 1, relation, true)
+		result.Links++
+	}
+
+	// SKOS statements between concepts become the ontology
+	for _, triple := range triples {
+		var source, target, kind string
+		switch triple.Predicate.Value {
+		case skosNS + "broader":
+			source, target, kind = triple.Subject.key(), triple.Object.key(), RelationBroader
+		case skosNS + "narrower":
+			source, target, kind = triple.Object.key(), triple.Subject.key(), RelationBroader
+		case skosNS + "related":
+			source, target, kind = triple.Subject.key(), triple.Object.key(), RelationRelated
+		default:
+			continue
+		}
+		if _, err := graph.AddConceptRelation(conceptName(source), conceptName(target), kind, OriginImport); err != nil {
+			log.Printf("Skipping concept relation %s %s %s: %v", conceptName(source), kind, conceptName(target), err)
+		}
+	}
+
+	return result, nil
+}
+
+// rdfRelationName names the edge relation for a predicate: the property name for the graph's own
+// vocabulary, otherwise the IRI's local name
+func rdfRelationName(predicate string) string {
+	if name, ok := strings.CutPrefix(predicate, kgVocab); ok {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			return unescaped
+		}
+		return name
+	}
+	return rdfLocalName(predicate)
+}
+
+// rdfLocalName returns the part of an IRI after its last '#', '/' or ':'
+func rdfLocalName(iri string) string {
+	if i := strings.LastIndexAny(iri, "#/:"); i >= 0 && i < len(iri)-1 {
+		return iri[i+1:]
+	}
+	return iri
+}
+
+// rdfStatementsHash hashes a subject's statements independently of their order
+func rdfStatementsHash(triples []Triple) string {
+	lines := make([]string, len(triples))
+	for i, triple := range triples {
+		lines[i] = triple.Predicate.nTriples() + " " + triple.Object.nTriples()
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newRDFTestGraph builds a graph whose texts and names need escaping in every RDF syntax
+func newRDFTestGraph(t *testing.T) *KnowledgeGraph {
+	t.Helper()
+	graph := NewKnowledgeGraph()
+	created := time.Date(2024, 2, 29, 13, 45, 0, 0, time.UTC)
+	first, err := AddNote(graph, "She said \"quorum\"\nthen left\\ with a tab\t", []string{"consensus", "raft"},
+		NodeMetadata{Author: "zoë", Tags: []string{"quotes \"here\""}, CreatedAt: created, UpdatedAt: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := AddNote(graph, "Unicode: 日本語, émigré and 🚀", []string{"unicode text", "raft"}, NodeMetadata{CreatedAt: created, UpdatedAt: created.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := AddNote(graph, "Plain note", []string{"paxos"}, NodeMetadata{CreatedAt: created, UpdatedAt: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.PutEdge(second.ID, first.ID, 0.75, "cites", true)
+	graph.PutEdge(third.ID, first.ID, 0.5, "part of", false)
+	if _, err := graph.AddConceptRelation("raft", "consensus", RelationBroader, OriginManual); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := graph.AddConceptRelation("raft", "paxos", RelationRelated, OriginManual); err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+// describeGraph lists the notes, links, concepts and concept relations of a graph without their IDs, sorted
+func describeGraph(graph *KnowledgeGraph) []string {
+	var lines []string
+	for _, node := range graph.Nodes {
+		tags := slices.Clone(node.Tags)
+		sort.Strings(tags)
+		lines = append(lines, fmt.Sprintf("note %q tags=%q concepts=%q author=%q created=%s", node.Text,
+			tags, graph.NodeConcepts(node.ID), node.Author, node.CreatedAt))
+	}
+	for _, edge := range graph.Edges {
+		if !edge.IsSimilarity() {
+			lines = append(lines, fmt.Sprintf("edge %q %s %q directed=%t weight=%g",
+				graph.Nodes[edge.SourceID].Text, edge.Relation, graph.Nodes[edge.TargetID].Text, edge.Directed, edge.Weight))
+		}
+	}
+	for _, concept := range graph.Concepts {
+		lines = append(lines, "concept "+concept.Name)
+	}
+	for _, relation := range graph.ConceptRelations {
+		lines = append(lines, fmt.Sprintf("relation %s %s %s", graph.Concepts[relation.SourceID].Name, relation.Kind,
+			graph.Concepts[relation.TargetID].Name))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// describeTriples lists triples in N-Triples syntax, sorted
+func describeTriples(triples []Triple) []string {
+	lines := make([]string, len(triples))
+	for i, triple := range triples {
+		lines[i] = triple.Subject.nTriples() + " " + triple.Predicate.nTriples() + " " + triple.Object.nTriples()
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestRDFRoundTrips(t *testing.T) {
+	graph := newRDFTestGraph(t)
+	subgraph, err := SelectSubgraph(graph, SubgraphOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	triples := GraphTriples(graph, subgraph)
+
+	for _, format := range []string{"turtle", "ntriples", "jsonld"} {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := rdfWriters[format](&out, triples); err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := rdfParsers[format](out.String())
+			if err != nil {
+				t.Fatalf("parsing the export: %v\n%s", err, out.String())
+			}
+			if got, want := describeTriples(parsed), describeTriples(triples); !slices.Equal(got, want) {
+				t.Errorf("parsed triples\n%q\nwant\n%q", got, want)
+			}
+
+			imported := NewKnowledgeGraph()
+			if _, err := (&NoteImporter{}).ImportRDF(imported, parsed); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := describeGraph(imported), describeGraph(graph); !slices.Equal(got, want) {
+				t.Errorf("imported graph holds\n%q\nwant\n%q", got, want)
+			}
+
+			// Importing the same document again changes nothing
+			result, err := (&NoteImporter{}).ImportRDF(imported, parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Added != 0 || result.Updated != 0 || result.Unchanged != len(graph.Nodes) {
+				t.Errorf("importing again gave %+v, want all %d notes unchanged", result, len(graph.Nodes))
+			}
+		})
+	}
+}
+
+func TestTurtleParsesEscapesAndTypedLiterals(t *testing.T) {
+	input := `@prefix kg: <https://example.org/kg#> .
+<urn:a> kg:text "line\nbreak \"quoted\" café \U0001F680" ;
+	kg:weight "0.25"^^<http://www.w3.org/2001/XMLSchema#double> , 3 ;
+	kg:label 'single'@en .
+`
+	triples, err := ParseTurtle(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RDFTerm{
+		{Kind: RDFLiteral, Value: "line\nbreak \"quoted\" café 🚀"},
+		{Kind: RDFLiteral, Value: "0.25", Datatype: xsdNS + "double"},
+		{Kind: RDFLiteral, Value: "3", Datatype: xsdNS + "integer"},
+		{Kind: RDFLiteral, Value: "single", Language: "en"},
+	}
+	if len(triples) != len(want) {
+		t.Fatalf("parsed %d triples, want %d", len(triples), len(want))
+	}
+	for i, triple := range triples {
+		if triple.Object != want[i] {
+			t.Errorf("object %d is %+v, want %+v", i, triple.Object, want[i])
+		}
+	}
+
+	if _, err := ParseTurtle(`<urn:a> <urn:p> "unterminated .`); err == nil {
+		t.Error("parsed an unterminated literal")
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRequestBody caps the JSON bodies of write requests
+const maxRequestBody = 1 << 20
+
+// Server serves the tenants' knowledge graphs over HTTP
+type Server struct {
+	Root   string
+	APIKey string
+
+	// UI serves the embedded graph viewer at the root path
+	UI bool
+
+	// mu guards the map of cached graphs; each graph has a lock of its own
+	mu     sync.Mutex
+	graphs map[string]*cachedGraph
+}
+
+// cachedGraph is a tenant's graph with the state of its files when the server last loaded or saved it
+// mu is held from fetching the graph until the request that changes it has saved it, so a reload
+// cannot swap the graph under a request and lose its writes
+type cachedGraph struct {
+	mu    sync.Mutex
+	graph *KnowledgeGraph
+	stamp string
+}
+
+// requestContext carries the authenticated caller and the graph they selected
+type requestContext struct {
+	Key    *APIKey
+	Tenant string
+	Graph  *KnowledgeGraph
+}
+
+// nodeView is the JSON representation of a node
+type nodeView struct {
+	ID          int64         `json:"id"`
+	Text        string        `json:"text"`
+	Concepts    []string      `json:"concepts"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	UpdatedAt   time.Time     `json:"updatedAt"`
+	Source      string        `json:"source,omitempty"`
+	Author      string        `json:"author,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
+	Attachments []string      `json:"attachments,omitempty"`
+	Related     []relatedView `json:"related,omitempty"`
+}
+
+// relatedView is the JSON representation of a related note
+type relatedView struct {
+	ID     int64   `json:"id"`
+	Text   string  `json:"text"`
+	Weight float64 `json:"weight"`
+}
+
+// graphView is the JSON representation of a subgraph drawn by the viewer
+type graphView struct {
+	Nodes []graphNodeView `json:"nodes"`
+	Edges []graphEdgeView `json:"edges"`
+}
+
+// graphNodeView is a node of a graphView
+type graphNodeView struct {
+	ID       int64    `json:"id"`
+	Label    string   `json:"label"`
+	Text     string   `json:"text"`
+	Concepts []string `json:"concepts"`
+	Cluster  int      `json:"cluster"`
+}
+
+// graphEdgeView is an edge of a graphView
+type graphEdgeView struct {
+	ID       int64   `json:"id"`
+	Source   int64   `json:"source"`
+	Target   int64   `json:"target"`
+	Weight   float64 `json:"weight"`
+	Relation string  `json:"relation"`
+	Directed bool    `json:"directed"`
+}
+
+// noteRequest is the body of a request adding a note
+type noteRequest struct {
+	Text     string   `json:"text"`
+	Concepts []string `json:"concepts"`
+	Tags     []string `json:"tags"`
+}
+
+// NewServer creates a server for the graphs in a data directory
+func NewServer(root, apiKey string) *Server {
+	return &Server{
+		Root:   root,
+		APIKey: apiKey,
+		graphs: make(map[string]*cachedGraph),
+	}
+}
+
+// Handler returns the HTTP handler serving the API
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/nodes", server.authorize(ScopeRead, server.handleListNodes))
+	mux.HandleFunc("/api/nodes/", server.handleNode)
+	mux.HandleFunc("/api/notes", server.authorize(ScopeWrite, server.handleAddNote))
+	mux.HandleFunc("/api/concepts", server.authorize(ScopeRead, server.handleListConcepts))
+	mux.HandleFunc("/api/graph", server.authorize(ScopeRead, server.handleGraph))
+	mux.HandleFunc("/api/graphql", server.authorize(ScopeRead, server.handleGraphQL))
+	mux.HandleFunc("/api/events", server.authorizeStream(ScopeRead, server.handleEvents))
+	mux.HandleFunc("/api/events/ws", server.authorizeStream(ScopeRead, server.handleEventsWebSocket))
+	mux.HandleFunc("/api/sync", server.authorize(ScopeWrite, server.handleSync))
+	if server.UI {
+		mux.Handle("/", uiHandler())
+	}
+	return mux
+}
+
+// authorize wraps a handler with API-key authentication, a scope check and tenant selection
+// Keys and workspaces are reloaded on every request so changes made from the CLI apply immediately
+func (server *Server) authorize(scope string, handler func(http.ResponseWriter, *http.Request, *requestContext)) http.HandlerFunc {
+	return server.authenticate(scope, false, handler)
+}
+
+// authorizeStream is authorize for the event streams, which also take the key from the access_token
+// query parameter because browsers cannot set headers on EventSource and WebSocket connections
+// Other endpoints refuse it, since URLs end up in logs and Referer headers
+func (server *Server) authorizeStream(scope string, handler func(http.ResponseWriter, *http.Request, *requestContext)) http.HandlerFunc {
+	return server.authenticate(scope, true, handler)
+}
+
+// authenticate implements authorize and authorizeStream
+// Requests hold their tenant's graph lock until the handler returns; streams only while they subscribe
+func (server *Server) authenticate(scope string, stream bool, handler func(http.ResponseWriter, *http.Request, *requestContext)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = strings.TrimSpace(bearer)
+		}
+		if token == "" && stream {
+			token = r.URL.Query().Get("access_token")
+		}
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="knowledge-graph"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing API key"))
+			return
+		}
+
+		keys, err := OpenKeyStore(server.Root)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		key, err := keys.Authenticate(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="knowledge-graph", error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if !key.Allows(scope) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("API key lacks the %s scope", scope))
+			return
+		}
+
+		// The key's user decides which graphs are reachable
+		store, err := OpenTenantStore(server.Root)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		workspace := r.URL.Query().Get("workspace")
+		if workspace == "" {
+			workspace = r.Header.Get("X-Workspace")
+		}
+		tenant, err := store.Resolve(key.User, workspace)
+		if err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+
+		graph, unlock, err := server.lockGraph(store, tenant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if stream {
+			// The stream reads only the graph's event bus, which outlives reloads
+			ctx := &requestContext{Key: key, Tenant: tenant, Graph: graph}
+			unlock()
+			handler(w, r, ctx)
+			return
+		}
+		defer unlock()
+		handler(w, r, &requestContext{Key: key, Tenant: tenant, Graph: graph})
+	}
+}
+
+// lockGraph locks the cached graph of a tenant and returns it with the function that unlocks it,
+// loading the graph on first use and again whenever another process, like a CLI command, changed its files since
+func (server *Server) lockGraph(store *TenantStore, tenant string) (*KnowledgeGraph, func(), error) {
+	server.mu.Lock()
+	cached, ok := server.graphs[tenant]
+	if !ok {
+		cached = &cachedGraph{}
+		server.graphs[tenant] = cached
+	}
+	server.mu.Unlock()
+
+	cached.mu.Lock()
+	path := store.GraphPath(tenant)
+	if cached.graph != nil && cached.stamp == graphFileStamp(path) {
+		return cached.graph, cached.mu.Unlock, nil
+	}
+	graph, err := store.LoadTenantGraph(tenant)
+	if err != nil {
+		cached.mu.Unlock()
+		return nil, nil, err
+	}
+	if server.APIKey != "" {
+		graph.Embedder = NewOpenAIEmbedder(server.APIKey)
+	}
+
+	// Subscribers of the stale graph keep their stream
+	if cached.graph != nil {
+		graph.Events = cached.graph.Events
+	} else {
+		graph.Events = NewEventBus()
+	}
+	cached.graph, cached.stamp = graph, graphFileStamp(path)
+	return graph, cached.mu.Unlock, nil
+}
+
+// graphFileStamp identifies the state of a graph file and its log by their sizes and modification times
+func graphFileStamp(path string) string {
+	var stamp strings.Builder
+	for _, file := range []string{path, path + ".log"} {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&stamp, "%d:%d;", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return stamp.String()
+}
+
+// save persists a tenant's graph; callers must hold the tenant's graph lock
+func (server *Server) save(ctx *requestContext) error {
+	store := &TenantStore{Root: server.Root}
+	path := store.GraphPath(ctx.Tenant)
+	if err := SaveGraph(path, ctx.Graph); err != nil {
+		return err
+	}
+	server.mu.Lock()
+	cached, ok := server.graphs[ctx.Tenant]
+	server.mu.Unlock()
+	if ok && cached.graph == ctx.Graph {
+		cached.stamp = graphFileStamp(path)
+	}
+	return nil
+}
+
+// stillAllowed rechecks a long-lived request against the current keys and workspaces,
+// so an event stream ends once its key is revoked or its workspace unshared
+func (server *Server) stillAllowed(ctx *requestContext) bool {
+	keys, err := OpenKeyStore(server.Root)
+	if err != nil {
+		return false
+	}
+	key, ok := keys.Keys[ctx.Key.ID]
+	if !ok || key.Revoked() {
+		return false
+	}
+	store, err := OpenTenantStore(server.Root)
+	if err != nil {
+		return false
+	}
+	return store.CanAccess(key.User, ctx.Tenant)
+}
+
+// handleNode routes /api/nodes/{id} and /api/nodes/{id}/reextract
+func (server *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/nodes/")
+	idText, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("invalid node ID %q", idText))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		server.authorize(ScopeRead, func(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+			server.handleGetNode(w, r, ctx, id)
+		})(w, r)
+	case action == "" && r.Method == http.MethodDelete:
+		server.authorize(ScopeAdmin, func(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+			server.handleDeleteNode(w, r, ctx, id)
+		})(w, r)
+	case action == "reextract" && r.Method == http.MethodPost:
+		server.authorize(ScopeAdmin, func(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+			server.handleReextractNode(w, r, ctx, id)
+		})(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on %s", r.Method, r.URL.Path))
+	}
+}
+
+// handleListNodes lists the notes matching the query-string filter
+func (server *Server) handleListNodes(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	filter, err := nodeFilterFromQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	views := []nodeView{}
+	for _, id := range SearchNodes(ctx.Graph, filter) {
+		views = append(views, newNodeView(ctx.Graph, ctx.Graph.Nodes[id]))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// handleGetNode returns a note with its related notes
+func (server *Server) handleGetNode(w http.ResponseWriter, r *http.Request, ctx *requestContext, id int64) {
+	node, ok := ctx.Graph.Nodes[id]
+	if !ok {
+		// A note merged into another moved permanently
+		if target, ok := ctx.Graph.Redirects[id]; ok {
+			location := fmt.Sprintf("/api/nodes/%d", target)
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, location, http.StatusMovedPermanently)
+			return
+		}
+		writeError(w, http.StatusNotFound, fmt.Errorf("node %d not found", id))
+		return
+	}
+	view := newNodeView(ctx.Graph, node)
+	for _, related := range RelatedNotes(ctx.Graph, id, 10, time.Now()) {
+		view.Related = append(view.Related, relatedView{ID: related.Node.ID, Text: related.Node.Text, Weight: related.Weight})
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// handleAddNote adds a note, extracting its concepts unless the caller supplied them
+func (server *Server) handleAddNote(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	var request noteRequest
+	if err := decodeJSONBody(w, r, maxRequestBody, &request); err != nil {
+		writeError(w, bodyErrorStatus(err), fmt.Errorf("invalid note: %v", err))
+		return
+	}
+	if strings.TrimSpace(request.Text) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("note text must not be empty"))
+		return
+	}
+
+	// Concept extraction talks to the LLM, so it runs before taking the graph lock
+	concepts := request.Concepts
+	if len(concepts) == 0 {
+		if server.APIKey == "" {
+			writeError(w, http.StatusBadRequest, errors.New("concepts are required when the server has no OpenAI API key"))
+			return
+		}
+		var err error
+		if concepts, err = ExtractConcepts(request.Text, server.APIKey); err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+	}
+
+	node, err := AddNote(ctx.Graph, request.Text, concepts, NodeMetadata{
+		Source: SourceAPI,
+		Author: ctx.Key.User,
+		Tags:   request.Tags,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := server.save(ctx); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newNodeView(ctx.Graph, node))
+}
+
+// handleDeleteNode deletes a note
+func (server *Server) handleDeleteNode(w http.ResponseWriter, r *http.Request, ctx *requestContext, id int64) {
+	if err := ctx.Graph.RemoveNode(id); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := server.save(ctx); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReextractNode extracts a note's concepts again and relinks it
+func (server *Server) handleReextractNode(w http.ResponseWriter, r *http.Request, ctx *requestContext, id int64) {
+	if server.APIKey == "" {
+		writeError(w, http.StatusServiceUnavailable, errors.New(missingAPIKeyMessage))
+		return
+	}
+
+	node, ok := ctx.Graph.Nodes[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("node %d not found", id))
+		return
+	}
+
+	concepts, err := ExtractConcepts(node.Text, server.APIKey)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	if err := ctx.Graph.UpdateNodeConcepts(id, concepts); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := server.save(ctx); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newNodeView(ctx.Graph, ctx.Graph.Nodes[id]))
+}
+
+// handleListConcepts lists the concepts in use with their note counts
+func (server *Server) handleListConcepts(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	writeJSON(w, http.StatusOK, TopConcepts(ctx.Graph, time.Time{}, time.Time{}))
+}
+
+// handleGraph returns the notes and edges matching the query-string filter, clustered for drawing
+func (server *Server) handleGraph(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	filter, err := nodeFilterFromQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	options := SubgraphOptions{Filter: filter, Depth: 1}
+	query := r.URL.Query()
+	if value := query.Get("min_weight"); value != "" {
+		if options.MinWeight, err = strconv.ParseFloat(value, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid min_weight %q", value))
+			return
+		}
+	}
+	if value := query.Get("node"); value != "" {
+		if options.Root, err = strconv.ParseInt(value, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid node %q", value))
+			return
+		}
+	}
+	if value := query.Get("depth"); value != "" {
+		if options.Depth, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid depth %q", value))
+			return
+		}
+	}
+
+	subgraph, err := SelectSubgraph(ctx.Graph, options)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	view := graphView{Nodes: []graphNodeView{}, Edges: []graphEdgeView{}}
+	for _, id := range subgraph.NodeIDs {
+		node := ctx.Graph.Nodes[id]
+		view.Nodes = append(view.Nodes, graphNodeView{
+			ID:       id,
+			Label:    exportLabel(node.Text),
+			Text:     node.Text,
+			Concepts: ctx.Graph.NodeConcepts(id),
+			Cluster:  subgraph.Clusters[id],
+		})
+	}
+	for _, edge := range subgraph.Edges {
+		view.Edges = append(view.Edges, graphEdgeView{
+			ID:       edge.ID,
+			Source:   edge.SourceID,
+			Target:   edge.TargetID,
+			Weight:   edge.Weight,
+			Relation: edge.Relation,
+			Directed: edge.Directed,
+		})
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// nodeFilterFromQuery builds a node filter from query-string parameters
+func nodeFilterFromQuery(r *http.Request) (NodeFilter, error) {
+	query := r.URL.Query()
+	filter := NodeFilter{
+		Concept: query.Get("concept"),
+		Rollup:  query.Get("rollup") != "false",
+		Text:    query.Get("q"),
+		Source:  query.Get("source"),
+		Author:  query.Get("author"),
+		Tag:     query.Get("tag"),
+	}
+	var err error
+	if filter.Since, err = parseTimeFlag(query.Get("since")); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseTimeFlag(query.Get("until")); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// newNodeView converts a node to its JSON representation
+func newNodeView(graph *KnowledgeGraph, node *Node) nodeView {
+	return nodeView{
+		ID:          node.ID,
+		Text:        node.Text,
+		Concepts:    graph.NodeConcepts(node.ID),
+		CreatedAt:   node.CreatedAt,
+		UpdatedAt:   node.UpdatedAt,
+		Source:      node.Source,
+		Author:      node.Author,
+		Tags:        node.Tags,
+		Attachments: node.Attachments,
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// decodeJSONBody decodes a request body of at most limit bytes
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, limit int64, value interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(value)
+}
+
+// bodyErrorStatus is the status for a body decodeJSONBody rejected
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// runServeCommand serves the graphs over HTTP
+func runServeCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	ui := flags.Bool("ui", false, "serve the interactive graph viewer at /")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	server := NewServer(env.Store.Root, env.APIKey)
+	server.UI = *ui
+	log.Printf("Serving knowledge graphs on http://%s", *addr)
+	return http.ListenAndServe(*addr, server.Handler())
+}
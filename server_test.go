@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAPI is a server over a temporary data directory with a key per user
+type testAPI struct {
+	t      *testing.T
+	root   string
+	api    *Server
+	server *httptest.Server
+	tokens map[string]string
+}
+
+// newTestAPI starts a server and issues an admin key to each user
+func newTestAPI(t *testing.T, users ...string) *testAPI {
+	t.Helper()
+	api := &testAPI{t: t, root: t.TempDir(), tokens: make(map[string]string)}
+	keys, err := OpenKeyStore(api.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range users {
+		token, _, err := keys.Issue(user, []string{ScopeAdmin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		api.tokens[user] = token
+	}
+	api.api = NewServer(api.root, "")
+	api.server = httptest.NewServer(api.api.Handler())
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// do sends a request as a user and returns the status and body
+func (api *testAPI) do(user, method, path string, body interface{}) (int, []byte) {
+	api.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, api.server.URL+path, reader)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+api.tokens[user])
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return response.StatusCode, data
+}
+
+// addNote adds a note as a user, optionally to a workspace, and returns its ID
+func (api *testAPI) addNote(user, workspace, text string) int64 {
+	api.t.Helper()
+	path := "/api/notes"
+	if workspace != "" {
+		path += "?workspace=" + workspace
+	}
+	status, body := api.do(user, http.MethodPost, path, noteRequest{Text: text, Concepts: []string{"secrets"}})
+	if status != http.StatusCreated {
+		api.t.Fatalf("adding a note as %s: %d %s", user, status, body)
+	}
+	var node nodeView
+	if err := json.Unmarshal(body, &node); err != nil {
+		api.t.Fatal(err)
+	}
+	return node.ID
+}
+
+// noteTexts lists the texts of the notes a user sees
+func (api *testAPI) noteTexts(user, query string) []string {
+	api.t.Helper()
+	status, body := api.do(user, http.MethodGet, "/api/nodes"+query, nil)
+	if status != http.StatusOK {
+		api.t.Fatalf("listing notes as %s: %d %s", user, status, body)
+	}
+	var nodes []nodeView
+	if err := json.Unmarshal(body, &nodes); err != nil {
+		api.t.Fatal(err)
+	}
+	texts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		texts = append(texts, node.Text)
+	}
+	return texts
+}
+
+func TestServerKeepsUsersOutOfEachOthersGraphs(t *testing.T) {
+	api := newTestAPI(t, "alice", "bob")
+	bobNote := api.addNote("bob", "", "Bob's private note")
+
+	if texts := api.noteTexts("alice", ""); len(texts) != 0 {
+		t.Errorf("alice sees notes in her own graph she never added: %q", texts)
+	}
+	if status, body := api.do("alice", http.MethodGet, fmt.Sprintf("/api/nodes/%d", bobNote), nil); status != http.StatusNotFound {
+		t.Errorf("alice reading bob's note ID in her graph: %d %s", status, body)
+	}
+
+	// Naming bob's graph as a workspace is refused for reads, writes and deletes
+	denied := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodGet, "/api/nodes?workspace=bob", nil},
+		{http.MethodGet, fmt.Sprintf("/api/nodes/%d?workspace=bob", bobNote), nil},
+		{http.MethodGet, "/api/graph?workspace=bob", nil},
+		{http.MethodPost, "/api/notes?workspace=bob", noteRequest{Text: "planted", Concepts: []string{"x"}}},
+		{http.MethodDelete, fmt.Sprintf("/api/nodes/%d?workspace=bob", bobNote), nil},
+	}
+	for _, request := range denied {
+		if status, body := api.do("alice", request.method, request.path, request.body); status != http.StatusForbidden {
+			t.Errorf("alice %s %s: got %d %s, want 403", request.method, request.path, status, body)
+		}
+	}
+	if texts := api.noteTexts("bob", ""); len(texts) != 1 || texts[0] != "Bob's private note" {
+		t.Errorf("bob's graph changed: %q", texts)
+	}
+}
+
+func TestServerWorkspaceAccessFollowsSharing(t *testing.T) {
+	api := newTestAPI(t, "alice", "bob")
+	store, err := OpenTenantStore(api.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateWorkspace("team", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	api.addNote("bob", "team", "Team plan")
+
+	if status, _ := api.do("alice", http.MethodGet, "/api/nodes?workspace=team", nil); status != http.StatusForbidden {
+		t.Errorf("alice read an unshared workspace: %d", status)
+	}
+	if status, _ := api.do("alice", http.MethodPost, "/api/notes?workspace=team", noteRequest{Text: "x", Concepts: []string{"x"}}); status != http.StatusForbidden {
+		t.Errorf("alice wrote to an unshared workspace: %d", status)
+	}
+
+	if err := store.ShareWorkspace("team", "bob", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if texts := api.noteTexts("alice", "?workspace=team"); len(texts) != 1 {
+		t.Errorf("alice sees %q in the shared workspace, want bob's note", texts)
+	}
+
+	// Unsharing applies to the next request although the server has the workspace cached
+	if err := store.UnshareWorkspace("team", "bob", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := api.do("alice", http.MethodGet, "/api/nodes?workspace=team", nil); status != http.StatusForbidden {
+		t.Errorf("alice still reads the workspace after it was unshared: %d", status)
+	}
+}
+
+func TestServerReloadsGraphChangedOutsideIt(t *testing.T) {
+	api := newTestAPI(t, "alice")
+	api.addNote("alice", "", "Written through the API")
+
+	// A CLI command writes the same graph file while the server has it cached
+	store := &TenantStore{Root: api.root}
+	graph, err := store.LoadTenantGraph("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddNote(graph, "Written from the CLI", []string{"cli"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveGraph(store.GraphPath("alice"), graph); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := api.noteTexts("alice", ""); len(texts) != 2 {
+		t.Errorf("server lists %q, want both notes", texts)
+	}
+	api.addNote("alice", "", "Written through the API again")
+	reloaded, err := LoadGraph(store.GraphPath("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Nodes) != 3 {
+		t.Errorf("graph file holds %d notes after the server saved, want 3", len(reloaded.Nodes))
+	}
+}
+
+func TestServerHoldsTheGraphAcrossConcurrentWrites(t *testing.T) {
+	api := newTestAPI(t, "alice")
+	api.addNote("alice", "", "First note")
+	store := &TenantStore{Root: api.root}
+	path := store.GraphPath("alice")
+
+	// A request holds the graph while another process touches its file and more requests arrive
+	graph, unlock, err := api.api.lockGraph(store, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+	const writers = 4
+	var wg sync.WaitGroup
+	for writer := 0; writer < writers; writer++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			data, err := json.Marshal(noteRequest{Text: fmt.Sprintf("Note of writer %d", writer), Concepts: []string{"load"}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			request, err := http.NewRequest(http.MethodPost, api.server.URL+"/api/notes", bytes.NewReader(data))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			request.Header.Set("Authorization", "Bearer "+api.tokens["alice"])
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusCreated {
+				t.Errorf("writer %d: got %d, want 201", writer, response.StatusCode)
+			}
+		}(writer)
+	}
+
+	// Give the writers time to queue up behind the lock before the held write lands
+	time.Sleep(100 * time.Millisecond)
+	if _, err := AddNote(graph, "Written while holding the graph", []string{"held"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.api.save(&requestContext{Tenant: "alice", Graph: graph}); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	wg.Wait()
+
+	want := 2 + writers
+	if texts := api.noteTexts("alice", ""); len(texts) != want {
+		t.Errorf("server lists %q, want %d notes", texts, want)
+	}
+	reloaded, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Nodes) != want {
+		t.Errorf("graph file holds %d notes, want %d", len(reloaded.Nodes), want)
+	}
+}
+
+func TestServerTakesQueryTokensOnlyOnEventStreams(t *testing.T) {
+	api := newTestAPI(t, "alice")
+	response, err := http.Get(api.server.URL + "/api/nodes?access_token=" + api.tokens["alice"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("query token on /api/nodes: got %d, want 401", response.StatusCode)
+	}
+
+	response, err = http.Get(api.server.URL + "/api/events?access_token=" + api.tokens["alice"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("query token on /api/events: got %d, want 200", response.StatusCode)
+	}
+}
+
+func TestServerRejectsOversizedBodies(t *testing.T) {
+	api := newTestAPI(t, "alice")
+	text := strings.Repeat("x", maxRequestBody)
+	status, body := api.do("alice", http.MethodPost, "/api/notes", noteRequest{Text: text, Concepts: []string{"x"}})
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized note: got %d %s, want 413", status, body)
+	}
+	if texts := api.noteTexts("alice", ""); len(texts) != 0 {
+		t.Errorf("oversized note was stored")
+	}
+}
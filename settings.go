@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// GraphSettings holds per-graph options persisted alongside the graph
+type GraphSettings struct {
+	// WeightStrategy names the WeightFunc used for similarity edges; empty means Jaccard
+	WeightStrategy string
+
+	// OntologyCredit is the partial weight given to concept pairs sharing an ancestor; 0 disables it
+	OntologyCredit float64
+
+	// MinWeight drops similarity edges weighing less than this
+	MinWeight float64
+
+	// TopK keeps only each node's k strongest similarity edges; 0 keeps all
+	TopK int
+
+	// MutualKNN keeps an edge only if it is among the top k of both of its nodes
+	MutualKNN bool
+
+	// DecayHalfLife makes edge weights fade with the time since they were last reinforced; 0 disables decay
+	DecayHalfLife time.Duration
+
+	// DuplicatePolicy is what adding a near duplicate of an existing note does: warn (the default), merge or off
+	DuplicatePolicy string
+
+	// DuplicateThreshold is the word overlap from which notes are near duplicates; 0 means the default
+	DuplicateThreshold float64
+}
+
+// writeSettings writes the graph settings as "Setting Key=value" records
+// Floats are written in full, since %f would round a small minimum weight to zero
+func writeSettings(w io.Writer, settings GraphSettings) error {
+	records := []string{
+		fmt.Sprintf("WeightStrategy=%s", settings.WeightStrategy),
+		fmt.Sprintf("OntologyCredit=%s", strconv.FormatFloat(settings.OntologyCredit, 'g', -1, 64)),
+		fmt.Sprintf("MinWeight=%s", strconv.FormatFloat(settings.MinWeight, 'g', -1, 64)),
+		fmt.Sprintf("TopK=%d", settings.TopK),
+		fmt.Sprintf("MutualKNN=%t", settings.MutualKNN),
+		fmt.Sprintf("DecayHalfLife=%s", settings.DecayHalfLife),
+		fmt.Sprintf("DuplicatePolicy=%s", settings.DuplicatePolicy),
+		fmt.Sprintf("DuplicateThreshold=%s", strconv.FormatFloat(settings.DuplicateThreshold, 'g', -1, 64)),
+	}
+	for _, record := range records {
+		if _, err := fmt.Fprintf(w, "Setting %s\n", record); err != nil {
+			return fmt.Errorf("failed to write setting: %v", err)
+		}
+	}
+	return nil
+}
+
+// applySetting parses a single "Key=value" setting record into the graph settings
+func applySetting(settings *GraphSettings, key, value string) error {
+	switch key {
+	case "WeightStrategy":
+		settings.WeightStrategy = value
+	case "OntologyCredit":
+		credit, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid OntologyCredit: %v", err)
+		}
+		settings.OntologyCredit = credit
+	case "MinWeight":
+		minWeight, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid MinWeight: %v", err)
+		}
+		settings.MinWeight = minWeight
+	case "TopK":
+		topK, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid TopK: %v", err)
+		}
+		settings.TopK = topK
+	case "MutualKNN":
+		mutual, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid MutualKNN: %v", err)
+		}
+		settings.MutualKNN = mutual
+	case "DecayHalfLife":
+		halfLife, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid DecayHalfLife: %v", err)
+		}
+		settings.DecayHalfLife = halfLife
+	case "DuplicatePolicy":
+		settings.DuplicatePolicy = value
+	case "DuplicateThreshold":
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid DuplicateThreshold: %v", err)
+		}
+		settings.DuplicateThreshold = threshold
+	default:
+		// Settings written by newer versions are kept out of the way rather than failing the load
+		log.Printf("Ignoring unknown graph setting %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSettingsSurviveSavingInFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	graph.Settings = GraphSettings{
+		WeightStrategy:     WeightTFIDF,
+		OntologyCredit:     0.125,
+		MinWeight:          0.0000005,
+		TopK:               7,
+		MutualKNN:          true,
+		DecayHalfLife:      90 * 24 * time.Hour,
+		DuplicatePolicy:    "merge",
+		DuplicateThreshold: 1.0 / 3,
+	}
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Settings != graph.Settings {
+		t.Errorf("reloaded settings %+v, want %+v", loaded.Settings, graph.Settings)
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotCurrent refers to the graph as it is now wherever a snapshot name is expected
+const snapshotCurrent = "current"
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// SnapshotTag names the state of a graph after one of its log entries
+type SnapshotTag struct {
+	Name      string
+	Seq       int64
+	CreatedAt time.Time
+}
+
+// graphSnapshotsPath returns the file listing a graph file's named snapshots
+func graphSnapshotsPath(graphFilePath string) string {
+	return graphFilePath + ".snapshots"
+}
+
+// LoadSnapshotTags reads the named snapshots of a graph file, oldest first
+func LoadSnapshotTags(graphFilePath string) ([]SnapshotTag, error) {
+	file, err := os.Open(graphSnapshotsPath(graphFilePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open snapshots file: %v", err)
+	}
+	defer file.Close()
+
+	var tags []SnapshotTag
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Tag ") {
+			continue
+		}
+		name, body, _ := strings.Cut(strings.TrimPrefix(line, "Tag "), ":")
+		fields := parseRecordFields(body)
+		tag := SnapshotTag{Name: name}
+		if tag.Seq, err = strconv.ParseInt(fields["Seq"], 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot %s: %v", name, err)
+		}
+		if tag.CreatedAt, err = parseTime(fields["CreatedAt"]); err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot %s: %v", name, err)
+		}
+		tags = append(tags, tag)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while scanning snapshots file: %v", err)
+	}
+	return tags, nil
+}
+
+// SaveSnapshotTags replaces the named snapshots of a graph file
+func SaveSnapshotTags(graphFilePath string, tags []SnapshotTag) error {
+	return writeFileAtomic(graphSnapshotsPath(graphFilePath), 0o644, func(w io.Writer) error {
+		for _, tag := range tags {
+			if _, err := fmt.Fprintf(w, "Tag %s: Seq=%d, CreatedAt=%s\n", tag.Name, tag.Seq, formatTime(tag.CreatedAt)); err != nil {
+				return fmt.Errorf("failed to write snapshot: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// findSnapshotTag returns the index of a named snapshot, or -1
+func findSnapshotTag(tags []SnapshotTag, name string) int {
+	return slices.IndexFunc(tags, func(tag SnapshotTag) bool { return tag.Name == name })
+}
+
+// loadSnapshot reconstructs the graph a snapshot name refers to; "current" is the loaded graph itself
+func loadSnapshot(env *CommandEnv, tags []SnapshotTag, name string) (*KnowledgeGraph, error) {
+	if name == snapshotCurrent {
+		return env.Graph, nil
+	}
+	index := findSnapshotTag(tags, name)
+	if index < 0 {
+		return nil, fmt.Errorf("snapshot %q not found", name)
+	}
+	return ReplayGraphLogSeq(env.GraphFilePath, tags[index].Seq)
+}
+
+// GraphDiff lists what changed between two versions of a graph
+// Nodes are matched by ID, edges by their endpoints and relation, concepts by name, concept relations by
+// their concepts' names, kind and origin, and redirects by the ID they redirect
+type GraphDiff struct {
+	From             string                `json:"from"`
+	To               string                `json:"to"`
+	Summary          map[string]int        `json:"summary"`
+	NodesAdded       []NodeDiff            `json:"nodesAdded"`
+	NodesRemoved     []NodeDiff            `json:"nodesRemoved"`
+	NodesChanged     []NodeDiff            `json:"nodesChanged"`
+	EdgesAdded       []EdgeDiff            `json:"edgesAdded"`
+	EdgesRemoved     []EdgeDiff            `json:"edgesRemoved"`
+	EdgesReweight    []EdgeDiff            `json:"edgesReweighted"`
+	EdgesChanged     []EdgeDiff            `json:"edgesChanged"`
+	ConceptsAdded    []string              `json:"conceptsAdded"`
+	ConceptsGone     []string              `json:"conceptsRemoved"`
+	RelationsAdded   []ConceptRelationDiff `json:"conceptRelationsAdded"`
+	RelationsGone    []ConceptRelationDiff `json:"conceptRelationsRemoved"`
+	SettingsChanged  []string              `json:"settingsChanged"`
+	RedirectsChanged []int64               `json:"redirectsChanged"`
+}
+
+// NodeDiff describes a node in a diff; Changed names the fields that differ
+type NodeDiff struct {
+	ID       int64    `json:"id"`
+	Text     string   `json:"text"`
+	Concepts []string `json:"concepts,omitempty"`
+	Changed  []string `json:"changed,omitempty"`
+}
+
+// EdgeDiff describes an edge in a diff with its weight before and after; Changed names the other fields that differ
+type EdgeDiff struct {
+	SourceID   int64    `json:"sourceId"`
+	TargetID   int64    `json:"targetId"`
+	Relation   string   `json:"relation"`
+	FromWeight float64  `json:"fromWeight"`
+	ToWeight   float64  `json:"toWeight"`
+	Changed    []string `json:"changed,omitempty"`
+}
+
+// ConceptRelationDiff describes a concept relation in a diff
+type ConceptRelationDiff struct {
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Origin string `json:"origin"`
+}
+
+// edgeWeightEpsilon ignores weight changes smaller than the precision the graph file keeps
+const edgeWeightEpsilon = 1e-6
+
+// DiffGraphs compares two versions of a graph
+func DiffGraphs(from, to *KnowledgeGraph) *GraphDiff {
+	diff := &GraphDiff{}
+
+	nodeView := func(graph *KnowledgeGraph, node *Node) NodeDiff {
+		concepts := graph.NodeConcepts(node.ID)
+		sort.Strings(concepts)
+		return NodeDiff{ID: node.ID, Text: node.Text, Concepts: concepts}
+	}
+	for id, node := range to.Nodes {
+		before, ok := from.Nodes[id]
+		if !ok {
+			diff.NodesAdded = append(diff.NodesAdded, nodeView(to, node))
+			continue
+		}
+		view := nodeView(to, node)
+		previous := nodeView(from, before)
+		if node.Text != before.Text {
+			view.Changed = append(view.Changed, "text")
+		}
+		if !slices.Equal(view.Concepts, previous.Concepts) {
+			view.Changed = append(view.Changed, "concepts")
+		} else if !slices.Equal(to.NodeConcepts(id), from.NodeConcepts(id)) {
+			view.Changed = append(view.Changed, "concept order")
+		}
+		if !slices.Equal(sortedCopy(node.Tags), sortedCopy(before.Tags)) {
+			view.Changed = append(view.Changed, "tags")
+		}
+		if !slices.Equal(sortedCopy(node.Attachments), sortedCopy(before.Attachments)) {
+			view.Changed = append(view.Changed, "attachments")
+		}
+		if node.Source != before.Source || node.Author != before.Author || node.SourcePath != before.SourcePath || node.ContentHash != before.ContentHash {
+			view.Changed = append(view.Changed, "provenance")
+		}
+		if !node.CreatedAt.Equal(before.CreatedAt) || !node.UpdatedAt.Equal(before.UpdatedAt) {
+			view.Changed = append(view.Changed, "timestamps")
+		}
+		if !slices.Equal(node.Embedding, before.Embedding) {
+			view.Changed = append(view.Changed, "embedding")
+		}
+		if len(view.Changed) > 0 {
+			diff.NodesChanged = append(diff.NodesChanged, view)
+		}
+	}
+	for id, node := range from.Nodes {
+		if _, ok := to.Nodes[id]; !ok {
+			diff.NodesRemoved = append(diff.NodesRemoved, nodeView(from, node))
+		}
+	}
+
+	edgesByKey := func(graph *KnowledgeGraph) map[edgeKey]*Edge {
+		edges := make(map[edgeKey]*Edge, len(graph.Edges))
+		for _, edge := range graph.Edges {
+			edges[canonicalEdgeKey(edge.SourceID, edge.TargetID, edge.Relation, edge.Directed)] = edge
+		}
+		return edges
+	}
+	fromEdges, toEdges := edgesByKey(from), edgesByKey(to)
+	for key, edge := range toEdges {
+		before, ok := fromEdges[key]
+		switch {
+		case !ok:
+			diff.EdgesAdded = append(diff.EdgesAdded, EdgeDiff{SourceID: key.SourceID, TargetID: key.TargetID, Relation: key.Relation, ToWeight: edge.Weight})
+		case math.Abs(edge.Weight-before.Weight) > edgeWeightEpsilon:
+			diff.EdgesReweight = append(diff.EdgesReweight, EdgeDiff{SourceID: key.SourceID, TargetID: key.TargetID, Relation: key.Relation, FromWeight: before.Weight, ToWeight: edge.Weight})
+		}
+		if ok && !edge.ReinforcedAt.Equal(before.ReinforcedAt) {
+			diff.EdgesChanged = append(diff.EdgesChanged, EdgeDiff{SourceID: key.SourceID, TargetID: key.TargetID, Relation: key.Relation,
+				FromWeight: before.Weight, ToWeight: edge.Weight, Changed: []string{"reinforcedAt"}})
+		}
+	}
+	for key, edge := range fromEdges {
+		if _, ok := toEdges[key]; !ok {
+			diff.EdgesRemoved = append(diff.EdgesRemoved, EdgeDiff{SourceID: key.SourceID, TargetID: key.TargetID, Relation: key.Relation, FromWeight: edge.Weight})
+		}
+	}
+
+	fromConcepts, toConcepts := conceptNameSet(from), conceptNameSet(to)
+	for name := range toConcepts {
+		if !fromConcepts[name] {
+			diff.ConceptsAdded = append(diff.ConceptsAdded, name)
+		}
+	}
+	for name := range fromConcepts {
+		if !toConcepts[name] {
+			diff.ConceptsGone = append(diff.ConceptsGone, name)
+		}
+	}
+
+	fromRelations, toRelations := conceptRelationSet(from), conceptRelationSet(to)
+	for relation := range toRelations {
+		if !fromRelations[relation] {
+			diff.RelationsAdded = append(diff.RelationsAdded, relation)
+		}
+	}
+	for relation := range fromRelations {
+		if !toRelations[relation] {
+			diff.RelationsGone = append(diff.RelationsGone, relation)
+		}
+	}
+
+	fromSettings, toSettings := settingRecords(from.Settings), settingRecords(to.Settings)
+	for i, record := range toSettings {
+		if record != fromSettings[i] {
+			key, _, _ := strings.Cut(record, "=")
+			diff.SettingsChanged = append(diff.SettingsChanged, key)
+		}
+	}
+
+	for id, target := range to.Redirects {
+		if before, ok := from.Redirects[id]; !ok || before != target {
+			diff.RedirectsChanged = append(diff.RedirectsChanged, id)
+		}
+	}
+	for id := range from.Redirects {
+		if _, ok := to.Redirects[id]; !ok {
+			diff.RedirectsChanged = append(diff.RedirectsChanged, id)
+		}
+	}
+
+	diff.sort()
+	diff.Summary = map[string]int{
+		"nodesAdded":              len(diff.NodesAdded),
+		"nodesRemoved":            len(diff.NodesRemoved),
+		"nodesChanged":            len(diff.NodesChanged),
+		"edgesAdded":              len(diff.EdgesAdded),
+		"edgesRemoved":            len(diff.EdgesRemoved),
+		"edgesReweighted":         len(diff.EdgesReweight),
+		"edgesChanged":            len(diff.EdgesChanged),
+		"conceptsAdded":           len(diff.ConceptsAdded),
+		"conceptsRemoved":         len(diff.ConceptsGone),
+		"conceptRelationsAdded":   len(diff.RelationsAdded),
+		"conceptRelationsRemoved": len(diff.RelationsGone),
+		"settingsChanged":         len(diff.SettingsChanged),
+		"redirectsChanged":        len(diff.RedirectsChanged),
+	}
+	return diff
+}
+
+// sort orders every list in a diff so the output is stable
+func (diff *GraphDiff) sort() {
+	for _, nodes := range [][]NodeDiff{diff.NodesAdded, diff.NodesRemoved, diff.NodesChanged} {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	}
+	for _, edges := range [][]EdgeDiff{diff.EdgesAdded, diff.EdgesRemoved, diff.EdgesReweight, diff.EdgesChanged} {
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].SourceID != edges[j].SourceID {
+				return edges[i].SourceID < edges[j].SourceID
+			}
+			if edges[i].TargetID != edges[j].TargetID {
+				return edges[i].TargetID < edges[j].TargetID
+			}
+			return edges[i].Relation < edges[j].Relation
+		})
+	}
+	sort.Strings(diff.ConceptsAdded)
+	sort.Strings(diff.ConceptsGone)
+	for _, relations := range [][]ConceptRelationDiff{diff.RelationsAdded, diff.RelationsGone} {
+		sort.Slice(relations, func(i, j int) bool {
+			a, b := relations[i], relations[j]
+			if a.Source != b.Source {
+				return a.Source < b.Source
+			}
+			if a.Target != b.Target {
+				return a.Target < b.Target
+			}
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			return a.Origin < b.Origin
+		})
+	}
+	slices.Sort(diff.RedirectsChanged)
+}
+
+// Empty reports whether the two versions are the same
+func (diff *GraphDiff) Empty() bool {
+	for _, count := range diff.Summary {
+		if count > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// conceptNameSet returns the names of a graph's concepts
+func conceptNameSet(graph *KnowledgeGraph) map[string]bool {
+	names := make(map[string]bool, len(graph.Concepts))
+	for _, concept := range graph.Concepts {
+		names[concept.Name] = true
+	}
+	return names
+}
+
+// conceptRelationSet returns a graph's concept relations by the names of their concepts
+func conceptRelationSet(graph *KnowledgeGraph) map[ConceptRelationDiff]bool {
+	relations := make(map[ConceptRelationDiff]bool, len(graph.ConceptRelations))
+	for _, relation := range graph.ConceptRelations {
+		relations[ConceptRelationDiff{
+			Source: graph.Concepts[relation.SourceID].Name,
+			Kind:   relation.Kind,
+			Target: graph.Concepts[relation.TargetID].Name,
+			Origin: relation.Origin,
+		}] = true
+	}
+	return relations
+}
+
+// settingRecords returns the "Key=value" records of a graph's settings, in a fixed order
+func settingRecords(settings GraphSettings) []string {
+	var records strings.Builder
+	writeSettings(&records, settings)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(records.String()), "\n") {
+		lines = append(lines, strings.TrimPrefix(line, "Setting "))
+	}
+	return lines
+}
+
+// sortedCopy returns a sorted copy of a list of strings
+func sortedCopy(values []string) []string {
+	sorted := slices.Clone(values)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// printGraphDiff writes a diff for people to read
+func printGraphDiff(w io.Writer, diff *GraphDiff) {
+	fmt.Fprintf(w, "Diff %s..%s: %d notes added, %d removed, %d changed; %d edges added, %d removed, %d reweighted, %d changed; %d concepts added, %d removed\n",
+		diff.From, diff.To, len(diff.NodesAdded), len(diff.NodesRemoved), len(diff.NodesChanged),
+		len(diff.EdgesAdded), len(diff.EdgesRemoved), len(diff.EdgesReweight), len(diff.EdgesChanged), len(diff.ConceptsAdded), len(diff.ConceptsGone))
+	for _, node := range diff.NodesAdded {
+		fmt.Fprintf(w, "+ Node %d: %s [%s]\n", node.ID, firstLine(node.Text), strings.Join(node.Concepts, ", "))
+	}
+	for _, node := range diff.NodesRemoved {
+		fmt.Fprintf(w, "- Node %d: %s [%s]\n", node.ID, firstLine(node.Text), strings.Join(node.Concepts, ", "))
+	}
+	for _, node := range diff.NodesChanged {
+		fmt.Fprintf(w, "~ Node %d: %s (%s changed) [%s]\n", node.ID, firstLine(node.Text), strings.Join(node.Changed, ", "), strings.Join(node.Concepts, ", "))
+	}
+	for _, edge := range diff.EdgesAdded {
+		fmt.Fprintf(w, "+ Edge %d-%d %s: %.4f\n", edge.SourceID, edge.TargetID, edge.Relation, edge.ToWeight)
+	}
+	for _, edge := range diff.EdgesRemoved {
+		fmt.Fprintf(w, "- Edge %d-%d %s: %.4f\n", edge.SourceID, edge.TargetID, edge.Relation, edge.FromWeight)
+	}
+	for _, edge := range diff.EdgesReweight {
+		fmt.Fprintf(w, "~ Edge %d-%d %s: %.4f -> %.4f\n", edge.SourceID, edge.TargetID, edge.Relation, edge.FromWeight, edge.ToWeight)
+	}
+	for _, edge := range diff.EdgesChanged {
+		fmt.Fprintf(w, "~ Edge %d-%d %s (%s changed)\n", edge.SourceID, edge.TargetID, edge.Relation, strings.Join(edge.Changed, ", "))
+	}
+	for _, name := range diff.ConceptsAdded {
+		fmt.Fprintf(w, "+ Concept %s\n", name)
+	}
+	for _, name := range diff.ConceptsGone {
+		fmt.Fprintf(w, "- Concept %s\n", name)
+	}
+	for _, relation := range diff.RelationsAdded {
+		fmt.Fprintf(w, "+ Relation %s %s %s (%s)\n", relation.Source, relation.Kind, relation.Target, relation.Origin)
+	}
+	for _, relation := range diff.RelationsGone {
+		fmt.Fprintf(w, "- Relation %s %s %s (%s)\n", relation.Source, relation.Kind, relation.Target, relation.Origin)
+	}
+	for _, key := range diff.SettingsChanged {
+		fmt.Fprintf(w, "~ Setting %s\n", key)
+	}
+	for _, id := range diff.RedirectsChanged {
+		fmt.Fprintf(w, "~ Redirect of note %d\n", id)
+	}
+}
+
+// firstLine returns the first line of a note's text
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
+
+// runSnapshotCommand tags, lists, diffs and rolls back to named snapshots of the graph
+func runSnapshotCommand(env *CommandEnv, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: snapshot tag|list|delete|diff|rollback ...")
+	}
+	tags, err := LoadSnapshotTags(env.GraphFilePath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "tag":
+		if len(args) != 2 {
+			return errors.New("usage: snapshot tag <name>")
+		}
+		name := args[1]
+		if !snapshotNamePattern.MatchString(name) || name == snapshotCurrent {
+			return fmt.Errorf("invalid snapshot name %q", name)
+		}
+		if findSnapshotTag(tags, name) >= 0 {
+			return fmt.Errorf("snapshot %q already exists", name)
+		}
+		// Saving first makes sure the log holds the state being tagged
+		if err := env.Save(); err != nil {
+			return err
+		}
+		tag := SnapshotTag{Name: name, Seq: env.Graph.journal.LastSeq(), CreatedAt: time.Now().UTC()}
+		if err := SaveSnapshotTags(env.GraphFilePath, append(tags, tag)); err != nil {
+			return err
+		}
+		fmt.Printf("Tagged snapshot %s at log entry %d\n", name, tag.Seq)
+		return nil
+
+	case "list":
+		for _, tag := range tags {
+			fmt.Printf("Snapshot %s: entry %d, tagged %s\n", tag.Name, tag.Seq, tag.CreatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		return nil
+
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("usage: snapshot delete <name>")
+		}
+		index := findSnapshotTag(tags, args[1])
+		if index < 0 {
+			return fmt.Errorf("snapshot %q not found", args[1])
+		}
+		return SaveSnapshotTags(env.GraphFilePath, slices.Delete(tags, index, index+1))
+
+	case "diff":
+		flags := flag.NewFlagSet("snapshot diff", flag.ContinueOnError)
+		asJSON := flags.Bool("json", false, "print the diff as JSON")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() < 1 || flags.NArg() > 2 {
+			return errors.New("usage: snapshot diff [-json] <from> [to]")
+		}
+		fromName, toName := flags.Arg(0), snapshotCurrent
+		if flags.NArg() == 2 {
+			toName = flags.Arg(1)
+		}
+		from, err := loadSnapshot(env, tags, fromName)
+		if err != nil {
+			return err
+		}
+		to, err := loadSnapshot(env, tags, toName)
+		if err != nil {
+			return err
+		}
+		diff := DiffGraphs(from, to)
+		diff.From, diff.To = fromName, toName
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(diff)
+		}
+		printGraphDiff(os.Stdout, diff)
+		return nil
+
+	case "rollback":
+		if len(args) != 2 {
+			return errors.New("usage: snapshot rollback <name>")
+		}
+		target, err := loadSnapshot(env, tags, args[1])
+		if err != nil {
+			return err
+		}
+		diff := DiffGraphs(env.Graph, target)
+		if diff.Empty() {
+			fmt.Printf("The graph already matches snapshot %s\n", args[1])
+			return nil
+		}
+
+		// The rollback is saved as an ordinary log entry, so it can itself be undone
+		target.Tenant = env.Graph.Tenant
+		target.Embedder = env.Graph.Embedder
+		target.journal = env.Graph.journal
+		// IDs handed out after the snapshot are not reused, so diffs never confuse old and new elements
+		target.ids = env.Graph.ids
+		advanceIDCounters(target)
+		target.touchAll()
+		env.Graph = target
+		if err := env.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back to snapshot %s\n", args[1])
+		diff.From, diff.To = snapshotCurrent, args[1]
+		printGraphDiff(os.Stdout, diff)
+		return nil
+	}
+
+	return fmt.Errorf("unknown snapshot subcommand %q", args[0])
+}
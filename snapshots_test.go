@@ -0,0 +1,187 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// loadEnv loads the graph at path into a command environment
+func loadEnv(t *testing.T, path string) *CommandEnv {
+	t.Helper()
+	graph, err := LoadGraph(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &CommandEnv{Graph: graph, GraphFilePath: path}
+}
+
+func TestNodeIDsAreNotReusedAfterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	for _, text := range []string{"Kept", "Deleted"} {
+		if _, err := AddNote(graph, text, []string{"ids"}, NodeMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := graph.RemoveNode(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	env := loadEnv(t, path)
+	node, err := AddNote(env.Graph, "Added after reloading", []string{"ids"}, NodeMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.ID != 3 {
+		t.Errorf("new note got ID %d, want 3 since 2 belonged to the deleted note", node.ID)
+	}
+}
+
+func TestSnapshotRollbackKeepsIDsApartAcrossReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.txt")
+	graph := NewKnowledgeGraph()
+	if _, err := AddNote(graph, "Base note", []string{"base"}, NodeMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveGraph(path, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	env := loadEnv(t, path)
+	if err := runSnapshotCommand(env, []string{"tag", "base"}); err != nil {
+		t.Fatal(err)
+	}
+	temporary, err := AddNote(env.Graph, "Temporary note", []string{"draft"}, NodeMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runSnapshotCommand(env, []string{"tag", "draft"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSnapshotCommand(env, []string{"rollback", "base"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := env.Graph.Nodes[temporary.ID]; ok {
+		t.Fatal("rollback kept the note added after the snapshot")
+	}
+
+	// A later session must not hand the rolled-back note's ID to a new note
+	env = loadEnv(t, path)
+	added, err := AddNote(env.Graph, "Unrelated note", []string{"other"}, NodeMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.ID == temporary.ID {
+		t.Fatalf("new note reused ID %d of the rolled-back note", added.ID)
+	}
+	if err := env.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := LoadSnapshotTags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft, err := loadSnapshot(env, tags, "draft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := DiffGraphs(draft, env.Graph)
+	if len(diff.NodesChanged) != 0 {
+		t.Errorf("diff reports %+v as changed; the draft note was removed and another added", diff.NodesChanged)
+	}
+	if len(diff.NodesRemoved) != 1 || diff.NodesRemoved[0].ID != temporary.ID {
+		t.Errorf("diff removes %+v, want note %d", diff.NodesRemoved, temporary.ID)
+	}
+	if len(diff.NodesAdded) != 1 || diff.NodesAdded[0].ID != added.ID {
+		t.Errorf("diff adds %+v, want note %d", diff.NodesAdded, added.ID)
+	}
+}
+
+func TestSnapshotRollbackUndoesChangesOutsideNotesAndEdges(t *testing.T) {
+	later := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	changes := []struct {
+		name   string
+		change func(graph *KnowledgeGraph)
+		field  func(diff *GraphDiff) int
+	}{
+		{"settings", func(graph *KnowledgeGraph) {
+			graph.Settings.MinWeight = 0.0005
+		}, func(diff *GraphDiff) int { return len(diff.SettingsChanged) }},
+		{"redirects", func(graph *KnowledgeGraph) {
+			graph.Redirects[42] = 1
+			graph.touch("Redirect", 42)
+		}, func(diff *GraphDiff) int { return len(diff.RedirectsChanged) }},
+		{"concept relations", func(graph *KnowledgeGraph) {
+			if _, err := graph.AddConceptRelation("raft", "consensus", RelationBroader, OriginManual); err != nil {
+				t.Fatal(err)
+			}
+		}, func(diff *GraphDiff) int { return len(diff.RelationsAdded) }},
+		{"note timestamps", func(graph *KnowledgeGraph) {
+			graph.Nodes[1].UpdatedAt = later
+			graph.touch("Node", 1)
+		}, func(diff *GraphDiff) int { return len(diff.NodesChanged) }},
+		{"edge reinforcement", func(graph *KnowledgeGraph) {
+			edge := graph.FindEdge(1, 2, RelationSimilar, false)
+			edge.ReinforcedAt = later
+			graph.touch("Edge", edge.ID)
+		}, func(diff *GraphDiff) int { return len(diff.EdgesChanged) }},
+	}
+
+	for _, test := range changes {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "graph.txt")
+			graph := NewKnowledgeGraph()
+			for _, text := range []string{"Raft elects a leader", "Raft replicates a log"} {
+				if _, err := AddNote(graph, text, []string{"raft"}, NodeMetadata{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := SaveGraph(path, graph); err != nil {
+				t.Fatal(err)
+			}
+
+			env := loadEnv(t, path)
+			if err := runSnapshotCommand(env, []string{"tag", "base"}); err != nil {
+				t.Fatal(err)
+			}
+			test.change(env.Graph)
+			if err := env.Save(); err != nil {
+				t.Fatal(err)
+			}
+			tags, err := LoadSnapshotTags(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			base, err := loadSnapshot(env, tags, "base")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := DiffGraphs(base, env.Graph); diff.Empty() || test.field(diff) != 1 {
+				t.Fatalf("diff %+v does not report the change", diff)
+			}
+
+			if err := runSnapshotCommand(env, []string{"rollback", "base"}); err != nil {
+				t.Fatal(err)
+			}
+			reloaded := loadEnv(t, path)
+			if diff := DiffGraphs(base, reloaded.Graph); !diff.Empty() {
+				t.Errorf("after rolling back the graph still differs: %+v", diff.Summary)
+			}
+		})
+	}
+}
+
+func TestDiffNamesChangedSettings(t *testing.T) {
+	from, to := NewKnowledgeGraph(), NewKnowledgeGraph()
+	to.Settings.TopK = 5
+	to.Settings.WeightStrategy = WeightOverlap
+	if got := DiffGraphs(from, to).SettingsChanged; !slices.Equal(got, []string{"WeightStrategy", "TopK"}) {
+		t.Errorf("changed settings %q, want WeightStrategy and TopK", got)
+	}
+}
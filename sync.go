@@ -0,0 +1,897 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sync operation kinds: adds and removes act on observed-remove sets, sets write last-writer-wins registers
+const (
+	syncAdd    = "add"
+	syncRemove = "remove"
+	syncSet    = "set"
+)
+
+// maxSyncBody caps a pushed delta, which may carry a whole graph on the first sync
+const maxSyncBody = 64 << 20
+
+// SyncDot identifies an operation by the replica that made it and its sequence number there
+type SyncDot struct {
+	Replica string `json:"replica"`
+	Seq     uint64 `json:"seq"`
+}
+
+// SyncOp is one change to the replicated graph
+// Keys name the element or register changed, e.g. "node|<uid>", "tag|<uid>|<tag>" or "text|<uid>";
+// a remove lists the adds of the element it observed, so a concurrent add survives it
+type SyncOp struct {
+	SyncDot
+	Clock   uint64    `json:"clock"`
+	Kind    string    `json:"kind"`
+	Key     string    `json:"key"`
+	Value   string    `json:"value,omitempty"`
+	Removes []SyncDot `json:"removes,omitempty"`
+}
+
+// newer orders operations by Lamport clock, breaking ties by replica and sequence number
+func (op SyncOp) newer(other SyncOp) bool {
+	if op.Clock != other.Clock {
+		return op.Clock > other.Clock
+	}
+	if op.Replica != other.Replica {
+		return op.Replica > other.Replica
+	}
+	return op.Seq > other.Seq
+}
+
+// SyncDelta carries the operations one replica has and another lacks, with the sender's version vector
+type SyncDelta struct {
+	Replica string            `json:"replica"`
+	Version map[string]uint64 `json:"version"`
+	Ops     []SyncOp          `json:"ops"`
+}
+
+// syncView is the replicated state of a graph flattened into set elements and registers, both keyed like SyncOp
+type syncView struct {
+	Elements  map[string]string `json:"elements"`
+	Registers map[string]string `json:"registers"`
+}
+
+// syncMeta is the replicated part of a note's metadata; tags are replicated as a set of their own
+type syncMeta struct {
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Source      string    `json:"source,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Attachments []string  `json:"attachments,omitempty"`
+	SourcePath  string    `json:"sourcePath,omitempty"`
+	ContentHash string    `json:"contentHash,omitempty"`
+}
+
+// SyncState is a graph's replica: every operation it knows, the UIDs its notes have on all replicas and
+// the view of the graph it last synced, against which local changes are found
+type SyncState struct {
+	Replica string                       `json:"replica"`
+	Clock   uint64                       `json:"clock"`
+	Ops     []SyncOp                     `json:"ops"`
+	UIDs    map[string]int64             `json:"uids"`
+	View    syncView                     `json:"view"`
+	Peers   map[string]map[string]uint64 `json:"peers"`
+
+	path    string
+	seen    map[SyncDot]bool
+	version map[string]uint64
+}
+
+// syncKey joins the parts of an element or register key
+func syncKey(parts ...string) string {
+	return strings.Join(parts, "|")
+}
+
+// graphSyncPath returns the file holding a graph file's replica
+func graphSyncPath(graphFilePath string) string {
+	return graphFilePath + ".sync"
+}
+
+// newReplicaID returns a random replica ID
+func newReplicaID() (string, error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate replica ID: %v", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// LoadSyncState reads the replica of a graph file, starting a new one if the graph was never synced
+func LoadSyncState(graphFilePath string) (*SyncState, error) {
+	state := &SyncState{path: graphSyncPath(graphFilePath)}
+	data, err := os.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		if state.Replica, err = newReplicaID(); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %v", err)
+	} else if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state: %v", err)
+	}
+
+	if state.UIDs == nil {
+		state.UIDs = make(map[string]int64)
+	}
+	if state.View.Elements == nil {
+		state.View = syncView{Elements: make(map[string]string), Registers: make(map[string]string)}
+	}
+	if state.Peers == nil {
+		state.Peers = make(map[string]map[string]uint64)
+	}
+	state.seen = make(map[SyncDot]bool)
+	for _, op := range state.Ops {
+		state.seen[op.SyncDot] = true
+	}
+	state.updateVersion()
+	return state, nil
+}
+
+// Save writes the replica beside its graph file
+func (state *SyncState) Save() error {
+	sort.Slice(state.Ops, func(i, j int) bool {
+		if state.Ops[i].Replica != state.Ops[j].Replica {
+			return state.Ops[i].Replica < state.Ops[j].Replica
+		}
+		return state.Ops[i].Seq < state.Ops[j].Seq
+	})
+	return writeFileAtomic(state.path, 0o644, func(w io.Writer) error {
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			return fmt.Errorf("failed to write sync state: %v", err)
+		}
+		return nil
+	})
+}
+
+// updateVersion recomputes the version vector: for each replica, the last sequence number up to which
+// every operation is known, so deltas received out of order never hide a gap
+func (state *SyncState) updateVersion() {
+	state.version = make(map[string]uint64)
+	for dot := range state.seen {
+		if _, ok := state.version[dot.Replica]; ok {
+			continue
+		}
+		seq := uint64(0)
+		for state.seen[SyncDot{Replica: dot.Replica, Seq: seq + 1}] {
+			seq++
+		}
+		state.version[dot.Replica] = seq
+	}
+}
+
+// Version returns a copy of the replica's version vector
+func (state *SyncState) Version() map[string]uint64 {
+	version := make(map[string]uint64, len(state.version))
+	for replica, seq := range state.version {
+		version[replica] = seq
+	}
+	return version
+}
+
+// newOp records an operation made on this replica
+func (state *SyncState) newOp(kind, key, value string, removes []SyncDot) {
+	state.Clock++
+	op := SyncOp{
+		SyncDot: SyncDot{Replica: state.Replica, Seq: state.version[state.Replica] + 1},
+		Clock:   state.Clock,
+		Kind:    kind,
+		Key:     key,
+		Value:   value,
+		Removes: removes,
+	}
+	state.Ops = append(state.Ops, op)
+	state.seen[op.SyncDot] = true
+	state.version[state.Replica] = op.Seq
+}
+
+// Merge adds the operations of a delta this replica does not have yet and returns how many it added
+func (state *SyncState) Merge(delta *SyncDelta) int {
+	added := 0
+	for _, op := range delta.Ops {
+		if state.seen[op.SyncDot] || op.Replica == "" || op.Seq == 0 {
+			continue
+		}
+		state.Ops = append(state.Ops, op)
+		state.seen[op.SyncDot] = true
+		state.Clock = max(state.Clock, op.Clock)
+		added++
+	}
+	state.updateVersion()
+	if delta.Replica != "" {
+		state.Peers[delta.Replica] = delta.Version
+	}
+	return added
+}
+
+// Delta returns the operations a replica with the given version vector lacks
+func (state *SyncState) Delta(version map[string]uint64) *SyncDelta {
+	delta := &SyncDelta{Replica: state.Replica, Version: state.Version(), Ops: []SyncOp{}}
+	for _, op := range state.Ops {
+		if op.Seq > version[op.Replica] {
+			delta.Ops = append(delta.Ops, op)
+		}
+	}
+	sort.Slice(delta.Ops, func(i, j int) bool {
+		if delta.Ops[i].Replica != delta.Ops[j].Replica {
+			return delta.Ops[i].Replica < delta.Ops[j].Replica
+		}
+		return delta.Ops[i].Seq < delta.Ops[j].Seq
+	})
+	return delta
+}
+
+// syncIndex groups a replica's operations by key
+type syncIndex struct {
+	adds      map[string][]SyncOp
+	removed   map[SyncDot]bool
+	registers map[string]SyncOp
+}
+
+// index groups the replica's operations by the element or register they change
+func (state *SyncState) index() *syncIndex {
+	index := &syncIndex{adds: make(map[string][]SyncOp), removed: make(map[SyncDot]bool), registers: make(map[string]SyncOp)}
+	for _, op := range state.Ops {
+		switch op.Kind {
+		case syncAdd:
+			index.adds[op.Key] = append(index.adds[op.Key], op)
+		case syncRemove:
+			for _, dot := range op.Removes {
+				index.removed[dot] = true
+			}
+		case syncSet:
+			if current, ok := index.registers[op.Key]; !ok || op.newer(current) {
+				index.registers[op.Key] = op
+			}
+		}
+	}
+	return index
+}
+
+// liveAdds returns the adds of an element no remove has observed
+func (index *syncIndex) liveAdds(key string) []SyncOp {
+	var live []SyncOp
+	for _, op := range index.adds[key] {
+		if !index.removed[op.SyncDot] {
+			live = append(live, op)
+		}
+	}
+	return live
+}
+
+// materialize returns the state every replica holding the same operations agrees on:
+// an element is present while one of its adds is unremoved, and takes the value of its newest such add
+func (state *SyncState) materialize() syncView {
+	index := state.index()
+	view := syncView{Elements: make(map[string]string), Registers: make(map[string]string)}
+	for key := range index.adds {
+		live := index.liveAdds(key)
+		if len(live) == 0 {
+			continue
+		}
+		winner := live[0]
+		for _, op := range live[1:] {
+			if op.newer(winner) {
+				winner = op
+			}
+		}
+		view.Elements[key] = winner.Value
+	}
+	for key, op := range index.registers {
+		view.Registers[key] = op.Value
+	}
+	return view
+}
+
+// Digest returns a hash of the materialized state, equal on replicas that have converged
+func (state *SyncState) Digest() string {
+	data, _ := json.Marshal(state.materialize())
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// viewOf flattens a graph into the replicated view, giving notes that have none a UID
+// UIDs are stamped with the replica's Lamport clock, so no two notes ever share one even if a local ID comes back;
+// similarity edges and embeddings are derived data and are recomputed on each replica instead
+func (state *SyncState) viewOf(graph *KnowledgeGraph) syncView {
+	view := syncView{Elements: make(map[string]string), Registers: make(map[string]string)}
+	uids := make(map[int64]string, len(state.UIDs))
+	for uid, id := range state.UIDs {
+		uids[id] = uid
+	}
+
+	for _, id := range graph.sortedNodeIDs() {
+		node := graph.Nodes[id]
+		uid, ok := uids[id]
+		if !ok {
+			state.Clock++
+			uid = fmt.Sprintf("%s-%d", state.Replica, state.Clock)
+			state.UIDs[uid] = id
+			uids[id] = uid
+		}
+		view.Elements[syncKey("node", uid)] = ""
+		view.Registers[syncKey("text", uid)] = node.Text
+		meta, _ := json.Marshal(syncMeta{
+			CreatedAt:   node.CreatedAt,
+			UpdatedAt:   node.UpdatedAt,
+			Source:      node.Source,
+			Author:      node.Author,
+			Attachments: node.Attachments,
+			SourcePath:  node.SourcePath,
+			ContentHash: node.ContentHash,
+		})
+		view.Registers[syncKey("meta", uid)] = string(meta)
+		for _, tag := range node.Tags {
+			view.Elements[syncKey("tag", uid, tag)] = ""
+		}
+		var order []string
+		for _, name := range graph.NodeConcepts(id) {
+			view.Elements[syncKey("note-concept", uid, normalizeConcept(name))] = name
+			order = append(order, normalizeConcept(name))
+		}
+		view.Registers[syncKey("concepts", uid)] = strings.Join(order, "\n")
+	}
+
+	for _, concept := range graph.Concepts {
+		view.Elements[syncKey("concept", normalizeConcept(concept.Name))] = concept.Name
+	}
+	for _, edge := range graph.Edges {
+		if key, ok := syncEdgeKey(edge, uids); ok {
+			view.Elements[syncKey("edge", key)] = ""
+			view.Registers[syncKey("weight", key)] = strconv.FormatFloat(edge.Weight, 'g', -1, 64)
+		}
+	}
+	for _, relation := range graph.ConceptRelations {
+		source, target := graph.Concepts[relation.SourceID], graph.Concepts[relation.TargetID]
+		if source == nil || target == nil {
+			continue
+		}
+		view.Elements[syncKey("relation", relation.Kind, normalizeConcept(source.Name), normalizeConcept(target.Name))] = relation.Origin
+	}
+	return view
+}
+
+// syncEdgeKey keys a typed edge by the UIDs of its endpoints; undirected edges put the smaller UID first
+func syncEdgeKey(edge *Edge, uids map[int64]string) (string, bool) {
+	if edge.IsSimilarity() {
+		return "", false
+	}
+	source, sourceOK := uids[edge.SourceID]
+	target, targetOK := uids[edge.TargetID]
+	if !sourceOK || !targetOK {
+		return "", false
+	}
+	if !edge.Directed && target < source {
+		source, target = target, source
+	}
+	return syncKey(source, target, strconv.FormatBool(edge.Directed), edge.Relation), true
+}
+
+// Record turns the changes made to the graph since it was last synced into operations and returns their number
+func (state *SyncState) Record(graph *KnowledgeGraph) int {
+	// Notes deleted here give up their UIDs; the removes below retire their elements on every replica
+	for uid, id := range state.UIDs {
+		if _, ok := graph.Nodes[id]; !ok {
+			delete(state.UIDs, uid)
+		}
+	}
+	current := state.viewOf(graph)
+	index := state.index()
+	before := len(state.Ops)
+
+	for _, key := range sortedKeys(current.Elements) {
+		if value, ok := state.View.Elements[key]; !ok || value != current.Elements[key] {
+			state.newOp(syncAdd, key, current.Elements[key], nil)
+		}
+	}
+	for _, key := range sortedKeys(state.View.Elements) {
+		if _, ok := current.Elements[key]; ok {
+			continue
+		}
+		var observed []SyncDot
+		for _, op := range index.liveAdds(key) {
+			observed = append(observed, op.SyncDot)
+		}
+		if len(observed) > 0 {
+			state.newOp(syncRemove, key, "", observed)
+		}
+	}
+	for _, key := range sortedKeys(current.Registers) {
+		if value, ok := state.View.Registers[key]; !ok || value != current.Registers[key] {
+			state.newOp(syncSet, key, current.Registers[key], nil)
+		}
+	}
+
+	state.View = current
+	return len(state.Ops) - before
+}
+
+// SyncSummary counts the changes applying a replica's state made to its graph
+type SyncSummary struct {
+	NodesAdded   int
+	NodesUpdated int
+	NodesRemoved int
+}
+
+// syncNote is a note of the materialized state
+type syncNote struct {
+	text     string
+	meta     syncMeta
+	tags     []string
+	concepts []string
+}
+
+// Apply makes the graph match the replica's materialized state
+// Changes are applied in key order, so replicas holding the same operations end up with the same graph;
+// a change the graph refuses, like a concept relation closing a cycle, is left out on every replica alike
+func (state *SyncState) Apply(graph *KnowledgeGraph) SyncSummary {
+	var summary SyncSummary
+	target := state.materialize()
+	notes := make(map[string]*syncNote)
+	for key := range target.Elements {
+		if uid, ok := strings.CutPrefix(key, "node|"); ok {
+			note := &syncNote{text: target.Registers[syncKey("text", uid)]}
+			json.Unmarshal([]byte(target.Registers[syncKey("meta", uid)]), &note.meta)
+			notes[uid] = note
+		}
+	}
+	noteConcepts := make(map[string]map[string]string)
+	for _, key := range sortedKeys(target.Elements) {
+		kind, rest, _ := strings.Cut(key, "|")
+		uid, item, _ := strings.Cut(rest, "|")
+		note := notes[uid]
+		switch {
+		case kind == "tag" && note != nil:
+			note.tags = append(note.tags, item)
+		case kind == "note-concept" && note != nil:
+			if noteConcepts[uid] == nil {
+				noteConcepts[uid] = make(map[string]string)
+			}
+			noteConcepts[uid][item] = target.Elements[key]
+		}
+	}
+
+	// A note's concepts keep the order of its newest concept list, with concepts added concurrently last
+	for uid, names := range noteConcepts {
+		order := strings.Split(target.Registers[syncKey("concepts", uid)], "\n")
+		keys := sortedKeys(names)
+		sort.SliceStable(keys, func(i, j int) bool {
+			return orderIndex(order, keys[i]) < orderIndex(order, keys[j])
+		})
+		for _, key := range keys {
+			notes[uid].concepts = append(notes[uid].concepts, names[key])
+		}
+	}
+
+	// Concepts exist before the notes that use them, so names keep the replicated spelling
+	for _, key := range sortedKeys(target.Elements) {
+		if _, ok := strings.CutPrefix(key, "concept|"); ok {
+			if concept := graph.ensureConcept(target.Elements[key]); concept.Name != target.Elements[key] {
+				concept.Name = target.Elements[key]
+				graph.touch("Concept", concept.ID)
+			}
+		}
+	}
+
+	touched := make(map[int64]bool)
+	for _, uid := range sortedKeys(notes) {
+		note := notes[uid]
+		node, ok := graph.Nodes[state.UIDs[uid]]
+		if !ok {
+			node = &Node{ID: graph.generateNodeID(), Text: note.text}
+			note.meta.applyTo(node)
+			node.Tags = note.tags
+			graph.embedNode(node)
+			graph.Nodes[node.ID] = node
+			graph.SetNodeConcepts(node.ID, note.concepts)
+			state.UIDs[uid] = node.ID
+			touched[node.ID] = true
+			summary.NodesAdded++
+			graph.publishNode(EventNodeAdded, node)
+			continue
+		}
+
+		changed := false
+		if node.Text != note.text {
+			node.Text = note.text
+			node.Embedding = nil
+			graph.embedNode(node)
+			touched[node.ID] = true
+			changed = true
+		}
+		if !slices.Equal(normalizedConcepts(graph.NodeConcepts(node.ID)), normalizedConcepts(note.concepts)) {
+			graph.SetNodeConcepts(node.ID, note.concepts)
+			touched[node.ID] = true
+			changed = true
+		}
+		if !slices.Equal(sortedStrings(node.Tags), note.tags) {
+			node.Tags = note.tags
+			changed = true
+		}
+		current := syncMeta{CreatedAt: node.CreatedAt, UpdatedAt: node.UpdatedAt, Source: node.Source, Author: node.Author,
+			Attachments: node.Attachments, SourcePath: node.SourcePath, ContentHash: node.ContentHash}
+		if !current.equal(note.meta) {
+			note.meta.applyTo(node)
+			changed = true
+		}
+		if changed {
+			summary.NodesUpdated++
+			graph.publishNode(EventNodeUpdated, node)
+		}
+	}
+	for _, uid := range sortedKeys(state.UIDs) {
+		if _, ok := notes[uid]; ok {
+			continue
+		}
+		if _, ok := graph.Nodes[state.UIDs[uid]]; ok {
+			graph.RemoveNode(state.UIDs[uid])
+			summary.NodesRemoved++
+		}
+		delete(state.UIDs, uid)
+	}
+
+	// Typed edges between notes present on this replica
+	uids := make(map[int64]string, len(state.UIDs))
+	for uid, id := range state.UIDs {
+		uids[id] = uid
+	}
+	for id, edge := range graph.Edges {
+		if key, ok := syncEdgeKey(edge, uids); ok {
+			if _, live := target.Elements[syncKey("edge", key)]; !live {
+				graph.RemoveEdge(id)
+			}
+		}
+	}
+	for _, key := range sortedKeys(target.Elements) {
+		rest, ok := strings.CutPrefix(key, "edge|")
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(rest, "|", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		sourceID, sourceOK := state.UIDs[parts[0]]
+		targetID, targetOK := state.UIDs[parts[1]]
+		weight, err := strconv.ParseFloat(target.Registers[syncKey("weight", rest)], 64)
+		if !sourceOK || !targetOK || err != nil {
+			continue
+		}
+		graph.PutEdge(sourceID, targetID, weight, parts[3], parts[2] == "true")
+	}
+
+	// Concept relations, then concepts removed on another replica that nothing uses any more
+	relationKeys := make(map[int64]string)
+	for id, relation := range graph.ConceptRelations {
+		source, target := graph.Concepts[relation.SourceID], graph.Concepts[relation.TargetID]
+		if source != nil && target != nil {
+			relationKeys[id] = syncKey("relation", relation.Kind, normalizeConcept(source.Name), normalizeConcept(target.Name))
+		}
+	}
+	for id, key := range relationKeys {
+		if _, live := target.Elements[key]; !live {
+			delete(graph.ConceptRelations, id)
+			graph.touch("ConceptRelation", id)
+		}
+	}
+	for _, key := range sortedKeys(target.Elements) {
+		rest, ok := strings.CutPrefix(key, "relation|")
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(rest, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		graph.AddConceptRelation(syncConceptName(target, parts[1]), syncConceptName(target, parts[2]), parts[0], target.Elements[key])
+	}
+	for _, key := range sortedKeys(state.View.Elements) {
+		name, ok := strings.CutPrefix(key, "concept|")
+		if _, live := target.Elements[key]; !ok || live {
+			continue
+		}
+		if concept := graph.ConceptByName(name); concept != nil {
+			graph.removeUnusedConcept(concept.ID)
+		}
+	}
+
+	for _, id := range sortedKeys(touched) {
+		if _, ok := graph.Nodes[id]; !ok {
+			continue
+		}
+		for edgeID, edge := range graph.Edges {
+			if edge.IsSimilarity() && (edge.SourceID == id || edge.TargetID == id) {
+				graph.RemoveEdge(edgeID)
+			}
+		}
+		graph.connectNode(id, func(int64) bool { return true })
+	}
+	graph.PruneEdges()
+
+	state.View = state.viewOf(graph)
+	return summary
+}
+
+// applyTo copies replicated metadata onto a note
+func (meta syncMeta) applyTo(node *Node) {
+	node.CreatedAt = meta.CreatedAt
+	node.UpdatedAt = meta.UpdatedAt
+	node.Source = meta.Source
+	node.Author = meta.Author
+	node.Attachments = meta.Attachments
+	node.SourcePath = meta.SourcePath
+	node.ContentHash = meta.ContentHash
+}
+
+// equal compares replicated metadata
+func (meta syncMeta) equal(other syncMeta) bool {
+	return meta.CreatedAt.Equal(other.CreatedAt) && meta.UpdatedAt.Equal(other.UpdatedAt) && meta.Source == other.Source &&
+		meta.Author == other.Author && slices.Equal(meta.Attachments, other.Attachments) &&
+		meta.SourcePath == other.SourcePath && meta.ContentHash == other.ContentHash
+}
+
+// orderIndex returns the position of a key in an ordering, placing unknown keys last
+func orderIndex(order []string, key string) int {
+	if i := slices.Index(order, key); i >= 0 {
+		return i
+	}
+	return len(order)
+}
+
+// normalizedConcepts normalizes a list of concept names, keeping their order
+func normalizedConcepts(names []string) []string {
+	normalized := make([]string, len(names))
+	for i, name := range names {
+		normalized[i] = normalizeConcept(name)
+	}
+	return normalized
+}
+
+// sortedStrings returns a sorted copy of a list
+func sortedStrings(values []string) []string {
+	sorted := slices.Clone(values)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// syncConceptName returns the replicated spelling of a normalized concept name
+func syncConceptName(view syncView, normalized string) string {
+	if name, ok := view.Elements[syncKey("concept", normalized)]; ok {
+		return name
+	}
+	return normalized
+}
+
+// removeUnusedConcept deletes a concept no note or relation refers to
+func (graph *KnowledgeGraph) removeUnusedConcept(conceptID int64) {
+	if len(graph.conceptMembers[conceptID]) > 0 {
+		return
+	}
+	for _, relation := range graph.ConceptRelations {
+		if relation.SourceID == conceptID || relation.TargetID == conceptID {
+			return
+		}
+	}
+	concept := graph.Concepts[conceptID]
+	delete(graph.conceptIndex, normalizeConcept(concept.Name))
+	delete(graph.conceptMembers, conceptID)
+	delete(graph.Concepts, conceptID)
+	graph.touch("Concept", conceptID)
+}
+
+// syncGraph merges a delta into a graph's replica after recording the graph's own changes, and applies the result
+func syncGraph(state *SyncState, graph *KnowledgeGraph, delta *SyncDelta) (int, SyncSummary) {
+	state.Record(graph)
+	received := state.Merge(delta)
+	return received, state.Apply(graph)
+}
+
+// readSyncDelta reads a delta written by sync export
+func readSyncDelta(path string) (*SyncDelta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read delta: %v", err)
+	}
+	var delta SyncDelta
+	if err := json.Unmarshal(data, &delta); err != nil {
+		return nil, fmt.Errorf("failed to parse delta %s: %v", path, err)
+	}
+	return &delta, nil
+}
+
+// exchangeSyncDelta sends a delta to a server's sync endpoint and returns the server's delta in reply
+func exchangeSyncDelta(serverURL, token, workspace string, delta *SyncDelta) (*SyncDelta, error) {
+	body, err := json.Marshal(delta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode delta: %v", err)
+	}
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(serverURL, "/")+"/api/sync", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sync request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	if workspace != "" {
+		request.Header.Set("X-Workspace", workspace)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %v", serverURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(response.Body).Decode(&failure)
+		return nil, fmt.Errorf("sync with %s failed: %s %s", serverURL, response.Status, failure.Error)
+	}
+	var reply SyncDelta
+	if err := json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("failed to parse sync reply: %v", err)
+	}
+	return &reply, nil
+}
+
+// handleSync merges a client's delta into the graph and replies with the operations the client lacks
+func (server *Server) handleSync(w http.ResponseWriter, r *http.Request, ctx *requestContext) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	var delta SyncDelta
+	if err := decodeJSONBody(w, r, maxSyncBody, &delta); err != nil {
+		writeError(w, bodyErrorStatus(err), fmt.Errorf("invalid delta: %v", err))
+		return
+	}
+
+	store := &TenantStore{Root: server.Root}
+	state, err := LoadSyncState(store.GraphPath(ctx.Tenant))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	syncGraph(state, ctx.Graph, &delta)
+	if err := server.save(ctx); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := state.Save(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, state.Delta(delta.Version))
+}
+
+// runSyncCommand replicates the graph between devices by exchanging deltas as files or with a server
+func runSyncCommand(env *CommandEnv, args []string) error {
+	usage := errors.New("usage: sync status | export [-for replica] [-out path] | import <delta-file>... | remote [-token t] [-workspace w] <url>")
+	if len(args) == 0 {
+		return usage
+	}
+	state, err := LoadSyncState(env.GraphFilePath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		if state.Record(env.Graph) > 0 {
+			if err := state.Save(); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Replica %s: %d operations, state %s\n", state.Replica, len(state.Ops), state.Digest())
+		for _, replica := range sortedKeys(state.version) {
+			fmt.Printf("  %s: %d\n", replica, state.version[replica])
+		}
+		for _, peer := range sortedKeys(state.Peers) {
+			behind := len(state.Delta(state.Peers[peer]).Ops)
+			fmt.Printf("Peer %s lacked %d of these operations when last heard from\n", peer, behind)
+		}
+		return nil
+
+	case "export":
+		flags := flag.NewFlagSet("sync export", flag.ContinueOnError)
+		peer := flags.String("for", "", "replica whose known operations to leave out")
+		out := flags.String("out", "", "delta file to write (default sync-<replica>-<time>.json)")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *out == "" {
+			*out = fmt.Sprintf("sync-%s-%s.json", state.Replica, time.Now().Format("20060102-150405"))
+		}
+		state.Record(env.Graph)
+		if err := state.Save(); err != nil {
+			return err
+		}
+		delta := state.Delta(state.Peers[*peer])
+		err := writeFileAtomic(*out, 0o644, func(w io.Writer) error {
+			if err := json.NewEncoder(w).Encode(delta); err != nil {
+				return fmt.Errorf("failed to write delta: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d operations to %s\n", len(delta.Ops), *out)
+		return nil
+
+	case "import":
+		if len(args) < 2 {
+			return usage
+		}
+		received := 0
+		var summary SyncSummary
+		for _, path := range args[1:] {
+			delta, err := readSyncDelta(path)
+			if err != nil {
+				return err
+			}
+			n, applied := syncGraph(state, env.Graph, delta)
+			received += n
+			summary.NodesAdded += applied.NodesAdded
+			summary.NodesUpdated += applied.NodesUpdated
+			summary.NodesRemoved += applied.NodesRemoved
+		}
+		if err := env.Save(); err != nil {
+			return err
+		}
+		if err := state.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Received %d new operations: %d notes added, %d updated, %d removed; state %s\n",
+			received, summary.NodesAdded, summary.NodesUpdated, summary.NodesRemoved, state.Digest())
+		return nil
+
+	case "remote":
+		flags := flag.NewFlagSet("sync remote", flag.ContinueOnError)
+		token := flags.String("token", os.Getenv("KNOWLEDGE_GRAPH_TOKEN"), "API key with the write scope on the server")
+		workspace := flags.String("workspace", "", "workspace on the server to sync with")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 || *token == "" {
+			return errors.New("usage: sync remote -token t [-workspace w] <url>")
+		}
+		serverURL := flags.Arg(0)
+
+		state.Record(env.Graph)
+		reply, err := exchangeSyncDelta(serverURL, *token, *workspace, state.Delta(state.Peers[serverURL]))
+		if err != nil {
+			return err
+		}
+		received, summary := syncGraph(state, env.Graph, reply)
+		state.Peers[serverURL] = reply.Version
+		if err := env.Save(); err != nil {
+			return err
+		}
+		if err := state.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Synced with %s: received %d new operations, %d notes added, %d updated, %d removed; state %s\n",
+			serverURL, received, summary.NodesAdded, summary.NodesUpdated, summary.NodesRemoved, state.Digest())
+		return nil
+	}
+	return usage
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testReplica is a graph with its own replica, as on one device
+type testReplica struct {
+	graph *KnowledgeGraph
+	state *SyncState
+}
+
+func newTestReplica(t *testing.T) *testReplica {
+	t.Helper()
+	state, err := LoadSyncState(filepath.Join(t.TempDir(), "graph.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testReplica{graph: NewKnowledgeGraph(), state: state}
+}
+
+// delta records the replica's changes and returns all of its operations
+func (replica *testReplica) delta() *SyncDelta {
+	replica.state.Record(replica.graph)
+	return replica.state.Delta(nil)
+}
+
+// receive syncs the replica with a delta
+func (replica *testReplica) receive(delta *SyncDelta) (int, SyncSummary) {
+	return syncGraph(replica.state, replica.graph, delta)
+}
+
+// note returns the note of the replica's graph with the given text
+func (replica *testReplica) note(t *testing.T, text string) *Node {
+	t.Helper()
+	for _, node := range replica.graph.Nodes {
+		if node.Text == text {
+			return node
+		}
+	}
+	t.Fatalf("no note reads %q", text)
+	return nil
+}
+
+// syncReplicas exchanges deltas between two replicas in both directions
+func syncReplicas(a, b *testReplica) {
+	b.receive(a.delta())
+	a.receive(b.delta())
+}
+
+// checkConverged checks that replicas agree on their state and hold the same graph
+func checkConverged(t *testing.T, replicas ...*testReplica) {
+	t.Helper()
+	want, wantGraph := replicas[0].state.Digest(), describeGraph(replicas[0].graph)
+	for i, replica := range replicas {
+		if got := replica.state.Digest(); got != want {
+			t.Errorf("replica %d has state %s, replica 0 has %s", i, got, want)
+		}
+		if got := describeGraph(replica.graph); !slices.Equal(got, wantGraph) {
+			t.Errorf("replica %d holds\n%q\nreplica 0 holds\n%q", i, got, wantGraph)
+		}
+		if n := replica.state.Record(replica.graph); n != 0 {
+			t.Errorf("replica %d found %d changes of its own after syncing", i, n)
+		}
+	}
+}
+
+func addTestNote(t *testing.T, graph *KnowledgeGraph, text string, concepts ...string) *Node {
+	t.Helper()
+	node, err := AddNote(graph, text, concepts, NodeMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestSyncMergesConcurrentEdits(t *testing.T) {
+	a, b := newTestReplica(t), newTestReplica(t)
+	addTestNote(t, a.graph, "Raft elects a leader", "raft")
+	addTestNote(t, a.graph, "Paxos needs a majority", "paxos")
+	syncReplicas(a, b)
+	checkConverged(t, a, b)
+
+	// a rewrites one note, b tags it and adds a concept; both rewrite the other and add a note of their own,
+	// spelling a new concept differently
+	a.note(t, "Raft elects a leader").Text = "Raft elects one leader per term"
+	raft := b.note(t, "Raft elects a leader")
+	raft.Tags = append(raft.Tags, "consensus")
+	if err := b.graph.UpdateNodeConcepts(raft.ID, []string{"raft", "consensus"}); err != nil {
+		t.Fatal(err)
+	}
+	a.note(t, "Paxos needs a majority").Text = "Paxos needs a majority of acceptors"
+	b.note(t, "Paxos needs a majority").Text = "Paxos needs a quorum"
+	addTestNote(t, a.graph, "Gossip spreads membership", "gossip", "Failure Detection")
+	addTestNote(t, b.graph, "Vector clocks order events", "clocks", "failure detection")
+
+	syncReplicas(a, b)
+	checkConverged(t, a, b)
+	if len(a.graph.Nodes) != 4 {
+		t.Errorf("replicas hold %d notes, want 4", len(a.graph.Nodes))
+	}
+	raft = a.note(t, "Raft elects one leader per term")
+	if !raft.HasTag("consensus") || !slices.Contains(a.graph.NodeConcepts(raft.ID), "consensus") {
+		t.Errorf("the rewritten note lost the concurrent tag or concept: tags %q, concepts %q", raft.Tags, a.graph.NodeConcepts(raft.ID))
+	}
+}
+
+func TestSyncDeleteWinsOverConcurrentEdit(t *testing.T) {
+	a, b := newTestReplica(t), newTestReplica(t)
+	doomed := addTestNote(t, a.graph, "Two-phase commit blocks", "transactions")
+	addTestNote(t, a.graph, "Sagas compensate", "transactions")
+	syncReplicas(a, b)
+
+	if err := a.graph.RemoveNode(doomed.ID); err != nil {
+		t.Fatal(err)
+	}
+	a.state.Record(a.graph)
+	for uid, id := range a.state.UIDs {
+		if id == doomed.ID {
+			t.Errorf("UID %s still maps to deleted note %d", uid, id)
+		}
+	}
+	edited := b.note(t, "Two-phase commit blocks")
+	edited.Text = "Two-phase commit blocks on coordinator failure"
+	edited.Tags = append(edited.Tags, "blocking")
+
+	syncReplicas(a, b)
+	checkConverged(t, a, b)
+	if len(a.graph.Nodes) != 1 {
+		t.Errorf("replicas hold %d notes, want 1", len(a.graph.Nodes))
+	}
+
+	// A note added after the deletion gets a UID of its own
+	added := addTestNote(t, b.graph, "Three-phase commit avoids blocking", "transactions")
+	syncReplicas(a, b)
+	checkConverged(t, a, b)
+	if len(a.graph.Nodes) != 2 {
+		t.Errorf("replicas hold %d notes after adding %q, want 2", len(a.graph.Nodes), added.Text)
+	}
+}
+
+func TestSyncConvergesWhateverTheDeltaOrder(t *testing.T) {
+	a, b := newTestReplica(t), newTestReplica(t)
+	first := addTestNote(t, a.graph, "Bloom filters answer maybe", "probabilistic")
+	second := addTestNote(t, a.graph, "Count-min sketches count", "probabilistic")
+	a.graph.PutEdge(second.ID, first.ID, 1, "extends", true)
+	syncReplicas(a, b)
+
+	first.Text = "Bloom filters answer maybe or no"
+	if _, err := a.graph.AddConceptRelation("probabilistic", "data structures", RelationBroader, OriginManual); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.graph.RemoveNode(b.note(t, "Count-min sketches count").ID); err != nil {
+		t.Fatal(err)
+	}
+	addTestNote(t, b.graph, "HyperLogLog estimates cardinality", "probabilistic")
+
+	fromA, fromB := a.delta(), b.delta()
+	c, d := newTestReplica(t), newTestReplica(t)
+	c.receive(fromA)
+	c.receive(fromB)
+	d.receive(fromB)
+	d.receive(fromA)
+	a.receive(fromB)
+	b.receive(fromA)
+	checkConverged(t, a, b, c, d)
+}
+
+func TestSyncIgnoresRepeatedDeltas(t *testing.T) {
+	a, b := newTestReplica(t), newTestReplica(t)
+	addTestNote(t, a.graph, "CRDTs merge without coordination", "crdt")
+	addTestNote(t, a.graph, "Lamport clocks order events", "clocks")
+	delta := a.delta()
+
+	b.receive(delta)
+	digest, graph := b.state.Digest(), describeGraph(b.graph)
+	received, summary := b.receive(delta)
+	if received != 0 || summary != (SyncSummary{}) {
+		t.Errorf("repeating the delta received %d operations and changed %+v", received, summary)
+	}
+	if b.state.Digest() != digest || !slices.Equal(describeGraph(b.graph), graph) {
+		t.Error("repeating the delta changed the replica")
+	}
+	checkConverged(t, a, b)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trend bucket sizes
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+// ConceptCount pairs a concept with the number of notes mentioning it
+type ConceptCount struct {
+	Concept string `json:"concept"`
+	Count   int    `json:"count"`
+}
+
+// TimelineEntry is one mention of a concept on a concept timeline
+type TimelineEntry struct {
+	Time time.Time
+	Node *Node
+}
+
+// RecencyDecay returns the factor by which a link of the given age fades, halving every halfLife
+// A zero half-life disables decay
+func RecencyDecay(age, halfLife time.Duration) float64 {
+	if halfLife <= 0 || age <= 0 {
+		return 1.0
+	}
+	return math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+// lastReinforced returns when an edge was last reinforced, falling back to the latest update of its notes
+func (graph *KnowledgeGraph) lastReinforced(edge *Edge) time.Time {
+	if !edge.ReinforcedAt.IsZero() {
+		return edge.ReinforcedAt
+	}
+	var latest time.Time
+	for _, id := range []int64{edge.SourceID, edge.TargetID} {
+		if node, ok := graph.Nodes[id]; ok && node.UpdatedAt.After(latest) {
+			latest = node.UpdatedAt
+		}
+	}
+	return latest
+}
+
+// EffectiveWeight returns an edge's weight after applying the graph's recency decay at the given time
+func (graph *KnowledgeGraph) EffectiveWeight(edge *Edge, now time.Time) float64 {
+	reinforced := graph.lastReinforced(edge)
+	if reinforced.IsZero() {
+		return edge.Weight
+	}
+	return edge.Weight * RecencyDecay(now.Sub(reinforced), graph.Settings.DecayHalfLife)
+}
+
+// Faded reports whether a similarity edge has decayed below the graph's minimum weight at the given time
+// Faded edges are kept, since a longer half-life or a reinforcing note brings them back, but readers leave them out
+func (graph *KnowledgeGraph) Faded(edge *Edge, now time.Time) bool {
+	return edge.IsSimilarity() && graph.Settings.MinWeight > 0 && graph.EffectiveWeight(edge, now) < graph.Settings.MinWeight
+}
+
+// reinforceEdges refreshes the similarity edges among the notes a new note was just linked to,
+// so links between old notes stay strong while new notes keep bringing them up together
+func (graph *KnowledgeGraph) reinforceEdges(nodeID int64, at time.Time) {
+	var neighbours []int64
+	for _, edge := range graph.Edges {
+		if edge.IsSimilarity() && (edge.SourceID == nodeID || edge.TargetID == nodeID) {
+			neighbours = append(neighbours, edge.Other(nodeID))
+		}
+	}
+	for i := range neighbours {
+		for j := i + 1; j < len(neighbours); j++ {
+			edge := graph.FindEdge(neighbours[i], neighbours[j], RelationSimilar, false)
+			if edge != nil && at.After(edge.ReinforcedAt) {
+				edge.ReinforcedAt = at
+				graph.touch("Edge", edge.ID)
+			}
+		}
+	}
+}
+
+// TopConcepts counts the concepts of the notes created in [since, until), most frequent first
+func TopConcepts(graph *KnowledgeGraph, since, until time.Time) []ConceptCount {
+	counts := make(map[int64]int)
+	filter := NodeFilter{Since: since, Until: until}
+	for _, nodeID := range SearchNodes(graph, filter) {
+		for _, conceptID := range graph.NodeConceptIDs(nodeID) {
+			counts[conceptID]++
+		}
+	}
+
+	result := make([]ConceptCount, 0, len(counts))
+	for conceptID, count := range counts {
+		result = append(result, ConceptCount{Concept: graph.Concepts[conceptID].Name, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Concept < result[j].Concept
+	})
+	return result
+}
+
+// ConceptTimeline lists the notes mentioning a concept in chronological order
+func ConceptTimeline(graph *KnowledgeGraph, concept string, rollup bool) []TimelineEntry {
+	var entries []TimelineEntry
+	for _, nodeID := range graph.NotesForConcept(concept, rollup) {
+		node := graph.Nodes[nodeID]
+		entries = append(entries, TimelineEntry{Time: node.CreatedAt, Node: node})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries
+}
+
+// bucketStart truncates a time to the start of its trend bucket in local time, the zone -since, -until and -month
+// are read in, so a month of trends holds the same notes as topics -month
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.Local()
+	switch bucket {
+	case BucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	case BucketWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		offset := (int(day.Weekday()) + 6) % 7 // weeks start on Monday
+		return day.AddDate(0, 0, -offset)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	}
+}
+
+// ConceptTrends counts concept mentions per time bucket, keyed by bucket start and concept name
+// Notes without a creation time are left out
+func ConceptTrends(graph *KnowledgeGraph, bucket string) map[time.Time]map[string]int {
+	trends := make(map[time.Time]map[string]int)
+	for _, node := range graph.Nodes {
+		if node.CreatedAt.IsZero() {
+			continue
+		}
+		start := bucketStart(node.CreatedAt, bucket)
+		if trends[start] == nil {
+			trends[start] = make(map[string]int)
+		}
+		for _, concept := range graph.NodeConcepts(node.ID) {
+			trends[start][concept]++
+		}
+	}
+	return trends
+}
+
+// parseHalfLife parses a decay half-life such as "720h" or "30d"
+func parseHalfLife(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid half-life %q", value)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	halfLife, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid half-life %q", value)
+	}
+	return halfLife, nil
+}
+
+// runTopicsCommand lists what was on your mind in a period
+func runTopicsCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("topics", flag.ContinueOnError)
+	month := flags.String("month", "", "month to look at, as YYYY-MM")
+	since := flags.String("since", "", "start of the period")
+	until := flags.String("until", "", "end of the period")
+	limit := flags.Int("limit", 10, "number of concepts to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	start, err := parseTimeFlag(*since)
+	if err != nil {
+		return err
+	}
+	end, err := parseTimeFlag(*until)
+	if err != nil {
+		return err
+	}
+	if *month != "" {
+		if start, err = parseTimeFlag(*month); err != nil {
+			return err
+		}
+		end = start.AddDate(0, 1, 0)
+	}
+
+	counts := TopConcepts(env.Graph, start, end)
+	for i, count := range counts {
+		if i == *limit {
+			break
+		}
+		fmt.Printf("%-30s %d notes\n", count.Concept, count.Count)
+	}
+	return nil
+}
+
+// runTimelineCommand prints the mentions of a concept over time
+func runTimelineCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("timeline", flag.ContinueOnError)
+	rollup := flags.Bool("rollup", true, "include notes tagged with narrower concepts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: timeline [-rollup=false] <concept>")
+	}
+
+	for _, entry := range ConceptTimeline(env.Graph, strings.Join(flags.Args(), " "), *rollup) {
+		date := "unknown date"
+		if !entry.Time.IsZero() {
+			date = entry.Time.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%s  Node %d: %s\n", date, entry.Node.ID, entry.Node.Text)
+	}
+	return nil
+}
+
+// runTrendsCommand prints how often concepts were mentioned per day, week or month
+func runTrendsCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("trends", flag.ContinueOnError)
+	bucket := flags.String("bucket", BucketMonth, "bucket size: day, week or month")
+	limit := flags.Int("limit", 5, "concepts to show per bucket when none are named")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *bucket != BucketDay && *bucket != BucketWeek && *bucket != BucketMonth {
+		return fmt.Errorf("invalid bucket %q", *bucket)
+	}
+
+	trends := ConceptTrends(env.Graph, *bucket)
+	starts := make([]time.Time, 0, len(trends))
+	for start := range trends {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	for _, start := range starts {
+		counts := trends[start]
+		var line []string
+		if flags.NArg() > 0 {
+			// Follow the named concepts
+			for _, name := range flags.Args() {
+				if concept := env.Graph.ConceptByName(name); concept != nil {
+					line = append(line, fmt.Sprintf("%s=%d", concept.Name, counts[concept.Name]))
+				}
+			}
+		} else {
+			// Show the most mentioned concepts of the bucket
+			names := make([]string, 0, len(counts))
+			for name := range counts {
+				names = append(names, name)
+			}
+			sort.Slice(names, func(i, j int) bool {
+				if counts[names[i]] != counts[names[j]] {
+					return counts[names[i]] > counts[names[j]]
+				}
+				return names[i] < names[j]
+			})
+			for i, name := range names {
+				if i == *limit {
+					break
+				}
+				line = append(line, fmt.Sprintf("%s=%d", name, counts[name]))
+			}
+		}
+		fmt.Printf("%s  %s\n", start.Format("2006-01-02"), strings.Join(line, ", "))
+	}
+	return nil
+}
+
+// runDecayCommand sets the recency-decay half-life of edge weights
+func runDecayCommand(env *CommandEnv, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: decay <half-life, e.g. 30d or 720h; 0 disables decay>")
+	}
+	halfLife, err := parseHalfLife(args[0])
+	if err != nil {
+		return err
+	}
+	if halfLife < 0 {
+		return errors.New("half-life must not be negative")
+	}
+	env.Graph.Settings.DecayHalfLife = halfLife
+
+	faded := 0
+	now := time.Now()
+	for _, edge := range env.Graph.Edges {
+		if env.Graph.Faded(edge, now) {
+			faded++
+		}
+	}
+	if faded > 0 {
+		fmt.Printf("%d edges have faded below the minimum weight and are hidden until a note reinforces them\n", faded)
+	}
+	return env.Save()
+}
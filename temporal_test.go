@@ -0,0 +1,111 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTemporalTestGraph adds notes created around a month boundary, in a local zone two hours ahead of UTC
+func newTemporalTestGraph(t *testing.T) *KnowledgeGraph {
+	t.Helper()
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	graph := NewKnowledgeGraph()
+	notes := []struct {
+		text     string
+		created  string
+		concepts []string
+	}{
+		{"Late on the last of March in UTC", "2024-03-31T23:30:00Z", []string{"raft", "consensus"}},
+		{"Mid April", "2024-04-15T10:00:00Z", []string{"raft"}},
+		{"Early March", "2024-03-10T10:00:00Z", []string{"paxos", "consensus"}},
+		{"May", "2024-05-02T10:00:00Z", []string{"raft"}},
+	}
+	for _, note := range notes {
+		created, err := time.Parse(time.RFC3339, note.created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := AddNote(graph, note.text, note.concepts, NodeMetadata{CreatedAt: created}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return graph
+}
+
+func TestTopConceptsOfALocalMonth(t *testing.T) {
+	graph := newTemporalTestGraph(t)
+	start, err := parseTimeFlag("2024-04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := TopConcepts(graph, start, start.AddDate(0, 1, 0))
+	want := []ConceptCount{{"raft", 2}, {"consensus", 1}}
+	if !slices.Equal(got, want) {
+		t.Errorf("top concepts of April %v, want %v", got, want)
+	}
+}
+
+func TestConceptTrendsAgreeWithTopConcepts(t *testing.T) {
+	graph := newTemporalTestGraph(t)
+	trends := ConceptTrends(graph, BucketMonth)
+	want := map[string]map[string]int{
+		"2024-03-01": {"paxos": 1, "consensus": 1},
+		"2024-04-01": {"raft": 2, "consensus": 1},
+		"2024-05-01": {"raft": 1},
+	}
+	if len(trends) != len(want) {
+		t.Errorf("got %d monthly buckets, want %d", len(trends), len(want))
+	}
+	for start, counts := range trends {
+		day := start.Format("2006-01-02")
+		if !maps.Equal(counts, want[day]) {
+			t.Errorf("bucket %s counts %v, want %v", day, counts, want[day])
+		}
+
+		// topics over the same month sees the same notes
+		top := make(map[string]int)
+		for _, count := range TopConcepts(graph, start, start.AddDate(0, 1, 0)) {
+			top[count.Concept] = count.Count
+		}
+		if !maps.Equal(top, counts) {
+			t.Errorf("topics for %s counts %v, trends count %v", day, top, counts)
+		}
+	}
+
+	// 2024-04-01 01:30 local is a Monday
+	weekly := ConceptTrends(graph, BucketWeek)
+	if counts := weekly[time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local)]; counts["raft"] != 1 {
+		t.Errorf("the week of April 1 counts %v, want the late March note in it", counts)
+	}
+}
+
+func TestConceptTimeline(t *testing.T) {
+	graph := newTemporalTestGraph(t)
+	texts := func(entries []TimelineEntry) []string {
+		var texts []string
+		for _, entry := range entries {
+			texts = append(texts, entry.Node.Text)
+		}
+		return texts
+	}
+
+	got := texts(ConceptTimeline(graph, "consensus", false))
+	want := []string{"Early March", "Late on the last of March in UTC"}
+	if !slices.Equal(got, want) {
+		t.Errorf("consensus timeline %q, want %q", got, want)
+	}
+
+	if _, err := graph.AddConceptRelation("raft", "consensus", RelationBroader, OriginManual); err != nil {
+		t.Fatal(err)
+	}
+	got = texts(ConceptTimeline(graph, "consensus", true))
+	want = []string{"Early March", "Late on the last of March in UTC", "Mid April", "May"}
+	if !slices.Equal(got, want) {
+		t.Errorf("rolled-up consensus timeline %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tenantNamePattern restricts tenant names to safe directory names
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Workspace is a graph shared between several users
+type Workspace struct {
+	Name    string
+	Owner   string
+	Members []string
+}
+
+// HasMember reports whether a user owns or was given access to the workspace
+func (workspace *Workspace) HasMember(user string) bool {
+	if workspace.Owner == user {
+		return true
+	}
+	for _, member := range workspace.Members {
+		if member == user {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantStore keeps every tenant's graph in its own directory and tracks who may open shared workspaces
+// The default tenant "" uses the single knowledge_graph.txt from before tenants existed
+type TenantStore struct {
+	Root       string
+	Workspaces map[string]*Workspace
+}
+
+// OpenTenantStore opens the tenant store rooted at a data directory
+func OpenTenantStore(root string) (*TenantStore, error) {
+	store := &TenantStore{
+		Root:       root,
+		Workspaces: make(map[string]*Workspace),
+	}
+
+	file, err := os.Open(store.workspacesPath())
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open workspaces file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Parse workspace
+		if strings.HasPrefix(line, "Workspace ") {
+			name, body, _ := strings.Cut(strings.TrimPrefix(line, "Workspace "), ":")
+			fields := parseRecordFields(body)
+			store.Workspaces[name] = &Workspace{Name: name, Owner: fields["Owner"]}
+		}
+
+		// Parse workspace member
+		if strings.HasPrefix(line, "Member ") {
+			name, member, _ := strings.Cut(strings.TrimPrefix(line, "Member "), ":")
+			workspace, ok := store.Workspaces[name]
+			if !ok {
+				return nil, fmt.Errorf("member of unknown workspace %q", name)
+			}
+			workspace.Members = append(workspace.Members, strings.TrimSpace(member))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while scanning workspaces file: %v", err)
+	}
+
+	return store, nil
+}
+
+// workspacesPath returns the file listing the shared workspaces
+func (store *TenantStore) workspacesPath() string {
+	return filepath.Join(store.Root, "tenants", "workspaces.txt")
+}
+
+// GraphPath returns the graph file of a tenant
+func (store *TenantStore) GraphPath(tenant string) string {
+	if tenant == "" {
+		return filepath.Join(store.Root, "knowledge_graph.txt")
+	}
+	return filepath.Join(store.Root, "tenants", tenant, "knowledge_graph.txt")
+}
+
+// Resolve picks the tenant a user works in: their own graph, or a workspace they belong to
+func (store *TenantStore) Resolve(user, workspace string) (string, error) {
+	if workspace == "" {
+		if user == "" {
+			return "", nil
+		}
+		if err := validateTenantName(user); err != nil {
+			return "", err
+		}
+		if _, ok := store.Workspaces[user]; ok {
+			return "", fmt.Errorf("%q is a workspace, not a user", user)
+		}
+		return user, nil
+	}
+
+	if user == "" {
+		return "", errors.New("opening a workspace needs a user")
+	}
+
+	// Both names become directory names, even when a user opens their own graph as a workspace
+	if err := validateTenantName(user); err != nil {
+		return "", err
+	}
+	if err := validateTenantName(workspace); err != nil {
+		return "", err
+	}
+	if !store.CanAccess(user, workspace) {
+		return "", fmt.Errorf("user %q has no access to workspace %q", user, workspace)
+	}
+	return workspace, nil
+}
+
+// CanAccess reports whether a user may read and write a tenant's graph
+func (store *TenantStore) CanAccess(user, tenant string) bool {
+	if workspace, ok := store.Workspaces[tenant]; ok {
+		return workspace.HasMember(user)
+	}
+	return user == tenant
+}
+
+// CreateWorkspace registers a new shared workspace owned by a user
+func (store *TenantStore) CreateWorkspace(name, owner string) error {
+	if err := validateTenantName(name); err != nil {
+		return err
+	}
+	if owner == "" {
+		return errors.New("creating a workspace needs a user")
+	}
+	if _, ok := store.Workspaces[name]; ok {
+		return fmt.Errorf("workspace %q already exists", name)
+	}
+	if _, err := os.Stat(filepath.Dir(store.GraphPath(name))); err == nil {
+		return fmt.Errorf("tenant %q already exists", name)
+	}
+	known, err := store.isUser(name)
+	if err != nil {
+		return err
+	}
+	if known {
+		return fmt.Errorf("%q is a user, not a workspace", name)
+	}
+	store.Workspaces[name] = &Workspace{Name: name, Owner: owner}
+	return store.Save()
+}
+
+// isUser reports whether a name belongs to a user who may not have a graph yet: a workspace owner or member,
+// or the holder of an API key
+func (store *TenantStore) isUser(name string) (bool, error) {
+	for _, workspace := range store.Workspaces {
+		if workspace.HasMember(name) {
+			return true, nil
+		}
+	}
+	keys, err := OpenKeyStore(store.Root)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys.Keys {
+		if key.User == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ShareWorkspace gives a user access to a workspace; only the owner may share it
+func (store *TenantStore) ShareWorkspace(name, owner, member string) error {
+	workspace, err := store.ownedWorkspace(name, owner)
+	if err != nil {
+		return err
+	}
+	if err := validateTenantName(member); err != nil {
+		return err
+	}
+	if !workspace.HasMember(member) {
+		workspace.Members = append(workspace.Members, member)
+	}
+	return store.Save()
+}
+
+// UnshareWorkspace revokes a user's access to a workspace; only the owner may unshare it
+func (store *TenantStore) UnshareWorkspace(name, owner, member string) error {
+	workspace, err := store.ownedWorkspace(name, owner)
+	if err != nil {
+		return err
+	}
+	for i, m := range workspace.Members {
+		if m == member {
+			workspace.Members = append(workspace.Members[:i], workspace.Members[i+1:]...)
+			return store.Save()
+		}
+	}
+	return fmt.Errorf("user %q is not a member of workspace %q", member, name)
+}
+
+// ownedWorkspace looks up a workspace and checks that the user owns it
+func (store *TenantStore) ownedWorkspace(name, owner string) (*Workspace, error) {
+	workspace, ok := store.Workspaces[name]
+	if !ok {
+		return nil, fmt.Errorf("workspace %q not found", name)
+	}
+	if workspace.Owner != owner {
+		return nil, fmt.Errorf("only the owner of workspace %q can change its members", name)
+	}
+	return workspace, nil
+}
+
+// Save replaces the workspaces file
+func (store *TenantStore) Save() error {
+	if err := os.MkdirAll(filepath.Dir(store.workspacesPath()), 0o755); err != nil {
+		return fmt.Errorf("failed to create tenants directory: %v", err)
+	}
+	names := make([]string, 0, len(store.Workspaces))
+	for name := range store.Workspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return writeFileAtomic(store.workspacesPath(), 0o644, func(w io.Writer) error {
+		for _, name := range names {
+			workspace := store.Workspaces[name]
+			if _, err := fmt.Fprintf(w, "Workspace %s: Owner=%s\n", name, workspace.Owner); err != nil {
+				return fmt.Errorf("failed to write workspace: %v", err)
+			}
+			for _, member := range workspace.Members {
+				if _, err := fmt.Fprintf(w, "Member %s: %s\n", name, member); err != nil {
+					return fmt.Errorf("failed to write workspace member: %v", err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// LoadTenantGraph loads a tenant's graph, creating an empty one if it doesn't exist yet
+func (store *TenantStore) LoadTenantGraph(tenant string) (*KnowledgeGraph, error) {
+	path := store.GraphPath(tenant)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create tenant directory: %v", err)
+	}
+	graph, err := loadOrCreateGraph(path)
+	if err != nil {
+		return nil, err
+	}
+	graph.Tenant = tenant
+	return graph, nil
+}
+
+// validateTenantName checks that a user or workspace name is safe to use as a directory
+func validateTenantName(name string) error {
+	if !tenantNamePattern.MatchString(name) || name == "tenants" {
+		return fmt.Errorf("invalid tenant name %q", name)
+	}
+	return nil
+}
+
+// runWorkspaceCommand creates, shares and lists shared workspaces
+func runWorkspaceCommand(env *CommandEnv, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: workspace create|share|unshare|list ...")
+	}
+	store := env.Store
+
+	switch args[0] {
+	case "create":
+		if len(args) != 2 {
+			return errors.New("usage: -user <owner> workspace create <name>")
+		}
+		return store.CreateWorkspace(args[1], env.User)
+
+	case "share", "unshare":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: -user <owner> workspace %s <name> <user>", args[0])
+		}
+		if args[0] == "share" {
+			return store.ShareWorkspace(args[1], env.User, args[2])
+		}
+		return store.UnshareWorkspace(args[1], env.User, args[2])
+
+	case "list":
+		names := make([]string, 0, len(store.Workspaces))
+		for name, workspace := range store.Workspaces {
+			if env.User == "" || workspace.HasMember(env.User) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			workspace := store.Workspaces[name]
+			fmt.Printf("%s (owner %s) members: %s\n", name, workspace.Owner, strings.Join(workspace.Members, ", "))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown workspace subcommand %q", args[0])
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestResolveRejectsUnsafeTenantNames(t *testing.T) {
+	store, err := OpenTenantStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateWorkspace("shared", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, names := range [][2]string{{"../x", ""}, {"../x", "../x"}, {"alice", "../x"}, {"../x", "shared"}, {"a/b", "a/b"}, {"tenants", "tenants"}} {
+		if tenant, err := store.Resolve(names[0], names[1]); err == nil {
+			t.Errorf("user %q in workspace %q resolved to tenant %q", names[0], names[1], tenant)
+		}
+	}
+	for _, names := range [][3]string{{"alice", "", "alice"}, {"alice", "alice", "alice"}, {"alice", "shared", "shared"}} {
+		if tenant, err := store.Resolve(names[0], names[1]); err != nil || tenant != names[2] {
+			t.Errorf("user %q in workspace %q resolved to %q (%v), want %q", names[0], names[1], tenant, err, names[2])
+		}
+	}
+}
+
+func TestCreateWorkspaceRejectsUserNames(t *testing.T) {
+	store, err := OpenTenantStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateWorkspace("team", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ShareWorkspace("team", "alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither alice nor bob has a graph yet, but both are users
+	for _, name := range []string{"alice", "bob", "team"} {
+		if err := store.CreateWorkspace(name, "carol"); err == nil {
+			t.Errorf("created workspace %q", name)
+		}
+	}
+	if err := store.CreateWorkspace("dave", "carol"); err != nil {
+		t.Errorf("failed to create an unclaimed workspace: %v", err)
+	}
+}
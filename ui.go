@@ -0,0 +1,22 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// uiAssets holds the static files of the graph viewer served by serve -ui
+//
+//go:embed ui
+var uiAssets embed.FS
+
+// uiHandler serves the embedded graph viewer
+func uiHandler() http.Handler {
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		// The embedded directory always exists, so this only fails if the build is broken
+		panic(err)
+	}
+	return http.FileServer(http.FS(assets))
+}
@@ -0,0 +1,445 @@
+// Interactive force-directed view of a knowledge graph, drawn from the serve API
+(function () {
+  "use strict";
+
+  const canvas = document.getElementById("graph");
+  const context = canvas.getContext("2d");
+  const status = document.getElementById("status");
+  const details = document.getElementById("details");
+
+  const settings = {
+    key: localStorage.getItem("kg.key") || "",
+    workspace: localStorage.getItem("kg.workspace") || "",
+  };
+
+  let nodes = [];
+  let edges = [];
+  let byId = new Map();
+  let maxWeight = 1;
+  let matches = null;
+  let selected = null;
+  let alpha = 0;
+  let dragged = null;
+  let panning = null;
+  let moved = false;
+  const view = { x: 0, y: 0, scale: 1 };
+
+  // API access
+
+  async function api(path, params) {
+    const query = new URLSearchParams();
+    for (const [name, value] of Object.entries(params || {})) {
+      if (value !== "" && value !== undefined && value !== null) {
+        query.set(name, value);
+      }
+    }
+    if (settings.workspace) {
+      query.set("workspace", settings.workspace);
+    }
+    const response = await fetch(path + (query.toString() ? "?" + query : ""), {
+      headers: { Authorization: "Bearer " + settings.key },
+    });
+    const body = await response.json().catch(() => ({}));
+    if (!response.ok) {
+      throw new Error(body.error || response.status + " " + response.statusText);
+    }
+    return body;
+  }
+
+  function filters() {
+    return {
+      concept: document.getElementById("concept").value.trim(),
+      min_weight: document.getElementById("min-weight").value,
+    };
+  }
+
+  async function loadGraph() {
+    if (!settings.key) {
+      status.textContent = "Enter an API key to connect";
+      return;
+    }
+    status.textContent = "Loading…";
+    try {
+      const graph = await api("/api/graph", filters());
+      setGraph(graph);
+      status.textContent = nodes.length + " notes, " + edges.length + " edges";
+    } catch (err) {
+      status.textContent = err.message;
+    }
+  }
+
+  async function search(text) {
+    if (!text) {
+      matches = null;
+      return;
+    }
+    const params = filters();
+    params.q = text;
+    delete params.min_weight;
+    const found = await api("/api/nodes", params);
+    matches = new Set(found.map((node) => node.id));
+    status.textContent = found.length + " matching notes";
+    const visible = nodes.filter((node) => matches.has(node.id));
+    if (visible.length > 0) {
+      centerOn(visible);
+    }
+  }
+
+  async function showDetails(node) {
+    selected = node;
+    details.hidden = false;
+    document.getElementById("details-title").textContent = "Note " + node.id;
+    document.getElementById("details-text").textContent = node.text;
+    fillList(document.getElementById("details-concepts"), node.concepts, (concept, item) => {
+      item.textContent = concept;
+      item.title = "Show only notes about " + concept;
+      item.onclick = () => {
+        document.getElementById("concept").value = concept;
+        loadGraph();
+      };
+    });
+    const related = document.getElementById("details-related");
+    related.textContent = "";
+    try {
+      const full = await api("/api/nodes/" + node.id);
+      fillList(related, full.related || [], (entry, item) => {
+        item.textContent = truncate(entry.text, 80) + " ";
+        const weight = document.createElement("span");
+        weight.className = "weight";
+        weight.textContent = entry.weight.toFixed(2);
+        item.appendChild(weight);
+        item.onclick = () => {
+          const target = byId.get(entry.id);
+          if (target) {
+            centerOn([target]);
+            showDetails(target);
+          }
+        };
+      });
+    } catch (err) {
+      related.textContent = err.message;
+    }
+  }
+
+  function fillList(list, values, render) {
+    list.textContent = "";
+    for (const value of values) {
+      const item = document.createElement("li");
+      render(value, item);
+      list.appendChild(item);
+    }
+  }
+
+  function truncate(text, length) {
+    return text.length <= length ? text : text.slice(0, length - 1) + "…";
+  }
+
+  // Live updates
+
+  let events = null;
+  let reloadTimer = null;
+
+  // subscribe reloads the graph shortly after any change, coalescing bursts such as a note and its new edges
+  function subscribe() {
+    if (events) {
+      events.close();
+      events = null;
+    }
+    if (!settings.key || !window.EventSource) {
+      return;
+    }
+    const query = new URLSearchParams({ access_token: settings.key });
+    if (settings.workspace) {
+      query.set("workspace", settings.workspace);
+    }
+    events = new EventSource("/api/events?" + query);
+    const reload = () => {
+      clearTimeout(reloadTimer);
+      reloadTimer = setTimeout(loadGraph, 500);
+    };
+    const types = ["node.added", "node.updated", "node.deleted", "edge.created", "edge.updated", "edge.removed", "concepts.updated", "stream.reset"];
+    for (const type of types) {
+      events.addEventListener(type, reload);
+    }
+  }
+
+  // Simulation
+
+  function setGraph(graph) {
+    const previous = byId;
+    byId = new Map();
+    nodes = graph.nodes.map((node, index) => {
+      // Keep the positions of notes already on screen so reloading does not scramble the layout
+      const old = previous.get(node.id);
+      const angle = index * 2.399963;
+      const radius = 12 * Math.sqrt(index + 1);
+      const placed = Object.assign(node, {
+        x: old ? old.x : radius * Math.cos(angle),
+        y: old ? old.y : radius * Math.sin(angle),
+        vx: 0,
+        vy: 0,
+        degree: 0,
+      });
+      byId.set(node.id, placed);
+      return placed;
+    });
+    edges = graph.edges
+      .filter((edge) => byId.has(edge.source) && byId.has(edge.target))
+      .map((edge) => Object.assign(edge, { from: byId.get(edge.source), to: byId.get(edge.target) }));
+    maxWeight = 1;
+    for (const edge of edges) {
+      edge.from.degree++;
+      edge.to.degree++;
+      maxWeight = Math.max(maxWeight, edge.weight);
+    }
+    if (selected && !byId.has(selected.id)) {
+      selected = null;
+      details.hidden = true;
+    }
+    alpha = 1;
+  }
+
+  function step() {
+    if (alpha < 0.005) {
+      return;
+    }
+    const count = nodes.length;
+
+    // Every pair of notes repels; connected notes are pulled together harder the heavier the edge
+    for (let i = 0; i < count; i++) {
+      const a = nodes[i];
+      for (let j = i + 1; j < count; j++) {
+        const b = nodes[j];
+        let dx = b.x - a.x;
+        let dy = b.y - a.y;
+        let distance2 = dx * dx + dy * dy;
+        if (distance2 < 0.01) {
+          dx = Math.random() - 0.5;
+          dy = Math.random() - 0.5;
+          distance2 = dx * dx + dy * dy;
+        }
+        const force = (900 * alpha) / distance2;
+        a.vx -= dx * force;
+        a.vy -= dy * force;
+        b.vx += dx * force;
+        b.vy += dy * force;
+      }
+    }
+    for (const edge of edges) {
+      const dx = edge.to.x - edge.from.x;
+      const dy = edge.to.y - edge.from.y;
+      const distance = Math.sqrt(dx * dx + dy * dy) || 1;
+      const strength = 0.05 + (0.25 * edge.weight) / maxWeight;
+      const force = ((distance - 60) / distance) * strength * alpha;
+      edge.from.vx += dx * force;
+      edge.from.vy += dy * force;
+      edge.to.vx -= dx * force;
+      edge.to.vy -= dy * force;
+    }
+    for (const node of nodes) {
+      node.vx -= node.x * 0.01 * alpha;
+      node.vy -= node.y * 0.01 * alpha;
+      if (node === dragged) {
+        node.vx = node.vy = 0;
+        continue;
+      }
+      node.vx *= 0.6;
+      node.vy *= 0.6;
+      node.x += node.vx;
+      node.y += node.vy;
+    }
+    alpha *= 0.99;
+  }
+
+  // Rendering
+
+  function clusterColor(cluster, faded) {
+    const hue = (cluster * 137.508) % 360;
+    return "hsla(" + hue + ", 65%, 50%, " + (faded ? 0.15 : 1) + ")";
+  }
+
+  function nodeRadius(node) {
+    return 4 + Math.min(8, Math.sqrt(node.degree) * 1.5);
+  }
+
+  function draw() {
+    const ratio = window.devicePixelRatio || 1;
+    const width = canvas.clientWidth;
+    const height = canvas.clientHeight;
+    if (canvas.width !== width * ratio || canvas.height !== height * ratio) {
+      canvas.width = width * ratio;
+      canvas.height = height * ratio;
+    }
+    context.setTransform(ratio, 0, 0, ratio, 0, 0);
+    context.clearRect(0, 0, width, height);
+    context.translate(width / 2 + view.x, height / 2 + view.y);
+    context.scale(view.scale, view.scale);
+
+    for (const edge of edges) {
+      const faded = matches && !(matches.has(edge.source) && matches.has(edge.target));
+      const touched = selected && (edge.from === selected || edge.to === selected);
+      context.strokeStyle = touched ? "rgba(29, 35, 42, 0.7)" : faded ? "rgba(120, 130, 140, 0.08)" : "rgba(120, 130, 140, 0.35)";
+      context.lineWidth = (0.5 + (4 * edge.weight) / maxWeight) / view.scale;
+      context.beginPath();
+      context.moveTo(edge.from.x, edge.from.y);
+      context.lineTo(edge.to.x, edge.to.y);
+      context.stroke();
+    }
+
+    for (const node of nodes) {
+      const faded = matches && !matches.has(node.id);
+      const radius = nodeRadius(node);
+      context.fillStyle = clusterColor(node.cluster, faded);
+      context.beginPath();
+      context.arc(node.x, node.y, radius, 0, 2 * Math.PI);
+      context.fill();
+      if (node === selected || (matches && !faded)) {
+        context.strokeStyle = "#1d232a";
+        context.lineWidth = 2 / view.scale;
+        context.stroke();
+      }
+    }
+
+    // Labels only once zoomed in far enough to read them, and always for the selection and matches
+    context.fillStyle = "#1d232a";
+    context.font = 11 / view.scale + "px system-ui, sans-serif";
+    for (const node of nodes) {
+      const highlighted = node === selected || (matches && matches.has(node.id));
+      if (view.scale >= 1.5 || highlighted) {
+        context.fillText(truncate(node.label, 40), node.x + nodeRadius(node) + 3, node.y + 4);
+      }
+    }
+  }
+
+  function frame() {
+    step();
+    draw();
+    requestAnimationFrame(frame);
+  }
+
+  // Interaction
+
+  function toGraph(event) {
+    const bounds = canvas.getBoundingClientRect();
+    return {
+      x: (event.clientX - bounds.left - bounds.width / 2 - view.x) / view.scale,
+      y: (event.clientY - bounds.top - bounds.height / 2 - view.y) / view.scale,
+    };
+  }
+
+  function nodeAt(point) {
+    for (let i = nodes.length - 1; i >= 0; i--) {
+      const node = nodes[i];
+      const radius = nodeRadius(node) + 2 / view.scale;
+      if ((node.x - point.x) ** 2 + (node.y - point.y) ** 2 <= radius * radius) {
+        return node;
+      }
+    }
+    return null;
+  }
+
+  function centerOn(targets) {
+    const x = targets.reduce((sum, node) => sum + node.x, 0) / targets.length;
+    const y = targets.reduce((sum, node) => sum + node.y, 0) / targets.length;
+    view.x = -x * view.scale;
+    view.y = -y * view.scale;
+  }
+
+  canvas.addEventListener("mousedown", (event) => {
+    moved = false;
+    dragged = nodeAt(toGraph(event));
+    if (!dragged) {
+      panning = { x: event.clientX - view.x, y: event.clientY - view.y };
+    }
+    canvas.classList.add("dragging");
+  });
+
+  window.addEventListener("mousemove", (event) => {
+    if (dragged) {
+      const point = toGraph(event);
+      dragged.x = point.x;
+      dragged.y = point.y;
+      alpha = Math.max(alpha, 0.3);
+      moved = true;
+    } else if (panning) {
+      view.x = event.clientX - panning.x;
+      view.y = event.clientY - panning.y;
+      moved = true;
+    }
+  });
+
+  window.addEventListener("mouseup", () => {
+    dragged = null;
+    panning = null;
+    canvas.classList.remove("dragging");
+  });
+
+  canvas.addEventListener("click", (event) => {
+    if (moved) {
+      return;
+    }
+    const node = nodeAt(toGraph(event));
+    if (node) {
+      showDetails(node);
+    } else {
+      selected = null;
+      details.hidden = true;
+    }
+  });
+
+  canvas.addEventListener(
+    "wheel",
+    (event) => {
+      event.preventDefault();
+      const bounds = canvas.getBoundingClientRect();
+      const cx = event.clientX - bounds.left - bounds.width / 2;
+      const cy = event.clientY - bounds.top - bounds.height / 2;
+      const factor = Math.exp(-event.deltaY * 0.001);
+      const scale = Math.min(8, Math.max(0.1, view.scale * factor));
+      // Zoom around the cursor
+      view.x = cx - ((cx - view.x) * scale) / view.scale;
+      view.y = cy - ((cy - view.y) * scale) / view.scale;
+      view.scale = scale;
+    },
+    { passive: false }
+  );
+
+  document.getElementById("close").addEventListener("click", () => {
+    selected = null;
+    details.hidden = true;
+  });
+
+  document.getElementById("search").addEventListener("submit", async (event) => {
+    event.preventDefault();
+    await loadGraph();
+    try {
+      await search(document.getElementById("query").value.trim());
+    } catch (err) {
+      status.textContent = err.message;
+    }
+  });
+
+  document.getElementById("reset").addEventListener("click", () => {
+    document.getElementById("query").value = "";
+    document.getElementById("concept").value = "";
+    document.getElementById("min-weight").value = "0";
+    matches = null;
+    loadGraph();
+  });
+
+  document.getElementById("auth").addEventListener("submit", (event) => {
+    event.preventDefault();
+    settings.key = document.getElementById("key").value.trim();
+    settings.workspace = document.getElementById("workspace").value.trim();
+    localStorage.setItem("kg.key", settings.key);
+    localStorage.setItem("kg.workspace", settings.workspace);
+    loadGraph();
+    subscribe();
+  });
+
+  document.getElementById("key").value = settings.key;
+  document.getElementById("workspace").value = settings.workspace;
+  loadGraph();
+  subscribe();
+  requestAnimationFrame(frame);
+})();
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Transcriber turns an audio file into text
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioPath string) (string, error)
+}
+
+// Summarizer condenses a transcript into the text stored on the note
+type Summarizer interface {
+	Summarize(ctx context.Context, transcript string) (string, error)
+}
+
+// WhisperTranscriber transcribes audio through OpenAI's Whisper API
+type WhisperTranscriber struct {
+	Client *openai.Client
+}
+
+// Transcribe implements Transcriber
+func (transcriber WhisperTranscriber) Transcribe(ctx context.Context, audioPath string) (string, error) {
+	resp, err := transcriber.Client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    openai.Whisper1,
+		FilePath: audioPath,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe %s: %v", audioPath, err)
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
+
+// StubTranscriber reads the transcript from a text file next to the audio file (voice.m4a → voice.txt),
+// for working offline or without a speech-to-text service
+type StubTranscriber struct{}
+
+// Transcribe implements Transcriber
+func (StubTranscriber) Transcribe(ctx context.Context, audioPath string) (string, error) {
+	transcriptPath := strings.TrimSuffix(audioPath, filepath.Ext(audioPath)) + ".txt"
+	transcript, err := os.ReadFile(transcriptPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stub transcript: %v", err)
+	}
+	return strings.TrimSpace(string(transcript)), nil
+}
+
+// OpenAISummarizer summarizes transcripts with a chat model
+type OpenAISummarizer struct {
+	Client *openai.Client
+}
+
+// Summarize implements Summarizer
+func (summarizer OpenAISummarizer) Summarize(ctx context.Context, transcript string) (string, error) {
+	// Prepare system prompt
+	prompt := "You are an AI assistant that is an expert at understanding context. You will take the provided voice note transcript and summarize it in a few sentences, keeping every idea, name and decision it mentions. Do not respond with a label, just the summary.\nTranscript:\n" + transcript
+
+	resp, err := summarizer.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT3Dot5Turbo,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: prompt,
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize transcript: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("failed to summarize transcript: empty response")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+// TranscriptSummarizer keeps the transcript itself as the note text
+type TranscriptSummarizer struct{}
+
+// Summarize implements Summarizer
+func (TranscriptSummarizer) Summarize(ctx context.Context, transcript string) (string, error) {
+	return transcript, nil
+}
+
+// VoicePipeline ingests audio notes: transcribe, summarize, extract concepts and add the note to the graph
+type VoicePipeline struct {
+	Transcriber Transcriber
+	Summarizer  Summarizer
+
+	// ExtractConcepts extracts the concepts of the summary
+	ExtractConcepts func(text string) ([]string, error)
+
+	// TranscriptDir receives the full transcript of every ingested note
+	TranscriptDir string
+}
+
+// Ingest turns an audio file into a note in the graph
+func (pipeline *VoicePipeline) Ingest(ctx context.Context, graph *KnowledgeGraph, audioPath, author string) (*Node, error) {
+	if _, err := os.Stat(audioPath); err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %v", err)
+	}
+
+	transcript, err := pipeline.Transcriber.Transcribe(ctx, audioPath)
+	if err != nil {
+		return nil, err
+	}
+	if transcript == "" {
+		return nil, fmt.Errorf("transcript of %s is empty", audioPath)
+	}
+
+	summary, err := pipeline.Summarizer.Summarize(ctx, transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	concepts, err := pipeline.ExtractConcepts(summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract concepts from summary: %v", err)
+	}
+
+	node, err := AddNote(graph, summary, concepts, NodeMetadata{
+		Source:      SourceVoice,
+		Author:      author,
+		Attachments: []string{audioPath},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the full transcript alongside the graph, since the note only holds the summary
+	if pipeline.TranscriptDir != "" {
+		if err := os.MkdirAll(pipeline.TranscriptDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create transcript directory: %v", err)
+		}
+		transcriptPath := filepath.Join(pipeline.TranscriptDir, fmt.Sprintf("%d.txt", node.ID))
+		if err := os.WriteFile(transcriptPath, []byte(transcript+"\n"), 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write transcript: %v", err)
+		}
+		node.Attachments = append(node.Attachments, transcriptPath)
+	}
+
+	return node, nil
+}
+
+// runIngestAudioCommand ingests voice notes from audio files
+func runIngestAudioCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("ingest-audio", flag.ContinueOnError)
+	stub := flags.Bool("stub", false, "read transcripts from .txt files next to the audio instead of calling Whisper")
+	conceptList := flags.String("concepts", "", "comma-separated concepts to use instead of extracting them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: ingest-audio [-stub] [-concepts a,b] <audio-file>...")
+	}
+
+	pipeline, err := newVoicePipeline(env, *stub, *conceptList)
+	if err != nil {
+		return err
+	}
+	return ingestAudioFiles(env, pipeline, flags.Args())
+}
+
+// newVoicePipeline picks the transcriber, summarizer and concept extraction for ingest-audio
+func newVoicePipeline(env *CommandEnv, stub bool, conceptList string) (*VoicePipeline, error) {
+	pipeline := &VoicePipeline{
+		Transcriber:   StubTranscriber{},
+		Summarizer:    TranscriptSummarizer{},
+		TranscriptDir: filepath.Join(filepath.Dir(env.GraphFilePath), "transcripts"),
+	}
+	if env.APIKey != "" {
+		client := openai.NewClient(env.APIKey)
+		if !stub {
+			pipeline.Transcriber = WhisperTranscriber{Client: client}
+		}
+		pipeline.Summarizer = OpenAISummarizer{Client: client}
+	} else if !stub {
+		return nil, errors.New(missingAPIKeyMessage + " Use -stub to ingest local transcripts.")
+	}
+
+	if conceptList != "" {
+		concepts := strings.Split(conceptList, ",")
+		pipeline.ExtractConcepts = func(string) ([]string, error) { return concepts, nil }
+	} else {
+		apiKey, err := env.RequireAPIKey()
+		if err != nil {
+			return nil, err
+		}
+		pipeline.ExtractConcepts = func(text string) ([]string, error) { return ExtractConcepts(text, apiKey) }
+	}
+	return pipeline, nil
+}
+
+// ingestAudioFiles runs the pipeline over audio files, saving the graph after each one
+func ingestAudioFiles(env *CommandEnv, pipeline *VoicePipeline, audioPaths []string) error {
+	author := env.User
+	if author == "" {
+		author = currentAuthor()
+	}
+	for _, audioPath := range audioPaths {
+		absolute, err := filepath.Abs(audioPath)
+		if err != nil {
+			return err
+		}
+		node, err := pipeline.Ingest(context.Background(), env.Graph, absolute, author)
+		if err != nil {
+			return err
+		}
+		printNode(env.Graph, node)
+
+		// Save after every file so a failure later on doesn't lose the notes already ingested
+		if err := env.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeTranscriber returns canned transcripts by audio file name
+type fakeTranscriber struct {
+	transcripts map[string]string
+	calls       []string
+}
+
+// Transcribe implements Transcriber
+func (transcriber *fakeTranscriber) Transcribe(ctx context.Context, audioPath string) (string, error) {
+	transcriber.calls = append(transcriber.calls, audioPath)
+	transcript, ok := transcriber.transcripts[filepath.Base(audioPath)]
+	if !ok {
+		return "", errors.New("no speech detected")
+	}
+	return transcript, nil
+}
+
+// writeAudio creates placeholder audio files in dir
+func writeAudio(t *testing.T, dir string, names ...string) []string {
+	t.Helper()
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("audio"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestIngestAudioStoresTranscribedNotes(t *testing.T) {
+	dir := t.TempDir()
+	env := &CommandEnv{Graph: NewKnowledgeGraph(), GraphFilePath: filepath.Join(dir, "graph.txt"), User: "alice"}
+	transcriber := &fakeTranscriber{transcripts: map[string]string{
+		"standup.m4a": "Ship the sync feature on Friday",
+		"retro.m4a":   "Sync shipped late because of the review",
+	}}
+	pipeline := &VoicePipeline{
+		Transcriber:     transcriber,
+		Summarizer:      TranscriptSummarizer{},
+		ExtractConcepts: func(string) ([]string, error) { return []string{"sync"}, nil },
+		TranscriptDir:   filepath.Join(dir, "transcripts"),
+	}
+	audio := writeAudio(t, dir, "standup.m4a", "retro.m4a", "silence.m4a")
+
+	if err := ingestAudioFiles(env, pipeline, audio); err == nil {
+		t.Fatal("ingesting a file without speech succeeded")
+	}
+	if !slices.Equal(transcriber.calls, audio) {
+		t.Errorf("transcribed %q, want %q", transcriber.calls, audio)
+	}
+
+	// The notes ingested before the failure were saved
+	graph, err := LoadGraph(env.GraphFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 2 {
+		t.Fatalf("graph holds %d notes, want 2", len(graph.Nodes))
+	}
+	for _, node := range graph.Nodes {
+		if node.Source != SourceVoice || node.Author != "alice" {
+			t.Errorf("note %d has source %q and author %q", node.ID, node.Source, node.Author)
+		}
+		if len(node.Attachments) != 2 {
+			t.Fatalf("note %d has attachments %q, want the audio and its transcript", node.ID, node.Attachments)
+		}
+		transcript, err := os.ReadFile(node.Attachments[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := transcriber.transcripts[filepath.Base(node.Attachments[0])] + "\n"; string(transcript) != want {
+			t.Errorf("transcript of note %d is %q, want %q", node.ID, transcript, want)
+		}
+	}
+	if len(graph.Edges) != 1 {
+		t.Errorf("graph holds %d edges, want the two notes linked through their shared concept", len(graph.Edges))
+	}
+}
+
+func TestIngestAudioCommandWithStubTranscripts(t *testing.T) {
+	dir := t.TempDir()
+	env := &CommandEnv{Graph: NewKnowledgeGraph(), GraphFilePath: filepath.Join(dir, "graph.txt"), User: "bob"}
+	audio := writeAudio(t, dir, "memo.wav")
+	if err := os.WriteFile(filepath.Join(dir, "memo.txt"), []byte("  Buy more coffee\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runIngestAudioCommand(env, []string{"memo.wav"}); err == nil {
+		t.Error("ingest-audio without an API key or -stub succeeded")
+	}
+	if err := runIngestAudioCommand(env, []string{"-stub", "-concepts", "coffee", audio[0]}); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := LoadGraph(env.GraphFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 1 {
+		t.Fatalf("graph holds %d notes, want 1", len(graph.Nodes))
+	}
+	for _, node := range graph.Nodes {
+		if node.Text != "Buy more coffee" || !slices.Equal(graph.NodeConcepts(node.ID), []string{"coffee"}) {
+			t.Errorf("note is %q with concepts %q", node.Text, graph.NodeConcepts(node.ID))
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client key to compute Sec-WebSocket-Accept (RFC 6455 section 1.3)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	websocketText  = 0x1
+	websocketClose = 0x8
+	websocketPing  = 0x9
+	websocketPong  = 0xA
+)
+
+// WebSocket close codes
+const (
+	websocketCloseNormal   = 1000
+	websocketClosePolicy   = 1008
+	websocketCloseTooBig   = 1009
+	websocketCloseTryAgain = 1013
+)
+
+const (
+	// websocketMaxFrame caps the payload of a frame read from a client
+	websocketMaxFrame = 64 * 1024
+
+	// websocketWriteTimeout bounds how long a write to a stalled client may block
+	websocketWriteTimeout = 10 * time.Second
+)
+
+// websocketConn is a server-side WebSocket connection
+type websocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// mu serializes frame writes from the event loop and the control-frame reader
+	mu     sync.Mutex
+	closed bool
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if r.Method != http.MethodGet {
+		return nil, fmt.Errorf("%s not allowed for a WebSocket handshake", r.Method)
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("expected a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, errors.New("invalid Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("connection cannot be upgraded")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take over connection: %v", err)
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to complete handshake: %v", err)
+	}
+	return &websocketConn{conn: conn, reader: buffered.Reader}, nil
+}
+
+// headerContainsToken reports whether a comma-separated header lists a token, ignoring case
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteText sends a text message in a single frame
+func (ws *websocketConn) WriteText(data []byte) error {
+	return ws.writeFrame(websocketText, data)
+}
+
+// Ping sends a ping the client must answer
+func (ws *websocketConn) Ping() error {
+	return ws.writeFrame(websocketPing, nil)
+}
+
+// CloseWith sends a close frame with a status code and reason
+func (ws *websocketConn) CloseWith(code uint16, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return ws.writeFrame(websocketClose, append(payload, reason...))
+}
+
+// Close sends a normal close frame, if none was sent yet, and closes the connection
+func (ws *websocketConn) Close() error {
+	ws.CloseWith(websocketCloseNormal, "")
+	return ws.conn.Close()
+}
+
+// writeFrame writes one unmasked, unfragmented frame; nothing is written after a close frame
+func (ws *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	if opcode == websocketClose {
+		ws.closed = true
+	}
+
+	header := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, byte(length>>8), byte(length))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := ws.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readFrame reads one frame from the client and unmasks its payload
+func (ws *websocketConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.reader, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		err = errors.New("client frames must be masked")
+		return
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err = io.ReadFull(ws.reader, extended[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err = io.ReadFull(ws.reader, extended[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > websocketMaxFrame {
+		ws.CloseWith(websocketCloseTooBig, "frame too large")
+		err = errors.New("frame too large")
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// readControlFrames answers pings and returns when the client closes the connection or it fails
+// Data frames are read and discarded
+func (ws *websocketConn) readControlFrames() {
+	for {
+		_, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case websocketPing:
+			if err := ws.writeFrame(websocketPong, payload); err != nil {
+				return
+			}
+		case websocketClose:
+			// Echo the status code back, as the closing handshake requires
+			if len(payload) >= 2 {
+				payload = payload[:2]
+			}
+			ws.writeFrame(websocketClose, payload)
+			return
+		}
+	}
+}
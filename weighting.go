@@ -0,0 +1,360 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Edge weighting strategy names
+const (
+	WeightJaccard   = "jaccard"
+	WeightOverlap   = "overlap"
+	WeightTFIDF     = "tfidf"
+	WeightSalience  = "salience"
+	WeightEmbedding = "embedding"
+)
+
+// WeightFunc calculates the similarity weight between two notes of a graph
+type WeightFunc interface {
+	Weight(graph *KnowledgeGraph, nodeID, otherID int64) float64
+}
+
+// candidateFunc is implemented by strategies that can relate notes without any shared concept
+type candidateFunc interface {
+	Candidates(graph *KnowledgeGraph, nodeID int64) []int64
+}
+
+// Embedder turns note text into an embedding vector
+type Embedder func(text string) ([]float32, error)
+
+// weightStrategies maps strategy names to their implementations
+var weightStrategies = map[string]WeightFunc{
+	WeightJaccard:   JaccardWeight{},
+	WeightOverlap:   OverlapWeight{},
+	WeightTFIDF:     TFIDFCosineWeight{},
+	WeightSalience:  SalienceWeight{},
+	WeightEmbedding: EmbeddingCosineWeight{},
+}
+
+// WeightStrategyNames returns the names of the available weighting strategies
+func WeightStrategyNames() []string {
+	names := make([]string, 0, len(weightStrategies))
+	for name := range weightStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// weightFunc returns the weighting strategy selected for the graph, defaulting to Jaccard
+func (graph *KnowledgeGraph) weightFunc() WeightFunc {
+	if strategy, ok := weightStrategies[graph.Settings.WeightStrategy]; ok {
+		return strategy
+	}
+	return JaccardWeight{}
+}
+
+// weightStrategyName returns the name of the graph's weighting strategy
+func (graph *KnowledgeGraph) weightStrategyName() string {
+	if _, ok := weightStrategies[graph.Settings.WeightStrategy]; ok {
+		return graph.Settings.WeightStrategy
+	}
+	return WeightJaccard
+}
+
+// JaccardWeight scores notes by the Jaccard similarity of their concepts, with ontology credit when the graph enables it
+type JaccardWeight struct{}
+
+// Weight implements WeightFunc
+func (JaccardWeight) Weight(graph *KnowledgeGraph, nodeID, otherID int64) float64 {
+	if graph.Settings.OntologyCredit > 0 {
+		return CalculateWeightWithOntology(graph, graph.NodeConcepts(nodeID), graph.NodeConcepts(otherID), graph.Settings.OntologyCredit)
+	}
+	return CalculateWeight(graph.NodeConcepts(nodeID), graph.NodeConcepts(otherID))
+}
+
+// OverlapWeight scores notes by the overlap coefficient |A∩B| / min(|A|, |B|) of their concepts
+type OverlapWeight struct{}
+
+// Weight implements WeightFunc
+func (OverlapWeight) Weight(graph *KnowledgeGraph, nodeID, otherID int64) float64 {
+	concepts := graph.nodeMembers[nodeID]
+	others := graph.nodeMembers[otherID]
+	smaller := min(len(concepts), len(others))
+	if smaller == 0 {
+		return 0.0
+	}
+
+	intersection := 0
+	for conceptID := range concepts {
+		if _, ok := others[conceptID]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(smaller)
+}
+
+// TFIDFCosineWeight scores notes by the cosine of their concept vectors weighted by inverse document frequency, so rare shared concepts count more
+type TFIDFCosineWeight struct{}
+
+// Weight implements WeightFunc
+func (TFIDFCosineWeight) Weight(graph *KnowledgeGraph, nodeID, otherID int64) float64 {
+	concepts := graph.nodeMembers[nodeID]
+	others := graph.nodeMembers[otherID]
+
+	var dot, norm1, norm2 float64
+	for conceptID := range concepts {
+		idf := graph.inverseDocumentFrequency(conceptID)
+		norm1 += idf * idf
+		if _, ok := others[conceptID]; ok {
+			dot += idf * idf
+		}
+	}
+	for conceptID := range others {
+		idf := graph.inverseDocumentFrequency(conceptID)
+		norm2 += idf * idf
+	}
+
+	if norm1 == 0 || norm2 == 0 {
+		return 0.0
+	}
+	return dot / (math.Sqrt(norm1) * math.Sqrt(norm2))
+}
+
+// inverseDocumentFrequency returns the smoothed IDF of a concept across the graph's notes
+func (graph *KnowledgeGraph) inverseDocumentFrequency(conceptID int64) float64 {
+	documentFrequency := len(graph.conceptMembers[conceptID])
+	return math.Log(1 + float64(len(graph.Nodes))/float64(1+documentFrequency))
+}
+
+// SalienceWeight scores notes by weighted Jaccard over the salience of their concept memberships
+type SalienceWeight struct{}
+
+// Weight implements WeightFunc
+func (SalienceWeight) Weight(graph *KnowledgeGraph, nodeID, otherID int64) float64 {
+	salience := graph.conceptSalience(nodeID)
+	others := graph.conceptSalience(otherID)
+
+	var shared, total float64
+	for conceptID, value := range salience {
+		other := others[conceptID]
+		shared += math.Min(value, other)
+		total += math.Max(value, other)
+	}
+	for conceptID, other := range others {
+		if _, ok := salience[conceptID]; !ok {
+			total += other
+		}
+	}
+
+	if total == 0 {
+		return 0.0
+	}
+	return shared / total
+}
+
+// conceptSalience maps each concept of a node to the salience of its membership
+func (graph *KnowledgeGraph) conceptSalience(nodeID int64) map[int64]float64 {
+	salience := make(map[int64]float64)
+	for conceptID, membershipID := range graph.nodeMembers[nodeID] {
+		salience[conceptID] = graph.Memberships[membershipID].Salience
+	}
+	return salience
+}
+
+// EmbeddingCosineWeight scores notes by the cosine similarity of their text embeddings
+type EmbeddingCosineWeight struct{}
+
+// Weight implements WeightFunc
+func (EmbeddingCosineWeight) Weight(graph *KnowledgeGraph, nodeID, otherID int64) float64 {
+	node, other := graph.Nodes[nodeID], graph.Nodes[otherID]
+	if node == nil || other == nil {
+		return 0.0
+	}
+	return math.Max(0, cosineSimilarity(node.Embedding, other.Embedding))
+}
+
+// Candidates implements candidateFunc, since embeddings relate notes regardless of concepts
+func (EmbeddingCosineWeight) Candidates(graph *KnowledgeGraph, nodeID int64) []int64 {
+	var ids []int64
+	for id, node := range graph.Nodes {
+		if id != nodeID && len(node.Embedding) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// cosineSimilarity calculates the cosine of two vectors, or 0 if they are empty or of different lengths
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0.0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0.0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// rankSalience gives earlier concepts of an extraction a higher salience, since the LLM lists the main concepts first
+func rankSalience(rank, total int) float64 {
+	if total <= 1 {
+		return 1.0
+	}
+	return 1.0 - 0.5*float64(rank)/float64(total-1)
+}
+
+// usesEmbeddings reports whether the graph's weighting strategy needs note embeddings
+func (graph *KnowledgeGraph) usesEmbeddings() bool {
+	return graph.Settings.WeightStrategy == WeightEmbedding
+}
+
+// embedNode computes a node's embedding if the graph's strategy needs one and an embedder is configured
+func (graph *KnowledgeGraph) embedNode(node *Node) error {
+	if !graph.usesEmbeddings() || graph.Embedder == nil || len(node.Embedding) > 0 {
+		return nil
+	}
+	embedding, err := graph.Embedder(node.Text)
+	if err != nil {
+		return fmt.Errorf("failed to embed node %d: %v", node.ID, err)
+	}
+	node.Embedding = embedding
+	graph.touch("Embedding", node.ID)
+	return nil
+}
+
+// NewOpenAIEmbedder returns an Embedder backed by OpenAI's embeddings API
+func NewOpenAIEmbedder(apiKey string) Embedder {
+	client := openai.NewClient(apiKey)
+	return func(text string) ([]float32, error) {
+		resp, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
+			Input: []string{text},
+			Model: openai.SmallEmbedding3,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create embedding: %v", err)
+		}
+		if len(resp.Data) == 0 {
+			return nil, errors.New("failed to create embedding: empty response")
+		}
+		return resp.Data[0].Embedding, nil
+	}
+}
+
+// RecomputeEdges rebuilds every similarity edge of the graph under its current weighting strategy and retention policy
+func (graph *KnowledgeGraph) RecomputeEdges() error {
+	for _, id := range graph.sortedNodeIDs() {
+		if err := graph.embedNode(graph.Nodes[id]); err != nil {
+			return err
+		}
+	}
+
+	// Keep track of when the existing links were reinforced so recomputing doesn't reset their decay
+	reinforced := make(map[edgeKey]time.Time)
+	for _, edge := range graph.Edges {
+		if edge.IsSimilarity() {
+			reinforced[edge.key()] = edge.ReinforcedAt
+		}
+	}
+
+	graph.removeSimilarityEdges()
+	for _, id := range graph.sortedNodeIDs() {
+		graph.connectNode(id, func(otherID int64) bool { return otherID < id })
+	}
+	for _, edge := range graph.Edges {
+		if at, ok := reinforced[edge.key()]; ok {
+			edge.ReinforcedAt = at
+		}
+	}
+	graph.PruneEdges()
+	return nil
+}
+
+// sortedNodeIDs returns the IDs of the graph's nodes in ascending order
+func (graph *KnowledgeGraph) sortedNodeIDs() []int64 {
+	return sortedKeys(graph.Nodes)
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// runReweightCommand switches the graph's weighting strategy and recomputes every edge weight
+func runReweightCommand(env *CommandEnv, args []string) error {
+	flags := flag.NewFlagSet("reweight", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	graph := env.Graph
+
+	if flags.NArg() > 0 {
+		strategy := flags.Arg(0)
+		if _, ok := weightStrategies[strategy]; !ok {
+			return fmt.Errorf("unknown weighting strategy %q (available: %s)", strategy, strings.Join(WeightStrategyNames(), ", "))
+		}
+		graph.Settings.WeightStrategy = strategy
+	}
+
+	// Embeddings are fetched for any notes that don't have one yet
+	if graph.usesEmbeddings() {
+		apiKey, err := env.RequireAPIKey()
+		if err != nil {
+			return err
+		}
+		graph.Embedder = NewOpenAIEmbedder(apiKey)
+	}
+
+	if err := graph.RecomputeEdges(); err != nil {
+		return err
+	}
+	fmt.Printf("Recomputed %d edges using %s weighting\n", len(graph.Edges), graph.weightStrategyName())
+	return env.Save()
+}
+
+// formatEmbedding encodes an embedding as space-separated numbers for the graph file
+func formatEmbedding(embedding []float32) string {
+	values := make([]string, len(embedding))
+	for i, value := range embedding {
+		values[i] = strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+	return strings.Join(values, " ")
+}
+
+// parseEmbedding decodes an embedding written by formatEmbedding
+func parseEmbedding(text string) ([]float32, error) {
+	fields := strings.Fields(text)
+	embedding := make([]float32, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return nil, err
+		}
+		embedding[i] = float32(value)
+	}
+	return embedding, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// weightingTestNotes are notes with known concepts, listed most salient first, and known embeddings
+var weightingTestNotes = []struct {
+	text      string
+	concepts  []string
+	embedding []float32
+}{
+	{"first", []string{"a", "b", "c"}, []float32{1, 0}},
+	{"second", []string{"a", "b"}, []float32{1, 1}},
+	{"third", []string{"c"}, []float32{0, 1}},
+	{"fourth", []string{"a", "d"}, []float32{-1, 0}},
+}
+
+// newWeightingTestGraph builds a graph of the weighting test notes under a strategy
+func newWeightingTestGraph(t *testing.T, strategy string) *KnowledgeGraph {
+	t.Helper()
+	graph := NewKnowledgeGraph()
+	graph.Settings.WeightStrategy = strategy
+	embeddings := make(map[string][]float32)
+	for _, note := range weightingTestNotes {
+		embeddings[note.text] = note.embedding
+	}
+	graph.Embedder = func(text string) ([]float32, error) { return embeddings[text], nil }
+	for _, note := range weightingTestNotes {
+		if err := BuildOrUpdateKnowledgeGraph(graph, note.text, note.concepts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return graph
+}
+
+func TestWeightStrategies(t *testing.T) {
+	// Weights of the first note against the second, third and fourth; IDFs are ln(1 + 4/(1+df)), so a is the
+	// most common concept and d the rarest, and saliences fall from 1 to 0.5 down each note's concept list
+	tests := []struct {
+		strategy string
+		want     [3]float64
+	}{
+		{WeightJaccard, [3]float64{2.0 / 3, 1.0 / 3, 1.0 / 4}},
+		{WeightOverlap, [3]float64{1, 1, 0.5}},
+		{WeightTFIDF, [3]float64{0.790797595, 0.612077743, 0.267185031}},
+		{WeightSalience, [3]float64{1.5 / 2.25, 0.5 / 2.75, 1 / 2.75}},
+		{WeightEmbedding, [3]float64{math.Sqrt2 / 2, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.strategy, func(t *testing.T) {
+			graph := newWeightingTestGraph(t, test.strategy)
+			ids := graph.sortedNodeIDs()
+			for i, want := range test.want {
+				otherID := ids[i+1]
+				got := graph.weightFunc().Weight(graph, ids[0], otherID)
+				if math.Abs(got-want) > 1e-6 {
+					t.Errorf("the first note and note %d weigh %g, want %g", i+2, got, want)
+				}
+				if reverse := graph.weightFunc().Weight(graph, otherID, ids[0]); math.Abs(reverse-got) > 1e-9 {
+					t.Errorf("note %d and the first note weigh %g, but the first note and note %d weigh %g", i+2, reverse, i+2, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRecomputeEdgesKeepsReinforcement(t *testing.T) {
+	graph := newWeightingTestGraph(t, WeightJaccard)
+	ids := graph.sortedNodeIDs()
+	edge := graph.FindEdge(ids[0], ids[1], RelationSimilar, false)
+	if edge == nil {
+		t.Fatal("the first two notes are not linked")
+	}
+	reinforced := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	edge.ReinforcedAt = reinforced
+
+	graph.Settings.WeightStrategy = WeightOverlap
+	if err := graph.RecomputeEdges(); err != nil {
+		t.Fatal(err)
+	}
+	edge = graph.FindEdge(ids[0], ids[1], RelationSimilar, false)
+	if edge == nil {
+		t.Fatal("recomputing dropped the link between the first two notes")
+	}
+	if edge.Weight != 1 {
+		t.Errorf("the recomputed edge weighs %g, want the overlap weight 1", edge.Weight)
+	}
+	if !edge.ReinforcedAt.Equal(reinforced) {
+		t.Errorf("the recomputed edge was reinforced at %s, want %s", edge.ReinforcedAt, reinforced)
+	}
+}